@@ -11,7 +11,10 @@ import (
 
 	"github.com/axelfrache/savesync/internal/app/authservice"
 	"github.com/axelfrache/savesync/internal/app/backupservice"
+	"github.com/axelfrache/savesync/internal/app/eventbus"
+	"github.com/axelfrache/savesync/internal/app/jobrunner"
 	"github.com/axelfrache/savesync/internal/app/jobservice"
+	"github.com/axelfrache/savesync/internal/app/scheduleservice"
 	"github.com/axelfrache/savesync/internal/app/settingsservice"
 	"github.com/axelfrache/savesync/internal/app/sourceservice"
 	"github.com/axelfrache/savesync/internal/app/targetservice"
@@ -117,21 +120,57 @@ func main() {
 	targetRepo := repositories.NewTargetRepo(database.DB)
 	snapshotRepo := repositories.NewSnapshotRepo(database.DB)
 	jobRepo := repositories.NewJobRepo(database.DB)
+	jobLogRepo := repositories.NewJobLogRepo(database.DB)
+	scheduleRepo := repositories.NewScheduleRepo(database.DB)
+	chunkRepo := repositories.NewChunkRepo(database.DB)
 
 	// Initialize backend registry
 	backendRegistry := backends.NewRegistry()
 
+	// Job and snapshot changes are streamed to API clients
+	eventBus := eventbus.New(logger)
+
 	// Initialize services
 	userService := userservice.New(userRepo, logger)
 	settingsService := settingsservice.New(settingsRepo, logger)
 	authService := authservice.New("your-secret-key-change-in-production", 24*time.Hour)
 	sourceService := sourceservice.New(sourceRepo, logger)
 	targetService := targetservice.New(targetRepo, backendRegistry, logger)
-	jobService := jobservice.New(jobRepo, logger)
-	backupService := backupservice.New(sourceRepo, targetRepo, snapshotRepo, jobRepo, logger)
+	jobService := jobservice.New(jobRepo, jobLogRepo, eventBus, logger)
+	backupService := backupservice.New(sourceRepo, targetRepo, snapshotRepo, jobRepo, chunkRepo, eventBus, logger)
+	jobRunner := jobrunner.New(backupService, targetService, jobService, sourceService, jobrunner.Options{
+		Workers:            cfg.Jobs.Workers,
+		ShutdownTimeout:    cfg.Jobs.ShutdownTimeout,
+		RequeueInterrupted: cfg.Jobs.RequeueInterrupted,
+		BackupPolicy:       cfg.Jobs.BackupPolicy,
+		MaxLogEntries:      cfg.Jobs.MaxLogEntries,
+	}, logger)
+	scheduler := scheduleservice.NewScheduler(scheduleRepo, jobRunner, logger)
+	scheduleService := scheduleservice.New(scheduleRepo, sourceRepo, targetRepo, scheduler, logger)
 
 	logger.Info("services initialized")
 
+	// Jobs still marked as running were cut short by the previous shutdown
+	if err := jobRunner.Recover(context.Background()); err != nil {
+		logger.Fatal("failed to recover interrupted jobs", zap.Error(err))
+	}
+
+	// Start the job queue, which stops after the server
+	runnerCtx, stopRunner := context.WithCancel(context.Background())
+	runnerDone := make(chan struct{})
+	go func() {
+		defer close(runnerDone)
+		jobRunner.Run(runnerCtx)
+	}()
+
+	// Start the scheduler, which stops with the server
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	schedulerDone := make(chan struct{})
+	go func() {
+		defer close(schedulerDone)
+		scheduler.Run(schedulerCtx)
+	}()
+
 	// Create HTTP router with all services
 	router := httpinfra.NewRouter(
 		userService,
@@ -141,6 +180,9 @@ func main() {
 		targetService,
 		backupService,
 		jobService,
+		jobRunner,
+		scheduleService,
+		eventBus,
 		logger,
 	)
 
@@ -152,6 +194,8 @@ func main() {
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	// Event streams would otherwise keep Shutdown waiting
+	server.RegisterOnShutdown(eventBus.Close)
 
 	go func() {
 		logger.Info("starting http server", zap.String("addr", addr))
@@ -166,6 +210,9 @@ func main() {
 
 	logger.Info("shutting down server...")
 
+	stopScheduler()
+	<-schedulerDone
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -173,5 +220,9 @@ func main() {
 		logger.Error("server forced to shutdown", zap.Error(err))
 	}
 
+	// Wait for running jobs, which are interrupted after the grace period
+	stopRunner()
+	<-runnerDone
+
 	logger.Info("server stopped")
 }
@@ -0,0 +1,332 @@
+package backupservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// checkConcurrency is how many chunks a check verifies at the same time
+const checkConcurrency = 8
+
+// maxReportedFiles is how many damaged files are listed for each snapshot
+const maxReportedFiles = 100
+
+// CheckResult reports what a check found in the repository of a target
+type CheckResult struct {
+	ReadData         bool               `json:"read_data"`
+	ReadDataPercent  int                `json:"read_data_percent,omitempty"`
+	Snapshots        int                `json:"snapshots"`         // Completed snapshots checked
+	ChunksReferenced int                `json:"chunks_referenced"` // Distinct chunks referenced by their manifests
+	ChunksRead       int                `json:"chunks_read"`       // Chunks downloaded and hashed again
+	MissingChunks    []string           `json:"missing_chunks"`
+	CorruptChunks    []string           `json:"corrupt_chunks"`
+	Damaged          []*DamagedSnapshot `json:"damaged_snapshots"` // Snapshots which can't be fully restored
+}
+
+// OK tells whether every checked snapshot can be restored
+func (r *CheckResult) OK() bool {
+	return len(r.Damaged) == 0
+}
+
+// DamagedSnapshot lists what can't be restored from a snapshot
+type DamagedSnapshot struct {
+	SnapshotID int64          `json:"snapshot_id"`
+	Error      string         `json:"error,omitempty"` // Why the manifest couldn't be read, in which case no file is listed
+	FileCount  int            `json:"file_count"`      // Damaged files, of which the first 100 are listed
+	Files      []*DamagedFile `json:"files,omitempty"`
+}
+
+// DamagedFile is a file of a snapshot referencing missing or corrupt chunks
+type DamagedFile struct {
+	Path   string   `json:"path"`
+	Chunks []string `json:"chunks"`
+}
+
+// Check verifies that the snapshots of a target can be restored: the
+// manifest of each completed snapshot, or only of snapshotID if set, is
+// loaded and every chunk it references must exist on the backend. With
+// opts.ReadData, the chunks are also downloaded, or a random share of them,
+// and hashed again against their name. The repository lock of the target is
+// shared, so a prune can't delete chunks while they are checked.
+//
+// Damage is reported in the result rather than as an error.
+func (s *Service) Check(ctx context.Context, targetID int64, snapshotID *int64, backend domain.Backend, opts domain.CheckOptions) (*CheckResult, error) {
+	startTime := time.Now()
+	logger := s.log(ctx)
+
+	if opts.ReadDataPercent < 0 || opts.ReadDataPercent > 100 {
+		return nil, fmt.Errorf("%w: read_data_percent must be between 0 and 100", domain.ErrInvalidInput)
+	}
+
+	snapshots, err := s.checkedSnapshots(ctx, targetID, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.locks.acquire(ctx, targetID, false, func() {
+		logger.Info("waiting for the prune of the target to finish", zap.Int64("target_id", targetID))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock repository: %w", err)
+	}
+	defer release()
+
+	result := &CheckResult{
+		ReadData:      opts.ReadData,
+		MissingChunks: []string{},
+		CorruptChunks: []string{},
+		Damaged:       []*DamagedSnapshot{},
+	}
+	if opts.ReadData {
+		result.ReadDataPercent = opts.ReadDataPercent
+	}
+
+	// Chunks referenced by the readable manifests
+	referenced := make(map[string]bool)
+	readable := make([]*domain.Snapshot, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		manifest, err := loadManifest(ctx, backend, snapshot.ID)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			logger.Warn("manifest of snapshot is unreadable", zap.Int64("snapshot_id", snapshot.ID), zap.Error(err))
+			result.Damaged = append(result.Damaged, &DamagedSnapshot{SnapshotID: snapshot.ID, Error: err.Error()})
+			continue
+		}
+
+		for _, file := range manifest.Files {
+			for _, hash := range file.Chunks {
+				referenced[hash] = true
+			}
+		}
+		readable = append(readable, snapshot)
+	}
+	result.Snapshots = len(snapshots)
+	result.ChunksReferenced = len(referenced)
+
+	hashes := make([]string, 0, len(referenced))
+	for hash := range referenced {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	missing, err := missingChunks(ctx, backend, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	corrupt := make(map[string]bool)
+	if opts.ReadData {
+		present := make([]string, 0, len(hashes))
+		for _, hash := range hashes {
+			if !missing[hash] {
+				present = append(present, hash)
+			}
+		}
+		sample := sampleChunks(present, opts.ReadDataPercent)
+
+		var mu sync.Mutex
+		err := forEachChunk(ctx, sample, func(ctx context.Context, hash string) error {
+			stored, err := backend.LoadChunk(ctx, hash)
+			if err == nil {
+				_, err = decodeChunk(hash, stored)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				result.ChunksRead++
+			case errors.Is(err, domain.ErrNotFound):
+				missing[hash] = true // Deleted since the chunks were listed
+			case errors.Is(err, domain.ErrSnapshotInvalid):
+				result.ChunksRead++
+				corrupt[hash] = true
+			default:
+				return fmt.Errorf("failed to read chunk %s: %w", hash, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for hash := range missing {
+		result.MissingChunks = append(result.MissingChunks, hash)
+	}
+	for hash := range corrupt {
+		result.CorruptChunks = append(result.CorruptChunks, hash)
+	}
+	sort.Strings(result.MissingChunks)
+	sort.Strings(result.CorruptChunks)
+
+	// Only damaged snapshots have their manifest read again
+	if len(missing) > 0 || len(corrupt) > 0 {
+		for _, snapshot := range readable {
+			damaged, err := damagedFiles(ctx, backend, snapshot.ID, missing, corrupt)
+			if err != nil {
+				return nil, err
+			}
+			if damaged != nil {
+				result.Damaged = append(result.Damaged, damaged)
+			}
+		}
+	}
+	sort.Slice(result.Damaged, func(i, j int) bool { return result.Damaged[i].SnapshotID < result.Damaged[j].SnapshotID })
+
+	logger.Info("check completed",
+		zap.Int64("target_id", targetID),
+		zap.Bool("read_data", opts.ReadData),
+		zap.Int("snapshots", result.Snapshots),
+		zap.Int("chunks_referenced", result.ChunksReferenced),
+		zap.Int("chunks_read", result.ChunksRead),
+		zap.Int("missing_chunks", len(result.MissingChunks)),
+		zap.Int("corrupt_chunks", len(result.CorruptChunks)),
+		zap.Int("damaged_snapshots", len(result.Damaged)),
+		zap.Float64("duration_seconds", time.Since(startTime).Seconds()),
+	)
+
+	return result, nil
+}
+
+// checkedSnapshots returns the completed snapshots of a target, or only the
+// given one, which must be completed and belong to the target
+func (s *Service) checkedSnapshots(ctx context.Context, targetID int64, snapshotID *int64) ([]*domain.Snapshot, error) {
+	if snapshotID != nil {
+		snapshot, err := s.snapshotRepo.GetByID(ctx, *snapshotID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get snapshot: %w", err)
+		}
+		if snapshot.TargetID != targetID {
+			return nil, fmt.Errorf("%w: snapshot %d is not stored on target %d", domain.ErrInvalidInput, snapshot.ID, targetID)
+		}
+		if !snapshot.Completed() {
+			return nil, fmt.Errorf("%w: snapshot status is %s", domain.ErrSnapshotInvalid, snapshot.Status)
+		}
+		return []*domain.Snapshot{snapshot}, nil
+	}
+
+	all, err := s.snapshotRepo.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	var snapshots []*domain.Snapshot
+	for _, snapshot := range all {
+		if snapshot.TargetID == targetID && snapshot.Completed() {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].ID < snapshots[j].ID })
+	return snapshots, nil
+}
+
+// missingChunks returns which of hashes are not on the backend. The chunks
+// are listed once when the backend can list them, and looked up one by one
+// otherwise.
+func missingChunks(ctx context.Context, backend domain.Backend, hashes []string) (map[string]bool, error) {
+	missing := make(map[string]bool)
+
+	stored, err := listChunks(ctx, backend, nil)
+	if err == nil {
+		for _, hash := range hashes {
+			if _, ok := stored[hash]; !ok {
+				missing[hash] = true
+			}
+		}
+		return missing, nil
+	}
+	if !errors.Is(err, domain.ErrNotSupported) {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	err = forEachChunk(ctx, hashes, func(ctx context.Context, hash string) error {
+		exists, err := backend.ChunkExists(ctx, hash)
+		if err != nil {
+			return fmt.Errorf("failed to check chunk %s: %w", hash, err)
+		}
+		if !exists {
+			mu.Lock()
+			missing[hash] = true
+			mu.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
+
+// sampleChunks returns a random share of hashes, rounded up, or all of them
+// when percent is 0 or 100
+func sampleChunks(hashes []string, percent int) []string {
+	if percent <= 0 || percent >= 100 {
+		return hashes
+	}
+
+	sample := append([]string(nil), hashes...)
+	rand.Shuffle(len(sample), func(i, j int) { sample[i], sample[j] = sample[j], sample[i] })
+	return sample[:(len(sample)*percent+99)/100]
+}
+
+// forEachChunk calls fn for each hash, checkConcurrency at a time, and
+// stops at the first error
+func forEachChunk(ctx context.Context, hashes []string, fn func(ctx context.Context, hash string) error) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(checkConcurrency)
+	for _, hash := range hashes {
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return fn(ctx, hash)
+		})
+	}
+	return g.Wait()
+}
+
+// damagedFiles returns the files of a snapshot referencing missing or
+// corrupt chunks, or nil if there are none
+func damagedFiles(ctx context.Context, backend domain.Backend, snapshotID int64, missing, corrupt map[string]bool) (*DamagedSnapshot, error) {
+	manifest, err := loadManifest(ctx, backend, snapshotID)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %d: %w", snapshotID, err)
+	}
+
+	var damaged *DamagedSnapshot
+	for _, file := range manifest.Files {
+		var bad []string
+		seen := make(map[string]bool)
+		for _, hash := range file.Chunks {
+			if (missing[hash] || corrupt[hash]) && !seen[hash] {
+				bad = append(bad, hash)
+				seen[hash] = true
+			}
+		}
+		if bad == nil {
+			continue
+		}
+
+		if damaged == nil {
+			damaged = &DamagedSnapshot{SnapshotID: snapshotID}
+		}
+		damaged.FileCount++
+		if len(damaged.Files) < maxReportedFiles {
+			damaged.Files = append(damaged.Files, &DamagedFile{Path: file.Path, Chunks: bad})
+		}
+	}
+	return damaged, nil
+}
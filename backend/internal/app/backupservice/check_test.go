@@ -0,0 +1,134 @@
+package backupservice
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newCheckTest returns a backend holding the chunks of three files, and a
+// service whose snapshot 1 references the first two and snapshot 2 the last
+// two. Chunks are stored raw, as written before chunk headers existed.
+func newCheckTest(t *testing.T) (*Service, *memoryBackend, []string) {
+	backend := newMemoryBackend()
+	var hashes []string
+	for _, content := range []string{"first", "second", "third"} {
+		hash := chunkHash([]byte(content))
+		backend.chunks[hash] = []byte(content)
+		hashes = append(hashes, hash)
+	}
+	backend.manifests["1"] = []byte(fmt.Sprintf(`{"snapshot_id":1,"files":[{"path":"a","chunks":[%q]},{"path":"b","chunks":[%q,%q]}]}`, hashes[0], hashes[1], hashes[1]))
+	backend.manifests["2"] = []byte(fmt.Sprintf(`{"snapshot_id":2,"files":[{"path":"b","chunks":[%q]},{"path":"c","chunks":[%q]}]}`, hashes[1], hashes[2]))
+
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	mockSnapshotRepo.On("GetAll", mock.Anything).Return([]*domain.Snapshot{
+		{ID: 1, TargetID: 2, Status: "success"},
+		{ID: 2, TargetID: 2, Status: "partial"},
+		{ID: 3, TargetID: 2, Status: "failed"},
+		{ID: 4, TargetID: 5, Status: "success"}, // Its manifest is on another backend
+	}, nil)
+	mockSnapshotRepo.On("GetByID", mock.Anything, int64(2)).Return(&domain.Snapshot{ID: 2, TargetID: 2, Status: "partial"}, nil)
+	mockSnapshotRepo.On("GetByID", mock.Anything, int64(3)).Return(&domain.Snapshot{ID: 3, TargetID: 2, Status: "failed"}, nil)
+	service := New(new(MockSourceRepository), new(MockTargetRepository), mockSnapshotRepo, new(MockJobRepository), newMemoryChunkRepo(), nil, zap.NewNop())
+	return service, backend, hashes
+}
+
+func TestBackupService_Check(t *testing.T) {
+	service, backend, hashes := newCheckTest(t)
+
+	result, err := service.Check(context.Background(), 2, nil, backend, domain.CheckOptions{})
+	require.NoError(t, err)
+	assert.True(t, result.OK())
+	assert.Equal(t, 2, result.Snapshots)
+	assert.Equal(t, 3, result.ChunksReferenced)
+	assert.Zero(t, result.ChunksRead)
+
+	// A missing chunk damages every snapshot referencing it
+	delete(backend.chunks, hashes[1])
+	result, err = service.Check(context.Background(), 2, nil, backend, domain.CheckOptions{})
+	require.NoError(t, err)
+	assert.False(t, result.OK())
+	assert.Equal(t, []string{hashes[1]}, result.MissingChunks)
+	assert.Empty(t, result.CorruptChunks)
+	assert.Equal(t, []*DamagedSnapshot{
+		{SnapshotID: 1, FileCount: 1, Files: []*DamagedFile{{Path: "b", Chunks: []string{hashes[1]}}}},
+		{SnapshotID: 2, FileCount: 1, Files: []*DamagedFile{{Path: "b", Chunks: []string{hashes[1]}}}},
+	}, result.Damaged)
+}
+
+func TestBackupService_CheckReadData(t *testing.T) {
+	service, backend, hashes := newCheckTest(t)
+	backend.chunks[hashes[2]] = []byte("bit rot")
+
+	// Only reading the chunks reveals the corruption
+	result, err := service.Check(context.Background(), 2, nil, backend, domain.CheckOptions{})
+	require.NoError(t, err)
+	assert.True(t, result.OK())
+
+	result, err = service.Check(context.Background(), 2, nil, backend, domain.CheckOptions{ReadData: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.ChunksRead)
+	assert.Equal(t, []string{hashes[2]}, result.CorruptChunks)
+	assert.Equal(t, []*DamagedSnapshot{
+		{SnapshotID: 2, FileCount: 1, Files: []*DamagedFile{{Path: "c", Chunks: []string{hashes[2]}}}},
+	}, result.Damaged)
+
+	// A share of the chunks, rounded up
+	result, err = service.Check(context.Background(), 2, nil, backend, domain.CheckOptions{ReadData: true, ReadDataPercent: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 10, result.ReadDataPercent)
+	assert.Equal(t, 1, result.ChunksRead)
+
+	_, err = service.Check(context.Background(), 2, nil, backend, domain.CheckOptions{ReadData: true, ReadDataPercent: 150})
+	assert.ErrorIs(t, err, domain.ErrInvalidInput)
+}
+
+func TestBackupService_CheckUnreadableManifest(t *testing.T) {
+	service, backend, _ := newCheckTest(t)
+	backend.manifests["1"] = []byte(`{"snapshot_id":1,"files":[`)
+
+	result, err := service.Check(context.Background(), 2, nil, backend, domain.CheckOptions{})
+	require.NoError(t, err)
+	require.Len(t, result.Damaged, 1)
+	assert.Equal(t, int64(1), result.Damaged[0].SnapshotID)
+	assert.Contains(t, result.Damaged[0].Error, "failed to parse manifest")
+	assert.Equal(t, 2, result.ChunksReferenced) // Those of snapshot 2
+}
+
+func TestBackupService_CheckSnapshot(t *testing.T) {
+	service, backend, hashes := newCheckTest(t)
+	delete(backend.chunks, hashes[0])
+
+	// Snapshot 2 doesn't reference the missing chunk
+	snapshotID := int64(2)
+	result, err := service.Check(context.Background(), 2, &snapshotID, &unlistedBackend{backend}, domain.CheckOptions{})
+	require.NoError(t, err)
+	assert.True(t, result.OK())
+	assert.Equal(t, 1, result.Snapshots)
+	assert.Equal(t, 2, result.ChunksReferenced)
+
+	_, err = service.Check(context.Background(), 7, &snapshotID, backend, domain.CheckOptions{})
+	assert.ErrorIs(t, err, domain.ErrInvalidInput)
+
+	failed := int64(3)
+	_, err = service.Check(context.Background(), 2, &failed, backend, domain.CheckOptions{})
+	assert.ErrorIs(t, err, domain.ErrSnapshotInvalid)
+}
+
+func TestBackupService_CheckWithoutListing(t *testing.T) {
+	service, backend, hashes := newCheckTest(t)
+	delete(backend.chunks, hashes[2])
+
+	// The chunks are looked up one by one instead
+	result, err := service.Check(context.Background(), 2, nil, &unlistedBackend{backend}, domain.CheckOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{hashes[2]}, result.MissingChunks)
+	require.Len(t, result.Damaged, 1)
+	assert.Equal(t, int64(2), result.Damaged[0].SnapshotID)
+}
@@ -5,60 +5,216 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"math/bits"
 	"os"
+	"sync"
+
+	"github.com/axelfrache/savesync/internal/domain"
+)
+
+// Chunking algorithms
+const (
+	ChunkerFastCDC = "fastcdc"
+	ChunkerFixed   = "fixed"
 )
 
 const (
-	// DefaultChunkSize is the default chunk size (4MB)
+	// DefaultChunkSize is the chunk size of the legacy fixed-size chunker (4MB)
 	DefaultChunkSize = 4 * 1024 * 1024
+
+	// Default FastCDC parameters
+	DefaultMinChunkSize = 512 * 1024
+	DefaultAvgChunkSize = 1024 * 1024
+	DefaultMaxChunkSize = 8 * 1024 * 1024
+
+	minChunkSizeLimit = 64
+	maxChunkSizeLimit = 64 * 1024 * 1024
 )
 
+// gearTable holds the random values of the gear rolling hash. It is derived
+// from a fixed seed and must never change: doing so would move every chunk
+// boundary and break deduplication against existing repositories.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x5361766553796e63) // "SaveSync"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// DefaultChunkerParams returns the parameters used when a target does not configure chunking
+func DefaultChunkerParams() domain.ChunkerParams {
+	return domain.ChunkerParams{
+		Algorithm: ChunkerFastCDC,
+		MinSize:   DefaultMinChunkSize,
+		AvgSize:   DefaultAvgChunkSize,
+		MaxSize:   DefaultMaxChunkSize,
+	}
+}
+
+// LegacyChunkerParams returns the parameters of the original fixed-size chunker
+func LegacyChunkerParams() domain.ChunkerParams {
+	return domain.ChunkerParams{
+		Algorithm: ChunkerFixed,
+		MaxSize:   DefaultChunkSize,
+	}
+}
+
 // Chunker handles file chunking
 type Chunker struct {
-	chunkSize int
+	params domain.ChunkerParams
+	maskS  uint64 // Stricter mask used before the average size is reached
+	maskL  uint64 // Looser mask used after the average size is reached
+
+	// windows recycles MaxSize read buffers between files
+	windows sync.Pool
+}
+
+// NewChunker creates a new chunker from validated parameters
+func NewChunker(params domain.ChunkerParams) (*Chunker, error) {
+	switch params.Algorithm {
+	case ChunkerFixed:
+		if params.MaxSize < minChunkSizeLimit || params.MaxSize > maxChunkSizeLimit {
+			return nil, fmt.Errorf("%w: chunk size must be between %d and %d bytes", domain.ErrInvalidInput, minChunkSizeLimit, maxChunkSizeLimit)
+		}
+		return &Chunker{params: domain.ChunkerParams{Algorithm: ChunkerFixed, MaxSize: params.MaxSize}}, nil
+
+	case ChunkerFastCDC:
+		if params.MinSize < minChunkSizeLimit || params.MaxSize > maxChunkSizeLimit {
+			return nil, fmt.Errorf("%w: chunk sizes must be between %d and %d bytes", domain.ErrInvalidInput, minChunkSizeLimit, maxChunkSizeLimit)
+		}
+		if params.MinSize >= params.AvgSize || params.AvgSize >= params.MaxSize {
+			return nil, fmt.Errorf("%w: chunk sizes must satisfy min < avg < max", domain.ErrInvalidInput)
+		}
+
+		// Normalized chunking: one bit harder than the average before it,
+		// one bit easier after it
+		avgBits := bits.Len(uint(params.AvgSize)) - 1
+		return &Chunker{
+			params: params,
+			maskS:  highBitsMask(avgBits + 1),
+			maskL:  highBitsMask(avgBits - 1),
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("%w: unknown chunker algorithm %q", domain.ErrInvalidInput, params.Algorithm)
+	}
+}
+
+// Params returns the parameters this chunker was created with
+func (c *Chunker) Params() domain.ChunkerParams {
+	return c.params
 }
 
-// NewChunker creates a new chunker
-func NewChunker(chunkSize int) *Chunker {
-	if chunkSize <= 0 {
-		chunkSize = DefaultChunkSize
+// window returns a MaxSize read buffer, reusing one from a previous file when possible
+func (c *Chunker) window() []byte {
+	if buffer, ok := c.windows.Get().(*[]byte); ok {
+		return *buffer
 	}
-	return &Chunker{chunkSize: chunkSize}
+	return make([]byte, c.params.MaxSize)
 }
 
-// ChunkFile chunks a file and returns chunk hashes and data
-func (c *Chunker) ChunkFile(filePath string) ([]ChunkInfo, error) {
+// highBitsMask returns a mask of n set bits taken from the top of the word,
+// which depend on the most input bytes in the gear hash
+func highBitsMask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// cut returns the length of the next chunk at the start of data. data holds
+// at most MaxSize bytes; it is shorter only at the end of the file.
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if c.params.Algorithm == ChunkerFixed {
+		return min(n, c.params.MaxSize)
+	}
+
+	if n <= c.params.MinSize {
+		return n
+	}
+	n = min(n, c.params.MaxSize)
+	normal := min(n, c.params.AvgSize)
+
+	var fp uint64
+	i := c.params.MinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// ChunkFile streams a file through the chunker, see Split
+func (c *Chunker) ChunkFile(filePath string, fn func(ChunkInfo) error) (string, int64, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+		return "", 0, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
-	var chunks []ChunkInfo
-	buffer := make([]byte, c.chunkSize)
+	return c.Split(file, fn)
+}
+
+// Split reads r once, calling fn for every chunk as soon as its boundary is
+// known, and returns the SHA256 and size of the whole stream. Memory use is
+// bounded by a single MaxSize window regardless of the stream length, so the
+// Data passed to fn is only valid until fn returns. An error from fn stops
+// the split and is returned unchanged.
+func (c *Chunker) Split(r io.Reader, fn func(ChunkInfo) error) (string, int64, error) {
+	fileHash := sha256.New()
+	buffer := c.window()
+	defer c.windows.Put(&buffer)
+	filled := 0
+	eof := false
+	var total int64
 
 	for {
-		n, err := file.Read(buffer)
-		if err != nil && err != io.EOF {
-			return nil, fmt.Errorf("failed to read file: %w", err)
+		// Top up the buffer so a full window is available to find the next boundary
+		for !eof && filled < len(buffer) {
+			n, err := r.Read(buffer[filled:])
+			fileHash.Write(buffer[filled : filled+n])
+			filled += n
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return "", 0, fmt.Errorf("failed to read file: %w", err)
+			}
 		}
-		if n == 0 {
+		if filled == 0 {
 			break
 		}
 
 		// Calculate hash for this chunk
-		chunkData := buffer[:n]
+		size := c.cut(buffer[:filled])
+		chunkData := buffer[:size]
 		hash := sha256.Sum256(chunkData)
-		hashStr := hex.EncodeToString(hash[:])
 
-		chunks = append(chunks, ChunkInfo{
-			Hash: hashStr,
-			Size: int64(n),
-			Data: append([]byte(nil), chunkData...), // Copy data
-		})
+		if err := fn(ChunkInfo{
+			Hash: hex.EncodeToString(hash[:]),
+			Size: int64(size),
+			Data: chunkData,
+		}); err != nil {
+			return "", 0, err
+		}
+
+		total += int64(size)
+		filled = copy(buffer, buffer[size:filled])
 	}
 
-	return chunks, nil
+	return hex.EncodeToString(fileHash.Sum(nil)), total, nil
 }
 
 // ChunkInfo contains information about a chunk
@@ -67,19 +223,3 @@ type ChunkInfo struct {
 	Size int64
 	Data []byte
 }
-
-// HashFile calculates the SHA256 hash of an entire file
-func HashFile(filePath string) (string, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
-
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", fmt.Errorf("failed to hash file: %w", err)
-	}
-
-	return hex.EncodeToString(hash.Sum(nil)), nil
-}
@@ -0,0 +1,159 @@
+package backupservice
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTempFile(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data.bin")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+func randomData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// chunkFile collects the chunks of data, copying them out of the chunker's window
+func chunkFile(t *testing.T, chunker *Chunker, data []byte) []ChunkInfo {
+	t.Helper()
+	var chunks []ChunkInfo
+	fileHash, size, err := chunker.ChunkFile(writeTempFile(t, data), func(chunk ChunkInfo) error {
+		chunk.Data = append([]byte(nil), chunk.Data...)
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, sha256Hex(data), fileHash)
+	assert.Equal(t, int64(len(data)), size)
+	return chunks
+}
+
+func smallCDCParams() domain.ChunkerParams {
+	return domain.ChunkerParams{Algorithm: ChunkerFastCDC, MinSize: 2048, AvgSize: 8192, MaxSize: 32768}
+}
+
+func TestChunker_FastCDCBoundsAndReassembly(t *testing.T) {
+	chunker, err := NewChunker(smallCDCParams())
+	require.NoError(t, err)
+
+	data := randomData(1<<20, 1)
+	chunks := chunkFile(t, chunker, data)
+
+	var reassembled []byte
+	for i, chunk := range chunks {
+		assert.LessOrEqual(t, chunk.Size, int64(32768))
+		if i < len(chunks)-1 {
+			assert.GreaterOrEqual(t, chunk.Size, int64(2048))
+		}
+		assert.Equal(t, sha256Hex(chunk.Data), chunk.Hash)
+		reassembled = append(reassembled, chunk.Data...)
+	}
+	assert.Equal(t, data, reassembled)
+}
+
+func TestChunker_FastCDCSurvivesInsertion(t *testing.T) {
+	chunker, err := NewChunker(smallCDCParams())
+	require.NoError(t, err)
+
+	data := randomData(1<<20, 2)
+	shifted := append([]byte{0x42}, data...)
+
+	original := chunkFile(t, chunker, data)
+	modified := chunkFile(t, chunker, shifted)
+
+	known := make(map[string]bool)
+	for _, chunk := range original {
+		known[chunk.Hash] = true
+	}
+	shared := 0
+	for _, chunk := range modified {
+		if known[chunk.Hash] {
+			shared++
+		}
+	}
+
+	// Only the chunk containing the inserted byte should change
+	assert.GreaterOrEqual(t, shared, len(original)-2)
+}
+
+func TestChunker_Fixed(t *testing.T) {
+	chunker, err := NewChunker(domain.ChunkerParams{Algorithm: ChunkerFixed, MaxSize: 4096})
+	require.NoError(t, err)
+
+	chunks := chunkFile(t, chunker, randomData(10000, 3))
+	require.Len(t, chunks, 3)
+	assert.Equal(t, int64(4096), chunks[0].Size)
+	assert.Equal(t, int64(4096), chunks[1].Size)
+	assert.Equal(t, int64(1808), chunks[2].Size)
+}
+
+func TestChunker_SplitStreamsLargeInput(t *testing.T) {
+	chunker, err := NewChunker(smallCDCParams())
+	require.NoError(t, err)
+
+	// 64 MiB streamed through a 32 KiB window, never materialized in memory
+	const size = 64 << 20
+	count := 0
+	fileHash, total, err := chunker.Split(io.LimitReader(rand.New(rand.NewSource(4)), size), func(chunk ChunkInfo) error {
+		assert.LessOrEqual(t, len(chunk.Data), 32768)
+		count++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(size), total)
+	assert.Len(t, fileHash, 64)
+	assert.Greater(t, count, size/32768)
+}
+
+func TestChunker_SplitStopsOnCallbackError(t *testing.T) {
+	chunker, err := NewChunker(smallCDCParams())
+	require.NoError(t, err)
+
+	stop := errors.New("stop")
+	calls := 0
+	_, _, err = chunker.Split(bytes.NewReader(randomData(1<<20, 5)), func(ChunkInfo) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+}
+
+func TestNewChunker_InvalidParams(t *testing.T) {
+	_, err := NewChunker(domain.ChunkerParams{Algorithm: ChunkerFastCDC, MinSize: 8192, AvgSize: 4096, MaxSize: 32768})
+	assert.ErrorIs(t, err, domain.ErrInvalidInput)
+
+	_, err = NewChunker(domain.ChunkerParams{Algorithm: "rabin"})
+	assert.ErrorIs(t, err, domain.ErrInvalidInput)
+}
+
+func TestParseTargetOptions_Chunker(t *testing.T) {
+	opts, err := parseTargetOptions(&domain.Target{ConfigJSON: `{"path":"/backups"}`})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultChunkerParams(), opts.chunker)
+
+	opts, err = parseTargetOptions(&domain.Target{ConfigJSON: `{"chunker":"fixed"}`})
+	require.NoError(t, err)
+	assert.Equal(t, LegacyChunkerParams(), opts.chunker)
+
+	opts, err = parseTargetOptions(&domain.Target{ConfigJSON: `{"chunk_min_size":"1024","chunk_avg_size":4096,"chunk_max_size":16384}`})
+	require.NoError(t, err)
+	assert.Equal(t, domain.ChunkerParams{Algorithm: ChunkerFastCDC, MinSize: 1024, AvgSize: 4096, MaxSize: 16384}, opts.chunker)
+
+	_, err = parseTargetOptions(&domain.Target{ConfigJSON: `{"chunk_avg_size":"big"}`})
+	assert.ErrorIs(t, err, domain.ErrInvalidInput)
+}
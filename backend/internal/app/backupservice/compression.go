@@ -0,0 +1,126 @@
+package backupservice
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Stored chunks start with a small header: the "SSC" magic followed by one
+// byte naming the encoding of the payload. Chunks written before the header
+// existed are raw data and are recognized by their hash.
+var chunkMagic = []byte("SSC")
+
+const (
+	chunkHeaderSize = 4
+
+	chunkEncodingRaw  byte = 0
+	chunkEncodingZstd byte = 1
+
+	// MaxCompressionLevel is the highest zstd level accepted
+	MaxCompressionLevel = 22
+)
+
+// chunkDecoder is shared by every restore; DecodeAll is safe for concurrent use
+var chunkDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+	return zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxChunkSizeLimit))
+})
+
+// compressor encodes chunks before they are stored
+type compressor struct {
+	encoder *zstd.Encoder // nil when compression is disabled
+}
+
+// newCompressor creates a compressor for an algorithm and level, 0 meaning
+// the default level of the algorithm
+func newCompressor(algorithm string, level int) (*compressor, error) {
+	switch algorithm {
+	case domain.CompressionNone:
+		return &compressor{}, nil
+	case domain.CompressionZstd:
+		encoderLevel := zstd.SpeedDefault
+		if level != 0 {
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(encoderLevel))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		return &compressor{encoder: encoder}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown compression %q", domain.ErrInvalidInput, algorithm)
+	}
+}
+
+// encode wraps a chunk in its stored form. Data that does not shrink by at
+// least 1/32 is considered incompressible and stored raw, so restores don't
+// pay for decompression that saves nothing.
+func (c *compressor) encode(data []byte) []byte {
+	if c.encoder != nil {
+		compressed := c.encoder.EncodeAll(data, chunkHeader(chunkEncodingZstd, len(data)))
+		if len(compressed)-chunkHeaderSize < len(data)-len(data)/32 {
+			return compressed
+		}
+	}
+	return append(chunkHeader(chunkEncodingRaw, len(data)), data...)
+}
+
+// close releases the encoder resources
+func (c *compressor) close() {
+	if c.encoder != nil {
+		c.encoder.Close()
+	}
+}
+
+// chunkHeader returns a header for the given encoding with room for size bytes of payload
+func chunkHeader(encoding byte, size int) []byte {
+	header := make([]byte, chunkHeaderSize, chunkHeaderSize+size)
+	copy(header, chunkMagic)
+	header[3] = encoding
+	return header
+}
+
+// decodeChunk returns the original data of a stored chunk and checks it
+// against the chunk hash
+func decodeChunk(hash string, stored []byte) ([]byte, error) {
+	if len(stored) >= chunkHeaderSize && bytes.Equal(stored[:3], chunkMagic) {
+		data, err := decodePayload(stored[3], stored[chunkHeaderSize:])
+		if err == nil && chunkHash(data) == hash {
+			return data, nil
+		}
+	}
+
+	// Written before chunk headers existed
+	if chunkHash(stored) == hash {
+		return stored, nil
+	}
+
+	return nil, fmt.Errorf("%w: chunk %s is corrupted", domain.ErrSnapshotInvalid, hash)
+}
+
+// decodePayload decodes the payload following a chunk header
+func decodePayload(encoding byte, payload []byte) ([]byte, error) {
+	switch encoding {
+	case chunkEncodingRaw:
+		return payload, nil
+	case chunkEncodingZstd:
+		decoder, err := chunkDecoder()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+		}
+		return decoder.DecodeAll(payload, nil)
+	default:
+		return nil, fmt.Errorf("unknown chunk encoding %d", encoding)
+	}
+}
+
+// chunkHash returns the hex SHA256 of data, as used for chunk addressing
+func chunkHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
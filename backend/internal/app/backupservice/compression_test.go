@@ -0,0 +1,97 @@
+package backupservice
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressor_RoundTrip(t *testing.T) {
+	compressor, err := newCompressor(domain.CompressionZstd, 9)
+	require.NoError(t, err)
+	defer compressor.close()
+
+	text := bytes.Repeat([]byte("2025-01-21 10:00:00 INFO request served in 12ms\n"), 2000)
+	stored := compressor.encode(text)
+	assert.Equal(t, chunkEncodingZstd, stored[3])
+	assert.Less(t, len(stored), len(text)/10)
+
+	decoded, err := decodeChunk(sha256Hex(text), stored)
+	require.NoError(t, err)
+	assert.Equal(t, text, decoded)
+
+	// Random data does not compress and is stored raw behind the header
+	random := randomData(100000, 6)
+	stored = compressor.encode(random)
+	assert.Equal(t, chunkEncodingRaw, stored[3])
+	assert.Equal(t, len(random)+chunkHeaderSize, len(stored))
+
+	decoded, err = decodeChunk(sha256Hex(random), stored)
+	require.NoError(t, err)
+	assert.Equal(t, random, decoded)
+}
+
+func TestDecodeChunk_LegacyAndCorrupted(t *testing.T) {
+	// Chunks written before headers existed are raw data, even when they
+	// happen to start with the magic
+	legacy := []byte("SSC\x01 not actually compressed")
+	decoded, err := decodeChunk(sha256Hex(legacy), legacy)
+	require.NoError(t, err)
+	assert.Equal(t, legacy, decoded)
+
+	compressor, err := newCompressor(domain.CompressionNone, 0)
+	require.NoError(t, err)
+	stored := compressor.encode([]byte("content"))
+	stored[len(stored)-1] ^= 1
+	_, err = decodeChunk(sha256Hex([]byte("content")), stored)
+	assert.ErrorIs(t, err, domain.ErrSnapshotInvalid)
+}
+
+func TestBackupService_CompressedBackup(t *testing.T) {
+	dir := t.TempDir()
+	text := bytes.Repeat([]byte("SELECT * FROM snapshots WHERE source_id = 1;\n"), 5000)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dump.sql"), text, 0644))
+
+	backend := newMemoryBackend()
+	manifest, err := runTestBackup(t, context.Background(), dir, `{"compression":"zstd","compression_level":"3"}`, backend)
+	require.NoError(t, err)
+	require.Len(t, manifest.Files, 1)
+
+	var restored []byte
+	for _, hash := range manifest.Files[0].Chunks {
+		stored, err := backend.LoadChunk(context.Background(), hash)
+		require.NoError(t, err)
+		assert.Equal(t, chunkEncodingZstd, stored[3])
+
+		data, err := decodeChunk(hash, stored)
+		require.NoError(t, err)
+		restored = append(restored, data...)
+	}
+	assert.Equal(t, text, restored)
+}
+
+func TestParseTargetOptions_Compression(t *testing.T) {
+	opts, err := parseTargetOptions(&domain.Target{ConfigJSON: `{}`})
+	require.NoError(t, err)
+	assert.Equal(t, domain.CompressionNone, opts.compression)
+
+	opts, err = parseTargetOptions(&domain.Target{ConfigJSON: `{"compression":"zstd","compression_level":19}`})
+	require.NoError(t, err)
+	assert.Equal(t, domain.CompressionZstd, opts.compression)
+	assert.Equal(t, 19, opts.compressionLevel)
+
+	// A source overrides its target
+	require.NoError(t, opts.applySource(&domain.Source{Compression: domain.CompressionNone}))
+	assert.Equal(t, domain.CompressionNone, opts.compression)
+	assert.Equal(t, 0, opts.compressionLevel)
+	assert.ErrorIs(t, opts.applySource(&domain.Source{Compression: "lz4"}), domain.ErrInvalidInput)
+
+	_, err = parseTargetOptions(&domain.Target{ConfigJSON: `{"compression":"zstd","compression_level":23}`})
+	assert.ErrorIs(t, err, domain.ErrInvalidInput)
+}
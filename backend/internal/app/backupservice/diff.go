@@ -0,0 +1,270 @@
+package backupservice
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/axelfrache/savesync/internal/domain"
+)
+
+// Attributes of a file which can differ between two snapshots, as reported
+// in DiffEntry.Changes
+const (
+	DiffType    = "type"
+	DiffContent = "content"
+	DiffLink    = "link_target"
+	DiffDevice  = "device"
+	DiffModTime = "mod_time"
+	DiffMode    = "mode"
+	DiffOwner   = "owner"
+	DiffXattrs  = "xattrs"
+)
+
+// SnapshotDiff lists the files which differ between two snapshots of a
+// source, going from snapshot From to snapshot To
+type SnapshotDiff struct {
+	From            int64        `json:"from"`
+	To              int64        `json:"to"`
+	Prefix          string       `json:"prefix,omitempty"`
+	Added           []*DiffEntry `json:"added"`
+	Removed         []*DiffEntry `json:"removed"`
+	Modified        []*DiffEntry `json:"modified"`         // Content, type or link target changed
+	MetadataChanged []*DiffEntry `json:"metadata_changed"` // Only times, permissions, owner or xattrs changed
+	Summary         DiffSummary  `json:"summary"`
+}
+
+// DiffSummary totals the changes of a SnapshotDiff
+type DiffSummary struct {
+	Added           int   `json:"added"`
+	Removed         int   `json:"removed"`
+	Modified        int   `json:"modified"`
+	MetadataChanged int   `json:"metadata_changed"`
+	Unchanged       int   `json:"unchanged"`
+	BytesAdded      int64 `json:"bytes_added"`   // Size of the added files
+	BytesRemoved    int64 `json:"bytes_removed"` // Size of the removed files
+	SizeDelta       int64 `json:"size_delta"`    // Change in the total size of the files
+}
+
+// DiffEntry is a file which differs between two snapshots. OldSize is zero
+// for added files and NewSize for removed ones.
+type DiffEntry struct {
+	Path       string   `json:"path"`
+	Type       string   `json:"type"`
+	OldSize    int64    `json:"old_size"`
+	NewSize    int64    `json:"new_size"`
+	SizeDelta  int64    `json:"size_delta"`
+	Changes    []string `json:"changes,omitempty"`    // What changed in a modified file, see the Diff* constants
+	Unreadable bool     `json:"unreadable,omitempty"` // Removed because it could not be read, rather than deleted
+}
+
+// DiffSnapshots compares the manifests of two completed snapshots of the
+// same source. Each snapshot is read from the backend of its target. With a
+// prefix, only the files under that path are compared.
+func (s *Service) DiffSnapshots(ctx context.Context, fromID, toID int64, fromBackend, toBackend domain.Backend, prefix string) (*SnapshotDiff, error) {
+	from, err := s.snapshotRepo.GetByID(ctx, fromID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.snapshotRepo.GetByID(ctx, toID)
+	if err != nil {
+		return nil, err
+	}
+	if from.SourceID != to.SourceID {
+		return nil, fmt.Errorf("%w: snapshots %d and %d are not of the same source", domain.ErrInvalidInput, from.ID, to.ID)
+	}
+	for _, snapshot := range []*domain.Snapshot{from, to} {
+		if !snapshot.Completed() {
+			return nil, fmt.Errorf("%w: snapshot %d is %s", domain.ErrInvalidInput, snapshot.ID, snapshot.Status)
+		}
+	}
+
+	prefix = cleanPrefix(prefix)
+
+	fromManifest, err := loadManifest(ctx, fromBackend, from.ID)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %d: %w", from.ID, err)
+	}
+	toManifest, err := loadManifest(ctx, toBackend, to.ID)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %d: %w", to.ID, err)
+	}
+
+	diff := &SnapshotDiff{
+		From:            from.ID,
+		To:              to.ID,
+		Prefix:          prefix,
+		Added:           []*DiffEntry{},
+		Removed:         []*DiffEntry{},
+		Modified:        []*DiffEntry{},
+		MetadataChanged: []*DiffEntry{},
+	}
+
+	old := make(map[string]domain.ManifestFile, len(fromManifest.Files))
+	for _, file := range fromManifest.Files {
+		if hasPrefix(file.Path, prefix) {
+			old[file.Path] = file
+		}
+	}
+
+	for _, file := range toManifest.Files {
+		if !hasPrefix(file.Path, prefix) {
+			continue
+		}
+
+		previous, ok := old[file.Path]
+		if !ok {
+			diff.Added = append(diff.Added, &DiffEntry{Path: file.Path, Type: entryType(file), NewSize: file.Size, SizeDelta: file.Size})
+			diff.Summary.BytesAdded += file.Size
+			diff.Summary.SizeDelta += file.Size
+			continue
+		}
+		delete(old, file.Path)
+
+		changes := fileChanges(previous, file)
+		if len(changes) == 0 {
+			diff.Summary.Unchanged++
+			continue
+		}
+
+		entry := &DiffEntry{
+			Path:      file.Path,
+			Type:      entryType(file),
+			OldSize:   previous.Size,
+			NewSize:   file.Size,
+			SizeDelta: file.Size - previous.Size,
+			Changes:   changes,
+		}
+		diff.Summary.SizeDelta += entry.SizeDelta
+		if contentChanged(changes) {
+			diff.Modified = append(diff.Modified, entry)
+		} else {
+			diff.MetadataChanged = append(diff.MetadataChanged, entry)
+		}
+	}
+
+	// What is left of the old files was removed, or couldn't be read this time
+	unreadable := make(map[string]bool, len(toManifest.Errors))
+	for _, fileErr := range toManifest.Errors {
+		unreadable[fileErr.Path] = true
+	}
+	for _, file := range old {
+		diff.Removed = append(diff.Removed, &DiffEntry{
+			Path:       file.Path,
+			Type:       entryType(file),
+			OldSize:    file.Size,
+			SizeDelta:  -file.Size,
+			Unreadable: unreadable[file.Path],
+		})
+		diff.Summary.BytesRemoved += file.Size
+		diff.Summary.SizeDelta -= file.Size
+	}
+
+	for _, entries := range [][]*DiffEntry{diff.Added, diff.Removed, diff.Modified, diff.MetadataChanged} {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	}
+	diff.Summary.Added = len(diff.Added)
+	diff.Summary.Removed = len(diff.Removed)
+	diff.Summary.Modified = len(diff.Modified)
+	diff.Summary.MetadataChanged = len(diff.MetadataChanged)
+
+	return diff, nil
+}
+
+// cleanPrefix normalizes a path prefix to the form of manifest paths, with
+// "" standing for every file
+func cleanPrefix(prefix string) string {
+	prefix = path.Clean(strings.ReplaceAll(prefix, `\`, "/"))
+	prefix = strings.TrimLeft(prefix, "/")
+	if prefix == "." {
+		return ""
+	}
+	return prefix
+}
+
+// hasPrefix tells whether a manifest path is prefix or lies under it
+func hasPrefix(filePath, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	filePath = strings.TrimLeft(filePath, "/")
+	return filePath == prefix || strings.HasPrefix(filePath, prefix+"/")
+}
+
+// entryType returns the type of a manifest entry, older manifests leaving it
+// empty for regular files
+func entryType(file domain.ManifestFile) string {
+	if file.Type == "" {
+		return domain.NodeFile
+	}
+	return file.Type
+}
+
+// fileChanges returns what differs between two versions of a manifest
+// entry. Inode numbers and change times are left out, as they change
+// without the file itself changing.
+func fileChanges(old, new domain.ManifestFile) []string {
+	var changes []string
+	if entryType(old) != entryType(new) {
+		changes = append(changes, DiffType)
+	}
+	if !sameContent(old, new) {
+		changes = append(changes, DiffContent)
+	}
+	if old.LinkTarget != new.LinkTarget {
+		changes = append(changes, DiffLink)
+	}
+	if old.Device != new.Device {
+		changes = append(changes, DiffDevice)
+	}
+	if !old.ModTime.Equal(new.ModTime) {
+		changes = append(changes, DiffModTime)
+	}
+	if old.Mode != new.Mode {
+		changes = append(changes, DiffMode)
+	}
+	if !sameID(old.UID, new.UID) || !sameID(old.GID, new.GID) {
+		changes = append(changes, DiffOwner)
+	}
+	if !maps.EqualFunc(old.Xattrs, new.Xattrs, bytes.Equal) {
+		changes = append(changes, DiffXattrs)
+	}
+	return changes
+}
+
+// sameContent tells whether two versions of a manifest entry hold the same
+// data. The file hashes are compared when both have one, and the chunks
+// otherwise.
+func sameContent(old, new domain.ManifestFile) bool {
+	if old.Size != new.Size || old.HardLink != new.HardLink {
+		return false
+	}
+	if old.Hash != "" || new.Hash != "" {
+		return old.Hash == new.Hash
+	}
+	return slices.Equal(old.Chunks, new.Chunks)
+}
+
+// contentChanged tells whether changes go beyond the metadata of a file
+func contentChanged(changes []string) bool {
+	for _, change := range changes {
+		switch change {
+		case DiffType, DiffContent, DiffLink, DiffDevice:
+			return true
+		}
+	}
+	return false
+}
+
+// sameID compares optional user or group IDs
+func sameID(a, b *uint32) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
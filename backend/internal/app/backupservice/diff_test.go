@@ -0,0 +1,115 @@
+package backupservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newDiffTest returns a service and backend holding snapshots 1 and 2 of
+// source 1, where docs/a.txt grew, docs/b.txt only had its mode changed,
+// docs/old.txt was removed, docs/new.txt and photos/x.jpg were added, and
+// notes.txt is unchanged. Snapshot 3 belongs to another source and snapshot
+// 4 failed.
+func newDiffTest(t *testing.T) (*Service, *memoryBackend) {
+	backend := newMemoryBackend()
+	backend.manifests["1"] = []byte(`{"snapshot_id":1,"files":[
+		{"path":"docs","type":"dir","mod_time":"2025-01-20T10:00:00Z"},
+		{"path":"docs/a.txt","size":10,"hash":"a1","mod_time":"2025-01-20T10:00:00Z"},
+		{"path":"docs/b.txt","size":5,"hash":"b1","mode":420,"mod_time":"2025-01-20T10:00:00Z"},
+		{"path":"docs/old.txt","size":7,"hash":"o1","mod_time":"2025-01-20T10:00:00Z"},
+		{"path":"notes.txt","size":3,"hash":"n1","mod_time":"2025-01-20T10:00:00Z"}
+	]}`)
+	backend.manifests["2"] = []byte(`{"snapshot_id":2,"files":[
+		{"path":"docs","type":"dir","mod_time":"2025-01-20T10:00:00Z"},
+		{"path":"docs/a.txt","type":"file","size":25,"hash":"a2","mod_time":"2025-01-21T10:00:00Z"},
+		{"path":"docs/b.txt","type":"file","size":5,"hash":"b1","mode":384,"mod_time":"2025-01-20T10:00:00Z"},
+		{"path":"docs/new.txt","type":"file","size":4,"hash":"w1","mod_time":"2025-01-21T10:00:00Z"},
+		{"path":"notes.txt","type":"file","size":3,"hash":"n1","mod_time":"2025-01-20T10:00:00Z","inode":42},
+		{"path":"photos/x.jpg","type":"file","size":100,"hash":"x1","mod_time":"2025-01-21T10:00:00Z"}
+	],"errors":[{"path":"docs/old.txt","reason":"permission denied"}]}`)
+
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	mockSnapshotRepo.On("GetByID", mock.Anything, int64(1)).Return(&domain.Snapshot{ID: 1, SourceID: 1, TargetID: 2, Status: "success"}, nil)
+	mockSnapshotRepo.On("GetByID", mock.Anything, int64(2)).Return(&domain.Snapshot{ID: 2, SourceID: 1, TargetID: 2, Status: "partial"}, nil)
+	mockSnapshotRepo.On("GetByID", mock.Anything, int64(3)).Return(&domain.Snapshot{ID: 3, SourceID: 5, TargetID: 2, Status: "success"}, nil)
+	mockSnapshotRepo.On("GetByID", mock.Anything, int64(4)).Return(&domain.Snapshot{ID: 4, SourceID: 1, TargetID: 2, Status: "failed"}, nil)
+	mockSnapshotRepo.On("GetByID", mock.Anything, int64(9)).Return(nil, domain.ErrNotFound)
+	service := New(new(MockSourceRepository), new(MockTargetRepository), mockSnapshotRepo, new(MockJobRepository), newMemoryChunkRepo(), nil, zap.NewNop())
+	return service, backend
+}
+
+func TestBackupService_DiffSnapshots(t *testing.T) {
+	service, backend := newDiffTest(t)
+
+	diff, err := service.DiffSnapshots(context.Background(), 1, 2, backend, backend, "")
+	require.NoError(t, err)
+	assert.Equal(t, []*DiffEntry{
+		{Path: "docs/new.txt", Type: domain.NodeFile, NewSize: 4, SizeDelta: 4},
+		{Path: "photos/x.jpg", Type: domain.NodeFile, NewSize: 100, SizeDelta: 100},
+	}, diff.Added)
+	assert.Equal(t, []*DiffEntry{
+		{Path: "docs/old.txt", Type: domain.NodeFile, OldSize: 7, SizeDelta: -7, Unreadable: true},
+	}, diff.Removed)
+	assert.Equal(t, []*DiffEntry{
+		{Path: "docs/a.txt", Type: domain.NodeFile, OldSize: 10, NewSize: 25, SizeDelta: 15, Changes: []string{DiffContent, DiffModTime}},
+	}, diff.Modified)
+	assert.Equal(t, []*DiffEntry{
+		{Path: "docs/b.txt", Type: domain.NodeFile, OldSize: 5, NewSize: 5, Changes: []string{DiffMode}},
+	}, diff.MetadataChanged)
+	assert.Equal(t, DiffSummary{
+		Added:           2,
+		Removed:         1,
+		Modified:        1,
+		MetadataChanged: 1,
+		Unchanged:       2, // The directory, and notes.txt whose inode is ignored
+		BytesAdded:      104,
+		BytesRemoved:    7,
+		SizeDelta:       112,
+	}, diff.Summary)
+
+	// The other way round
+	diff, err = service.DiffSnapshots(context.Background(), 2, 1, backend, backend, "")
+	require.NoError(t, err)
+	assert.Len(t, diff.Added, 1)
+	assert.Len(t, diff.Removed, 2)
+	assert.Equal(t, int64(-112), diff.Summary.SizeDelta)
+}
+
+func TestBackupService_DiffSnapshotsPrefix(t *testing.T) {
+	service, backend := newDiffTest(t)
+
+	for _, prefix := range []string{"docs", "/docs/", "./docs"} {
+		diff, err := service.DiffSnapshots(context.Background(), 1, 2, backend, backend, prefix)
+		require.NoError(t, err)
+		assert.Equal(t, "docs", diff.Prefix)
+		assert.Equal(t, 1, diff.Summary.Added, prefix)
+		assert.Equal(t, 1, diff.Summary.Unchanged, prefix) // The directory itself
+		assert.Equal(t, int64(12), diff.Summary.SizeDelta, prefix)
+	}
+
+	// Only whole path components match
+	diff, err := service.DiffSnapshots(context.Background(), 1, 2, backend, backend, "doc")
+	require.NoError(t, err)
+	assert.Empty(t, diff.Added)
+	assert.Empty(t, diff.Modified)
+	assert.Zero(t, diff.Summary.Unchanged)
+}
+
+func TestBackupService_DiffSnapshotsRejectsOtherSnapshots(t *testing.T) {
+	service, backend := newDiffTest(t)
+
+	_, err := service.DiffSnapshots(context.Background(), 1, 3, backend, backend, "")
+	assert.ErrorIs(t, err, domain.ErrInvalidInput)
+
+	_, err = service.DiffSnapshots(context.Background(), 1, 4, backend, backend, "")
+	assert.ErrorIs(t, err, domain.ErrInvalidInput)
+
+	_, err = service.DiffSnapshots(context.Background(), 9, 1, backend, backend, "")
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+}
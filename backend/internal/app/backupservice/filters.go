@@ -0,0 +1,82 @@
+package backupservice
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"go.uber.org/zap"
+)
+
+// day is the unit of the age filters
+const day = 24 * time.Hour
+
+// sourceFilter applies the filters of a source to the entries of a backup
+type sourceFilter struct {
+	filters  domain.SourceFilters
+	includes *ignoreMatcher // nil when every file is included
+	types    map[string]bool
+	now      time.Time // Reference for the age filters
+}
+
+// newSourceFilter prepares the filters of a source, logging and skipping
+// invalid include patterns
+func (s *Service) newSourceFilter(ctx context.Context, source *domain.Source, now time.Time) *sourceFilter {
+	f := &sourceFilter{filters: source.Filters, now: now}
+
+	if len(source.Filters.Includes) > 0 {
+		matcher, errs := newIgnoreMatcher(source.Filters.Includes)
+		for _, err := range errs {
+			s.log(ctx).Warn("invalid include pattern", zap.Int64("source_id", source.ID), zap.Error(err))
+		}
+		f.includes = matcher
+	}
+
+	if len(source.Filters.Types) > 0 {
+		f.types = make(map[string]bool, len(source.Filters.Types))
+		for _, t := range source.Filters.Types {
+			f.types[t] = true
+		}
+	}
+
+	return f
+}
+
+// skip returns the name of the first filter rejecting an entry, or "" when
+// it is backed up. Directories are never filtered so their content is walked.
+func (f *sourceFilter) skip(relPath string, info os.FileInfo) string {
+	if info.IsDir() {
+		return ""
+	}
+
+	if f.types != nil && !f.types[nodeType(info.Mode())] {
+		return domain.FilterType
+	}
+	if f.includes != nil {
+		if included, _ := f.includes.excluded(relPath, false); !included {
+			return domain.FilterInclude
+		}
+	}
+
+	if !info.Mode().IsRegular() {
+		return ""
+	}
+
+	if f.filters.MinSize > 0 && info.Size() < f.filters.MinSize {
+		return domain.FilterMinSize
+	}
+	if f.filters.MaxSize > 0 && info.Size() > f.filters.MaxSize {
+		return domain.FilterMaxSize
+	}
+
+	age := f.now.Sub(info.ModTime())
+	if f.filters.MinAgeDays > 0 && age < time.Duration(f.filters.MinAgeDays)*day {
+		return domain.FilterMinAge
+	}
+	if f.filters.MaxAgeDays > 0 && age > time.Duration(f.filters.MaxAgeDays)*day {
+		return domain.FilterMaxAge
+	}
+
+	return ""
+}
@@ -0,0 +1,117 @@
+package backupservice
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestBackupService_FiltersSkipFiles(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	files := []struct {
+		path    string
+		size    int
+		modTime time.Time
+	}{
+		{"report.pdf", 2000, now},
+		{"docs/letter.docx", 1500, now.Add(-10 * day)},
+		{"docs/huge.pdf", 10000, now},
+		{"docs/tiny.pdf", 10, now},
+		{"archive/old.pdf", 2000, now.Add(-400 * day)},
+		{"notes.txt", 2000, now},
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, filepath.FromSlash(file.path))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, randomData(file.size, 1), 0644))
+		require.NoError(t, os.Chtimes(path, file.modTime, file.modTime))
+	}
+	require.NoError(t, os.Symlink("report.pdf", filepath.Join(dir, "latest.pdf")))
+
+	mockSourceRepo := new(MockSourceRepository)
+	mockTargetRepo := new(MockTargetRepository)
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	service := New(mockSourceRepo, mockTargetRepo, mockSnapshotRepo, new(MockJobRepository), newMemoryChunkRepo(), nil, zap.NewNop())
+
+	targetID := int64(2)
+	mockSourceRepo.On("GetByID", mock.Anything, int64(1)).Return(&domain.Source{
+		ID:       1,
+		Path:     dir,
+		TargetID: &targetID,
+		Filters: domain.SourceFilters{
+			Includes:   []string{"*.pdf", "*.docx"},
+			MinSize:    100,
+			MaxSize:    5000,
+			MaxAgeDays: 90,
+			Types:      []string{domain.NodeFile},
+		},
+	}, nil)
+	mockTargetRepo.On("GetByID", mock.Anything, targetID).Return(&domain.Target{ID: targetID, ConfigJSON: `{}`}, nil)
+	mockSnapshotRepo.On("GetBySourceID", mock.Anything, int64(1)).Return([]*domain.Snapshot{}, nil)
+	mockSnapshotRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+	var snapshot domain.Snapshot
+	mockSnapshotRepo.On("Update", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		snapshot = *args.Get(1).(*domain.Snapshot)
+	}).Return(nil)
+
+	backend := newMemoryBackend()
+	require.NoError(t, service.RunBackup(context.Background(), 1, backend))
+
+	assert.Equal(t, "success", snapshot.Status)
+	assert.Equal(t, 2, snapshot.FileCount)
+	assert.Equal(t, map[string]int{
+		domain.FilterType:    1,
+		domain.FilterInclude: 1,
+		domain.FilterMinSize: 1,
+		domain.FilterMaxSize: 1,
+		domain.FilterMaxAge:  1,
+	}, snapshot.FilterSkips)
+
+	data, err := backend.LoadManifest(context.Background(), "1")
+	require.NoError(t, err)
+	var manifest domain.Manifest
+	require.NoError(t, json.Unmarshal(data, &manifest))
+
+	var paths []string
+	for _, file := range manifest.Files {
+		if file.Type != domain.NodeDir {
+			paths = append(paths, file.Path)
+		}
+	}
+	sort.Strings(paths)
+	assert.Equal(t, []string{"docs/letter.docx", "report.pdf"}, paths)
+}
+
+func TestSourceFilter_MinAge(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	path := filepath.Join(dir, "file.txt")
+	require.NoError(t, os.WriteFile(path, []byte("content"), 0644))
+	require.NoError(t, os.Chtimes(path, now.Add(-2*day), now.Add(-2*day)))
+	info, err := os.Lstat(path)
+	require.NoError(t, err)
+
+	service := New(new(MockSourceRepository), new(MockTargetRepository), new(MockSnapshotRepository), new(MockJobRepository), newMemoryChunkRepo(), nil, zap.NewNop())
+
+	filter := service.newSourceFilter(context.Background(), &domain.Source{Filters: domain.SourceFilters{MinAgeDays: 7}}, now)
+	assert.Equal(t, domain.FilterMinAge, filter.skip("file.txt", info))
+
+	filter = service.newSourceFilter(context.Background(), &domain.Source{Filters: domain.SourceFilters{MinAgeDays: 1}}, now)
+	assert.Empty(t, filter.skip("file.txt", info))
+
+	// Without filters, everything is backed up
+	filter = service.newSourceFilter(context.Background(), &domain.Source{}, now)
+	assert.Empty(t, filter.skip("file.txt", info))
+}
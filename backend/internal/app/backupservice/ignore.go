@@ -0,0 +1,306 @@
+package backupservice
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"go.uber.org/zap"
+)
+
+// IgnoreFileName is the per-directory file holding exclusion rules, with the
+// same syntax as .gitignore
+const IgnoreFileName = ".savesyncignore"
+
+// ignoreRule is a single gitignore-style pattern
+type ignoreRule struct {
+	pattern string // As written, for reporting
+	origin  string // Where the rule comes from: "source" or an ignore file path
+	line    int    // Line in the ignore file, or index in the source exclusions (1-based)
+	base    string // Directory the rule is relative to, "" for the source root
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// ExclusionMatch describes the rule deciding whether a path is excluded
+type ExclusionMatch struct {
+	Path     string `json:"path"`
+	Excluded bool   `json:"excluded"`
+	Pattern  string `json:"pattern,omitempty"`
+	Origin   string `json:"origin,omitempty"` // "source" or the ignore file declaring the rule
+	Line     int    `json:"line,omitempty"`
+}
+
+// ignoreMatcher applies gitignore rules to slash-separated paths relative to
+// the source root. As with git, the last matching rule wins, and a file
+// inside an excluded directory can't be re-included since the directory is
+// never walked.
+type ignoreMatcher struct {
+	rules []ignoreRule
+}
+
+// newIgnoreMatcher creates a matcher from the exclusions of a source. Invalid
+// patterns are returned as errors alongside the matcher, which ignores them.
+func newIgnoreMatcher(exclusions []string) (*ignoreMatcher, []error) {
+	m := &ignoreMatcher{}
+	var errs []error
+	for i, pattern := range exclusions {
+		if err := m.add(pattern, "source", i+1, ""); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return m, errs
+}
+
+// addIgnoreFile adds the rules of an ignore file found in dir, a path
+// relative to the source root ("" for the root itself)
+func (m *ignoreMatcher) addIgnoreFile(dir string, data []byte) []error {
+	origin := IgnoreFileName
+	if dir != "" {
+		origin = dir + "/" + IgnoreFileName
+	}
+
+	var errs []error
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		if err := m.add(scanner.Text(), origin, line, dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// loadIgnoreFile reads the ignore file of a directory, if there is one
+func (m *ignoreMatcher) loadIgnoreFile(dirPath, relDir string) ([]error, error) {
+	data, err := os.ReadFile(filepath.Join(dirPath, IgnoreFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return m.addIgnoreFile(relDir, data), nil
+}
+
+// add parses a single pattern line
+func (m *ignoreMatcher) add(line, origin string, lineNo int, base string) error {
+	pattern := strings.TrimRight(strings.TrimSuffix(line, "\r"), " ")
+	if strings.HasSuffix(pattern, "\\") && strings.HasSuffix(line, " ") {
+		pattern += " " // An escaped trailing space is kept
+	}
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil
+	}
+
+	rule := ignoreRule{pattern: pattern, origin: origin, line: lineNo, base: base}
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, "\\!") || strings.HasPrefix(pattern, "\\#") {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return nil
+	}
+
+	// A slash anywhere but at the end anchors the pattern to its base
+	// directory; otherwise it matches a name at any depth
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	body, err := globToRegexp(pattern)
+	if err != nil {
+		return fmt.Errorf("%w: %s:%d: %q: %v", domain.ErrInvalidInput, origin, lineNo, rule.pattern, err)
+	}
+	if !anchored {
+		body = "(?:.*/)?" + body
+	}
+	rule.re = regexp.MustCompile("^" + body + "$")
+
+	m.rules = append(m.rules, rule)
+	return nil
+}
+
+// match returns the last rule matching relPath, or nil when none does
+func (m *ignoreMatcher) match(relPath string, isDir bool) *ignoreRule {
+	relPath = filepath.ToSlash(relPath)
+	for i := len(m.rules) - 1; i >= 0; i-- {
+		rule := &m.rules[i]
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		target := relPath
+		if rule.base != "" {
+			if !strings.HasPrefix(relPath, rule.base+"/") {
+				continue
+			}
+			target = relPath[len(rule.base)+1:]
+		}
+
+		if rule.re.MatchString(target) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// excluded reports whether relPath itself is excluded by the rules
+func (m *ignoreMatcher) excluded(relPath string, isDir bool) (bool, *ignoreRule) {
+	rule := m.match(relPath, isDir)
+	return rule != nil && !rule.negate, rule
+}
+
+// explain evaluates relPath the way a backup would: each parent directory is
+// checked first, since an excluded directory hides everything below it, and
+// enter is called for each directory walked into, the root being ""
+func (m *ignoreMatcher) explain(relPath string, isDir bool, enter func(dir string) error) (*ExclusionMatch, error) {
+	parts := strings.Split(relPath, "/")
+
+	result := &ExclusionMatch{Path: relPath}
+	for i := range parts {
+		if err := enter(strings.Join(parts[:i], "/")); err != nil {
+			return nil, err
+		}
+
+		last := i == len(parts)-1
+		excluded, rule := m.excluded(strings.Join(parts[:i+1], "/"), isDir || !last)
+		if rule != nil && (excluded || last) {
+			result.Excluded = excluded
+			result.Pattern = rule.pattern
+			result.Origin = rule.origin
+			result.Line = rule.line
+		}
+		if excluded {
+			break
+		}
+	}
+	return result, nil
+}
+
+// globToRegexp translates a gitignore glob to a regular expression body
+func globToRegexp(pattern string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				atStart := i == 0 || pattern[i-1] == '/'
+				atEnd := i+2 == len(pattern)
+				if atStart && atEnd {
+					sb.WriteString(".*") // "**" or "dir/**": everything below
+					i++
+					continue
+				}
+				if atStart && pattern[i+2] == '/' {
+					sb.WriteString("(?:.*/)?") // "**/": zero or more directories
+					i += 2
+					continue
+				}
+				i++ // Any other "**" behaves like "*"
+			}
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if end == 0 {
+				// "[]...]" includes a literal bracket
+				next := strings.IndexByte(pattern[i+2:], ']')
+				if next < 0 {
+					sb.WriteString(regexp.QuoteMeta("["))
+					continue
+				}
+				class = pattern[i+1 : i+2+next]
+				end = next + 1
+			}
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			class = strings.ReplaceAll(class, "\\", "\\\\")
+			if _, err := regexp.Compile("[" + class + "]"); err != nil {
+				return "", fmt.Errorf("invalid character class")
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String(), nil
+}
+
+// newSourceMatcher creates the matcher of a source from its exclusions,
+// logging and skipping invalid patterns
+func (s *Service) newSourceMatcher(ctx context.Context, source *domain.Source) *ignoreMatcher {
+	matcher, errs := newIgnoreMatcher(source.Exclusions)
+	for _, err := range errs {
+		s.log(ctx).Warn("invalid exclusion pattern", zap.Int64("source_id", source.ID), zap.Error(err))
+	}
+	return matcher
+}
+
+// loadIgnoreFile adds the ignore file of a directory to a matcher
+func (s *Service) loadIgnoreFile(ctx context.Context, matcher *ignoreMatcher, dirPath, relDir string) error {
+	errs, err := matcher.loadIgnoreFile(dirPath, relDir)
+	if err != nil {
+		return fmt.Errorf("failed to read ignore file in %q: %w", dirPath, err)
+	}
+	for _, err := range errs {
+		s.log(ctx).Warn("invalid exclusion pattern", zap.Error(err))
+	}
+	return nil
+}
+
+// TestExclusion reports whether a path relative to a source would be
+// excluded from its backups, and by which rule. Ignore files are read from
+// the source directory as a backup would.
+func (s *Service) TestExclusion(ctx context.Context, sourceID int64, relPath string, isDir bool) (*ExclusionMatch, error) {
+	relPath = path.Clean(strings.TrimPrefix(filepath.ToSlash(relPath), "/"))
+	if relPath == "." || relPath == ".." || strings.HasPrefix(relPath, "../") {
+		return nil, domain.ErrInvalidInput
+	}
+
+	source, err := s.sourceRepo.GetByID(ctx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	matcher := s.newSourceMatcher(ctx, source)
+	return matcher.explain(relPath, isDir, func(dir string) error {
+		dirPath := filepath.Join(source.Path, filepath.FromSlash(dir))
+		errs, err := matcher.loadIgnoreFile(dirPath, dir)
+		if err != nil && !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTDIR) {
+			return fmt.Errorf("failed to read ignore file in %q: %w", dirPath, err)
+		}
+		for _, err := range errs {
+			s.log(ctx).Warn("invalid exclusion pattern", zap.Error(err))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,195 @@
+package backupservice
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestIgnoreMatcher_Patterns(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		isDir    bool
+		excluded bool
+	}{
+		{"*.tmp", "a.tmp", false, true},
+		{"*.tmp", "deep/dir/a.tmp", false, true},
+		{"*.tmp", "a.tmp.txt", false, false},
+		{"/var/cache", "var/cache", true, true},
+		{"/var/cache", "home/var/cache", true, false},
+		{"var/cache", "home/var/cache", true, false},
+		{"build/**", "build/out/app.o", false, true},
+		{"build/**", "src/build/app.o", false, false},
+		{"**/logs", "logs", true, true},
+		{"**/logs", "a/b/logs", true, true},
+		{"a/**/z", "a/z", false, true},
+		{"a/**/z", "a/b/c/z", false, true},
+		{"a/**/z", "b/a/z", false, false},
+		{"node_modules/", "web/node_modules", true, true},
+		{"node_modules/", "web/node_modules", false, false},
+		{"file?.txt", "file1.txt", false, true},
+		{"file?.txt", "dir/file.txt", false, false},
+		{"*.[oa]", "lib.a", false, true},
+		{"*.[!oa]", "lib.a", false, false},
+		{"*.[!oa]", "lib.c", false, true},
+		{"*", "any/where", false, true},
+		{"src/*.go", "src/main.go", false, true},
+		{"src/*.go", "src/pkg/main.go", false, false},
+		{`\#notes`, "#notes", false, true},
+		{`\!important`, "!important", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			matcher, errs := newIgnoreMatcher([]string{tt.pattern})
+			require.Empty(t, errs)
+
+			excluded, _ := matcher.excluded(tt.path, tt.isDir)
+			assert.Equal(t, tt.excluded, excluded)
+		})
+	}
+}
+
+func TestIgnoreMatcher_NegationAndComments(t *testing.T) {
+	matcher, errs := newIgnoreMatcher([]string{"# comment", "", "*.log", "!keep.log", "[z-a]"})
+	require.Len(t, errs, 1)
+	assert.ErrorIs(t, errs[0], domain.ErrInvalidInput)
+
+	excluded, rule := matcher.excluded("debug.log", false)
+	assert.True(t, excluded)
+	assert.Equal(t, "*.log", rule.pattern)
+	assert.Equal(t, 3, rule.line)
+
+	excluded, rule = matcher.excluded("dir/keep.log", false)
+	assert.False(t, excluded)
+	assert.Equal(t, "!keep.log", rule.pattern)
+
+	excluded, rule = matcher.excluded("readme.md", false)
+	assert.False(t, excluded)
+	assert.Nil(t, rule)
+}
+
+func TestIgnoreMatcher_IgnoreFilesAreScoped(t *testing.T) {
+	matcher, _ := newIgnoreMatcher([]string{"*.bak"})
+	require.Empty(t, matcher.addIgnoreFile("", []byte("/tmp\n")))
+	require.Empty(t, matcher.addIgnoreFile("project", []byte("# Generated\n/dist\n!*.bak\n")))
+
+	excluded, _ := matcher.excluded("tmp", true)
+	assert.True(t, excluded)
+
+	excluded, rule := matcher.excluded("project/dist", true)
+	assert.True(t, excluded)
+	assert.Equal(t, "project/"+IgnoreFileName, rule.origin)
+	assert.Equal(t, 2, rule.line)
+
+	// Rules of a directory only apply below it
+	excluded, _ = matcher.excluded("dist", true)
+	assert.False(t, excluded)
+
+	// Deeper ignore files override the source exclusions
+	excluded, _ = matcher.excluded("project/old.bak", false)
+	assert.False(t, excluded)
+	excluded, _ = matcher.excluded("other/old.bak", false)
+	assert.True(t, excluded)
+}
+
+func TestBackupService_ExclusionsPruneDirectories(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"keep.txt":                 "keep",
+		"debug.log":                "log",
+		"important.log":            "log",
+		"build/out/app.o":          "binary",
+		"src/main.go":              "package main",
+		"src/.savesyncignore":      "*.gen.go\n",
+		"src/types.gen.go":         "generated",
+		"src/vendor/lib/lib.go":    "vendored",
+		"docs/build/index.html":    "docs",
+		"docs/.savesyncignore":     "# nothing excluded\n",
+		"cache/.savesyncignore":    "!important\n",
+		"cache/important":          "still excluded",
+		"nested/var/cache/file.db": "kept",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	source := &domain.Source{ID: 1, Path: dir, Exclusions: []string{"*.log", "!important.log", "/build/", "src/vendor", "/cache"}}
+	manifest, err := runTestSourceBackup(t, context.Background(), source, `{}`, newMemoryBackend(), []*domain.Snapshot{})
+	require.NoError(t, err)
+
+	var paths []string
+	for _, file := range manifest.Files {
+		if file.Type != domain.NodeDir {
+			paths = append(paths, file.Path)
+		}
+	}
+	sort.Strings(paths)
+	assert.Equal(t, []string{
+		"docs/.savesyncignore",
+		"docs/build/index.html",
+		"important.log",
+		"keep.txt",
+		"nested/var/cache/file.db",
+		"src/.savesyncignore",
+		"src/main.go",
+	}, paths)
+}
+
+func TestBackupService_TestExclusion(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "web"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "web", IgnoreFileName), []byte("\n!debug.log\n/dist/\n"), 0644))
+
+	mockSourceRepo := new(MockSourceRepository)
+	mockSourceRepo.On("GetByID", mock.Anything, int64(1)).Return(&domain.Source{
+		ID:         1,
+		Path:       dir,
+		Exclusions: []string{"*.log", "node_modules/"},
+	}, nil)
+	mockSourceRepo.On("GetByID", mock.Anything, int64(2)).Return(nil, domain.ErrNotFound)
+	service := New(mockSourceRepo, new(MockTargetRepository), new(MockSnapshotRepository), new(MockJobRepository), newMemoryChunkRepo(), nil, zap.NewNop())
+	ctx := context.Background()
+
+	match, err := service.TestExclusion(ctx, 1, "logs/app.log", false)
+	require.NoError(t, err)
+	assert.Equal(t, &ExclusionMatch{Path: "logs/app.log", Excluded: true, Pattern: "*.log", Origin: "source", Line: 1}, match)
+
+	match, err = service.TestExclusion(ctx, 1, "web/debug.log", false)
+	require.NoError(t, err)
+	assert.False(t, match.Excluded)
+	assert.Equal(t, "web/"+IgnoreFileName, match.Origin)
+	assert.Equal(t, 2, match.Line)
+
+	// Files below an excluded directory report the directory rule
+	match, err = service.TestExclusion(ctx, 1, "/web/node_modules/react/index.js", false)
+	require.NoError(t, err)
+	assert.True(t, match.Excluded)
+	assert.Equal(t, "web/node_modules/react/index.js", match.Path)
+	assert.Equal(t, "node_modules/", match.Pattern)
+
+	match, err = service.TestExclusion(ctx, 1, "web/dist", true)
+	require.NoError(t, err)
+	assert.True(t, match.Excluded)
+
+	match, err = service.TestExclusion(ctx, 1, "README.md", false)
+	require.NoError(t, err)
+	assert.Equal(t, &ExclusionMatch{Path: "README.md"}, match)
+
+	_, err = service.TestExclusion(ctx, 1, "../etc/passwd", false)
+	assert.Equal(t, domain.ErrInvalidInput, err)
+
+	_, err = service.TestExclusion(ctx, 2, "file", false)
+	assert.Equal(t, domain.ErrNotFound, err)
+}
@@ -0,0 +1,47 @@
+package backupservice
+
+import (
+	"context"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"go.uber.org/zap"
+)
+
+// loadParent finds the latest completed snapshot of a source on the given
+// target and returns it with its manifest files indexed by path. Any failure
+// is logged and results in a full scan rather than a failed backup.
+func (s *Service) loadParent(ctx context.Context, sourceID, targetID int64, backend domain.Backend) (*domain.Snapshot, map[string]domain.ManifestFile) {
+	snapshots, err := s.snapshotRepo.GetBySourceID(ctx, sourceID)
+	if err != nil {
+		s.log(ctx).Warn("failed to list snapshots, running full scan", zap.Error(err), zap.Int64("source_id", sourceID))
+		return nil, nil
+	}
+
+	// Snapshots are ordered newest first. Chunks only exist on the target
+	// they were written to, so snapshots of other targets can't be reused.
+	var parent *domain.Snapshot
+	for _, snapshot := range snapshots {
+		if snapshot.Completed() && snapshot.TargetID == targetID {
+			parent = snapshot
+			break
+		}
+	}
+	if parent == nil {
+		return nil, nil
+	}
+
+	manifest, err := loadManifest(ctx, backend, parent.ID)
+	if err != nil {
+		s.log(ctx).Warn("failed to load parent manifest, running full scan", zap.Error(err), zap.Int64("parent_id", parent.ID))
+		return nil, nil
+	}
+
+	files := make(map[string]domain.ManifestFile, len(manifest.Files))
+	for _, file := range manifest.Files {
+		if isRegularFile(file) {
+			files[file.Path] = file
+		}
+	}
+
+	return parent, files
+}
@@ -0,0 +1,76 @@
+package backupservice
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingBackend counts the chunk lookups made against a memoryBackend
+type countingBackend struct {
+	*memoryBackend
+	lookups atomic.Int64
+}
+
+func (b *countingBackend) ChunkExists(ctx context.Context, hash string) (bool, error) {
+	b.lookups.Add(1)
+	return b.memoryBackend.ChunkExists(ctx, hash)
+}
+
+func TestBackupService_IncrementalReusesUnchangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("first file"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.txt"), []byte("second file"), 0644))
+
+	backend := &countingBackend{memoryBackend: newMemoryBackend()}
+	first, err := runTestBackup(t, context.Background(), dir, `{}`, backend)
+	require.NoError(t, err)
+	assert.Nil(t, first.ParentID)
+	assert.Equal(t, int64(2), backend.lookups.Load())
+
+	history := []*domain.Snapshot{{ID: 1, Status: "success", TargetID: 2}}
+
+	// Nothing changed: no file is read, so no chunk is looked up
+	backend.lookups.Store(0)
+	second, err := runTestBackupWithHistory(t, context.Background(), dir, `{}`, backend, history)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), backend.lookups.Load())
+	require.NotNil(t, second.ParentID)
+	assert.Equal(t, int64(1), *second.ParentID)
+	assert.Equal(t, first.Files[0].Hash, second.Files[0].Hash)
+	assert.Equal(t, first.Files[1].Chunks, second.Files[1].Chunks)
+
+	// Only the modified file is read again
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.txt"), []byte("second file, modified"), 0644))
+	backend.lookups.Store(0)
+	third, err := runTestBackupWithHistory(t, context.Background(), dir, `{}`, backend, history)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), backend.lookups.Load())
+	assert.Equal(t, sha256Hex([]byte("second file, modified")), third.Files[1].Hash)
+}
+
+func TestBackupService_IncrementalIgnoresOtherTargets(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("content"), 0644))
+
+	backend := &countingBackend{memoryBackend: newMemoryBackend()}
+	_, err := runTestBackup(t, context.Background(), dir, `{}`, backend)
+	require.NoError(t, err)
+
+	// The only successful snapshot lives on another target
+	history := []*domain.Snapshot{
+		{ID: 1, Status: "failed", TargetID: 2},
+		{ID: 1, Status: "success", TargetID: 3},
+	}
+	backend.lookups.Store(0)
+	manifest, err := runTestBackupWithHistory(t, context.Background(), dir, `{}`, backend, history)
+	require.NoError(t, err)
+	assert.Nil(t, manifest.ParentID)
+	assert.Equal(t, int64(1), backend.lookups.Load())
+}
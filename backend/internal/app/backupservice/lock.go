@@ -0,0 +1,101 @@
+package backupservice
+
+import (
+	"context"
+	"sync"
+)
+
+// repoLocks guards the repository of each target. Backups share it, while a
+// prune holds it alone so that no backup can reuse a chunk it is deleting.
+// A prune waiting for the lock keeps new backups out, so that a steady flow
+// of backups can't starve it. The locks only cover this process: a single
+// daemon must own the database.
+type repoLocks struct {
+	mu    sync.Mutex
+	locks map[int64]*repoLock
+}
+
+// repoLock is the state of the lock of one target
+type repoLock struct {
+	readers int           // Backups holding the lock
+	writer  bool          // Whether a prune holds the lock
+	waiting int           // Prunes waiting for the lock
+	changed chan struct{} // Closed and replaced whenever the state changes
+}
+
+func newRepoLocks() *repoLocks {
+	return &repoLocks{locks: make(map[int64]*repoLock)}
+}
+
+// acquire takes the lock of a target, shared or exclusive, waiting until it
+// is free or ctx is done. onWait, if set, is called once when the lock can't
+// be taken right away. The returned function releases the lock.
+func (l *repoLocks) acquire(ctx context.Context, targetID int64, exclusive bool, onWait func()) (release func(), err error) {
+	l.mu.Lock()
+	lock, ok := l.locks[targetID]
+	if !ok {
+		lock = &repoLock{changed: make(chan struct{})}
+		l.locks[targetID] = lock
+	}
+
+	busy := func() bool {
+		if exclusive {
+			return lock.writer || lock.readers > 0
+		}
+		return lock.writer || lock.waiting > 0
+	}
+
+	if exclusive {
+		lock.waiting++
+	}
+	for waited := false; busy(); waited = true {
+		changed := lock.changed
+		l.mu.Unlock()
+		if !waited && onWait != nil {
+			onWait()
+		}
+
+		select {
+		case <-changed:
+			l.mu.Lock()
+		case <-ctx.Done():
+			if exclusive {
+				// Backups held back by this prune may go on
+				l.mu.Lock()
+				lock.waiting--
+				lock.broadcast()
+				l.mu.Unlock()
+			}
+			return nil, ctx.Err()
+		}
+	}
+
+	if exclusive {
+		lock.waiting--
+		lock.writer = true
+	} else {
+		lock.readers++
+	}
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if exclusive {
+				lock.writer = false
+			} else {
+				lock.readers--
+			}
+			lock.broadcast()
+		})
+	}, nil
+}
+
+// broadcast wakes up every waiter of the lock. It must be called with the
+// mutex of repoLocks held.
+func (l *repoLock) broadcast() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
@@ -0,0 +1,152 @@
+package backupservice
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/axelfrache/savesync/internal/domain"
+)
+
+// storeManifest streams the manifest of a snapshot to the backend, so that
+// its JSON is never held whole in memory
+func storeManifest(ctx context.Context, backend domain.Backend, manifest *domain.Manifest) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeManifest(pw, manifest))
+	}()
+
+	err := backend.PutObject(ctx, domain.ManifestKey(strconv.FormatInt(manifest.SnapshotID, 10)), pr, -1)
+	// Unblocks the encoder if the upload stopped early
+	pr.CloseWithError(err)
+	return err
+}
+
+// loadManifest streams the manifest of a snapshot from the backend
+func loadManifest(ctx context.Context, backend domain.Backend, snapshotID int64) (*domain.Manifest, error) {
+	body, err := backend.GetObject(ctx, domain.ManifestKey(strconv.FormatInt(snapshotID, 10)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve manifest: %w", err)
+	}
+	defer body.Close()
+
+	manifest, err := readManifest(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// writeManifest encodes a manifest to w. The files, which make up most of a
+// manifest, are encoded one at a time in place of an empty list.
+func writeManifest(w io.Writer, manifest *domain.Manifest) error {
+	header := *manifest
+	header.Files = []domain.ManifestFile{}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	// Quotes are escaped within strings, so this can only be the key
+	before, after, found := bytes.Cut(headerJSON, []byte(`"files":[]`))
+	if !found {
+		return fmt.Errorf("files missing from the encoded manifest")
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.Write(before)
+	bw.WriteString(`"files":[`)
+	for i := range manifest.Files {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		fileJSON, err := json.Marshal(&manifest.Files[i])
+		if err != nil {
+			return err
+		}
+		bw.Write(fileJSON)
+	}
+	bw.WriteByte(']')
+	bw.Write(after)
+	return bw.Flush()
+}
+
+// readManifest decodes a manifest from r, one file at a time, so that the
+// JSON is never held whole in memory
+func readManifest(r io.Reader) (*domain.Manifest, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	// Fields other than the files are small and decoded at the end
+	fields := make(map[string]json.RawMessage)
+	var files []domain.ManifestFile
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := token.(string)
+
+		if key != "files" {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return nil, err
+			}
+			fields[key] = raw
+			continue
+		}
+
+		token, err = dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if token == nil {
+			continue // No files
+		}
+		if token != json.Delim('[') {
+			return nil, fmt.Errorf("files of the manifest are not a list")
+		}
+		for dec.More() {
+			var file domain.ManifestFile
+			if err := dec.Decode(&file); err != nil {
+				return nil, err
+			}
+			files = append(files, file)
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return nil, err
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+
+	headerJSON, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var manifest domain.Manifest
+	if err := json.Unmarshal(headerJSON, &manifest); err != nil {
+		return nil, err
+	}
+	manifest.Files = files
+	return &manifest, nil
+}
+
+// expectDelim reads the next token of dec, which must be delim
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %q in manifest, found %v", delim, token)
+	}
+	return nil
+}
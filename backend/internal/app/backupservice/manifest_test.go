@@ -0,0 +1,89 @@
+package backupservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestEncoding(t *testing.T) {
+	parentID := int64(3)
+	manifest := &domain.Manifest{
+		SnapshotID: 4,
+		SourcePath: `/data/"files":[]`,
+		ParentID:   &parentID,
+		Chunker:    &domain.ChunkerParams{Algorithm: "fastcdc", MinSize: 1, AvgSize: 2, MaxSize: 4},
+		Files: []domain.ManifestFile{
+			{Path: "/data/a", Size: 10, Chunks: []string{"aa", "bb"}},
+			{Path: "/data/b", Size: 0},
+		},
+		Errors:    []domain.FileError{{Path: "/data/c", Reason: "permission denied"}},
+		CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeManifest(&buf, manifest))
+
+	// The streamed encoding is plain JSON
+	var decoded domain.Manifest
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, manifest, &decoded)
+
+	read, err := readManifest(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, manifest, read)
+
+	// Manifests written in one piece, or without files, read the same
+	for _, m := range []*domain.Manifest{manifest, {SnapshotID: 5, CreatedAt: manifest.CreatedAt}} {
+		encoded, err := json.Marshal(m)
+		require.NoError(t, err)
+		read, err := readManifest(bytes.NewReader(encoded))
+		require.NoError(t, err)
+		assert.Equal(t, m, read)
+	}
+
+	_, err = readManifest(bytes.NewReader([]byte(`{"files":{}}`)))
+	assert.Error(t, err)
+	_, err = readManifest(bytes.NewReader([]byte(`{"files":[{"path":"a"}`)))
+	assert.Error(t, err)
+}
+
+// failingBackend is a memoryBackend whose uploads fail after reading a few
+// bytes
+type failingBackend struct {
+	*memoryBackend
+}
+
+func (b *failingBackend) PutObject(ctx context.Context, key string, r io.Reader, size int64) error {
+	io.CopyN(io.Discard, r, 10)
+	return errors.New("connection reset")
+}
+
+func TestStoreManifest(t *testing.T) {
+	ctx := context.Background()
+	backend := newMemoryBackend()
+	manifest := &domain.Manifest{SnapshotID: 7, Files: make([]domain.ManifestFile, 1000)}
+	for i := range manifest.Files {
+		manifest.Files[i] = domain.ManifestFile{Path: "/data/file", Chunks: []string{"aa"}}
+	}
+
+	require.NoError(t, storeManifest(ctx, backend, manifest))
+	loaded, err := loadManifest(ctx, backend, 7)
+	require.NoError(t, err)
+	assert.Equal(t, manifest.Files, loaded.Files)
+
+	// A failed upload doesn't leave the encoder blocked
+	err = storeManifest(ctx, &failingBackend{backend}, manifest)
+	assert.EqualError(t, err, "connection reset")
+
+	_, err = loadManifest(ctx, backend, 8)
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+}
@@ -0,0 +1,127 @@
+package backupservice
+
+import (
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+)
+
+// Unix permission bits beyond rwx, as stored in manifests
+const (
+	modeSetuid = 0o4000
+	modeSetgid = 0o2000
+	modeSticky = 0o1000
+)
+
+// nodeStat holds the attributes of a file that os.FileInfo doesn't expose
+// portably. ok is false on platforms where they are not available.
+type nodeStat struct {
+	ok    bool
+	dev   uint64
+	inode uint64
+	nlink uint64
+	uid   uint32
+	gid   uint32
+	rdev  uint64
+	ctime *time.Time
+}
+
+// hardLinkKey identifies a file across the paths that link to it
+type hardLinkKey struct {
+	dev   uint64
+	inode uint64
+}
+
+// nodeType returns the manifest type of a file, or "" for types that can't
+// be backed up, such as sockets
+func nodeType(mode fs.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return domain.NodeFile
+	case mode.IsDir():
+		return domain.NodeDir
+	case mode&fs.ModeSymlink != 0:
+		return domain.NodeSymlink
+	case mode&fs.ModeNamedPipe != 0:
+		return domain.NodeFIFO
+	case mode&fs.ModeCharDevice != 0:
+		return domain.NodeCharDevice
+	case mode&fs.ModeDevice != 0:
+		return domain.NodeBlockDevice
+	default:
+		return ""
+	}
+}
+
+// isRegularFile reports whether an entry holds file content of its own
+func isRegularFile(file domain.ManifestFile) bool {
+	return (file.Type == "" || file.Type == domain.NodeFile) && file.HardLink == ""
+}
+
+// unixMode converts Go file mode permissions to Unix permission bits
+func unixMode(mode fs.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		bits |= modeSetuid
+	}
+	if mode&fs.ModeSetgid != 0 {
+		bits |= modeSetgid
+	}
+	if mode&fs.ModeSticky != 0 {
+		bits |= modeSticky
+	}
+	return bits
+}
+
+// fileMode converts Unix permission bits back to a Go file mode
+func fileMode(bits uint32) fs.FileMode {
+	mode := fs.FileMode(bits & 0o777)
+	if bits&modeSetuid != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if bits&modeSetgid != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if bits&modeSticky != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// describeNode builds the manifest entry of a file without its content
+func describeNode(path, relPath string, info os.FileInfo, stat nodeStat) (*domain.ManifestFile, error) {
+	entry := &domain.ManifestFile{
+		Path:    relPath,
+		Type:    nodeType(info.Mode()),
+		ModTime: info.ModTime(),
+		Mode:    unixMode(info.Mode()),
+	}
+
+	if stat.ok {
+		uid, gid := stat.uid, stat.gid
+		entry.UID, entry.GID = &uid, &gid
+		entry.Inode = stat.inode
+		entry.ChangeTime = stat.ctime
+	}
+
+	switch entry.Type {
+	case domain.NodeSymlink:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		entry.LinkTarget = target
+	case domain.NodeCharDevice, domain.NodeBlockDevice:
+		entry.Device = stat.rdev
+	}
+
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		return nil, err
+	}
+	entry.Xattrs = xattrs
+
+	return entry, nil
+}
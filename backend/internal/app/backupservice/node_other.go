@@ -0,0 +1,34 @@
+//go:build !linux && !darwin
+
+package backupservice
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+)
+
+// readXattrs is not supported on this platform
+func readXattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// writeXattrs is not supported on this platform
+func writeXattrs(path string, xattrs map[string][]byte) error {
+	if len(xattrs) > 0 {
+		return fmt.Errorf("extended attributes are not supported on this platform")
+	}
+	return nil
+}
+
+// makeSpecialNode is not supported on this platform
+func makeSpecialNode(path string, entry domain.ManifestFile) error {
+	return fmt.Errorf("%s nodes are not supported on this platform", entry.Type)
+}
+
+// setSymlinkTimes is not supported on this platform; symlinks keep the time
+// of their creation
+func setSymlinkTimes(path string, modTime time.Time) error {
+	return nil
+}
@@ -0,0 +1,187 @@
+//go:build linux || darwin
+
+package backupservice
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+func TestBackupService_ManifestRecordsMetadata(t *testing.T) {
+	source := t.TempDir()
+	modTime := time.Date(2025, 1, 21, 10, 0, 0, 0, time.UTC)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(source, "bin"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(source, "empty"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "bin", "tool"), []byte("#!/bin/sh\n"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "secret.txt"), []byte("secret"), 0600))
+	require.NoError(t, os.Chmod(filepath.Join(source, "bin", "tool"), 0755|os.ModeSetgid))
+	require.NoError(t, os.Link(filepath.Join(source, "secret.txt"), filepath.Join(source, "z-link.txt")))
+	require.NoError(t, os.Symlink("bin/tool", filepath.Join(source, "tool")))
+	require.NoError(t, syscall.Mkfifo(filepath.Join(source, "pipe"), 0640))
+	require.NoError(t, os.Chtimes(filepath.Join(source, "empty"), modTime, modTime))
+
+	xattrs := unix.Lsetxattr(filepath.Join(source, "secret.txt"), "user.savesync", []byte("kept"), 0) == nil
+
+	manifest, err := runTestBackup(t, context.Background(), source, `{}`, newMemoryBackend())
+	require.NoError(t, err)
+
+	entries := make(map[string]domain.ManifestFile)
+	for _, file := range manifest.Files {
+		entries[file.Path] = file
+	}
+	assert.Equal(t, domain.NodeDir, entries["empty"].Type)
+	assert.Equal(t, domain.NodeSymlink, entries["tool"].Type)
+	assert.Equal(t, "bin/tool", entries["tool"].LinkTarget)
+	assert.Equal(t, domain.NodeFIFO, entries["pipe"].Type)
+	assert.Equal(t, "secret.txt", entries["z-link.txt"].HardLink)
+	assert.Empty(t, entries["z-link.txt"].Chunks)
+	assert.Equal(t, uint32(0o2755), entries["bin/tool"].Mode)
+	require.NotNil(t, entries["secret.txt"].UID)
+	assert.Equal(t, uint32(os.Getuid()), *entries["secret.txt"].UID)
+	if xattrs {
+		assert.Equal(t, []byte("kept"), entries["secret.txt"].Xattrs["user.savesync"])
+	}
+}
+
+func TestBackupService_HardLinkToUnreadableFileTakesOver(t *testing.T) {
+	source := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(source, "a.bin"), randomData(8*1024, 1), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "b.bin"), []byte("shared"), 0644))
+	require.NoError(t, os.Link(filepath.Join(source, "b.bin"), filepath.Join(source, "c.bin")))
+	require.NoError(t, os.Link(filepath.Join(source, "b.bin"), filepath.Join(source, "d.bin")))
+
+	// As in TestBackupService_UnreadableFilesMakeSnapshotPartial, b.bin
+	// disappears before it is read, after the walker found its links
+	backend := &hookBackend{memoryBackend: newMemoryBackend(), onStore: func() {
+		os.Remove(filepath.Join(source, "b.bin"))
+	}}
+	manifest, err := runTestBackup(t, context.Background(), source, `{"chunker":"fixed","chunk_size":1024,"hash_concurrency":1,"upload_concurrency":1}`, backend)
+	require.NoError(t, err)
+
+	entries := make(map[string]domain.ManifestFile)
+	for _, file := range manifest.Files {
+		entries[file.Path] = file
+	}
+	assert.NotContains(t, entries, "b.bin")
+	assert.Empty(t, entries["c.bin"].HardLink)
+	assert.Equal(t, sha256Hex([]byte("shared")), entries["c.bin"].Hash)
+	assert.Equal(t, "c.bin", entries["d.bin"].HardLink)
+	require.Len(t, manifest.Errors, 1)
+	assert.Equal(t, "b.bin", manifest.Errors[0].Path)
+
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	mockSnapshotRepo.On("GetByID", mock.Anything, int64(1)).Return(&domain.Snapshot{ID: 1, Status: "partial"}, nil)
+	service := New(new(MockSourceRepository), new(MockTargetRepository), mockSnapshotRepo, new(MockJobRepository), newMemoryChunkRepo(), nil, zap.NewNop())
+
+	destination := t.TempDir()
+	require.NoError(t, service.RestoreSnapshot(context.Background(), 1, backend.memoryBackend, RestoreOptions{Destination: destination}))
+	c, err := os.Stat(filepath.Join(destination, "c.bin"))
+	require.NoError(t, err)
+	d, err := os.Stat(filepath.Join(destination, "d.bin"))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(c, d))
+}
+
+func TestBackupService_UnsearchableDirectoryMakesSnapshotPartial(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root bypasses directory permissions")
+	}
+	source := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(source, "keep.txt"), []byte("keep"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(source, "locked"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "locked", "file.txt"), []byte("hidden"), 0644))
+
+	// Listable but not searchable, so its ignore file can't be opened
+	require.NoError(t, os.Chmod(filepath.Join(source, "locked"), 0644))
+	t.Cleanup(func() { os.Chmod(filepath.Join(source, "locked"), 0755) })
+
+	manifest, err := runTestBackup(t, context.Background(), source, `{}`, newMemoryBackend())
+	require.NoError(t, err)
+
+	var paths []string
+	for _, file := range manifest.Files {
+		paths = append(paths, file.Path)
+	}
+	assert.Equal(t, []string{"keep.txt"}, paths)
+	require.Len(t, manifest.Errors, 1)
+	assert.Equal(t, "locked", manifest.Errors[0].Path)
+	assert.Contains(t, manifest.Errors[0].Reason, "permission denied")
+}
+
+func TestBackupService_RestoreRoundTripsTree(t *testing.T) {
+	source := t.TempDir()
+	modTime := time.Date(2025, 1, 21, 10, 0, 0, 0, time.UTC)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(source, "readonly"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "readonly", "file.txt"), []byte("content"), 0640))
+	require.NoError(t, os.Chmod(filepath.Join(source, "readonly"), 0555))
+	t.Cleanup(func() { os.Chmod(filepath.Join(source, "readonly"), 0755) })
+	require.NoError(t, os.MkdirAll(filepath.Join(source, "empty"), 0710))
+	require.NoError(t, os.Chtimes(filepath.Join(source, "empty"), modTime, modTime))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "a.txt"), []byte("shared"), 0600))
+	require.NoError(t, os.Link(filepath.Join(source, "a.txt"), filepath.Join(source, "b.txt")))
+	require.NoError(t, os.Symlink("readonly/file.txt", filepath.Join(source, "link")))
+	require.NoError(t, syscall.Mkfifo(filepath.Join(source, "pipe"), 0600))
+	require.NoError(t, os.Chmod(filepath.Join(source, "pipe"), 0620))
+	xattrs := unix.Lsetxattr(filepath.Join(source, "a.txt"), "user.savesync", []byte("kept"), 0) == nil
+
+	backend := newMemoryBackend()
+	_, err := runTestBackup(t, context.Background(), source, `{}`, backend)
+	require.NoError(t, err)
+
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	mockSnapshotRepo.On("GetByID", mock.Anything, int64(1)).Return(&domain.Snapshot{ID: 1, Status: "success"}, nil)
+	service := New(new(MockSourceRepository), new(MockTargetRepository), mockSnapshotRepo, new(MockJobRepository), newMemoryChunkRepo(), nil, zap.NewNop())
+
+	destination := t.TempDir()
+	require.NoError(t, service.RestoreSnapshot(context.Background(), 1, backend, RestoreOptions{Destination: destination}))
+	t.Cleanup(func() { os.Chmod(filepath.Join(destination, "readonly"), 0755) })
+
+	info, err := os.Stat(filepath.Join(destination, "readonly"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0555), info.Mode().Perm())
+
+	info, err = os.Stat(filepath.Join(destination, "readonly", "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	info, err = os.Stat(filepath.Join(destination, "empty"))
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.Equal(t, os.FileMode(0710), info.Mode().Perm())
+	assert.True(t, info.ModTime().Equal(modTime))
+
+	a, err := os.Stat(filepath.Join(destination, "a.txt"))
+	require.NoError(t, err)
+	b, err := os.Stat(filepath.Join(destination, "b.txt"))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(a, b))
+
+	target, err := os.Readlink(filepath.Join(destination, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "readonly/file.txt", target)
+
+	info, err = os.Lstat(filepath.Join(destination, "pipe"))
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeNamedPipe, info.Mode().Type())
+	assert.Equal(t, os.FileMode(0620), info.Mode().Perm())
+
+	if xattrs {
+		value := make([]byte, 16)
+		n, err := unix.Lgetxattr(filepath.Join(destination, "a.txt"), "user.savesync", value)
+		require.NoError(t, err)
+		assert.Equal(t, "kept", string(value[:n]))
+	}
+}
@@ -0,0 +1,92 @@
+//go:build linux || darwin
+
+package backupservice
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of a file without following
+// symlinks. Filesystems without xattr support yield none.
+func readXattrs(path string) (map[string][]byte, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list extended attributes: %w", err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	names := make([]byte, size)
+	size, err = unix.Llistxattr(path, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extended attributes: %w", err)
+	}
+
+	xattrs := make(map[string][]byte)
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := readXattr(path, string(name))
+		if err != nil {
+			return nil, err
+		}
+		xattrs[string(name)] = value
+	}
+	return xattrs, nil
+}
+
+// readXattr returns the value of a single extended attribute
+func readXattr(path, name string) ([]byte, error) {
+	size, err := unix.Lgetxattr(path, name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read extended attribute %s: %w", name, err)
+	}
+	value := make([]byte, size)
+	size, err = unix.Lgetxattr(path, name, value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read extended attribute %s: %w", name, err)
+	}
+	return value[:size], nil
+}
+
+// writeXattrs sets extended attributes on a file without following symlinks
+func writeXattrs(path string, xattrs map[string][]byte) error {
+	for name, value := range xattrs {
+		if err := unix.Lsetxattr(path, name, value, 0); err != nil {
+			return fmt.Errorf("failed to set extended attribute %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// makeSpecialNode creates a device node or FIFO
+func makeSpecialNode(path string, entry domain.ManifestFile) error {
+	perm := entry.Mode & 0o7777
+	switch entry.Type {
+	case domain.NodeFIFO:
+		return unix.Mkfifo(path, perm)
+	case domain.NodeCharDevice:
+		return unix.Mknod(path, unix.S_IFCHR|perm, int(entry.Device))
+	case domain.NodeBlockDevice:
+		return unix.Mknod(path, unix.S_IFBLK|perm, int(entry.Device))
+	default:
+		return fmt.Errorf("unsupported node type %q", entry.Type)
+	}
+}
+
+// setSymlinkTimes sets the modification time of a symlink itself
+func setSymlinkTimes(path string, modTime time.Time) error {
+	tv := unix.NsecToTimeval(modTime.UnixNano())
+	return unix.Lutimes(path, []unix.Timeval{tv, tv})
+}
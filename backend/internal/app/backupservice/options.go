@@ -0,0 +1,174 @@
+package backupservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strconv"
+
+	"github.com/axelfrache/savesync/internal/domain"
+)
+
+const (
+	// DefaultUploadConcurrency is the number of chunks uploaded in parallel by default
+	DefaultUploadConcurrency = 4
+
+	// MaxConcurrency bounds the configurable worker counts
+	MaxConcurrency = 64
+)
+
+// targetOptions holds the per-target settings that shape how data is written.
+// They live alongside the backend settings in the target's config JSON.
+type targetOptions struct {
+	chunker           domain.ChunkerParams
+	hashConcurrency   int // Files hashed and chunked in parallel
+	uploadConcurrency int // Chunks uploaded in parallel
+	compression       string
+	compressionLevel  int // 0 for the default level
+	maxFileErrors     int // Unreadable files tolerated before the backup fails, -1 for no limit
+}
+
+// parseTargetOptions extracts the backup settings from a target's config
+func parseTargetOptions(target *domain.Target) (*targetOptions, error) {
+	var config map[string]interface{}
+	if target.ConfigJSON != "" {
+		if err := json.Unmarshal([]byte(target.ConfigJSON), &config); err != nil {
+			return nil, fmt.Errorf("failed to parse target config: %w", err)
+		}
+	}
+
+	opts := &targetOptions{}
+
+	switch algorithm := configString(config, "chunker", ChunkerFastCDC); algorithm {
+	case ChunkerFastCDC:
+		defaults := DefaultChunkerParams()
+		opts.chunker.Algorithm = ChunkerFastCDC
+		var err error
+		if opts.chunker.MinSize, err = configInt(config, "chunk_min_size", defaults.MinSize); err != nil {
+			return nil, err
+		}
+		if opts.chunker.AvgSize, err = configInt(config, "chunk_avg_size", defaults.AvgSize); err != nil {
+			return nil, err
+		}
+		if opts.chunker.MaxSize, err = configInt(config, "chunk_max_size", defaults.MaxSize); err != nil {
+			return nil, err
+		}
+	case ChunkerFixed:
+		size, err := configInt(config, "chunk_size", DefaultChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		opts.chunker = LegacyChunkerParams()
+		opts.chunker.MaxSize = size
+	default:
+		return nil, fmt.Errorf("%w: unknown chunker %q", domain.ErrInvalidInput, algorithm)
+	}
+
+	var err error
+	if opts.hashConcurrency, err = configConcurrency(config, "hash_concurrency", defaultHashConcurrency()); err != nil {
+		return nil, err
+	}
+	if opts.uploadConcurrency, err = configConcurrency(config, "upload_concurrency", DefaultUploadConcurrency); err != nil {
+		return nil, err
+	}
+
+	opts.compression = configString(config, "compression", domain.CompressionNone)
+	if opts.compressionLevel, err = configInt(config, "compression_level", 0); err != nil {
+		return nil, err
+	}
+	if err := validateCompression(opts.compression, opts.compressionLevel); err != nil {
+		return nil, err
+	}
+
+	if opts.maxFileErrors, err = configInt(config, "max_file_errors", -1); err != nil {
+		return nil, err
+	}
+	if opts.maxFileErrors < -1 {
+		return nil, fmt.Errorf("%w: max_file_errors must be -1 (no limit) or more", domain.ErrInvalidInput)
+	}
+
+	return opts, nil
+}
+
+// applySource lets a source override the compression settings of its target
+func (o *targetOptions) applySource(source *domain.Source) error {
+	if source.Compression == "" {
+		return nil
+	}
+	if err := validateCompression(source.Compression, source.CompressionLevel); err != nil {
+		return err
+	}
+	o.compression = source.Compression
+	o.compressionLevel = source.CompressionLevel
+	return nil
+}
+
+// validateCompression checks a compression algorithm and level
+func validateCompression(algorithm string, level int) error {
+	switch algorithm {
+	case domain.CompressionNone, domain.CompressionZstd:
+	default:
+		return fmt.Errorf("%w: unknown compression %q", domain.ErrInvalidInput, algorithm)
+	}
+	if level < 0 || level > MaxCompressionLevel {
+		return fmt.Errorf("%w: compression_level must be between 0 and %d", domain.ErrInvalidInput, MaxCompressionLevel)
+	}
+	return nil
+}
+
+// defaultHashConcurrency returns the number of hashing workers used when a
+// target does not configure it
+func defaultHashConcurrency() int {
+	return min(runtime.NumCPU(), 4)
+}
+
+// configConcurrency reads a worker count setting
+func configConcurrency(config map[string]interface{}, key string, def int) (int, error) {
+	n, err := configInt(config, key, def)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 || n > MaxConcurrency {
+		return 0, fmt.Errorf("%w: %s must be between 1 and %d", domain.ErrInvalidInput, key, MaxConcurrency)
+	}
+	return n, nil
+}
+
+// configString reads a string setting, falling back to def when unset
+func configString(config map[string]interface{}, key, def string) string {
+	value, ok := config[key]
+	if !ok || value == nil {
+		return def
+	}
+	if str, ok := value.(string); ok && str != "" {
+		return str
+	}
+	return def
+}
+
+// configInt reads an integer setting given either as a JSON number or a string
+func configInt(config map[string]interface{}, key string, def int) (int, error) {
+	value, ok := config[key]
+	if !ok || value == nil {
+		return def, nil
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("%w: %s must be an integer", domain.ErrInvalidInput, key)
+		}
+		return int(v), nil
+	case string:
+		if v == "" {
+			return def, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s must be an integer", domain.ErrInvalidInput, key)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("%w: %s must be an integer", domain.ErrInvalidInput, key)
+	}
+}
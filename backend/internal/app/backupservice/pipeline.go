@@ -0,0 +1,577 @@
+package backupservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// fileTask is a file discovered by the walker
+type fileTask struct {
+	index    int // Position in walk order, used to keep the manifest deterministic
+	path     string
+	relPath  string
+	info     os.FileInfo
+	stat     nodeStat
+	hardLink string // Earlier path linking to the same inode, if any
+}
+
+// fileResult is the manifest entry produced for a file task
+type fileResult struct {
+	index   int
+	file    *domain.ManifestFile // nil when the file was skipped
+	reused  bool                 // Entry taken from the parent manifest
+	readErr *domain.FileError    // Why the file was skipped, if it could not be read
+}
+
+// uploadTask is a chunk that still has to be written to the backend
+type uploadTask struct {
+	hash string
+	data []byte
+}
+
+// scanResult summarizes the files written by a backup
+type scanResult struct {
+	files       []domain.ManifestFile
+	fileCount   int // Regular files, as opposed to every manifest entry
+	totalBytes  int64
+	deltaBytes  int64              // New bytes before compression
+	storedBytes int64              // New bytes written to the backend
+	reusedFiles int                // Files taken from the parent manifest without being read
+	filterSkips map[string]int     // Files skipped by each source filter
+	fileErrors  []domain.FileError // Files which could not be read, by path
+}
+
+// backupRun holds the state shared by the workers of a single backup
+type backupRun struct {
+	service    *Service
+	source     *domain.Source
+	backend    domain.Backend
+	chunker    *Chunker
+	compressor *compressor
+	opts       *targetOptions
+	parent     map[string]domain.ManifestFile // Files of the parent snapshot by path, nil for a full scan
+	inflight   *inflightChunks
+	stored     *storedChunks
+	progress   *Progress   // nil when nobody follows the backup
+	logger     *zap.Logger // Logger of the job running the backup
+}
+
+// fatalError marks a failure that aborts the backup, such as a backend error
+// or a cancellation, as opposed to read errors which only skip the file
+type fatalError struct {
+	err error
+}
+
+func (e *fatalError) Error() string { return e.err.Error() }
+
+func (e *fatalError) Unwrap() error { return e.err }
+
+// inflightChunks tracks chunks queued for upload by the current backup, so
+// two files sharing a chunk don't upload it twice. Entries are released once
+// stored; from then on the backend's ChunkExists answers for them, which keeps
+// the set bounded by the number of chunks in flight.
+type inflightChunks struct {
+	mu     sync.Mutex
+	hashes map[string]struct{}
+}
+
+func (c *inflightChunks) claim(hash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.hashes[hash]; ok {
+		return false
+	}
+	c.hashes[hash] = struct{}{}
+	return true
+}
+
+func (c *inflightChunks) release(hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.hashes, hash)
+}
+
+// chunkBatchSize is how many stored chunks are indexed at once
+const chunkBatchSize = 500
+
+// storedChunks batches the chunks written by the current backup before they
+// are recorded in the chunk index
+type storedChunks struct {
+	mu      sync.Mutex
+	pending []*domain.Chunk
+}
+
+// add queues a stored chunk, returning the batch to record once it is full
+func (c *storedChunks) add(hash string, size int64) []*domain.Chunk {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, &domain.Chunk{Hash: hash, Size: size})
+	if len(c.pending) < chunkBatchSize {
+		return nil
+	}
+	return c.take()
+}
+
+// take returns the queued chunks and empties the queue. The caller must hold
+// the mutex.
+func (c *storedChunks) take() []*domain.Chunk {
+	batch := c.pending
+	c.pending = nil
+	return batch
+}
+
+// recordChunks adds stored chunks to the index of the target. A chunk missing
+// from the index is never deleted by a prune, so a failure only leaks space
+// and doesn't fail the backup.
+func (r *backupRun) recordChunks(ctx context.Context, chunks []*domain.Chunk) {
+	if len(chunks) == 0 {
+		return
+	}
+	if err := r.service.chunkRepo.Record(ctx, *r.source.TargetID, chunks); err != nil {
+		r.logger.Warn("failed to index stored chunks", zap.Error(err), zap.Int("chunks", len(chunks)))
+	}
+}
+
+// scan backs up the files of a source through a bounded pipeline: a walker
+// feeds hashing/chunking workers, which feed upload workers. Files are
+// returned in walk order so the manifest matches a serial run.
+func (r *backupRun) scan(ctx context.Context) (*scanResult, error) {
+	runCtx := ctx
+	g, ctx := errgroup.WithContext(ctx)
+
+	source, opts, logger := r.source, r.opts, r.logger
+	tasks := make(chan fileTask, opts.hashConcurrency)
+	uploads := make(chan uploadTask, opts.uploadConcurrency)
+	results := make(chan fileResult, opts.hashConcurrency)
+	r.inflight = &inflightChunks{hashes: make(map[string]struct{})}
+	r.stored = &storedChunks{}
+	filter := r.service.newSourceFilter(ctx, source, time.Now())
+	filterSkips := make(map[string]int)      // Only written by the walker
+	var walkErrors []domain.FileError        // Only written by the walker
+	linkTasks := make(map[string][]fileTask) // Hard links by first path, only written by the walker
+
+	// Walker
+	g.Go(func() error {
+		defer close(tasks)
+
+		index := 0
+		links := make(map[hardLinkKey]string)
+		ignore := r.service.newSourceMatcher(ctx, source)
+		err := filepath.Walk(source.Path, func(path string, info os.FileInfo, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+
+			relPath, _ := filepath.Rel(source.Path, path)
+			if relPath == "." {
+				relPath = ""
+			}
+
+			// An unreadable source fails the backup, an unreadable entry
+			// below it is only left out. Returning nil for a directory which
+			// can't be listed skips its content.
+			if err != nil {
+				if relPath == "" {
+					return err
+				}
+				logger.Warn("failed to read directory entry", zap.Error(err), zap.String("path", path))
+				walkErrors = append(walkErrors, *newFileError(relPath, err))
+				return nil
+			}
+
+			// The source root itself becomes the restore destination
+			if relPath != "" {
+				if excluded, rule := ignore.excluded(relPath, info.IsDir()); excluded {
+					logger.Debug("excluding file",
+						zap.String("path", relPath),
+						zap.String("pattern", rule.pattern),
+						zap.String("origin", rule.origin),
+					)
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+			}
+
+			// Rules of an ignore file apply to everything below its directory,
+			// which is left out if its ignore file can't be read
+			if info.IsDir() {
+				if err := r.service.loadIgnoreFile(ctx, ignore, path, filepath.ToSlash(relPath)); err != nil {
+					if relPath == "" {
+						return err
+					}
+					logger.Warn("failed to read ignore file", zap.Error(err), zap.String("path", path))
+					walkErrors = append(walkErrors, *newFileError(relPath, err))
+					return filepath.SkipDir
+				}
+			}
+
+			if relPath == "" {
+				return nil
+			}
+
+			if nodeType(info.Mode()) == "" {
+				logger.Debug("skipping unsupported file type", zap.String("path", relPath), zap.Stringer("mode", info.Mode()))
+				return nil
+			}
+
+			if name := filter.skip(relPath, info); name != "" {
+				logger.Debug("file skipped by filter", zap.String("path", relPath), zap.String("filter", name))
+				filterSkips[name]++
+				return nil
+			}
+
+			task := fileTask{index: index, path: path, relPath: relPath, info: info, stat: statNode(info)}
+
+			// Only the first path of a hard-linked file carries its content
+			if info.Mode().IsRegular() && task.stat.nlink > 1 {
+				key := hardLinkKey{dev: task.stat.dev, inode: task.stat.inode}
+				if first, ok := links[key]; ok {
+					task.hardLink = first
+					linkTasks[first] = append(linkTasks[first], task)
+				} else {
+					links[key] = relPath
+				}
+			}
+
+			if info.Mode().IsRegular() && task.hardLink == "" {
+				r.progress.scanned(info.Size())
+			}
+
+			select {
+			case tasks <- task:
+				index++
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err == nil {
+			r.progress.completeScan()
+		}
+		return err
+	})
+
+	// Hashing and chunking workers
+	var hashers sync.WaitGroup
+	for i := 0; i < opts.hashConcurrency; i++ {
+		hashers.Add(1)
+		g.Go(func() error {
+			defer hashers.Done()
+			for task := range tasks {
+				result, err := r.processFile(ctx, task, uploads)
+				if err != nil {
+					return err
+				}
+
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+	}
+
+	// Once every file is chunked, no more uploads or results can be produced
+	go func() {
+		hashers.Wait()
+		close(uploads)
+		close(results)
+	}()
+
+	// Upload workers
+	var deltaBytes, storedBytes atomic.Int64
+	for i := 0; i < opts.uploadConcurrency; i++ {
+		g.Go(func() error {
+			return r.uploadChunks(ctx, uploads, &deltaBytes, &storedBytes)
+		})
+	}
+
+	// Collector
+	var collected []*domain.ManifestFile
+	var readErrors []domain.FileError
+	var totalBytes int64
+	fileCount, reusedFiles := 0, 0
+	g.Go(func() error {
+		for result := range results {
+			for len(collected) <= result.index {
+				collected = append(collected, nil)
+			}
+			if result.readErr != nil {
+				readErrors = append(readErrors, *result.readErr)
+			}
+			if result.file == nil {
+				continue
+			}
+
+			collected[result.index] = result.file
+			if !isRegularFile(*result.file) {
+				continue
+			}
+
+			totalBytes += result.file.Size
+			fileCount++
+			r.progress.processed()
+			if result.reused {
+				reusedFiles++
+			}
+
+			if fileCount%100 == 0 {
+				logger.Info("backup progress",
+					zap.Int("files", fileCount),
+					zap.Int64("bytes", totalBytes),
+				)
+			}
+		}
+		return nil
+	})
+
+	err := g.Wait()
+	if err == nil {
+		var promoted []fileResult
+		promoted, err = r.resolveHardLinks(runCtx, collected, linkTasks, &deltaBytes, &storedBytes)
+		for _, result := range promoted {
+			if result.readErr != nil {
+				readErrors = append(readErrors, *result.readErr)
+				collected[result.index] = nil
+				continue
+			}
+			collected[result.index] = result.file
+			totalBytes += result.file.Size
+			fileCount++
+			r.progress.processed()
+		}
+	}
+
+	// Chunks stored before a failure are indexed too, so that a prune can
+	// reclaim them
+	r.stored.mu.Lock()
+	remaining := r.stored.take()
+	r.stored.mu.Unlock()
+	r.recordChunks(context.WithoutCancel(runCtx), remaining)
+
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]domain.ManifestFile, 0, len(collected))
+	for _, file := range collected {
+		if file != nil {
+			files = append(files, *file)
+		}
+	}
+
+	// Workers finish in any order, so errors are sorted to keep the manifest deterministic
+	fileErrors := append(walkErrors, readErrors...)
+	sort.Slice(fileErrors, func(i, j int) bool { return fileErrors[i].Path < fileErrors[j].Path })
+
+	return &scanResult{
+		files:       files,
+		fileCount:   fileCount,
+		totalBytes:  totalBytes,
+		deltaBytes:  deltaBytes.Load(),
+		storedBytes: storedBytes.Load(),
+		reusedFiles: reusedFiles,
+		filterSkips: filterSkips,
+		fileErrors:  fileErrors,
+	}, nil
+}
+
+// uploadChunks stores the queued chunks which the backend doesn't have yet
+func (r *backupRun) uploadChunks(ctx context.Context, uploads <-chan uploadTask, deltaBytes, storedBytes *atomic.Int64) error {
+	for task := range uploads {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Check if chunk already exists
+		exists, err := r.backend.ChunkExists(ctx, task.hash)
+		if err != nil {
+			return fmt.Errorf("failed to check chunk existence: %w", err)
+		}
+
+		if exists {
+			r.progress.deduplicated()
+		} else {
+			// Upload new chunk
+			stored := r.compressor.encode(task.data)
+			if err := r.backend.StoreChunk(ctx, task.hash, stored); err != nil {
+				return fmt.Errorf("failed to store chunk: %w", err)
+			}
+			deltaBytes.Add(int64(len(task.data)))
+			storedBytes.Add(int64(len(stored)))
+			r.progress.uploaded(int64(len(stored)))
+			if batch := r.stored.add(task.hash, int64(len(stored))); batch != nil {
+				r.recordChunks(ctx, batch)
+			}
+		}
+
+		r.inflight.release(task.hash)
+	}
+	return nil
+}
+
+// resolveHardLinks repairs the hard links whose first path could not be
+// read, which would otherwise point to a file missing from the manifest. The
+// next link that can be read carries the content instead, and the links after
+// it point to it. It returns the entries of the links it read, or their read
+// errors; the other links are updated in collected.
+func (r *backupRun) resolveHardLinks(ctx context.Context, collected []*domain.ManifestFile, linkTasks map[string][]fileTask, deltaBytes, storedBytes *atomic.Int64) ([]fileResult, error) {
+	stored := make(map[string]bool)
+	for _, file := range collected {
+		if file != nil && isRegularFile(*file) {
+			stored[file.Path] = true
+		}
+	}
+	var dangling []string
+	for first := range linkTasks {
+		if !stored[first] {
+			dangling = append(dangling, first)
+		}
+	}
+	if len(dangling) == 0 {
+		return nil, nil
+	}
+	sort.Strings(dangling)
+
+	var results []fileResult
+	g, ctx := errgroup.WithContext(ctx)
+	uploads := make(chan uploadTask, r.opts.uploadConcurrency)
+	g.Go(func() error {
+		return r.uploadChunks(ctx, uploads, deltaBytes, storedBytes)
+	})
+	g.Go(func() error {
+		defer close(uploads)
+		for _, first := range dangling {
+			target := ""
+			for _, task := range linkTasks[first] {
+				if collected[task.index] == nil {
+					continue // Its own metadata could not be read either
+				}
+				if target != "" {
+					collected[task.index].HardLink = target
+					continue
+				}
+
+				r.logger.Debug("hard link takes over unreadable file", zap.String("path", task.relPath), zap.String("first", first))
+				task.hardLink = ""
+				result, err := r.processFile(ctx, task, uploads)
+				if err != nil {
+					return err
+				}
+				results = append(results, result)
+				if result.file != nil {
+					target = task.relPath
+				}
+			}
+		}
+		return nil
+	})
+	return results, g.Wait()
+}
+
+// processFile describes a single entry of the source. Regular files are
+// hashed and chunked, queueing chunks not yet in flight for upload, unless
+// they are unchanged since the parent snapshot, in which case they are not
+// read at all. Read errors skip the file; only fatal errors are returned.
+func (r *backupRun) processFile(ctx context.Context, task fileTask, uploads chan<- uploadTask) (fileResult, error) {
+	entry, err := describeNode(task.path, task.relPath, task.info, task.stat)
+	if err != nil {
+		r.logger.Warn("failed to read file metadata", zap.Error(err), zap.String("path", task.path))
+		return fileResult{index: task.index, readErr: newFileError(task.relPath, err)}, nil // Skip file but continue
+	}
+	if entry.Type != domain.NodeFile {
+		return fileResult{index: task.index, file: entry}, nil
+	}
+
+	entry.Size = task.info.Size()
+	if task.hardLink != "" {
+		entry.HardLink = task.hardLink
+		return fileResult{index: task.index, file: entry}, nil
+	}
+
+	if prev, ok := r.parent[task.relPath]; ok && unchanged(prev, task.info, task.stat.inode, task.stat.ctime) {
+		entry.Hash = prev.Hash
+		entry.Chunks = prev.Chunks
+		r.progress.unchanged(entry.Size)
+		return fileResult{index: task.index, file: entry, reused: true}, nil
+	}
+
+	r.progress.processing(task.relPath)
+
+	var chunkHashes []string
+	fileHash, fileSize, err := r.chunker.ChunkFile(task.path, func(chunk ChunkInfo) error {
+		// Large files must not delay cancellation until they are fully read
+		if err := ctx.Err(); err != nil {
+			return &fatalError{err}
+		}
+
+		r.progress.read(int64(len(chunk.Data)))
+		chunkHashes = append(chunkHashes, chunk.Hash)
+		if !r.inflight.claim(chunk.Hash) {
+			r.progress.deduplicated()
+			return nil // Already queued by another file of this backup
+		}
+
+		// The chunker reuses its window, so the upload gets its own copy
+		select {
+		case uploads <- uploadTask{hash: chunk.Hash, data: append([]byte(nil), chunk.Data...)}:
+			return nil
+		case <-ctx.Done():
+			return &fatalError{ctx.Err()}
+		}
+	})
+	if err != nil {
+		var fatalErr *fatalError
+		if errors.As(err, &fatalErr) {
+			return fileResult{}, fatalErr.err
+		}
+		r.logger.Warn("failed to chunk file", zap.Error(err), zap.String("path", task.path))
+		return fileResult{index: task.index, readErr: newFileError(task.relPath, err)}, nil // Skip file but continue
+	}
+
+	entry.Size = fileSize
+	entry.Hash = fileHash
+	entry.Chunks = chunkHashes
+	return fileResult{index: task.index, file: entry}, nil
+}
+
+// newFileError records why a file was left out of a backup. The path of an
+// *os.PathError is dropped from the reason since the entry already carries it.
+func newFileError(relPath string, err error) *domain.FileError {
+	reason := err.Error()
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		reason = pathErr.Op + ": " + pathErr.Err.Error()
+	}
+	return &domain.FileError{Path: relPath, Reason: reason}
+}
+
+// unchanged reports whether a file still matches its entry in the parent
+// manifest. Inode and change time are only compared when both sides have them.
+func unchanged(prev domain.ManifestFile, info os.FileInfo, inode uint64, ctime *time.Time) bool {
+	if prev.Size != info.Size() || !prev.ModTime.Equal(info.ModTime()) {
+		return false
+	}
+	if prev.Inode != 0 && inode != 0 && prev.Inode != inode {
+		return false
+	}
+	if prev.ChangeTime != nil && ctime != nil && !prev.ChangeTime.Equal(*ctime) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,428 @@
+package backupservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// memoryBackend is a thread-safe in-memory domain.Backend
+type memoryBackend struct {
+	mu        sync.Mutex
+	chunks    map[string][]byte
+	manifests map[string][]byte
+	keyFile   []byte
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{
+		chunks:    make(map[string][]byte),
+		manifests: make(map[string][]byte),
+	}
+}
+
+func (b *memoryBackend) Init(config map[string]string) error { return nil }
+func (b *memoryBackend) Close() error                        { return nil }
+
+func (b *memoryBackend) StoreChunk(ctx context.Context, hash string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.chunks[hash] = append([]byte(nil), data...)
+	return nil
+}
+
+func (b *memoryBackend) LoadChunk(ctx context.Context, hash string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.chunks[hash]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return data, nil
+}
+
+func (b *memoryBackend) DeleteChunk(ctx context.Context, hash string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.chunks, hash)
+	return nil
+}
+
+func (b *memoryBackend) ChunkExists(ctx context.Context, hash string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.chunks[hash]
+	return ok, nil
+}
+
+func (b *memoryBackend) StoreManifest(ctx context.Context, snapshotID string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.manifests[snapshotID] = append([]byte(nil), data...)
+	return nil
+}
+
+func (b *memoryBackend) LoadManifest(ctx context.Context, snapshotID string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.manifests[snapshotID]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return data, nil
+}
+
+func (b *memoryBackend) DeleteManifest(ctx context.Context, snapshotID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.manifests, snapshotID)
+	return nil
+}
+
+func (b *memoryBackend) StoreKeyFile(ctx context.Context, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.keyFile != nil {
+		return domain.ErrAlreadyExists
+	}
+	b.keyFile = append([]byte(nil), data...)
+	return nil
+}
+
+func (b *memoryBackend) LoadKeyFile(ctx context.Context) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.keyFile == nil {
+		return nil, domain.ErrNotFound
+	}
+	return b.keyFile, nil
+}
+
+func (b *memoryBackend) ListChunks(ctx context.Context, fn func(domain.ObjectInfo) error) error {
+	return listObjects(&b.mu, b.chunks, fn)
+}
+
+func (b *memoryBackend) ListManifests(ctx context.Context, fn func(domain.ObjectInfo) error) error {
+	return listObjects(&b.mu, b.manifests, fn)
+}
+
+func (b *memoryBackend) PutObject(ctx context.Context, key string, r io.Reader, size int64) error {
+	kind, name, err := domain.SplitObjectKey(key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if kind == domain.ObjectManifest {
+		return b.StoreManifest(ctx, name, data)
+	}
+	return b.StoreChunk(ctx, name, data)
+}
+
+func (b *memoryBackend) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	return b.GetObjectRange(ctx, key, 0, -1)
+}
+
+func (b *memoryBackend) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	kind, name, err := domain.SplitObjectKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if kind == domain.ObjectManifest {
+		data, err = b.LoadManifest(ctx, name)
+	} else {
+		data, err = b.LoadChunk(ctx, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data = data[min(offset, int64(len(data))):]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// listObjects calls fn for each object outside of the lock, so that fn may
+// delete them
+func listObjects(mu *sync.Mutex, objects map[string][]byte, fn func(domain.ObjectInfo) error) error {
+	mu.Lock()
+	infos := make([]domain.ObjectInfo, 0, len(objects))
+	for name, data := range objects {
+		infos = append(infos, domain.ObjectInfo{Name: name, Size: int64(len(data))})
+	}
+	mu.Unlock()
+
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hookBackend is a memoryBackend which calls onStore after storing each chunk
+type hookBackend struct {
+	*memoryBackend
+	onStore func()
+}
+
+func (b *hookBackend) StoreChunk(ctx context.Context, hash string, data []byte) error {
+	if err := b.memoryBackend.StoreChunk(ctx, hash, data); err != nil {
+		return err
+	}
+	b.onStore()
+	return nil
+}
+
+// runTestBackup backs up dir into backend using the given target config and
+// returns the manifest, which is always stored under snapshot ID 1
+func runTestBackup(t *testing.T, ctx context.Context, dir string, targetConfig string, backend domain.Backend) (*domain.Manifest, error) {
+	return runTestBackupWithHistory(t, ctx, dir, targetConfig, backend, []*domain.Snapshot{})
+}
+
+// runTestBackupWithHistory is runTestBackup with existing snapshots of the source
+func runTestBackupWithHistory(t *testing.T, ctx context.Context, dir string, targetConfig string, backend domain.Backend, history []*domain.Snapshot) (*domain.Manifest, error) {
+	t.Helper()
+	return runTestSourceBackup(t, ctx, &domain.Source{ID: 1, Name: "src", Path: dir}, targetConfig, backend, history)
+}
+
+// runTestSourceBackup backs up a source, which must have ID 1
+func runTestSourceBackup(t *testing.T, ctx context.Context, source *domain.Source, targetConfig string, backend domain.Backend, history []*domain.Snapshot) (*domain.Manifest, error) {
+	t.Helper()
+	mockSourceRepo := new(MockSourceRepository)
+	mockTargetRepo := new(MockTargetRepository)
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	logger := zap.NewNop()
+
+	service := New(mockSourceRepo, mockTargetRepo, mockSnapshotRepo, new(MockJobRepository), newMemoryChunkRepo(), nil, logger)
+
+	targetID := int64(2)
+	source.TargetID = &targetID
+	mockSourceRepo.On("GetByID", mock.Anything, int64(1)).Return(source, nil)
+	mockTargetRepo.On("GetByID", mock.Anything, targetID).Return(&domain.Target{ID: targetID, ConfigJSON: targetConfig}, nil)
+	mockSnapshotRepo.On("GetBySourceID", mock.Anything, int64(1)).Return(history, nil)
+	mockSnapshotRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+	mockSnapshotRepo.On("Update", mock.Anything, mock.Anything).Return(nil)
+
+	if err := service.RunBackup(ctx, 1, backend); err != nil {
+		return nil, err
+	}
+
+	data, err := backend.LoadManifest(ctx, "1")
+	require.NoError(t, err)
+	var manifest domain.Manifest
+	require.NoError(t, json.Unmarshal(data, &manifest))
+	return &manifest, nil
+}
+
+func TestBackupService_ConcurrentScanMatchesSerial(t *testing.T) {
+	dir := t.TempDir()
+	shared := randomData(100000, 10)
+	for i := 0; i < 30; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("dir%d", i%4))
+		require.NoError(t, os.MkdirAll(sub, 0755))
+		data := append(randomData(20000+i*1000, int64(i)), shared...)
+		require.NoError(t, os.WriteFile(filepath.Join(sub, fmt.Sprintf("file%02d.bin", i)), data, 0644))
+	}
+
+	const chunking = `"chunk_min_size":1024,"chunk_avg_size":4096,"chunk_max_size":16384`
+	serialBackend := newMemoryBackend()
+	serial, err := runTestBackup(t, context.Background(), dir, `{`+chunking+`,"hash_concurrency":1,"upload_concurrency":1}`, serialBackend)
+	require.NoError(t, err)
+
+	concurrentBackend := newMemoryBackend()
+	concurrent, err := runTestBackup(t, context.Background(), dir, `{`+chunking+`,"hash_concurrency":8,"upload_concurrency":8}`, concurrentBackend)
+	require.NoError(t, err)
+
+	require.Len(t, serial.Files, 34) // 30 files in 4 directories
+	assert.Equal(t, serial.Files, concurrent.Files)
+	assert.Equal(t, serialBackend.chunks, concurrentBackend.chunks)
+}
+
+func TestBackupService_ReportsProgress(t *testing.T) {
+	dir := t.TempDir()
+	shared := randomData(50000, 1)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.bin"), shared, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.bin"), shared, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "c.bin"), randomData(30000, 2), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+
+	progress := NewProgress()
+	backend := newMemoryBackend()
+	ctx := WithProgress(context.Background(), progress)
+	manifest, err := runTestBackup(t, ctx, dir, `{"chunk_min_size":1024,"chunk_avg_size":4096,"chunk_max_size":16384}`, backend)
+	require.NoError(t, err)
+
+	// Directories are not counted, and the copy's chunks are only stored once
+	chunkRefs, stored := 0, int64(0)
+	for _, file := range manifest.Files {
+		chunkRefs += len(file.Chunks)
+	}
+	for _, data := range backend.chunks {
+		stored += int64(len(data))
+	}
+
+	report := progress.Report()
+	assert.Equal(t, int64(3), report.FilesScanned)
+	assert.Equal(t, int64(3), report.FilesProcessed)
+	assert.Equal(t, int64(130000), report.BytesTotal)
+	assert.Equal(t, int64(130000), report.BytesRead)
+	assert.Equal(t, stored, report.BytesUploaded)
+	assert.Equal(t, int64(chunkRefs-len(backend.chunks)), report.ChunksDeduplicated)
+	assert.True(t, report.ScanComplete)
+	require.NotNil(t, report.ETASeconds)
+	assert.Equal(t, int64(0), *report.ETASeconds)
+}
+
+func TestBackupService_ScanCancelled(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := runTestBackup(t, ctx, dir, `{}`, newMemoryBackend())
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestParseTargetOptions_Concurrency(t *testing.T) {
+	opts, err := parseTargetOptions(&domain.Target{ConfigJSON: `{"hash_concurrency":2,"upload_concurrency":"16"}`})
+	require.NoError(t, err)
+	assert.Equal(t, 2, opts.hashConcurrency)
+	assert.Equal(t, 16, opts.uploadConcurrency)
+
+	_, err = parseTargetOptions(&domain.Target{ConfigJSON: `{"upload_concurrency":0}`})
+	assert.ErrorIs(t, err, domain.ErrInvalidInput)
+}
+
+func TestBackupService_CancelledBackupKeepsStoredChunks(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 8; i++ {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.bin", i)), randomData(64*1024, int64(i)), 0644))
+	}
+	const config = `{"chunk_min_size":1024,"chunk_avg_size":4096,"chunk_max_size":16384,"hash_concurrency":1,"upload_concurrency":1}`
+
+	mockSourceRepo := new(MockSourceRepository)
+	mockTargetRepo := new(MockTargetRepository)
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	service := New(mockSourceRepo, mockTargetRepo, mockSnapshotRepo, new(MockJobRepository), newMemoryChunkRepo(), nil, zap.NewNop())
+
+	targetID := int64(2)
+	mockSourceRepo.On("GetByID", mock.Anything, int64(1)).Return(&domain.Source{ID: 1, Path: dir, TargetID: &targetID}, nil)
+	mockTargetRepo.On("GetByID", mock.Anything, targetID).Return(&domain.Target{ID: targetID, ConfigJSON: config}, nil)
+	mockSnapshotRepo.On("GetBySourceID", mock.Anything, int64(1)).Return([]*domain.Snapshot{}, nil)
+	mockSnapshotRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+	var status string
+	mockSnapshotRepo.On("Update", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		status = args.Get(1).(*domain.Snapshot).Status
+	}).Return(nil)
+
+	// Cancel the job once a few chunks are stored
+	ctx, cancel := context.WithCancelCause(context.Background())
+	stored := 0
+	backend := &hookBackend{memoryBackend: newMemoryBackend(), onStore: func() {
+		if stored++; stored == 10 {
+			cancel(domain.ErrJobCancelled)
+		}
+	}}
+
+	err := service.RunBackup(ctx, 1, backend)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, "cancelled", status)
+	require.Len(t, backend.chunks, 10)
+
+	// The next run only uploads the chunks which are still missing
+	uploads := 0
+	backend.onStore = func() { uploads++ }
+	require.NoError(t, service.RunBackup(context.Background(), 1, backend))
+	assert.Equal(t, "success", status)
+	assert.Equal(t, len(backend.chunks)-10, uploads)
+}
+
+func TestBackupService_UnreadableFilesMakeSnapshotPartial(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.bin"), randomData(8*1024, 1), 0644))
+
+	// With a single worker of each kind, the first chunk of a.bin is stored
+	// before b.bin is opened, so removing b.bin then makes it unreadable
+	run := func(limit string) (*domain.Snapshot, *memoryBackend, error) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "b.bin"), []byte("vanishing"), 0644))
+		config := `{"chunker":"fixed","chunk_size":1024,"hash_concurrency":1,"upload_concurrency":1` + limit + `}`
+
+		mockSourceRepo := new(MockSourceRepository)
+		mockTargetRepo := new(MockTargetRepository)
+		mockSnapshotRepo := new(MockSnapshotRepository)
+		service := New(mockSourceRepo, mockTargetRepo, mockSnapshotRepo, new(MockJobRepository), newMemoryChunkRepo(), nil, zap.NewNop())
+
+		targetID := int64(2)
+		mockSourceRepo.On("GetByID", mock.Anything, int64(1)).Return(&domain.Source{ID: 1, Path: dir, TargetID: &targetID}, nil)
+		mockTargetRepo.On("GetByID", mock.Anything, targetID).Return(&domain.Target{ID: targetID, ConfigJSON: config}, nil)
+		mockSnapshotRepo.On("GetBySourceID", mock.Anything, int64(1)).Return([]*domain.Snapshot{}, nil)
+		mockSnapshotRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+		var snapshot domain.Snapshot
+		mockSnapshotRepo.On("Update", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			snapshot = *args.Get(1).(*domain.Snapshot)
+		}).Return(nil)
+
+		backend := &hookBackend{memoryBackend: newMemoryBackend(), onStore: func() {
+			os.Remove(filepath.Join(dir, "b.bin"))
+		}}
+		err := service.RunBackup(context.Background(), 1, backend)
+		return &snapshot, backend.memoryBackend, err
+	}
+
+	snapshot, backend, err := run("")
+	require.NoError(t, err)
+	assert.Equal(t, "partial", snapshot.Status)
+	assert.Equal(t, 1, snapshot.ErrorCount)
+	assert.Equal(t, 1, snapshot.FileCount)
+	assert.True(t, snapshot.Completed())
+
+	var manifest domain.Manifest
+	require.NoError(t, json.Unmarshal(backend.manifests["1"], &manifest))
+	require.Len(t, manifest.Errors, 1)
+	assert.Equal(t, "b.bin", manifest.Errors[0].Path)
+	assert.Contains(t, manifest.Errors[0].Reason, "no such file or directory")
+	assert.NotContains(t, manifest.Errors[0].Reason, dir)
+
+	snapshot, _, err = run(`,"max_file_errors":1`)
+	require.NoError(t, err)
+	assert.Equal(t, "partial", snapshot.Status)
+
+	snapshot, _, err = run(`,"max_file_errors":0`)
+	require.Error(t, err)
+	assert.Equal(t, "failed", snapshot.Status)
+	assert.Equal(t, 1, snapshot.ErrorCount)
+	require.NotNil(t, snapshot.Error)
+	assert.Contains(t, *snapshot.Error, "too many unreadable files: 1, the limit is 0")
+	assert.False(t, snapshot.Completed())
+}
+
+func TestParseTargetOptions_MaxFileErrors(t *testing.T) {
+	opts, err := parseTargetOptions(&domain.Target{ConfigJSON: `{}`})
+	require.NoError(t, err)
+	assert.Equal(t, -1, opts.maxFileErrors)
+
+	opts, err = parseTargetOptions(&domain.Target{ConfigJSON: `{"max_file_errors":"25"}`})
+	require.NoError(t, err)
+	assert.Equal(t, 25, opts.maxFileErrors)
+
+	_, err = parseTargetOptions(&domain.Target{ConfigJSON: `{"max_file_errors":-2}`})
+	assert.ErrorIs(t, err, domain.ErrInvalidInput)
+}
@@ -0,0 +1,159 @@
+package backupservice
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+)
+
+// Progress tracks a running backup or restore. Its counters are updated by
+// the workers of the run and read concurrently by whoever reports it. A nil
+// *Progress ignores updates.
+type Progress struct {
+	start time.Time
+
+	filesScanned       atomic.Int64
+	filesProcessed     atomic.Int64
+	bytesTotal         atomic.Int64
+	bytesRead          atomic.Int64
+	bytesUnchanged     atomic.Int64
+	bytesUploaded      atomic.Int64
+	chunksDeduplicated atomic.Int64
+	scanComplete       atomic.Bool
+
+	mu          sync.Mutex
+	currentPath string
+}
+
+// NewProgress creates a progress tracker for a run starting now
+func NewProgress() *Progress {
+	return &Progress{start: time.Now()}
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context whose backup or restore reports to p
+func WithProgress(ctx context.Context, p *Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+// progressFrom returns the tracker of a context, nil if there is none
+func progressFrom(ctx context.Context) *Progress {
+	p, _ := ctx.Value(progressKey{}).(*Progress)
+	return p
+}
+
+// scanned records a file found by the walker
+func (p *Progress) scanned(size int64) {
+	if p == nil {
+		return
+	}
+	p.filesScanned.Add(1)
+	p.bytesTotal.Add(size)
+}
+
+// completeScan records that every file is known, which makes the ETA
+// available
+func (p *Progress) completeScan() {
+	if p == nil {
+		return
+	}
+	p.scanComplete.Store(true)
+}
+
+// processing records the file being read
+func (p *Progress) processing(path string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.currentPath = path
+	p.mu.Unlock()
+}
+
+// read records bytes read from a source file, or written to a restored one
+func (p *Progress) read(n int64) {
+	if p == nil {
+		return
+	}
+	p.bytesRead.Add(n)
+}
+
+// processed records a finished file
+func (p *Progress) processed() {
+	if p == nil {
+		return
+	}
+	p.filesProcessed.Add(1)
+}
+
+// unchanged records a file reused from the parent snapshot
+func (p *Progress) unchanged(size int64) {
+	if p == nil {
+		return
+	}
+	p.bytesUnchanged.Add(size)
+}
+
+// uploaded records a chunk written to the backend
+func (p *Progress) uploaded(n int64) {
+	if p == nil {
+		return
+	}
+	p.bytesUploaded.Add(n)
+}
+
+// deduplicated records a chunk which didn't need to be written
+func (p *Progress) deduplicated() {
+	if p == nil {
+		return
+	}
+	p.chunksDeduplicated.Add(1)
+}
+
+// Report returns the current progress
+func (p *Progress) Report() domain.JobProgress {
+	return p.report(time.Now())
+}
+
+// report computes the progress at a given time. The ETA divides the bytes
+// left to read by the average read throughput so far.
+func (p *Progress) report(now time.Time) domain.JobProgress {
+	p.mu.Lock()
+	currentPath := p.currentPath
+	p.mu.Unlock()
+
+	progress := domain.JobProgress{
+		FilesScanned:       p.filesScanned.Load(),
+		FilesProcessed:     p.filesProcessed.Load(),
+		BytesTotal:         p.bytesTotal.Load(),
+		BytesRead:          p.bytesRead.Load(),
+		BytesUnchanged:     p.bytesUnchanged.Load(),
+		BytesUploaded:      p.bytesUploaded.Load(),
+		ChunksDeduplicated: p.chunksDeduplicated.Load(),
+		CurrentPath:        currentPath,
+		ScanComplete:       p.scanComplete.Load(),
+		UpdatedAt:          now,
+	}
+
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 {
+		progress.BytesPerSecond = float64(progress.BytesRead) / elapsed
+	}
+
+	if progress.ScanComplete {
+		remaining := max(progress.BytesTotal-progress.BytesUnchanged-progress.BytesRead, 0)
+		switch {
+		case remaining == 0:
+			eta := int64(0)
+			progress.ETASeconds = &eta
+		case progress.BytesPerSecond > 0:
+			eta := int64(float64(remaining)/progress.BytesPerSecond + 0.5)
+			progress.ETASeconds = &eta
+		}
+	}
+
+	return progress
+}
@@ -0,0 +1,58 @@
+package backupservice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress_Report(t *testing.T) {
+	start := time.Date(2025, 1, 21, 11, 0, 0, 0, time.UTC)
+	p := &Progress{start: start}
+
+	p.scanned(1000)
+	p.scanned(3000)
+	p.scanned(6000)
+	p.unchanged(1000)
+	p.processed()
+	p.processing("big.bin")
+	p.read(3000)
+	p.processed()
+
+	// The total is unknown while scanning, and so is the ETA
+	report := p.report(start.Add(10 * time.Second))
+	assert.Equal(t, int64(3), report.FilesScanned)
+	assert.Equal(t, int64(2), report.FilesProcessed)
+	assert.Equal(t, "big.bin", report.CurrentPath)
+	assert.Equal(t, 300.0, report.BytesPerSecond)
+	assert.Nil(t, report.ETASeconds)
+
+	// 6000 bytes are left to read at 300 bytes per second
+	p.completeScan()
+	report = p.report(start.Add(10 * time.Second))
+	require.NotNil(t, report.ETASeconds)
+	assert.Equal(t, int64(20), *report.ETASeconds)
+
+	p.read(6000)
+	p.processed()
+	report = p.report(start.Add(20 * time.Second))
+	require.NotNil(t, report.ETASeconds)
+	assert.Equal(t, int64(0), *report.ETASeconds)
+	assert.Equal(t, int64(3), report.FilesProcessed)
+}
+
+func TestProgress_NilIgnoresUpdates(t *testing.T) {
+	var p *Progress
+	assert.NotPanics(t, func() {
+		p.scanned(10)
+		p.completeScan()
+		p.processing("file")
+		p.read(10)
+		p.processed()
+		p.unchanged(10)
+		p.uploaded(10)
+		p.deduplicated()
+	})
+}
@@ -0,0 +1,172 @@
+package backupservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"go.uber.org/zap"
+)
+
+// PruneResult reports what a prune found and deleted on a target
+type PruneResult struct {
+	DryRun           bool  `json:"dry_run"`
+	Listed           bool  `json:"listed"`            // Whether the chunks were listed on the backend rather than taken from the chunk index
+	Snapshots        int   `json:"snapshots"`         // Completed snapshots whose manifest was read
+	ChunksStored     int   `json:"chunks_stored"`     // Chunks of the target
+	ChunksReferenced int   `json:"chunks_referenced"` // Distinct chunks referenced by the manifests
+	ChunksDeleted    int   `json:"chunks_deleted"`    // Chunks no manifest references, only counted by a dry run
+	BytesReclaimed   int64 `json:"bytes_reclaimed"`   // Bytes the deleted chunks took on the backend
+}
+
+// Prune deletes the chunks of a target which no manifest references anymore,
+// such as those of forgotten or failed snapshots. Chunks referenced by the
+// manifest of a completed snapshot of the target are marked, then the chunks
+// listed on the backend which are left unmarked are swept. The repository
+// lock of the target is held throughout, so no backup can reuse a chunk being
+// deleted. With dryRun, nothing is deleted.
+//
+// Backends which can't list their chunks, such as encrypted ones, are swept
+// from the chunk index instead: chunks written before the index existed are
+// then kept.
+func (s *Service) Prune(ctx context.Context, targetID int64, backend domain.Backend, dryRun bool) (*PruneResult, error) {
+	startTime := time.Now()
+	logger := s.log(ctx)
+
+	release, err := s.locks.acquire(ctx, targetID, true, func() {
+		logger.Info("waiting for the backups of the target to finish", zap.Int64("target_id", targetID))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock repository: %w", err)
+	}
+	defer release()
+
+	result := &PruneResult{DryRun: dryRun}
+
+	// Mark
+	referenced, snapshots, err := s.referencedChunks(ctx, targetID, backend)
+	if err != nil {
+		return nil, err
+	}
+	result.Snapshots = snapshots
+	result.ChunksReferenced = len(referenced)
+
+	// Sweep
+	indexed, err := s.chunkRepo.GetByTarget(ctx, targetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indexed chunks: %w", err)
+	}
+	indexedSizes := make(map[string]int64, len(indexed))
+	for _, chunk := range indexed {
+		indexedSizes[chunk.Hash] = chunk.Size
+	}
+
+	stored, err := listChunks(ctx, backend, indexedSizes)
+	switch {
+	case err == nil:
+		result.Listed = true
+	case errors.Is(err, domain.ErrNotSupported):
+		stored = indexedSizes
+	default:
+		return nil, err
+	}
+	result.ChunksStored = len(stored)
+
+	for hash, size := range stored {
+		if referenced[hash] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if !dryRun {
+			if err := backend.DeleteChunk(ctx, hash); err != nil && !errors.Is(err, domain.ErrNotFound) {
+				return nil, fmt.Errorf("failed to delete chunk %s: %w", hash, err)
+			}
+			if err := s.chunkRepo.Delete(ctx, targetID, hash); err != nil && !errors.Is(err, domain.ErrNotFound) {
+				return nil, fmt.Errorf("failed to unindex chunk %s: %w", hash, err)
+			}
+		}
+		result.ChunksDeleted++
+		result.BytesReclaimed += size
+	}
+
+	// Index entries of chunks gone from the backend are only dropped
+	if result.Listed && !dryRun {
+		for hash := range indexedSizes {
+			if _, ok := stored[hash]; ok {
+				continue
+			}
+			if err := s.chunkRepo.Delete(ctx, targetID, hash); err != nil && !errors.Is(err, domain.ErrNotFound) {
+				return nil, fmt.Errorf("failed to unindex chunk %s: %w", hash, err)
+			}
+		}
+	}
+
+	logger.Info("prune completed",
+		zap.Int64("target_id", targetID),
+		zap.Bool("dry_run", dryRun),
+		zap.Bool("listed", result.Listed),
+		zap.Int("snapshots", result.Snapshots),
+		zap.Int("chunks_referenced", result.ChunksReferenced),
+		zap.Int("chunks_deleted", result.ChunksDeleted),
+		zap.Int64("bytes_reclaimed", result.BytesReclaimed),
+		zap.Float64("duration_seconds", time.Since(startTime).Seconds()),
+	)
+
+	return result, nil
+}
+
+// referencedChunks returns the hashes of the chunks referenced by the
+// manifests of the completed snapshots of a target, and how many snapshots
+// there were. A manifest which can't be read fails the prune, since its
+// chunks would otherwise be deleted.
+func (s *Service) referencedChunks(ctx context.Context, targetID int64, backend domain.Backend) (map[string]bool, int, error) {
+	snapshots, err := s.snapshotRepo.GetAll(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	referenced := make(map[string]bool)
+	count := 0
+	for _, snapshot := range snapshots {
+		if snapshot.TargetID != targetID || !snapshot.Completed() {
+			continue
+		}
+
+		manifest, err := loadManifest(ctx, backend, snapshot.ID)
+		if err != nil {
+			return nil, 0, fmt.Errorf("snapshot %d: %w", snapshot.ID, err)
+		}
+
+		for _, file := range manifest.Files {
+			for _, hash := range file.Chunks {
+				referenced[hash] = true
+			}
+		}
+		count++
+	}
+
+	return referenced, count, nil
+}
+
+// listChunks lists the chunks of a backend with the bytes they take. Sizes
+// the backend doesn't report are taken from the chunk index, if known.
+func listChunks(ctx context.Context, backend domain.Backend, indexedSizes map[string]int64) (map[string]int64, error) {
+	stored := make(map[string]int64)
+	err := backend.ListChunks(ctx, func(object domain.ObjectInfo) error {
+		size := object.Size
+		if size < 0 {
+			size = indexedSizes[object.Name]
+		}
+		stored[object.Name] = size
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chunks: %w", err)
+	}
+	return stored, nil
+}
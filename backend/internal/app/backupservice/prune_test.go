@@ -0,0 +1,274 @@
+package backupservice
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// memoryChunkRepo is an in-memory domain.ChunkRepository
+type memoryChunkRepo struct {
+	mu     sync.Mutex
+	chunks map[int64]map[string]int64 // Sizes by hash, by target
+}
+
+func newMemoryChunkRepo() *memoryChunkRepo {
+	return &memoryChunkRepo{chunks: make(map[int64]map[string]int64)}
+}
+
+func (m *memoryChunkRepo) Record(ctx context.Context, targetID int64, chunks []*domain.Chunk) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.chunks[targetID] == nil {
+		m.chunks[targetID] = make(map[string]int64)
+	}
+	for _, chunk := range chunks {
+		m.chunks[targetID][chunk.Hash] = chunk.Size
+	}
+	return nil
+}
+
+func (m *memoryChunkRepo) GetByTarget(ctx context.Context, targetID int64) ([]*domain.Chunk, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var chunks []*domain.Chunk
+	for hash, size := range m.chunks[targetID] {
+		chunks = append(chunks, &domain.Chunk{Hash: hash, Size: size})
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Hash < chunks[j].Hash })
+	return chunks, nil
+}
+
+func (m *memoryChunkRepo) Delete(ctx context.Context, targetID int64, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.chunks[targetID][hash]; !ok {
+		return domain.ErrNotFound
+	}
+	delete(m.chunks[targetID], hash)
+	return nil
+}
+
+// unlistedBackend is a memoryBackend which can't list its chunks, like an
+// encrypted one
+type unlistedBackend struct {
+	*memoryBackend
+}
+
+func (b *unlistedBackend) ListChunks(ctx context.Context, fn func(domain.ObjectInfo) error) error {
+	return domain.ErrNotSupported
+}
+
+// newPruneTest returns a backend and chunk index holding a referenced chunk
+// aa, an unreferenced chunk bb, an unreferenced chunk cc the index doesn't
+// know and an index entry ee whose chunk is gone, and a service whose
+// snapshots reference aa
+func newPruneTest(t *testing.T, targetID int64) (*Service, *memoryBackend, *memoryChunkRepo) {
+	backend := newMemoryBackend()
+	chunkRepo := newMemoryChunkRepo()
+	backend.chunks["aa"] = []byte("kept")
+	backend.chunks["bb"] = []byte("forgotten")
+	backend.chunks["cc"] = []byte("written before the index")
+	require.NoError(t, chunkRepo.Record(context.Background(), targetID, []*domain.Chunk{
+		{Hash: "aa", Size: int64(len("kept"))},
+		{Hash: "bb", Size: int64(len("forgotten"))},
+		{Hash: "ee", Size: 100},
+	}))
+	backend.manifests["1"] = []byte(`{"snapshot_id":1,"files":[{"path":"a","chunks":["aa"]},{"path":"b","chunks":["aa"]}]}`)
+
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	mockSnapshotRepo.On("GetAll", mock.Anything).Return([]*domain.Snapshot{
+		{ID: 1, TargetID: targetID, Status: "partial"},
+		{ID: 2, TargetID: targetID, Status: "failed"},
+		{ID: 3, TargetID: targetID + 1, Status: "success"}, // Its manifest is on another backend
+	}, nil)
+	service := New(new(MockSourceRepository), new(MockTargetRepository), mockSnapshotRepo, new(MockJobRepository), chunkRepo, nil, zap.NewNop())
+	return service, backend, chunkRepo
+}
+
+func TestBackupService_Prune(t *testing.T) {
+	service, backend, chunkRepo := newPruneTest(t, 2)
+
+	// A dry run only counts
+	result, err := service.Prune(context.Background(), 2, backend, true)
+	require.NoError(t, err)
+	assert.Equal(t, &PruneResult{
+		DryRun:           true,
+		Listed:           true,
+		Snapshots:        1,
+		ChunksStored:     3,
+		ChunksReferenced: 1,
+		ChunksDeleted:    2,
+		BytesReclaimed:   int64(len("forgotten") + len("written before the index")),
+	}, result)
+	assert.Len(t, backend.chunks, 3)
+
+	result, err = service.Prune(context.Background(), 2, backend, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.ChunksDeleted)
+	assert.Equal(t, int64(len("forgotten")+len("written before the index")), result.BytesReclaimed)
+	assert.Equal(t, []string{"aa"}, chunkNames(backend))
+
+	// The index entry of the missing chunk is dropped as well
+	remaining, err := chunkRepo.GetByTarget(context.Background(), 2)
+	require.NoError(t, err)
+	assert.Equal(t, []*domain.Chunk{{Hash: "aa", Size: int64(len("kept"))}}, remaining)
+}
+
+func TestBackupService_PruneWithoutListing(t *testing.T) {
+	service, backend, chunkRepo := newPruneTest(t, 2)
+
+	// Only indexed chunks can be swept
+	result, err := service.Prune(context.Background(), 2, &unlistedBackend{backend}, false)
+	require.NoError(t, err)
+	assert.False(t, result.Listed)
+	assert.Equal(t, 3, result.ChunksStored)
+	assert.Equal(t, 2, result.ChunksDeleted)
+	assert.Equal(t, int64(len("forgotten")+100), result.BytesReclaimed)
+	assert.Equal(t, []string{"aa", "cc"}, chunkNames(backend))
+
+	remaining, err := chunkRepo.GetByTarget(context.Background(), 2)
+	require.NoError(t, err)
+	assert.Len(t, remaining, 1)
+}
+
+// chunkNames returns the sorted hashes of the chunks of a backend
+func chunkNames(backend *memoryBackend) []string {
+	var names []string
+	for hash := range backend.chunks {
+		names = append(names, hash)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestBackupService_PruneStopsOnUnreadableManifest(t *testing.T) {
+	backend := newMemoryBackend()
+	chunkRepo := newMemoryChunkRepo()
+	backend.chunks["aa"] = []byte("data")
+	require.NoError(t, chunkRepo.Record(context.Background(), 2, []*domain.Chunk{{Hash: "aa", Size: 4}}))
+
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	mockSnapshotRepo.On("GetAll", mock.Anything).Return([]*domain.Snapshot{{ID: 1, TargetID: 2, Status: "success"}}, nil)
+	service := New(new(MockSourceRepository), new(MockTargetRepository), mockSnapshotRepo, new(MockJobRepository), chunkRepo, nil, zap.NewNop())
+
+	// Without the manifest, the chunks it references can't be told apart
+	_, err := service.Prune(context.Background(), 2, backend, false)
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+	assert.Contains(t, backend.chunks, "aa")
+}
+
+func TestBackupService_RunBackupIndexesStoredChunks(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.bin"), randomData(50000, 1), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.bin"), randomData(50000, 2), 0644))
+
+	mockSourceRepo := new(MockSourceRepository)
+	mockTargetRepo := new(MockTargetRepository)
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	chunkRepo := newMemoryChunkRepo()
+	service := New(mockSourceRepo, mockTargetRepo, mockSnapshotRepo, new(MockJobRepository), chunkRepo, nil, zap.NewNop())
+
+	targetID := int64(2)
+	mockSourceRepo.On("GetByID", mock.Anything, int64(1)).Return(&domain.Source{ID: 1, Path: dir, TargetID: &targetID}, nil)
+	mockTargetRepo.On("GetByID", mock.Anything, targetID).Return(&domain.Target{ID: targetID, ConfigJSON: `{"compression":"zstd"}`}, nil)
+	mockSnapshotRepo.On("GetBySourceID", mock.Anything, int64(1)).Return([]*domain.Snapshot{}, nil)
+	mockSnapshotRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+	mockSnapshotRepo.On("Update", mock.Anything, mock.Anything).Return(nil)
+
+	backend := newMemoryBackend()
+	require.NoError(t, service.RunBackup(context.Background(), 1, backend))
+
+	// Every stored chunk is indexed with the bytes it takes on the backend
+	indexed, err := chunkRepo.GetByTarget(context.Background(), targetID)
+	require.NoError(t, err)
+	require.Len(t, indexed, len(backend.chunks))
+	for _, chunk := range indexed {
+		assert.Equal(t, int64(len(backend.chunks[chunk.Hash])), chunk.Size)
+	}
+
+	// Once the snapshot is gone, a prune reclaims all of it
+	mockSnapshotRepo.On("GetAll", mock.Anything).Return([]*domain.Snapshot{}, nil)
+	result, err := service.Prune(context.Background(), targetID, backend, false)
+	require.NoError(t, err)
+	assert.Equal(t, len(indexed), result.ChunksDeleted)
+	assert.Empty(t, backend.chunks)
+}
+
+func TestRepoLocks(t *testing.T) {
+	locks := newRepoLocks()
+	ctx := context.Background()
+
+	// Backups share the lock
+	releaseFirst, err := locks.acquire(ctx, 1, false, nil)
+	require.NoError(t, err)
+	releaseSecond, err := locks.acquire(ctx, 1, false, nil)
+	require.NoError(t, err)
+
+	// A prune waits for them, and other targets are not affected
+	waited := make(chan struct{})
+	pruned := make(chan func())
+	go func() {
+		release, err := locks.acquire(ctx, 1, true, func() { close(waited) })
+		assert.NoError(t, err)
+		pruned <- release
+	}()
+	<-waited
+	releaseOther, err := locks.acquire(ctx, 2, true, nil)
+	require.NoError(t, err)
+	releaseOther()
+
+	// A backup queued behind the waiting prune doesn't get the lock first
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = locks.acquire(timeout, 1, false, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	releaseFirst()
+	releaseSecond()
+	releasePrune := <-pruned
+
+	timeout, cancel = context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = locks.acquire(timeout, 1, false, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "backups wait for the prune")
+
+	releasePrune()
+	release, err := locks.acquire(ctx, 1, false, nil)
+	require.NoError(t, err)
+	release()
+}
+
+func TestRepoLocks_CancelledPruneLetsBackupsThrough(t *testing.T) {
+	locks := newRepoLocks()
+	ctx := context.Background()
+
+	releaseBackup, err := locks.acquire(ctx, 1, false, nil)
+	require.NoError(t, err)
+	defer releaseBackup()
+
+	pruneCtx, cancelPrune := context.WithCancel(ctx)
+	waited := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := locks.acquire(pruneCtx, 1, true, func() { close(waited) })
+		done <- err
+	}()
+	<-waited
+	cancelPrune()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	release, err := locks.acquire(ctx, 1, false, nil)
+	require.NoError(t, err)
+	release()
+}
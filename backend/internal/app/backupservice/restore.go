@@ -0,0 +1,354 @@
+package backupservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/axelfrache/savesync/internal/infra/observability"
+	"go.uber.org/zap"
+)
+
+// RestoreOptions controls where a snapshot is restored
+type RestoreOptions struct {
+	// Destination is the directory to restore into. When empty, files are
+	// written back to the snapshot's original source path.
+	Destination string
+}
+
+// RestoreSnapshot restores every entry of a snapshot using the provided
+// backend, recreating directories, symlinks, hard links and special files
+// with their metadata. Its progress is reported to the tracker of the context,
+// if any, and it logs to the logger of the context.
+func (s *Service) RestoreSnapshot(ctx context.Context, id int64, backend domain.Backend, opts RestoreOptions) error {
+	startTime := time.Now()
+
+	snapshot, err := s.snapshotRepo.GetByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get snapshot: %w", err)
+	}
+
+	if !snapshot.Completed() {
+		return fmt.Errorf("%w: snapshot status is %s", domain.ErrSnapshotInvalid, snapshot.Status)
+	}
+
+	manifest, err := loadManifest(ctx, backend, id)
+	if err != nil {
+		return err
+	}
+
+	destination := opts.Destination
+	if destination == "" {
+		destination = manifest.SourcePath
+	}
+	if !filepath.IsAbs(destination) {
+		return fmt.Errorf("%w: restore destination must be absolute", domain.ErrInvalidPath)
+	}
+
+	logger := s.log(ctx)
+	logger.Info("starting restore",
+		zap.Int64("snapshot_id", id),
+		zap.String("destination", destination),
+		zap.Int("files", len(manifest.Files)),
+	)
+
+	if err := os.MkdirAll(destination, 0755); err != nil {
+		return fmt.Errorf("failed to create destination: %w", err)
+	}
+
+	progress := progressFrom(ctx)
+	contents := make(map[string]bool) // Paths hard links can point to
+	for _, file := range manifest.Files {
+		if isRegularFile(file) {
+			progress.scanned(file.Size)
+			contents[file.Path] = true
+		}
+	}
+	progress.completeScan()
+
+	var restoredBytes int64
+	var dirs, symlinks []restoreEntry
+	for _, file := range manifest.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		targetPath, err := restorePath(destination, manifest.SourcePath, file.Path)
+		if err != nil {
+			observability.ErrorCountTotal.WithLabelValues("restore").Inc()
+			return err
+		}
+
+		switch file.Type {
+		case domain.NodeDir:
+			if err := mkdirInside(destination, targetPath, 0700); err != nil {
+				observability.ErrorCountTotal.WithLabelValues("restore").Inc()
+				return fmt.Errorf("failed to create directory %s: %w", file.Path, err)
+			}
+			dirs = append(dirs, restoreEntry{file: file, path: targetPath})
+			continue
+		case domain.NodeSymlink:
+			symlinks = append(symlinks, restoreEntry{file: file, path: targetPath})
+			continue
+		}
+
+		// Older backups kept the links of a file whose first path failed to read
+		if file.HardLink != "" && !contents[file.HardLink] {
+			logger.Warn("skipping hard link to a file missing from the snapshot",
+				zap.String("path", file.Path),
+				zap.String("link", file.HardLink),
+			)
+			continue
+		}
+
+		if isRegularFile(file) {
+			progress.processing(file.Path)
+		}
+		if err := s.restoreNode(ctx, backend, file, targetPath, destination, manifest.SourcePath); err != nil {
+			observability.ErrorCountTotal.WithLabelValues("restore").Inc()
+			return fmt.Errorf("failed to restore %s: %w", file.Path, err)
+		}
+
+		if isRegularFile(file) {
+			restoredBytes += file.Size
+			progress.processed()
+		}
+	}
+
+	// Symlinks are created last so that no file is ever written through one
+	for _, link := range symlinks {
+		if err := s.restoreSymlink(ctx, link.file, link.path, destination); err != nil {
+			observability.ErrorCountTotal.WithLabelValues("restore").Inc()
+			return fmt.Errorf("failed to restore %s: %w", link.file.Path, err)
+		}
+	}
+
+	// Directory metadata goes last, deepest first: creating entries updates a
+	// directory's modification time, and a read-only directory would block them
+	for i := len(dirs) - 1; i >= 0; i-- {
+		s.applyMetadata(ctx, dirs[i].file, dirs[i].path)
+	}
+
+	logger.Info("restore completed",
+		zap.Int64("snapshot_id", id),
+		zap.Int("files", len(manifest.Files)),
+		zap.Int64("bytes", restoredBytes),
+		zap.Float64("duration_seconds", time.Since(startTime).Seconds()),
+	)
+
+	return nil
+}
+
+// restoreEntry is a manifest entry whose restore is deferred
+type restoreEntry struct {
+	file domain.ManifestFile
+	path string
+}
+
+// restoreNode restores a regular file, hard link, device node or FIFO
+func (s *Service) restoreNode(ctx context.Context, backend domain.Backend, file domain.ManifestFile, targetPath, destination, sourcePath string) error {
+	if isRegularFile(file) {
+		if err := s.restoreFile(ctx, backend, file, targetPath, destination); err != nil {
+			return err
+		}
+		s.applyMetadata(ctx, file, targetPath)
+		return nil
+	}
+
+	if err := mkdirInside(destination, filepath.Dir(targetPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Remove(targetPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace existing file: %w", err)
+	}
+
+	// Hard links share the inode, and so the metadata, of their first path
+	if file.HardLink != "" {
+		linkPath, err := restorePath(destination, sourcePath, file.HardLink)
+		if err != nil {
+			return err
+		}
+		if err := mkdirInside(destination, filepath.Dir(linkPath), 0755); err != nil {
+			return fmt.Errorf("failed to resolve hard link: %w", err)
+		}
+		if err := os.Link(linkPath, targetPath); err != nil {
+			return fmt.Errorf("failed to create hard link: %w", err)
+		}
+		return nil
+	}
+
+	if err := makeSpecialNode(targetPath, file); err != nil {
+		return fmt.Errorf("failed to create %s: %w", file.Type, err)
+	}
+	s.applyMetadata(ctx, file, targetPath)
+	return nil
+}
+
+// restoreSymlink recreates a symlink with its original, unresolved target
+func (s *Service) restoreSymlink(ctx context.Context, file domain.ManifestFile, targetPath, destination string) error {
+	if err := mkdirInside(destination, filepath.Dir(targetPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Remove(targetPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace existing file: %w", err)
+	}
+	if err := os.Symlink(file.LinkTarget, targetPath); err != nil {
+		return fmt.Errorf("failed to create symlink: %w", err)
+	}
+	s.applyMetadata(ctx, file, targetPath)
+	return nil
+}
+
+// applyMetadata restores ownership, extended attributes, permissions and
+// modification time. Failures are logged rather than returned: ownership in
+// particular can only be restored when running as root.
+func (s *Service) applyMetadata(ctx context.Context, file domain.ManifestFile, targetPath string) {
+	logger := s.log(ctx)
+	if file.UID != nil && file.GID != nil {
+		if err := os.Lchown(targetPath, int(*file.UID), int(*file.GID)); err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				logger.Debug("cannot restore ownership", zap.Error(err), zap.String("path", targetPath))
+			} else {
+				logger.Warn("failed to restore ownership", zap.Error(err), zap.String("path", targetPath))
+			}
+		}
+	}
+
+	if err := writeXattrs(targetPath, file.Xattrs); err != nil {
+		logger.Warn("failed to restore extended attributes", zap.Error(err), zap.String("path", targetPath))
+	}
+
+	if file.Type == domain.NodeSymlink {
+		if err := setSymlinkTimes(targetPath, file.ModTime); err != nil {
+			logger.Warn("failed to restore modification time", zap.Error(err), zap.String("path", targetPath))
+		}
+		return
+	}
+
+	// Manifests written before modes were recorded get the historical default.
+	// Permissions are set after ownership, since chown clears setuid bits.
+	mode := fileMode(file.Mode)
+	if file.Mode == 0 {
+		mode = 0644
+	}
+	if err := os.Chmod(targetPath, mode); err != nil {
+		logger.Warn("failed to restore permissions", zap.Error(err), zap.String("path", targetPath))
+	}
+
+	if err := os.Chtimes(targetPath, file.ModTime, file.ModTime); err != nil {
+		logger.Warn("failed to restore modification time", zap.Error(err), zap.String("path", targetPath))
+	}
+}
+
+// restoreFile rebuilds a single file from its chunks and verifies its hash
+func (s *Service) restoreFile(ctx context.Context, backend domain.Backend, file domain.ManifestFile, targetPath, destination string) error {
+	dir := filepath.Dir(targetPath)
+	if err := mkdirInside(destination, dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Write to a temporary file first so a failed restore never leaves a
+	// truncated file in place of the original
+	tmp, err := os.CreateTemp(dir, ".savesync-restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	progress := progressFrom(ctx)
+	hash := sha256.New()
+	for _, chunkHash := range file.Chunks {
+		stored, err := backend.LoadChunk(ctx, chunkHash)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to load chunk %s: %w", chunkHash, err)
+		}
+		data, err := decodeChunk(chunkHash, stored)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+
+		hash.Write(data)
+		if _, err := tmp.Write(data); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write file: %w", err)
+		}
+		progress.read(int64(len(data)))
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != file.Hash {
+		return fmt.Errorf("%w: hash mismatch (expected %s, got %s)", domain.ErrSnapshotInvalid, file.Hash, sum)
+	}
+
+	if err := os.Rename(tmpPath, targetPath); err != nil {
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+
+	return nil
+}
+
+// restorePath resolves a manifest path inside the destination directory,
+// rejecting entries that would escape it
+func restorePath(destination, sourcePath, filePath string) (string, error) {
+	relPath := filePath
+	if filepath.IsAbs(relPath) {
+		rel, err := filepath.Rel(sourcePath, relPath)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", domain.ErrInvalidPath, filePath)
+		}
+		relPath = rel
+	}
+
+	relPath = filepath.Clean(relPath)
+	if relPath == "." || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) || filepath.IsAbs(relPath) {
+		return "", fmt.Errorf("%w: %s", domain.ErrInvalidPath, filePath)
+	}
+
+	return filepath.Join(destination, relPath), nil
+}
+
+// mkdirInside creates dir and its missing parents below destination. Unlike
+// os.MkdirAll, it never follows a symlink already in the way, which could
+// lead the restore outside of the destination: every existing path between
+// destination and dir must be a real directory.
+func mkdirInside(destination, dir string, perm os.FileMode) error {
+	rel, err := filepath.Rel(destination, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%w: %s is outside of %s", domain.ErrInvalidPath, dir, destination)
+	}
+	if rel == "." {
+		return nil
+	}
+
+	current := destination
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(current, perm); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%w: %s is not a directory", domain.ErrInvalidPath, current)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package backupservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/axelfrache/savesync/internal/infra/backends"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestBackupService_RestoreSnapshot(t *testing.T) {
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	mockBackend := new(MockBackend)
+	logger, _ := zap.NewDevelopment()
+
+	service := New(new(MockSourceRepository), new(MockTargetRepository), mockSnapshotRepo, new(MockJobRepository), newMemoryChunkRepo(), nil, logger)
+
+	chunkA := []byte("hello ")
+	chunkB := []byte("world")
+	content := append(append([]byte(nil), chunkA...), chunkB...)
+	modTime := time.Date(2025, 1, 21, 10, 0, 0, 0, time.UTC)
+
+	manifest := domain.Manifest{
+		SnapshotID: 1,
+		SourcePath: "/original/source",
+		Files: []domain.ManifestFile{
+			{
+				Path:    filepath.Join("dir", "file.txt"),
+				Size:    int64(len(content)),
+				Hash:    sha256Hex(content),
+				Chunks:  []string{sha256Hex(chunkA), sha256Hex(chunkB)},
+				ModTime: modTime,
+			},
+		},
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	assert.NoError(t, err)
+
+	mockSnapshotRepo.On("GetByID", mock.Anything, int64(1)).Return(&domain.Snapshot{ID: 1, Status: "success"}, nil)
+	mockBackend.On("LoadManifest", mock.Anything, "1").Return(manifestJSON, nil)
+	mockBackend.On("LoadChunk", mock.Anything, sha256Hex(chunkA)).Return(chunkA, nil)
+	mockBackend.On("LoadChunk", mock.Anything, sha256Hex(chunkB)).Return(chunkB, nil)
+
+	destination := t.TempDir()
+	progress := NewProgress()
+	err = service.RestoreSnapshot(WithProgress(context.Background(), progress), 1, backends.Adapt(mockBackend), RestoreOptions{Destination: destination})
+	assert.NoError(t, err)
+
+	restored, err := os.ReadFile(filepath.Join(destination, "dir", "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, restored)
+
+	report := progress.Report()
+	assert.Equal(t, int64(1), report.FilesProcessed)
+	assert.Equal(t, int64(len(content)), report.BytesRead)
+	assert.Equal(t, filepath.Join("dir", "file.txt"), report.CurrentPath)
+
+	info, err := os.Stat(filepath.Join(destination, "dir", "file.txt"))
+	assert.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(modTime))
+
+	mockBackend.AssertExpectations(t)
+}
+
+func TestBackupService_RestoreSnapshot_HashMismatch(t *testing.T) {
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	mockBackend := new(MockBackend)
+	logger, _ := zap.NewDevelopment()
+
+	service := New(new(MockSourceRepository), new(MockTargetRepository), mockSnapshotRepo, new(MockJobRepository), newMemoryChunkRepo(), nil, logger)
+
+	chunk := []byte("corrupted")
+	manifest := domain.Manifest{
+		SnapshotID: 1,
+		SourcePath: "/original/source",
+		Files: []domain.ManifestFile{
+			{Path: "file.txt", Size: 9, Hash: sha256Hex([]byte("original!")), Chunks: []string{"abcd"}},
+		},
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	assert.NoError(t, err)
+
+	mockSnapshotRepo.On("GetByID", mock.Anything, int64(1)).Return(&domain.Snapshot{ID: 1, Status: "success"}, nil)
+	mockBackend.On("LoadManifest", mock.Anything, "1").Return(manifestJSON, nil)
+	mockBackend.On("LoadChunk", mock.Anything, "abcd").Return(chunk, nil)
+
+	destination := t.TempDir()
+	err = service.RestoreSnapshot(context.Background(), 1, backends.Adapt(mockBackend), RestoreOptions{Destination: destination})
+	assert.ErrorIs(t, err, domain.ErrSnapshotInvalid)
+
+	// Nothing should be left behind, not even the temporary file
+	entries, err := os.ReadDir(destination)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestRestorePath(t *testing.T) {
+	path, err := restorePath("/restore", "/src", "a/b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("/restore", "a", "b.txt"), path)
+
+	path, err = restorePath("/restore", "/src", "/src/a/b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("/restore", "a", "b.txt"), path)
+
+	_, err = restorePath("/restore", "/src", "../etc/passwd")
+	assert.ErrorIs(t, err, domain.ErrInvalidPath)
+}
+
+func TestBackupService_RestoreSnapshot_RefusesExistingSymlinks(t *testing.T) {
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	mockBackend := new(MockBackend)
+	service := New(new(MockSourceRepository), new(MockTargetRepository), mockSnapshotRepo, new(MockJobRepository), newMemoryChunkRepo(), nil, zap.NewNop())
+
+	content := []byte("payload")
+	manifest := domain.Manifest{
+		SnapshotID: 1,
+		SourcePath: "/original/source",
+		Files: []domain.ManifestFile{
+			{Path: filepath.Join("dir", "file.txt"), Size: int64(len(content)), Hash: sha256Hex(content), Chunks: []string{sha256Hex(content)}},
+		},
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	assert.NoError(t, err)
+
+	mockSnapshotRepo.On("GetByID", mock.Anything, int64(1)).Return(&domain.Snapshot{ID: 1, Status: "success"}, nil)
+	mockBackend.On("LoadManifest", mock.Anything, "1").Return(manifestJSON, nil)
+	mockBackend.On("LoadChunk", mock.Anything, sha256Hex(content)).Return(content, nil)
+
+	// The destination already holds a symlink where the snapshot has a directory
+	destination := t.TempDir()
+	outside := t.TempDir()
+	assert.NoError(t, os.Symlink(outside, filepath.Join(destination, "dir")))
+
+	err = service.RestoreSnapshot(context.Background(), 1, backends.Adapt(mockBackend), RestoreOptions{Destination: destination})
+	assert.ErrorIs(t, err, domain.ErrInvalidPath)
+
+	entries, err := os.ReadDir(outside)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestBackupService_RestoreSnapshot_SkipsDanglingHardLinks(t *testing.T) {
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	mockBackend := new(MockBackend)
+	service := New(new(MockSourceRepository), new(MockTargetRepository), mockSnapshotRepo, new(MockJobRepository), newMemoryChunkRepo(), nil, zap.NewNop())
+
+	// Older backups kept the links of a first path which could not be read
+	content := []byte("payload")
+	manifest := domain.Manifest{
+		SnapshotID: 1,
+		SourcePath: "/original/source",
+		Files: []domain.ManifestFile{
+			{Path: "link.txt", Size: 7, HardLink: "unreadable.txt"},
+			{Path: "file.txt", Size: int64(len(content)), Hash: sha256Hex(content), Chunks: []string{sha256Hex(content)}},
+		},
+		Errors: []domain.FileError{{Path: "unreadable.txt", Reason: "open: permission denied"}},
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	assert.NoError(t, err)
+
+	mockSnapshotRepo.On("GetByID", mock.Anything, int64(1)).Return(&domain.Snapshot{ID: 1, Status: "partial"}, nil)
+	mockBackend.On("LoadManifest", mock.Anything, "1").Return(manifestJSON, nil)
+	mockBackend.On("LoadChunk", mock.Anything, sha256Hex(content)).Return(content, nil)
+
+	destination := t.TempDir()
+	err = service.RestoreSnapshot(context.Background(), 1, backends.Adapt(mockBackend), RestoreOptions{Destination: destination})
+	assert.NoError(t, err)
+
+	restored, err := os.ReadFile(filepath.Join(destination, "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, restored)
+	_, err = os.Lstat(filepath.Join(destination, "link.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
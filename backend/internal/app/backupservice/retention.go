@@ -0,0 +1,207 @@
+package backupservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"go.uber.org/zap"
+)
+
+// Reasons for keeping a snapshot, as reported by ForgetSnapshots
+const (
+	RetainLast    = "last"
+	RetainHourly  = "hourly"
+	RetainDaily   = "daily"
+	RetainWeekly  = "weekly"
+	RetainMonthly = "monthly"
+	RetainYearly  = "yearly"
+	RetainWithin  = "within"
+	RetainInUse   = "in_use" // Read by a pending or running restore
+)
+
+// RetentionDecision is a snapshot considered by a retention policy, with the
+// reasons it is kept, if any
+type RetentionDecision struct {
+	Snapshot *domain.Snapshot `json:"snapshot"`
+	Reasons  []string         `json:"reasons,omitempty"`
+}
+
+// ForgetResult lists the snapshots kept and forgotten by a retention policy,
+// newest first
+type ForgetResult struct {
+	DryRun bool                `json:"dry_run"`
+	Keep   []RetentionDecision `json:"keep"`
+	Forget []RetentionDecision `json:"forget"`
+}
+
+// retentionBucket assigns a snapshot time to a period of a GFS rule
+type retentionBucket struct {
+	reason string
+	count  int // Periods left to keep
+	period func(t time.Time) string
+	last   string // Period of the latest snapshot kept
+}
+
+// ForgetSnapshots applies a retention policy to the completed snapshots of a
+// source on its current target, deleting the manifests and rows of the
+// snapshots no rule keeps. The policy of the source is used when policy is
+// nil. With dryRun, nothing is deleted.
+func (s *Service) ForgetSnapshots(ctx context.Context, sourceID int64, policy *domain.RetentionPolicy, backend domain.Backend, dryRun bool) (*ForgetResult, error) {
+	source, err := s.sourceRepo.GetByID(ctx, sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get source: %w", err)
+	}
+	if policy == nil {
+		policy = source.Retention
+	}
+	if policy == nil {
+		return nil, fmt.Errorf("%w: source has no retention policy", domain.ErrInvalidInput)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	if source.TargetID == nil {
+		return nil, fmt.Errorf("%w: source has no target configured", domain.ErrInvalidInput)
+	}
+
+	return s.forget(ctx, source, policy, backend, dryRun)
+}
+
+// forget selects the snapshots of a source to keep and deletes the others
+// unless dryRun is set
+func (s *Service) forget(ctx context.Context, source *domain.Source, policy *domain.RetentionPolicy, backend domain.Backend, dryRun bool) (*ForgetResult, error) {
+	snapshots, err := s.snapshotRepo.GetBySourceID(ctx, source.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	// Snapshots of other targets have their manifest elsewhere, and those
+	// which are not completed have none: both are left alone
+	var candidates []*domain.Snapshot
+	for _, snapshot := range snapshots {
+		if snapshot.Completed() && snapshot.TargetID == *source.TargetID {
+			candidates = append(candidates, snapshot)
+		}
+	}
+
+	inUse, err := s.restoringSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keep, forget, err := selectRetained(candidates, policy, inUse, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ForgetResult{DryRun: dryRun, Keep: keep, Forget: forget}
+	if dryRun {
+		return result, nil
+	}
+
+	logger := s.log(ctx)
+	for _, decision := range forget {
+		id := decision.Snapshot.ID
+		// The manifest goes first: a row without its manifest could still be
+		// picked for a restore
+		if err := backend.DeleteManifest(ctx, strconv.FormatInt(id, 10)); err != nil && !errors.Is(err, domain.ErrNotFound) {
+			return nil, fmt.Errorf("failed to delete manifest of snapshot %d: %w", id, err)
+		}
+		if err := s.snapshotRepo.Delete(ctx, id); err != nil && !errors.Is(err, domain.ErrNotFound) {
+			return nil, fmt.Errorf("failed to delete snapshot %d: %w", id, err)
+		}
+		logger.Info("snapshot forgotten", zap.Int64("snapshot_id", id), zap.Int64("source_id", source.ID))
+	}
+
+	return result, nil
+}
+
+// restoringSnapshots returns the IDs of the snapshots read by pending or
+// running restore jobs
+func (s *Service) restoringSnapshots(ctx context.Context) (map[int64]bool, error) {
+	inUse := make(map[int64]bool)
+	for _, status := range []string{domain.JobPending, domain.JobRunning} {
+		jobs, err := s.jobRepo.GetByStatus(ctx, status)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s jobs: %w", status, err)
+		}
+		for _, job := range jobs {
+			if job.Type == domain.JobTypeRestore && job.SnapshotID != nil {
+				inUse[*job.SnapshotID] = true
+			}
+		}
+	}
+	return inUse, nil
+}
+
+// selectRetained splits snapshots between those a policy keeps and those it
+// forgets. Each GFS rule keeps the newest snapshot of each of its last N
+// periods which have one; periods use the local time zone.
+func selectRetained(snapshots []*domain.Snapshot, policy *domain.RetentionPolicy, inUse map[int64]bool, now time.Time) (keep, forget []RetentionDecision, err error) {
+	cutoff, err := policy.WithinCutoff(now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sorted := append([]*domain.Snapshot(nil), snapshots...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].CreatedAt.Equal(sorted[j].CreatedAt) {
+			return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+		}
+		return sorted[i].ID > sorted[j].ID
+	})
+
+	buckets := []*retentionBucket{
+		{reason: RetainHourly, count: policy.KeepHourly, period: func(t time.Time) string { return t.Format("2006-01-02 15") }},
+		{reason: RetainDaily, count: policy.KeepDaily, period: func(t time.Time) string { return t.Format("2006-01-02") }},
+		{reason: RetainWeekly, count: policy.KeepWeekly, period: func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{reason: RetainMonthly, count: policy.KeepMonthly, period: func(t time.Time) string { return t.Format("2006-01") }},
+		{reason: RetainYearly, count: policy.KeepYearly, period: func(t time.Time) string { return t.Format("2006") }},
+	}
+	keep, forget = []RetentionDecision{}, []RetentionDecision{}
+
+	for i, snapshot := range sorted {
+		var reasons []string
+		if i < policy.KeepLast {
+			reasons = append(reasons, RetainLast)
+		}
+
+		created := snapshot.CreatedAt.Local()
+		for _, bucket := range buckets {
+			if bucket.count == 0 {
+				continue
+			}
+			period := bucket.period(created)
+			if period == bucket.last {
+				continue
+			}
+			bucket.last = period
+			bucket.count--
+			reasons = append(reasons, bucket.reason)
+		}
+
+		if !cutoff.IsZero() && snapshot.CreatedAt.After(cutoff) {
+			reasons = append(reasons, RetainWithin)
+		}
+		if inUse[snapshot.ID] {
+			reasons = append(reasons, RetainInUse)
+		}
+
+		decision := RetentionDecision{Snapshot: snapshot, Reasons: reasons}
+		if len(reasons) > 0 {
+			keep = append(keep, decision)
+		} else {
+			forget = append(forget, decision)
+		}
+	}
+
+	return keep, forget, nil
+}
@@ -0,0 +1,165 @@
+package backupservice
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// retainedIDs returns the snapshot IDs of retention decisions, with their reasons
+func retainedIDs(decisions []RetentionDecision) map[int64][]string {
+	ids := make(map[int64][]string, len(decisions))
+	for _, decision := range decisions {
+		ids[decision.Snapshot.ID] = decision.Reasons
+	}
+	return ids
+}
+
+func TestSelectRetained(t *testing.T) {
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2026, month, day, hour, 0, 0, 0, time.Local)
+	}
+	snapshots := []*domain.Snapshot{
+		{ID: 1, CreatedAt: at(time.January, 10, 12)},
+		{ID: 2, CreatedAt: at(time.January, 20, 12)},
+		{ID: 3, CreatedAt: at(time.February, 5, 12)},
+		{ID: 4, CreatedAt: at(time.February, 6, 8)},
+		{ID: 5, CreatedAt: at(time.February, 6, 20)},
+	}
+	now := at(time.February, 10, 12)
+
+	// The newest snapshot of a period stands for it
+	keep, forget, err := selectRetained(snapshots, &domain.RetentionPolicy{KeepDaily: 2, KeepMonthly: 2}, nil, now)
+	require.NoError(t, err)
+	assert.Equal(t, map[int64][]string{
+		5: {RetainDaily, RetainMonthly},
+		3: {RetainDaily},
+		2: {RetainMonthly},
+	}, retainedIDs(keep))
+	assert.Equal(t, map[int64][]string{4: nil, 1: nil}, retainedIDs(forget))
+	assert.Equal(t, int64(5), keep[0].Snapshot.ID, "decisions are listed newest first")
+
+	keep, _, err = selectRetained(snapshots, &domain.RetentionPolicy{KeepLast: 2, KeepWithin: "1w"}, map[int64]bool{1: true}, now)
+	require.NoError(t, err)
+	assert.Equal(t, map[int64][]string{
+		5: {RetainLast, RetainWithin},
+		4: {RetainLast, RetainWithin},
+		3: {RetainWithin},
+		1: {RetainInUse},
+	}, retainedIDs(keep))
+
+	_, forget, err = selectRetained(snapshots, &domain.RetentionPolicy{KeepYearly: 1}, nil, now)
+	require.NoError(t, err)
+	assert.Len(t, forget, 4)
+}
+
+func TestRetentionPolicy_Validate(t *testing.T) {
+	assert.NoError(t, (&domain.RetentionPolicy{KeepWithin: "1y6m2w"}).Validate())
+	assert.ErrorIs(t, (&domain.RetentionPolicy{}).Validate(), domain.ErrInvalidInput)
+	assert.ErrorIs(t, (&domain.RetentionPolicy{KeepDaily: -1}).Validate(), domain.ErrInvalidInput)
+	assert.ErrorIs(t, (&domain.RetentionPolicy{KeepWithin: "30"}).Validate(), domain.ErrInvalidInput)
+	assert.ErrorIs(t, (&domain.RetentionPolicy{KeepWithin: "3s"}).Validate(), domain.ErrInvalidInput)
+
+	now := time.Date(2026, time.March, 31, 12, 0, 0, 0, time.UTC)
+	cutoff, err := (&domain.RetentionPolicy{KeepWithin: "1m1d12h"}).WithinCutoff(now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, time.March, 2, 0, 0, 0, 0, time.UTC), cutoff) // February has no 30th
+}
+
+func TestBackupService_ForgetSnapshots(t *testing.T) {
+	mockSourceRepo := new(MockSourceRepository)
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	mockJobRepo := new(MockJobRepository)
+	service := New(mockSourceRepo, new(MockTargetRepository), mockSnapshotRepo, mockJobRepo, newMemoryChunkRepo(), nil, zap.NewNop())
+
+	targetID, otherTarget := int64(2), int64(3)
+	restored := int64(10)
+	now := time.Now()
+	snapshots := []*domain.Snapshot{
+		{ID: 14, TargetID: targetID, Status: "success", CreatedAt: now},
+		{ID: 13, TargetID: targetID, Status: "failed", CreatedAt: now.Add(-time.Hour)},
+		{ID: 12, TargetID: targetID, Status: "partial", CreatedAt: now.Add(-2 * time.Hour)},
+		{ID: 11, TargetID: otherTarget, Status: "success", CreatedAt: now.Add(-3 * time.Hour)},
+		{ID: 10, TargetID: targetID, Status: "success", CreatedAt: now.Add(-4 * time.Hour)},
+	}
+	mockSourceRepo.On("GetByID", mock.Anything, int64(1)).Return(&domain.Source{
+		ID:        1,
+		TargetID:  &targetID,
+		Retention: &domain.RetentionPolicy{KeepLast: 1},
+	}, nil)
+	mockSnapshotRepo.On("GetBySourceID", mock.Anything, int64(1)).Return(snapshots, nil)
+	mockJobRepo.On("GetByStatus", mock.Anything, domain.JobPending).Return([]*domain.Job{
+		{ID: 1, Type: domain.JobTypeRestore, SnapshotID: &restored, Status: domain.JobPending},
+	}, nil)
+	mockJobRepo.On("GetByStatus", mock.Anything, domain.JobRunning).Return([]*domain.Job{}, nil)
+
+	backend := newMemoryBackend()
+	for _, id := range []string{"14", "12", "10"} {
+		backend.manifests[id] = []byte(`{}`)
+	}
+
+	// A dry run deletes nothing: the mock has no Delete expectation
+	result, err := service.ForgetSnapshots(context.Background(), 1, nil, backend, true)
+	require.NoError(t, err)
+	assert.True(t, result.DryRun)
+	assert.Equal(t, map[int64][]string{14: {RetainLast}, 10: {RetainInUse}}, retainedIDs(result.Keep))
+	assert.Equal(t, map[int64][]string{12: nil}, retainedIDs(result.Forget))
+	assert.Len(t, backend.manifests, 3)
+
+	mockSnapshotRepo.On("Delete", mock.Anything, int64(12)).Return(nil).Once()
+	_, err = service.ForgetSnapshots(context.Background(), 1, nil, backend, false)
+	require.NoError(t, err)
+	assert.NotContains(t, backend.manifests, "12")
+	assert.Len(t, backend.manifests, 2)
+	mockSnapshotRepo.AssertExpectations(t)
+
+	// A policy given with the request replaces the one of the source, and is validated too
+	_, err = service.ForgetSnapshots(context.Background(), 1, &domain.RetentionPolicy{}, backend, true)
+	assert.ErrorIs(t, err, domain.ErrInvalidInput)
+}
+
+func TestBackupService_RunBackupAppliesRetention(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0644))
+
+	mockSourceRepo := new(MockSourceRepository)
+	mockTargetRepo := new(MockTargetRepository)
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	mockJobRepo := new(MockJobRepository)
+	service := New(mockSourceRepo, mockTargetRepo, mockSnapshotRepo, mockJobRepo, newMemoryChunkRepo(), nil, zap.NewNop())
+
+	targetID := int64(2)
+	mockSourceRepo.On("GetByID", mock.Anything, int64(1)).Return(&domain.Source{
+		ID:        1,
+		Path:      dir,
+		TargetID:  &targetID,
+		Retention: &domain.RetentionPolicy{KeepLast: 1},
+	}, nil)
+	mockTargetRepo.On("GetByID", mock.Anything, targetID).Return(&domain.Target{ID: targetID, ConfigJSON: `{}`}, nil)
+
+	// The retention policy sees the new snapshot, which is always stored as ID 1
+	previous := &domain.Snapshot{ID: 7, TargetID: targetID, Status: "success", CreatedAt: time.Now().Add(-time.Hour)}
+	current := &domain.Snapshot{ID: 1, TargetID: targetID, Status: "success", CreatedAt: time.Now()}
+	mockSnapshotRepo.On("GetBySourceID", mock.Anything, int64(1)).Return([]*domain.Snapshot{previous}, nil).Once()
+	mockSnapshotRepo.On("GetBySourceID", mock.Anything, int64(1)).Return([]*domain.Snapshot{current, previous}, nil).Once()
+	mockSnapshotRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+	mockSnapshotRepo.On("Update", mock.Anything, mock.Anything).Return(nil)
+	mockSnapshotRepo.On("Delete", mock.Anything, int64(7)).Return(nil).Once()
+	mockJobRepo.On("GetByStatus", mock.Anything, mock.Anything).Return([]*domain.Job{}, nil)
+
+	backend := newMemoryBackend()
+	backend.manifests["7"] = []byte(`{"snapshot_id":7,"files":[]}`)
+
+	require.NoError(t, service.RunBackup(context.Background(), 1, backend))
+	assert.NotContains(t, backend.manifests, "7")
+	assert.Contains(t, backend.manifests, "1")
+	mockSnapshotRepo.AssertExpectations(t)
+}
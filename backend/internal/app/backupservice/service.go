@@ -2,9 +2,9 @@ package backupservice
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"io"
 	"path/filepath"
 	"strconv"
 	"time"
@@ -20,8 +20,10 @@ type Service struct {
 	targetRepo   domain.TargetRepository
 	snapshotRepo domain.SnapshotRepository
 	jobRepo      domain.JobRepository
+	chunkRepo    domain.ChunkRepository
+	events       domain.EventPublisher
 	logger       *zap.Logger
-	chunker      *Chunker
+	locks        *repoLocks // Repository lock of each target, see Prune
 }
 
 // New creates a new backup service
@@ -30,6 +32,8 @@ func New(
 	targetRepo domain.TargetRepository,
 	snapshotRepo domain.SnapshotRepository,
 	jobRepo domain.JobRepository,
+	chunkRepo domain.ChunkRepository,
+	events domain.EventPublisher,
 	logger *zap.Logger,
 ) *Service {
 	return &Service{
@@ -37,14 +41,19 @@ func New(
 		targetRepo:   targetRepo,
 		snapshotRepo: snapshotRepo,
 		jobRepo:      jobRepo,
+		chunkRepo:    chunkRepo,
+		events:       events,
 		logger:       logger,
-		chunker:      NewChunker(DefaultChunkSize),
+		locks:        newRepoLocks(),
 	}
 }
 
-// RunBackup executes a backup for a source
+// RunBackup executes a backup for a source. Its progress is reported to the
+// tracker of the context, if any (see WithProgress), and it logs to the
+// logger of the context (see WithLogger).
 func (s *Service) RunBackup(ctx context.Context, sourceID int64, backend domain.Backend) error {
 	startTime := time.Now()
+	logger := s.log(ctx)
 
 	// Get source
 	source, err := s.sourceRepo.GetByID(ctx, sourceID)
@@ -57,6 +66,43 @@ func (s *Service) RunBackup(ctx context.Context, sourceID int64, backend domain.
 		return fmt.Errorf("source has no target configured")
 	}
 
+	// Load the chunking settings of the target repository
+	target, err := s.targetRepo.GetByID(ctx, *source.TargetID)
+	if err != nil {
+		return fmt.Errorf("failed to get target: %w", err)
+	}
+
+	opts, err := parseTargetOptions(target)
+	if err != nil {
+		return err
+	}
+	if err := opts.applySource(source); err != nil {
+		return err
+	}
+
+	// Backups of a target run side by side, but not during a prune
+	release, err := s.locks.acquire(ctx, *source.TargetID, false, func() {
+		logger.Info("waiting for the prune of the target to finish", zap.Int64("target_id", *source.TargetID))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to lock repository: %w", err)
+	}
+	defer release()
+
+	chunker, err := NewChunker(opts.chunker)
+	if err != nil {
+		return fmt.Errorf("invalid chunker settings: %w", err)
+	}
+
+	compressor, err := newCompressor(opts.compression, opts.compressionLevel)
+	if err != nil {
+		return err
+	}
+	defer compressor.close()
+
+	// Reuse unchanged files from the latest completed snapshot
+	parent, parentFiles := s.loadParent(ctx, sourceID, *source.TargetID, backend)
+
 	// Create snapshot
 	snapshot := &domain.Snapshot{
 		SourceID:  sourceID,
@@ -64,137 +110,94 @@ func (s *Service) RunBackup(ctx context.Context, sourceID int64, backend domain.
 		Status:    "running",
 		CreatedAt: time.Now(),
 	}
+	if parent != nil {
+		snapshot.ParentID = &parent.ID
+	}
 
 	if err := s.snapshotRepo.Create(ctx, snapshot); err != nil {
 		return fmt.Errorf("failed to create snapshot: %w", err)
 	}
 
-	s.logger.Info("starting backup",
+	logger.Info("starting backup",
 		zap.Int64("snapshot_id", snapshot.ID),
 		zap.Int64("source_id", sourceID),
 		zap.String("path", source.Path),
+		zap.Int64p("parent_id", snapshot.ParentID),
 	)
 
 	// Scan and backup files
-	var manifestFiles []domain.ManifestFile
-	var totalBytes int64
-	var deltaBytes int64
-	fileCount := 0
-
-	err = filepath.Walk(source.Path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip directories
-		if info.IsDir() {
-			return nil
-		}
-
-		// Check exclusions
-		relPath, _ := filepath.Rel(source.Path, path)
-		if s.shouldExclude(relPath, source.Exclusions) {
-			s.logger.Debug("excluding file", zap.String("path", relPath))
-			return nil
-		}
-
-		// Hash file
-		fileHash, err := HashFile(path)
-		if err != nil {
-			s.logger.Warn("failed to hash file", zap.Error(err), zap.String("path", path))
-			return nil // Skip file but continue
-		}
-
-		// Chunk file
-		chunks, err := s.chunker.ChunkFile(path)
-		if err != nil {
-			s.logger.Warn("failed to chunk file", zap.Error(err), zap.String("path", path))
-			return nil // Skip file but continue
-		}
-
-		// Upload chunks with deduplication
-		var chunkHashes []string
-		for _, chunk := range chunks {
-			// Check if chunk already exists
-			exists, err := backend.ChunkExists(ctx, chunk.Hash)
-			if err != nil {
-				return fmt.Errorf("failed to check chunk existence: %w", err)
-			}
-
-			if !exists {
-				// Upload new chunk
-				if err := backend.StoreChunk(ctx, chunk.Hash, chunk.Data); err != nil {
-					return fmt.Errorf("failed to store chunk: %w", err)
-				}
-				deltaBytes += chunk.Size
-			}
-
-			chunkHashes = append(chunkHashes, chunk.Hash)
-			totalBytes += chunk.Size
-		}
-
-		// Add to manifest
-		manifestFiles = append(manifestFiles, domain.ManifestFile{
-			Path:    relPath,
-			Size:    info.Size(),
-			Hash:    fileHash,
-			Chunks:  chunkHashes,
-			ModTime: info.ModTime(),
-		})
-
-		fileCount++
-
-		if fileCount%100 == 0 {
-			s.logger.Info("backup progress",
-				zap.Int("files", fileCount),
-				zap.Int64("bytes", totalBytes),
-			)
-		}
-
-		return nil
-	})
-
+	run := &backupRun{
+		service:    s,
+		source:     source,
+		backend:    backend,
+		chunker:    chunker,
+		compressor: compressor,
+		opts:       opts,
+		parent:     parentFiles,
+		progress:   progressFrom(ctx),
+		logger:     logger,
+	}
+	result, err := run.scan(ctx)
 	if err != nil {
-		// Update snapshot as failed
-		snapshot.Status = "failed"
-		errMsg := err.Error()
-		snapshot.Error = &errMsg
-		now := time.Now()
-		snapshot.CompletedAt = &now
-		s.snapshotRepo.Update(ctx, snapshot)
+		s.failSnapshot(ctx, snapshot, err)
+		return fmt.Errorf("backup failed: %w", err)
+	}
 
-		observability.ErrorCountTotal.WithLabelValues("backup").Inc()
+	fileCount := result.fileCount
+	totalBytes := result.totalBytes
+	deltaBytes := result.deltaBytes
+
+	// Unreadable files make the snapshot partial, or fail it past the limit of the target
+	snapshot.ErrorCount = len(result.fileErrors)
+	if opts.maxFileErrors >= 0 && snapshot.ErrorCount > opts.maxFileErrors {
+		err := fmt.Errorf("too many unreadable files: %d, the limit is %d", snapshot.ErrorCount, opts.maxFileErrors)
+		s.failSnapshot(ctx, snapshot, err)
 		return fmt.Errorf("backup failed: %w", err)
 	}
 
 	// Create and store manifest
+	chunkerParams := chunker.Params()
 	manifest := domain.Manifest{
 		SnapshotID: snapshot.ID,
 		SourcePath: source.Path,
-		Files:      manifestFiles,
+		ParentID:   snapshot.ParentID,
+		Chunker:    &chunkerParams,
+		Files:      result.files,
+		Errors:     result.fileErrors,
 		CreatedAt:  time.Now(),
 	}
 
-	manifestJSON, err := json.Marshal(manifest)
-	if err != nil {
-		return fmt.Errorf("failed to marshal manifest: %w", err)
-	}
-
-	if err := backend.StoreManifest(ctx, strconv.FormatInt(snapshot.ID, 10), manifestJSON); err != nil {
+	if err := storeManifest(ctx, backend, &manifest); err != nil {
+		s.failSnapshot(ctx, snapshot, err)
 		return fmt.Errorf("failed to store manifest: %w", err)
 	}
 
 	// Update snapshot as success
 	snapshot.Status = "success"
+	if snapshot.ErrorCount > 0 {
+		snapshot.Status = "partial"
+	}
 	snapshot.FileCount = fileCount
 	snapshot.TotalBytes = totalBytes
 	snapshot.DeltaBytes = deltaBytes
+	snapshot.StoredBytes = result.storedBytes
+	if len(result.filterSkips) > 0 {
+		snapshot.FilterSkips = result.filterSkips
+	}
 	now := time.Now()
 	snapshot.CompletedAt = &now
 
 	if err := s.snapshotRepo.Update(ctx, snapshot); err != nil {
 		return fmt.Errorf("failed to update snapshot: %w", err)
 	}
+	s.publishSnapshot(snapshot)
+
+	// A failed cleanup leaves extra snapshots behind but doesn't fail the backup
+	if source.Retention != nil {
+		if _, err := s.forget(ctx, source, source.Retention, backend, false); err != nil {
+			logger.Warn("failed to apply retention policy", zap.Error(err), zap.Int64("source_id", sourceID))
+		}
+	}
 
 	// Update metrics
 	duration := time.Since(startTime).Seconds()
@@ -218,30 +221,102 @@ func (s *Service) RunBackup(ctx context.Context, sourceID int64, backend domain.
 		source.Name,
 	).Add(float64(deltaBytes))
 
-	s.logger.Info("backup completed",
+	logger.Info("backup completed",
 		zap.Int64("snapshot_id", snapshot.ID),
+		zap.String("status", snapshot.Status),
 		zap.Int("files", fileCount),
+		zap.Int("file_errors", snapshot.ErrorCount),
+		zap.Int("reused_files", result.reusedFiles),
 		zap.Int64("total_bytes", totalBytes),
 		zap.Int64("delta_bytes", deltaBytes),
+		zap.Int64("stored_bytes", result.storedBytes),
 		zap.Float64("duration_seconds", duration),
 	)
 
 	return nil
 }
 
-// shouldExclude checks if a file should be excluded based on patterns
-func (s *Service) shouldExclude(path string, patterns []string) bool {
-	for _, pattern := range patterns {
-		matched, err := filepath.Match(pattern, filepath.Base(path))
-		if err != nil {
-			s.logger.Warn("invalid exclusion pattern", zap.String("pattern", pattern), zap.Error(err))
-			continue
-		}
-		if matched {
-			return true
+type loggerKey struct{}
+
+// WithLogger returns a context whose backup or restore logs to logger rather
+// than to the service logger, so that a job can keep its own log
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// log returns the logger of the backup or restore running with ctx
+func (s *Service) log(ctx context.Context) *zap.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return s.logger
+}
+
+// failSnapshot records why a backup stopped. A backup whose context was
+// cancelled is cancelled when its job was, and interrupted when the daemon is
+// shutting down, rather than failed.
+func (s *Service) failSnapshot(ctx context.Context, snapshot *domain.Snapshot, err error) {
+	errMsg := err.Error()
+	switch {
+	case errors.Is(context.Cause(ctx), domain.ErrJobCancelled):
+		snapshot.Status = "cancelled"
+		errMsg = "cancelled: the job was cancelled during the backup"
+	case ctx.Err() != nil:
+		snapshot.Status = "interrupted"
+		errMsg = "interrupted: the daemon shut down during the backup"
+	default:
+		snapshot.Status = "failed"
+		observability.ErrorCountTotal.WithLabelValues("backup").Inc()
+	}
+	snapshot.Error = &errMsg
+	now := time.Now()
+	snapshot.CompletedAt = &now
+
+	// The update must go through even when the backup was cancelled
+	if updateErr := s.snapshotRepo.Update(context.WithoutCancel(ctx), snapshot); updateErr != nil {
+		s.log(ctx).Error("failed to update snapshot", zap.Error(updateErr), zap.Int64("snapshot_id", snapshot.ID))
+		return
+	}
+	s.publishSnapshot(snapshot)
+}
+
+// publishSnapshot announces the outcome of a backup, when events are enabled
+func (s *Service) publishSnapshot(snapshot *domain.Snapshot) {
+	if s.events == nil {
+		return
+	}
+	published := *snapshot
+	s.events.Publish(domain.Event{
+		Type:       domain.EventSnapshotCompleted,
+		SourceID:   &published.SourceID,
+		SnapshotID: &published.ID,
+		Data:       &published,
+		Time:       time.Now(),
+	})
+}
+
+// InterruptOrphanedSnapshots marks the snapshots left running by a previous
+// run of the daemon as interrupted, and returns how many there were. It must
+// be called before any backup starts.
+func (s *Service) InterruptOrphanedSnapshots(ctx context.Context) (int, error) {
+	snapshots, err := s.snapshotRepo.GetByStatus(ctx, "running")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list running snapshots: %w", err)
+	}
+
+	errMsg := "interrupted: the daemon stopped during the backup"
+	for _, snapshot := range snapshots {
+		snapshot.Status = "interrupted"
+		snapshot.Error = &errMsg
+		now := time.Now()
+		snapshot.CompletedAt = &now
+		if err := s.snapshotRepo.Update(ctx, snapshot); err != nil {
+			return 0, fmt.Errorf("failed to update snapshot %d: %w", snapshot.ID, err)
 		}
+		s.logger.Warn("orphaned snapshot marked as interrupted", zap.Int64("snapshot_id", snapshot.ID))
 	}
-	return false
+
+	return len(snapshots), nil
 }
 
 // ListSnapshots returns all snapshots
@@ -254,12 +329,6 @@ func (s *Service) GetSnapshot(ctx context.Context, id int64) (*domain.Snapshot,
 	return s.snapshotRepo.GetByID(ctx, id)
 }
 
-// RestoreSnapshot restores a snapshot
-func (s *Service) RestoreSnapshot(ctx context.Context, id int64) error {
-	// TODO: Implement restore logic
-	return fmt.Errorf("restore not implemented")
-}
-
 // GetManifest returns the manifest for a snapshot
 func (s *Service) GetManifest(ctx context.Context, id int64) ([]byte, error) {
 	snapshot, err := s.snapshotRepo.GetByID(ctx, id)
@@ -292,42 +361,37 @@ func (s *Service) GetManifest(ctx context.Context, id int64) ([]byte, error) {
 	return nil, fmt.Errorf("not implemented: requires backend injection")
 }
 
-// GetManifestWithBackend returns the manifest using the provided backend
-func (s *Service) GetManifestWithBackend(ctx context.Context, id int64, backend domain.Backend) ([]byte, error) {
-	// The manifest is stored with the snapshot ID as key (or similar)
-	// In RunBackup: backend.StoreManifest(ctx, strconv.FormatInt(snapshot.ID, 10), manifestJSON)
-
-	manifestJSON, err := backend.LoadManifest(ctx, strconv.FormatInt(id, 10))
+// OpenManifest opens the JSON manifest of a snapshot using the provided
+// backend. The caller closes it.
+func (s *Service) OpenManifest(ctx context.Context, id int64, backend domain.Backend) (io.ReadCloser, error) {
+	body, err := backend.GetObject(ctx, domain.ManifestKey(strconv.FormatInt(id, 10)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve manifest: %w", err)
 	}
 
-	return manifestJSON, nil
+	return body, nil
 }
 
 // FileNode represents a node in the file tree
 type FileNode struct {
-	Name     string      `json:"name"`
-	Path     string      `json:"path"`
-	IsDir    bool        `json:"is_dir"`
-	Size     int64       `json:"size,omitempty"`
-	ModTime  string      `json:"mod_time,omitempty"`
-	Children []*FileNode `json:"children,omitempty"`
+	Name       string      `json:"name"`
+	Path       string      `json:"path"`
+	IsDir      bool        `json:"is_dir"`
+	Type       string      `json:"type,omitempty"` // Manifest entry type, see the domain.Node* constants
+	Size       int64       `json:"size,omitempty"`
+	ModTime    string      `json:"mod_time,omitempty"`
+	LinkTarget string      `json:"link_target,omitempty"`
+	Children   []*FileNode `json:"children,omitempty"`
 }
 
 // GetSnapshotFileTree builds a hierarchical file tree from the manifest
 func (s *Service) GetSnapshotFileTree(ctx context.Context, id int64, backend domain.Backend) (*FileNode, error) {
 	// Get manifest
-	manifestJSON, err := s.GetManifestWithBackend(ctx, id, backend)
+	manifest, err := loadManifest(ctx, backend, id)
 	if err != nil {
 		return nil, err
 	}
 
-	var manifest domain.Manifest
-	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
-		return nil, fmt.Errorf("failed to parse manifest: %w", err)
-	}
-
 	// Create root node
 	root := &FileNode{
 		Name:     filepath.Base(manifest.SourcePath),
@@ -372,42 +436,51 @@ func (s *Service) insertFileIntoTree(root *FileNode, file domain.ManifestFile, s
 		if part == "." || part == "" {
 			continue
 		}
+		current = childDir(current, part)
+	}
 
-		// Find or create directory
-		found := false
-		for _, child := range current.Children {
-			if child.Name == part && child.IsDir {
-				current = child
-				found = true
-				break
-			}
-		}
+	fileName := parts[len(parts)-1]
+	if fileName == "." || fileName == "" {
+		return
+	}
 
-		if !found {
-			// Create new directory node
-			newDir := &FileNode{
-				Name:     part,
-				Path:     filepath.Join(current.Path, part),
-				IsDir:    true,
-				Children: make([]*FileNode, 0),
-			}
-			current.Children = append(current.Children, newDir)
-			current = newDir
-		}
+	// Directories may already exist as parents of earlier entries
+	if file.Type == domain.NodeDir {
+		dir := childDir(current, fileName)
+		dir.Type = file.Type
+		dir.ModTime = file.ModTime.Format(time.RFC3339)
+		return
 	}
 
 	// Add the file
-	fileName := parts[len(parts)-1]
-	if fileName != "." && fileName != "" {
-		fileNode := &FileNode{
-			Name:    fileName,
-			Path:    file.Path,
-			IsDir:   false,
-			Size:    file.Size,
-			ModTime: file.ModTime.Format(time.RFC3339),
+	fileNode := &FileNode{
+		Name:       fileName,
+		Path:       file.Path,
+		IsDir:      false,
+		Type:       file.Type,
+		Size:       file.Size,
+		ModTime:    file.ModTime.Format(time.RFC3339),
+		LinkTarget: file.LinkTarget,
+	}
+	current.Children = append(current.Children, fileNode)
+}
+
+// childDir finds or creates the directory node named name under parent
+func childDir(parent *FileNode, name string) *FileNode {
+	for _, child := range parent.Children {
+		if child.Name == name && child.IsDir {
+			return child
 		}
-		current.Children = append(current.Children, fileNode)
 	}
+
+	dir := &FileNode{
+		Name:     name,
+		Path:     filepath.Join(parent.Path, name),
+		IsDir:    true,
+		Children: make([]*FileNode, 0),
+	}
+	parent.Children = append(parent.Children, dir)
+	return dir
 }
 
 // splitPath splits a file path into its components
@@ -2,11 +2,15 @@ package backupservice
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/axelfrache/savesync/internal/infra/backends"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.uber.org/zap"
@@ -83,6 +87,10 @@ func (m *MockSnapshotRepository) GetBySourceID(ctx context.Context, sourceID int
 	args := m.Called(ctx, sourceID)
 	return args.Get(0).([]*domain.Snapshot), args.Error(1)
 }
+func (m *MockSnapshotRepository) GetByStatus(ctx context.Context, status string) ([]*domain.Snapshot, error) {
+	args := m.Called(ctx, status)
+	return args.Get(0).([]*domain.Snapshot), args.Error(1)
+}
 func (m *MockSnapshotRepository) Delete(ctx context.Context, id int64) error {
 	return m.Called(ctx, id).Error(0)
 }
@@ -103,10 +111,39 @@ func (m *MockJobRepository) GetAll(ctx context.Context) ([]*domain.Job, error) {
 	args := m.Called(ctx)
 	return args.Get(0).([]*domain.Job), args.Error(1)
 }
+func (m *MockJobRepository) GetByStatus(ctx context.Context, status string) ([]*domain.Job, error) {
+	args := m.Called(ctx, status)
+	return args.Get(0).([]*domain.Job), args.Error(1)
+}
+func (m *MockJobRepository) GetActiveBackups(ctx context.Context, sourceID int64) ([]*domain.Job, error) {
+	args := m.Called(ctx, sourceID)
+	return args.Get(0).([]*domain.Job), args.Error(1)
+}
+func (m *MockJobRepository) ClaimNext(ctx context.Context, at time.Time) (*domain.Job, error) {
+	args := m.Called(ctx, at)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Job), args.Error(1)
+}
+func (m *MockJobRepository) Heartbeat(ctx context.Context, id int64, at time.Time) error {
+	return m.Called(ctx, id, at).Error(0)
+}
+func (m *MockJobRepository) UpdateProgress(ctx context.Context, id int64, progress *domain.JobProgress) error {
+	return m.Called(ctx, id, progress).Error(0)
+}
+func (m *MockJobRepository) UpdateResult(ctx context.Context, id int64, result json.RawMessage) error {
+	return m.Called(ctx, id, result).Error(0)
+}
+func (m *MockJobRepository) CancelPending(ctx context.Context, id int64, at time.Time) error {
+	return m.Called(ctx, id, at).Error(0)
+}
 func (m *MockJobRepository) Delete(ctx context.Context, id int64) error {
 	return m.Called(ctx, id).Error(0)
 }
 
+// MockBackend only implements the byte-slice methods, tests adapt it with
+// backends.Adapt
 type MockBackend struct{ mock.Mock }
 
 func (m *MockBackend) Init(config map[string]string) error { return nil }
@@ -139,6 +176,19 @@ func (m *MockBackend) LoadManifest(ctx context.Context, snapshotID string) ([]by
 func (m *MockBackend) DeleteManifest(ctx context.Context, snapshotID string) error {
 	return m.Called(ctx, snapshotID).Error(0)
 }
+func (m *MockBackend) StoreKeyFile(ctx context.Context, data []byte) error {
+	return m.Called(ctx, data).Error(0)
+}
+func (m *MockBackend) LoadKeyFile(ctx context.Context) ([]byte, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]byte), args.Error(1)
+}
+func (m *MockBackend) ListChunks(ctx context.Context, fn func(domain.ObjectInfo) error) error {
+	return m.Called(ctx, fn).Error(0)
+}
+func (m *MockBackend) ListManifests(ctx context.Context, fn func(domain.ObjectInfo) error) error {
+	return m.Called(ctx, fn).Error(0)
+}
 
 func TestBackupService_RunBackup(t *testing.T) {
 	// Setup
@@ -149,7 +199,7 @@ func TestBackupService_RunBackup(t *testing.T) {
 	mockBackend := new(MockBackend)
 	logger, _ := zap.NewDevelopment()
 
-	service := New(mockSourceRepo, mockTargetRepo, mockSnapshotRepo, mockJobRepo, logger)
+	service := New(mockSourceRepo, mockTargetRepo, mockSnapshotRepo, mockJobRepo, newMemoryChunkRepo(), nil, logger)
 
 	// Create temp dir for source
 	tmpDir, err := os.MkdirTemp("", "savesync-test")
@@ -170,6 +220,8 @@ func TestBackupService_RunBackup(t *testing.T) {
 	}
 
 	mockSourceRepo.On("GetByID", mock.Anything, int64(1)).Return(source, nil)
+	mockTargetRepo.On("GetByID", mock.Anything, targetID).Return(&domain.Target{ID: targetID, Type: domain.TargetLocal}, nil)
+	mockSnapshotRepo.On("GetBySourceID", mock.Anything, int64(1)).Return([]*domain.Snapshot{}, nil)
 	mockSnapshotRepo.On("Create", mock.Anything, mock.AnythingOfType("*domain.Snapshot")).Return(nil)
 	mockSnapshotRepo.On("Update", mock.Anything, mock.AnythingOfType("*domain.Snapshot")).Return(nil)
 
@@ -179,11 +231,12 @@ func TestBackupService_RunBackup(t *testing.T) {
 	mockBackend.On("StoreManifest", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 	// Execute
-	err = service.RunBackup(context.Background(), 1, mockBackend)
+	err = service.RunBackup(context.Background(), 1, backends.Adapt(mockBackend))
 
 	// Assert
 	assert.NoError(t, err)
 	mockSourceRepo.AssertExpectations(t)
+	mockTargetRepo.AssertExpectations(t)
 	mockSnapshotRepo.AssertExpectations(t)
 	mockBackend.AssertExpectations(t)
 }
@@ -196,7 +249,7 @@ func TestBackupService_ListSnapshots(t *testing.T) {
 	mockJobRepo := new(MockJobRepository)
 	logger, _ := zap.NewDevelopment()
 
-	service := New(mockSourceRepo, mockTargetRepo, mockSnapshotRepo, mockJobRepo, logger)
+	service := New(mockSourceRepo, mockTargetRepo, mockSnapshotRepo, mockJobRepo, newMemoryChunkRepo(), nil, logger)
 
 	expectedSnapshots := []*domain.Snapshot{
 		{ID: 1, Status: "success"},
@@ -213,3 +266,35 @@ func TestBackupService_ListSnapshots(t *testing.T) {
 	assert.Equal(t, 2, len(snapshots))
 	mockSnapshotRepo.AssertExpectations(t)
 }
+
+func TestBackupService_RunBackup_BackendErrorAborts(t *testing.T) {
+	mockSourceRepo := new(MockSourceRepository)
+	mockTargetRepo := new(MockTargetRepository)
+	mockSnapshotRepo := new(MockSnapshotRepository)
+	mockBackend := new(MockBackend)
+	logger, _ := zap.NewDevelopment()
+
+	service := New(mockSourceRepo, mockTargetRepo, mockSnapshotRepo, new(MockJobRepository), newMemoryChunkRepo(), nil, logger)
+
+	tmpDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("test content"), 0644))
+
+	targetID := int64(2)
+	source := &domain.Source{ID: 1, Name: "test-source", Path: tmpDir, TargetID: &targetID}
+
+	mockSourceRepo.On("GetByID", mock.Anything, int64(1)).Return(source, nil)
+	mockTargetRepo.On("GetByID", mock.Anything, targetID).Return(&domain.Target{ID: targetID, Type: domain.TargetLocal}, nil)
+	mockSnapshotRepo.On("GetBySourceID", mock.Anything, int64(1)).Return([]*domain.Snapshot{}, nil)
+	mockSnapshotRepo.On("Create", mock.Anything, mock.AnythingOfType("*domain.Snapshot")).Return(nil)
+	mockSnapshotRepo.On("Update", mock.Anything, mock.MatchedBy(func(s *domain.Snapshot) bool {
+		return s.Status == "failed"
+	})).Return(nil)
+	mockBackend.On("ChunkExists", mock.Anything, mock.Anything).Return(false, nil)
+	mockBackend.On("StoreChunk", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("connection reset"))
+
+	err := service.RunBackup(context.Background(), 1, backends.Adapt(mockBackend))
+
+	assert.ErrorContains(t, err, "connection reset")
+	mockSnapshotRepo.AssertExpectations(t)
+	mockBackend.AssertNotCalled(t, "StoreManifest", mock.Anything, mock.Anything, mock.Anything)
+}
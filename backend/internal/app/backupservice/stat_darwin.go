@@ -0,0 +1,28 @@
+//go:build darwin
+
+package backupservice
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// statNode returns the platform-specific attributes of a file
+func statNode(info os.FileInfo) nodeStat {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nodeStat{}
+	}
+	ctime := time.Unix(stat.Ctimespec.Unix())
+	return nodeStat{
+		ok:    true,
+		dev:   uint64(stat.Dev),
+		inode: stat.Ino,
+		nlink: uint64(stat.Nlink),
+		uid:   stat.Uid,
+		gid:   stat.Gid,
+		rdev:  uint64(uint32(stat.Rdev)),
+		ctime: &ctime,
+	}
+}
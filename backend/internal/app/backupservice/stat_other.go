@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package backupservice
+
+import (
+	"os"
+)
+
+// statNode is not available on this platform; incremental scans fall back
+// to comparing size and modification time only, and ownership, hard links
+// and devices are not recorded
+func statNode(info os.FileInfo) nodeStat {
+	return nodeStat{}
+}
@@ -0,0 +1,122 @@
+package eventbus
+
+import (
+	"sync"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"go.uber.org/zap"
+)
+
+// subscriptionBuffer is how many events a subscriber may lag behind before
+// it is dropped
+const subscriptionBuffer = 64
+
+// Filter selects the events of a subscription. Unset fields match any event.
+type Filter struct {
+	SourceID *int64
+	JobID    *int64
+}
+
+// matches reports whether an event passes the filter. Events which don't
+// carry a filtered ID are excluded.
+func (f Filter) matches(event domain.Event) bool {
+	if f.SourceID != nil && (event.SourceID == nil || *event.SourceID != *f.SourceID) {
+		return false
+	}
+	if f.JobID != nil && (event.JobID == nil || *event.JobID != *f.JobID) {
+		return false
+	}
+	return true
+}
+
+// Subscription receives the events matching its filter on C. C is closed
+// when the subscription is closed, or when the subscriber falls too far
+// behind, in which case it has missed events and should reload its state.
+type Subscription struct {
+	C <-chan domain.Event
+
+	bus    *Bus
+	events chan domain.Event
+	filter Filter
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.bus.remove(s)
+}
+
+// Bus implements domain.EventPublisher, fanning events out to the
+// subscriptions of this process
+type Bus struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+	logger *zap.Logger
+}
+
+// New creates a new event bus
+func New(logger *zap.Logger) *Bus {
+	return &Bus{
+		subs:   make(map[*Subscription]struct{}),
+		logger: logger,
+	}
+}
+
+// Subscribe starts receiving the events matching a filter
+func (b *Bus) Subscribe(filter Filter) *Subscription {
+	events := make(chan domain.Event, subscriptionBuffer)
+	sub := &Subscription{C: events, bus: b, events: events, filter: filter}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(events)
+		return sub
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Publish delivers an event to the matching subscriptions without waiting
+// for them. A subscription whose buffer is full is dropped.
+func (b *Bus) Publish(event domain.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		if !sub.filter.matches(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			b.logger.Warn("dropping slow event subscriber", zap.String("event", event.Type))
+			delete(b.subs, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// Close ends every subscription, current and future, so that long-lived
+// streams let the server shut down
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub.events)
+	}
+}
+
+// remove closes a subscription unless it was already dropped
+func (b *Bus) remove(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.events)
+	}
+}
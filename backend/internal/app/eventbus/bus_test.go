@@ -0,0 +1,69 @@
+package eventbus
+
+import (
+	"testing"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func int64Ptr(v int64) *int64 { return &v }
+
+func TestBus_FiltersEvents(t *testing.T) {
+	bus := New(zap.NewNop())
+	all := bus.Subscribe(Filter{})
+	bySource := bus.Subscribe(Filter{SourceID: int64Ptr(1)})
+	byJob := bus.Subscribe(Filter{JobID: int64Ptr(7)})
+	defer all.Close()
+	defer bySource.Close()
+	defer byJob.Close()
+
+	bus.Publish(domain.Event{Type: domain.EventJobUpdated, JobID: int64Ptr(7), SourceID: int64Ptr(1)})
+	bus.Publish(domain.Event{Type: domain.EventJobUpdated, JobID: int64Ptr(8), SourceID: int64Ptr(2)})
+	bus.Publish(domain.Event{Type: domain.EventSnapshotCompleted, SourceID: int64Ptr(1), SnapshotID: int64Ptr(3)})
+
+	assert.Len(t, all.C, 3)
+	require.Len(t, bySource.C, 2)
+	assert.Equal(t, int64(7), *(<-bySource.C).JobID)
+	assert.Equal(t, domain.EventSnapshotCompleted, (<-bySource.C).Type)
+	require.Len(t, byJob.C, 1)
+	assert.Equal(t, int64(7), *(<-byJob.C).JobID)
+}
+
+func TestBus_DropsSlowSubscribers(t *testing.T) {
+	bus := New(zap.NewNop())
+	slow := bus.Subscribe(Filter{})
+	other := bus.Subscribe(Filter{SourceID: int64Ptr(2)})
+	defer other.Close()
+
+	for i := 0; i <= subscriptionBuffer; i++ {
+		bus.Publish(domain.Event{Type: domain.EventJobProgress, SourceID: int64Ptr(1)})
+	}
+
+	// The buffered events are still delivered, then the channel is closed
+	received := 0
+	for range slow.C {
+		received++
+	}
+	assert.Equal(t, subscriptionBuffer, received)
+	assert.NotPanics(t, slow.Close)
+
+	bus.Publish(domain.Event{Type: domain.EventJobUpdated, SourceID: int64Ptr(2)})
+	assert.Len(t, other.C, 1)
+}
+
+func TestBus_CloseEndsSubscriptions(t *testing.T) {
+	bus := New(zap.NewNop())
+	before := bus.Subscribe(Filter{})
+
+	bus.Close()
+	_, open := <-before.C
+	assert.False(t, open)
+
+	after := bus.Subscribe(Filter{})
+	_, open = <-after.C
+	assert.False(t, open)
+	assert.NotPanics(t, func() { bus.Publish(domain.Event{Type: domain.EventJobUpdated}) })
+}
@@ -0,0 +1,129 @@
+package jobrunner
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"go.uber.org/zap/zapcore"
+)
+
+// maxLogMessage caps the length of a single log message, in bytes
+const maxLogMessage = 2048
+
+// jobLog collects the warnings and errors logged while a job runs until they
+// are saved. Only the first max entries are kept.
+type jobLog struct {
+	jobID int64
+	max   int
+
+	mu      sync.Mutex
+	pending []*domain.JobLogEntry // Kept entries not saved yet
+	kept    int
+	dropped int
+}
+
+func newJobLog(jobID int64, max int) *jobLog {
+	return &jobLog{jobID: jobID, max: max}
+}
+
+// core returns a zap core feeding the log, to be teed with the job's logger
+func (l *jobLog) core() zapcore.Core {
+	return &jobLogCore{log: l}
+}
+
+// add records an entry, unless the log is full
+func (l *jobLog) add(entry zapcore.Entry, fields []zapcore.Field) {
+	logEntry := &domain.JobLogEntry{
+		JobID:     l.jobID,
+		Level:     entry.Level.String(),
+		Message:   entry.Message,
+		CreatedAt: entry.Time,
+	}
+	for _, field := range fields {
+		switch {
+		case field.Key == "path" && field.Type == zapcore.StringType:
+			logEntry.Path = field.String
+		case field.Type == zapcore.ErrorType:
+			if err, ok := field.Interface.(error); ok {
+				logEntry.Message += ": " + err.Error()
+			}
+		}
+	}
+	logEntry.Message = truncate(logEntry.Message, maxLogMessage)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.kept >= l.max {
+		l.dropped++
+		return
+	}
+	l.kept++
+	l.pending = append(l.pending, logEntry)
+}
+
+// take returns the entries added since the last call
+func (l *jobLog) take() []*domain.JobLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := l.pending
+	l.pending = nil
+	return entries
+}
+
+// finish notes how many entries were dropped, once the job is over
+func (l *jobLog) finish() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.dropped > 0 {
+		l.pending = append(l.pending, &domain.JobLogEntry{
+			JobID:     l.jobID,
+			Level:     zapcore.WarnLevel.String(),
+			Message:   fmt.Sprintf("log limit of %d entries reached, %d more were not recorded", l.max, l.dropped),
+			CreatedAt: time.Now(),
+		})
+	}
+}
+
+// truncate shortens s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// jobLogCore is a zapcore.Core writing warnings and errors to a jobLog
+type jobLogCore struct {
+	log    *jobLog
+	fields []zapcore.Field // Fields added with With
+}
+
+func (c *jobLogCore) Enabled(level zapcore.Level) bool {
+	return level >= zapcore.WarnLevel
+}
+
+func (c *jobLogCore) With(fields []zapcore.Field) zapcore.Core {
+	return &jobLogCore{log: c.log, fields: append(c.fields[:len(c.fields):len(c.fields)], fields...)}
+}
+
+func (c *jobLogCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *jobLogCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	c.log.add(entry, append(c.fields[:len(c.fields):len(c.fields)], fields...))
+	return nil
+}
+
+func (c *jobLogCore) Sync() error {
+	return nil
+}
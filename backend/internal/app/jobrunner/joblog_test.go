@@ -0,0 +1,49 @@
+package jobrunner
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestJobLog_CapturesWarningsAndErrors(t *testing.T) {
+	log := newJobLog(7, 3)
+	logger := zap.New(log.core()).With(zap.String("source", "docs"))
+
+	logger.Info("backup progress", zap.Int("files", 100))
+	logger.Warn("failed to chunk file", zap.Error(errors.New("permission denied")), zap.String("path", "/data/secret.txt"))
+	logger.Error("job failed")
+	logger.Warn("failed to read file metadata", zap.String("path", "/data/a"))
+	logger.Warn("failed to read file metadata", zap.String("path", "/data/b"))
+
+	entries := log.take()
+	require.Len(t, entries, 3)
+	assert.Equal(t, int64(7), entries[0].JobID)
+	assert.Equal(t, "warn", entries[0].Level)
+	assert.Equal(t, "/data/secret.txt", entries[0].Path)
+	assert.Equal(t, "failed to chunk file: permission denied", entries[0].Message)
+	assert.Equal(t, "error", entries[1].Level)
+	assert.Empty(t, entries[1].Path)
+	assert.Empty(t, log.take())
+
+	// Entries past the limit are only counted
+	log.finish()
+	entries = log.take()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "log limit of 3 entries reached, 1 more were not recorded", entries[0].Message)
+}
+
+func TestJobLog_TruncatesMessages(t *testing.T) {
+	log := newJobLog(1, 10)
+	log.add(zapcore.Entry{Level: zapcore.WarnLevel, Message: strings.Repeat("é", maxLogMessage)}, nil)
+
+	entries := log.take()
+	require.Len(t, entries, 1)
+	assert.Len(t, entries[0].Message, maxLogMessage)
+	assert.True(t, strings.HasSuffix(entries[0].Message, "é"))
+}
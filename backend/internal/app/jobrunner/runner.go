@@ -0,0 +1,671 @@
+package jobrunner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/app/backupservice"
+	"github.com/axelfrache/savesync/internal/app/jobservice"
+	"github.com/axelfrache/savesync/internal/app/sourceservice"
+	"github.com/axelfrache/savesync/internal/app/targetservice"
+	"github.com/axelfrache/savesync/internal/domain"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Options tunes the job queue. Unset counts and intervals are replaced by
+// defaults.
+type Options struct {
+	Workers            int           // Jobs run at the same time
+	PollInterval       time.Duration // How often idle workers look for jobs queued by another process
+	HeartbeatInterval  time.Duration // How often running jobs record that they are alive
+	ProgressInterval   time.Duration // How often running jobs record their progress and log
+	MaxLogEntries      int           // Warnings and errors kept in the log of each job
+	ShutdownTimeout    time.Duration // How long shutdown waits for running jobs before interrupting them
+	RequeueInterrupted bool          // Queue interrupted jobs again
+	MaxAttempts        int           // Interrupted jobs are not queued again after this many attempts
+	BackupPolicy       string        // What to do with a backup of a source already being backed up, see the domain.BackupPolicy* constants
+}
+
+// withDefaults fills the unset options
+func (o Options) withDefaults() Options {
+	if o.Workers <= 0 {
+		o.Workers = 2
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = 5 * time.Second
+	}
+	if o.HeartbeatInterval <= 0 {
+		o.HeartbeatInterval = 15 * time.Second
+	}
+	if o.ProgressInterval <= 0 {
+		o.ProgressInterval = 2 * time.Second
+	}
+	if o.MaxLogEntries <= 0 {
+		o.MaxLogEntries = 1000
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 3
+	}
+	if o.BackupPolicy == "" {
+		o.BackupPolicy = domain.BackupPolicyRefuse
+	}
+	return o
+}
+
+// ConflictError is returned when a backup is refused because another backup
+// of the same source is pending or running
+type ConflictError struct {
+	Job *domain.Job // The existing backup
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("source %d already has a %s backup (job %d)", *e.Job.SourceID, e.Job.Status, e.Job.ID)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return domain.ErrJobRunning
+}
+
+// Runner runs backup, restore, prune and check jobs from a queue persisted in the jobs
+// table, whether they are triggered from the API or by a schedule
+type Runner struct {
+	backupService *backupservice.Service
+	targetService *targetservice.Service
+	jobService    *jobservice.Service
+	sourceService *sourceservice.Service
+	opts          Options
+	logger        *zap.Logger
+	execute       func(ctx context.Context, job *domain.Job) error
+	wake          chan struct{}
+
+	mu     sync.Mutex                        // Serializes claims, cancellations and backup requests
+	active map[int64]context.CancelCauseFunc // Running jobs of this process
+}
+
+// restoreParams are the parameters of a restore job
+type restoreParams struct {
+	Destination string `json:"destination,omitempty"`
+}
+
+// pruneParams are the parameters of a prune job
+type pruneParams struct {
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// New creates a new job runner
+func New(
+	backupService *backupservice.Service,
+	targetService *targetservice.Service,
+	jobService *jobservice.Service,
+	sourceService *sourceservice.Service,
+	opts Options,
+	logger *zap.Logger,
+) *Runner {
+	opts = opts.withDefaults()
+	r := &Runner{
+		backupService: backupService,
+		targetService: targetService,
+		jobService:    jobService,
+		sourceService: sourceService,
+		opts:          opts,
+		logger:        logger,
+		wake:          make(chan struct{}, opts.Workers),
+		active:        make(map[int64]context.CancelCauseFunc),
+	}
+	r.execute = r.dispatch
+	return r
+}
+
+// EnqueueBackup queues a backup job for a source. When the source already
+// has a pending or running backup, the backup policy decides whether a
+// *ConflictError is returned, a new job is queued anyway, or the pending job
+// is returned instead.
+func (r *Runner) EnqueueBackup(ctx context.Context, sourceID int64) (*domain.Job, error) {
+	// Checking and queueing must not interleave with another request
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	active, err := r.jobService.GetActiveBackups(ctx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(active) > 0 {
+		switch r.opts.BackupPolicy {
+		case domain.BackupPolicyRefuse:
+			return nil, &ConflictError{Job: active[0]}
+		case domain.BackupPolicyCoalesce:
+			for _, job := range active {
+				if job.Status == domain.JobPending {
+					r.logger.Info("backup coalesced with pending job", zap.Int64("job_id", job.ID), zap.Int64("source_id", sourceID))
+					return job, nil
+				}
+			}
+		}
+	}
+
+	job, err := r.jobService.CreateBackupJob(ctx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	r.notify()
+	return job, nil
+}
+
+// EnqueueRestore queues a restore job for a snapshot
+func (r *Runner) EnqueueRestore(ctx context.Context, snapshotID int64, opts backupservice.RestoreOptions) (*domain.Job, error) {
+	params, err := json.Marshal(restoreParams{Destination: opts.Destination})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode restore parameters: %w", err)
+	}
+
+	job, err := r.jobService.CreateRestoreJob(ctx, snapshotID, string(params))
+	if err != nil {
+		return nil, err
+	}
+
+	r.notify()
+	return job, nil
+}
+
+// EnqueuePrune queues a prune job for a target. It returns
+// domain.ErrNotFound if the target doesn't exist.
+func (r *Runner) EnqueuePrune(ctx context.Context, targetID int64, dryRun bool) (*domain.Job, error) {
+	if _, err := r.targetService.GetByID(ctx, targetID); err != nil {
+		return nil, err
+	}
+
+	params, err := json.Marshal(pruneParams{DryRun: dryRun})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode prune parameters: %w", err)
+	}
+
+	job, err := r.jobService.CreatePruneJob(ctx, targetID, string(params))
+	if err != nil {
+		return nil, err
+	}
+
+	r.notify()
+	return job, nil
+}
+
+// EnqueueCheck queues a check job for a target, or for one snapshot of it.
+// It returns domain.ErrNotFound if the target doesn't exist.
+func (r *Runner) EnqueueCheck(ctx context.Context, targetID int64, snapshotID *int64, opts domain.CheckOptions) (*domain.Job, error) {
+	if _, err := r.targetService.GetByID(ctx, targetID); err != nil {
+		return nil, err
+	}
+	if opts.ReadDataPercent < 0 || opts.ReadDataPercent > 100 {
+		return nil, fmt.Errorf("%w: read_data_percent must be between 0 and 100", domain.ErrInvalidInput)
+	}
+
+	params, err := json.Marshal(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode check options: %w", err)
+	}
+
+	job, err := r.jobService.CreateCheckJob(ctx, targetID, snapshotID, string(params))
+	if err != nil {
+		return nil, err
+	}
+
+	r.notify()
+	return job, nil
+}
+
+// Cancel stops a job. A pending job is cancelled right away, while a running
+// one stops at its next cancellation check and is then recorded as cancelled.
+// It returns domain.ErrJobFinished if the job is no longer pending or running.
+func (r *Runner) Cancel(ctx context.Context, jobID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cancel, ok := r.active[jobID]; ok {
+		cancel(domain.ErrJobCancelled)
+		r.logger.Info("job cancellation requested", zap.Int64("job_id", jobID))
+		return nil
+	}
+
+	// Jobs are claimed under the same lock, so this one can't start meanwhile
+	return r.jobService.CancelPending(ctx, jobID)
+}
+
+// notify wakes an idle worker up
+func (r *Runner) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default: // Every worker already has a wake-up pending
+	}
+}
+
+// Recover marks the jobs and snapshots left running by a previous run of the
+// daemon as interrupted, and queues the jobs again when enabled. A single
+// daemon owns the database, so it must be called before Run.
+func (r *Runner) Recover(ctx context.Context) error {
+	snapshots, err := r.backupService.InterruptOrphanedSnapshots(ctx)
+	if err != nil {
+		return err
+	}
+
+	jobs, err := r.jobService.GetRunning(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list running jobs: %w", err)
+	}
+
+	for _, job := range jobs {
+		r.logger.Warn("orphaned job marked as interrupted",
+			zap.Int64("job_id", job.ID),
+			zap.String("type", job.Type),
+			zap.Timep("heartbeat_at", job.HeartbeatAt),
+		)
+		r.interrupt(ctx, job, errors.New("the daemon stopped while the job was running"))
+	}
+
+	if len(jobs) > 0 || snapshots > 0 {
+		r.logger.Info("recovered from unclean shutdown", zap.Int("jobs", len(jobs)), zap.Int("snapshots", snapshots))
+	}
+	return nil
+}
+
+// Run processes queued jobs with a bounded pool of workers until the context
+// is cancelled. Running jobs are then given ShutdownTimeout to finish, after
+// which they are cancelled and recorded as interrupted.
+func (r *Runner) Run(ctx context.Context) {
+	// Jobs keep running after ctx is cancelled, until the grace period ends
+	jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelJobs()
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work(ctx, jobCtx)
+		}()
+	}
+
+	r.logger.Info("job queue started", zap.Int("workers", r.opts.Workers))
+
+	<-ctx.Done()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(r.opts.ShutdownTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		r.logger.Warn("interrupting running jobs", zap.Duration("shutdown_timeout", r.opts.ShutdownTimeout))
+		cancelJobs()
+		<-done
+	}
+
+	r.logger.Info("job queue stopped")
+}
+
+// work runs jobs as they are queued until ctx is cancelled
+func (r *Runner) work(ctx, jobCtx context.Context) {
+	ticker := time.NewTicker(r.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		r.drain(ctx, jobCtx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain runs queued jobs until the queue is empty or the runner stops
+func (r *Runner) drain(ctx, jobCtx context.Context) {
+	for ctx.Err() == nil {
+		job, runCtx, err := r.claim(ctx, jobCtx)
+		if err == domain.ErrNotFound {
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				r.logger.Error("failed to claim job", zap.Error(err))
+			}
+			return
+		}
+
+		r.process(runCtx, job)
+		r.release(job.ID)
+	}
+}
+
+// claim takes the next queued job and registers it as active, giving it its
+// own context so that it can be cancelled
+func (r *Runner) claim(ctx, jobCtx context.Context) (*domain.Job, context.Context, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, err := r.jobService.ClaimNext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	runCtx, cancel := context.WithCancelCause(jobCtx)
+	r.active[job.ID] = cancel
+	return job, runCtx, nil
+}
+
+// release unregisters a job once its outcome is recorded
+func (r *Runner) release(jobID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cancel, ok := r.active[jobID]; ok {
+		cancel(nil)
+		delete(r.active, jobID)
+	}
+}
+
+// process runs a claimed job and records its outcome
+func (r *Runner) process(ctx context.Context, job *domain.Job) {
+	r.logger.Info("job started",
+		zap.Int64("job_id", job.ID),
+		zap.String("type", job.Type),
+		zap.Int("attempt", job.Attempt),
+	)
+
+	// Warnings and errors logged by the job are also kept in its log
+	jobLog := newJobLog(job.ID, r.opts.MaxLogEntries)
+	logger := r.logger.With(zap.Int64("job_id", job.ID)).WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, jobLog.core())
+	}))
+
+	progress := backupservice.NewProgress()
+	ctx = backupservice.WithProgress(ctx, progress)
+	ctx = backupservice.WithLogger(ctx, logger)
+
+	stopHeartbeat := r.heartbeat(ctx, job.ID)
+	stopReporting := r.report(ctx, job, progress, jobLog)
+	err := r.execute(ctx, job)
+	stopReporting()
+	stopHeartbeat()
+
+	var status string
+	switch {
+	case err == nil:
+		status = domain.JobSuccess
+		logger.Info("job completed successfully")
+	case errors.Is(context.Cause(ctx), domain.ErrJobCancelled):
+		status = domain.JobCancelled
+		logger.Info("job cancelled")
+	case ctx.Err() != nil:
+		status = domain.JobInterrupted
+		logger.Warn("job interrupted by shutdown", zap.Error(err))
+	default:
+		status = domain.JobFailed
+		logger.Error("job failed", zap.Error(err))
+	}
+
+	// The outcome is recorded even when the job was cancelled, and once the
+	// log is complete
+	statusCtx := context.WithoutCancel(ctx)
+	jobLog.finish()
+	r.saveLog(statusCtx, jobLog)
+
+	switch status {
+	case domain.JobInterrupted:
+		r.interrupt(statusCtx, job, fmt.Errorf("the daemon shut down before the job finished: %w", err))
+	case domain.JobFailed:
+		r.jobService.UpdateStatus(statusCtx, job.ID, status, err)
+	default:
+		r.jobService.UpdateStatus(statusCtx, job.ID, status, nil)
+	}
+}
+
+// heartbeat records that a job is alive until the returned function is called
+func (r *Runner) heartbeat(ctx context.Context, jobID int64) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(r.opts.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.jobService.Heartbeat(ctx, jobID); err != nil && ctx.Err() == nil {
+					r.logger.Warn("failed to record job heartbeat", zap.Error(err), zap.Int64("job_id", jobID))
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// report records the progress of a job and its new log entries until the
+// returned function is called, which records the progress a last time
+func (r *Runner) report(ctx context.Context, job *domain.Job, progress *backupservice.Progress, log *jobLog) (stop func()) {
+	save := func(ctx context.Context) {
+		report := progress.Report()
+		if err := r.jobService.UpdateProgress(ctx, job, &report); err != nil && ctx.Err() == nil {
+			r.logger.Warn("failed to record job progress", zap.Error(err), zap.Int64("job_id", job.ID))
+		}
+		r.saveLog(ctx, log)
+	}
+
+	tickCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(r.opts.ProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-tickCtx.Done():
+				return
+			case <-ticker.C:
+				save(tickCtx)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+		save(context.WithoutCancel(ctx))
+	}
+}
+
+// saveLog records the log entries added since the last call
+func (r *Runner) saveLog(ctx context.Context, log *jobLog) {
+	entries := log.take()
+	if len(entries) == 0 {
+		return
+	}
+	if err := r.jobService.AppendLogs(ctx, entries); err != nil && ctx.Err() == nil {
+		r.logger.Warn("failed to record job log", zap.Error(err), zap.Int64("job_id", log.jobID), zap.Int("entries", len(entries)))
+	}
+}
+
+// interrupt marks a job as interrupted and queues it again when enabled
+func (r *Runner) interrupt(ctx context.Context, job *domain.Job, cause error) {
+	if err := r.jobService.UpdateStatus(ctx, job.ID, domain.JobInterrupted, cause); err != nil {
+		return
+	}
+
+	if !r.opts.RequeueInterrupted {
+		return
+	}
+	if job.Attempt >= r.opts.MaxAttempts {
+		r.logger.Warn("interrupted job not requeued, too many attempts",
+			zap.Int64("job_id", job.ID),
+			zap.Int("attempt", job.Attempt),
+		)
+		return
+	}
+
+	if _, err := r.jobService.Requeue(ctx, job); err == nil {
+		r.notify()
+	}
+}
+
+// dispatch runs a job according to its type
+func (r *Runner) dispatch(ctx context.Context, job *domain.Job) error {
+	switch job.Type {
+	case domain.JobTypeBackup:
+		if job.SourceID == nil {
+			return fmt.Errorf("backup job has no source")
+		}
+		return r.runBackup(ctx, *job.SourceID)
+	case domain.JobTypeRestore:
+		return r.runRestore(ctx, job)
+	case domain.JobTypePrune:
+		return r.runPrune(ctx, job)
+	case domain.JobTypeCheck:
+		return r.runCheck(ctx, job)
+	default:
+		return fmt.Errorf("unknown job type %q", job.Type)
+	}
+}
+
+// runBackup backs a source up to its target
+func (r *Runner) runBackup(ctx context.Context, sourceID int64) error {
+	// Get source to find target
+	source, err := r.sourceService.GetByID(ctx, sourceID)
+	if err != nil {
+		return fmt.Errorf("failed to get source: %w", err)
+	}
+
+	if source.TargetID == nil {
+		return fmt.Errorf("source has no target configured")
+	}
+
+	// Initialize backend
+	backend, err := r.targetService.GetBackend(ctx, *source.TargetID)
+	if err != nil {
+		return fmt.Errorf("failed to initialize backend: %w", err)
+	}
+	defer backend.Close()
+
+	if err := r.backupService.RunBackup(ctx, sourceID, backend); err != nil {
+		return fmt.Errorf("backup failed: %w", err)
+	}
+	return nil
+}
+
+// runRestore restores a snapshot with the options the job was queued with
+func (r *Runner) runRestore(ctx context.Context, job *domain.Job) error {
+	if job.SnapshotID == nil {
+		return fmt.Errorf("restore job has no snapshot")
+	}
+
+	var params restoreParams
+	if job.Params != "" {
+		if err := json.Unmarshal([]byte(job.Params), &params); err != nil {
+			return fmt.Errorf("invalid restore parameters: %w", err)
+		}
+	}
+
+	snapshot, err := r.backupService.GetSnapshot(ctx, *job.SnapshotID)
+	if err != nil {
+		return fmt.Errorf("failed to get snapshot: %w", err)
+	}
+
+	// Chunks live on the target the snapshot was written to, which may
+	// differ from the source's current target
+	backend, err := r.targetService.GetBackend(ctx, snapshot.TargetID)
+	if err != nil {
+		return fmt.Errorf("failed to initialize backend: %w", err)
+	}
+	defer backend.Close()
+
+	opts := backupservice.RestoreOptions{Destination: params.Destination}
+	if err := r.backupService.RestoreSnapshot(ctx, snapshot.ID, backend, opts); err != nil {
+		return fmt.Errorf("restore failed: %w", err)
+	}
+	return nil
+}
+
+// runPrune deletes the unreferenced chunks of a target and records what was
+// reclaimed as the result of the job
+func (r *Runner) runPrune(ctx context.Context, job *domain.Job) error {
+	if job.TargetID == nil {
+		return fmt.Errorf("prune job has no target")
+	}
+
+	var params pruneParams
+	if job.Params != "" {
+		if err := json.Unmarshal([]byte(job.Params), &params); err != nil {
+			return fmt.Errorf("invalid prune parameters: %w", err)
+		}
+	}
+
+	backend, err := r.targetService.GetBackend(ctx, *job.TargetID)
+	if err != nil {
+		return fmt.Errorf("failed to initialize backend: %w", err)
+	}
+	defer backend.Close()
+
+	result, err := r.backupService.Prune(ctx, *job.TargetID, backend, params.DryRun)
+	if err != nil {
+		return fmt.Errorf("prune failed: %w", err)
+	}
+
+	// The chunks are gone already, so a result which can't be recorded only loses the report
+	_ = r.jobService.SetResult(context.WithoutCancel(ctx), job.ID, result)
+	return nil
+}
+
+// runCheck verifies the repository of a target and records what was found
+// as the result of the job. The job fails when a snapshot is damaged, so
+// that the damage doesn't go unnoticed.
+func (r *Runner) runCheck(ctx context.Context, job *domain.Job) error {
+	if job.TargetID == nil {
+		return fmt.Errorf("check job has no target")
+	}
+
+	var opts domain.CheckOptions
+	if job.Params != "" {
+		if err := json.Unmarshal([]byte(job.Params), &opts); err != nil {
+			return fmt.Errorf("invalid check options: %w", err)
+		}
+	}
+
+	backend, err := r.targetService.GetBackend(ctx, *job.TargetID)
+	if err != nil {
+		return fmt.Errorf("failed to initialize backend: %w", err)
+	}
+	defer backend.Close()
+
+	result, err := r.backupService.Check(ctx, *job.TargetID, job.SnapshotID, backend, opts)
+	if err != nil {
+		return fmt.Errorf("check failed: %w", err)
+	}
+
+	if err := r.jobService.SetResult(context.WithoutCancel(ctx), job.ID, result); err != nil {
+		return fmt.Errorf("failed to record check result: %w", err)
+	}
+	if !result.OK() {
+		return fmt.Errorf("repository damaged: damaged snapshots %d, missing chunks %d, corrupt chunks %d",
+			len(result.Damaged), len(result.MissingChunks), len(result.CorruptChunks))
+	}
+	return nil
+}
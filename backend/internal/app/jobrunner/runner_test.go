@@ -0,0 +1,577 @@
+package jobrunner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/app/backupservice"
+	"github.com/axelfrache/savesync/internal/app/eventbus"
+	"github.com/axelfrache/savesync/internal/app/jobservice"
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// memoryJobRepo is an in-memory domain.JobRepository and domain.JobLogRepository
+type memoryJobRepo struct {
+	mu   sync.Mutex
+	jobs []*domain.Job
+	logs []*domain.JobLogEntry
+}
+
+func (m *memoryJobRepo) Create(ctx context.Context, job *domain.Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job.ID = int64(len(m.jobs) + 1)
+	stored := *job
+	m.jobs = append(m.jobs, &stored)
+	return nil
+}
+
+func (m *memoryJobRepo) GetByID(ctx context.Context, id int64) (*domain.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id < 1 || int(id) > len(m.jobs) {
+		return nil, domain.ErrNotFound
+	}
+	job := *m.jobs[id-1]
+	return &job, nil
+}
+
+func (m *memoryJobRepo) GetAll(ctx context.Context) ([]*domain.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]*domain.Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		copied := *job
+		jobs = append(jobs, &copied)
+	}
+	return jobs, nil
+}
+
+func (m *memoryJobRepo) GetByStatus(ctx context.Context, status string) ([]*domain.Job, error) {
+	all, _ := m.GetAll(ctx)
+	var jobs []*domain.Job
+	for _, job := range all {
+		if job.Status == status {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (m *memoryJobRepo) GetActiveBackups(ctx context.Context, sourceID int64) ([]*domain.Job, error) {
+	all, _ := m.GetAll(ctx)
+	var jobs []*domain.Job
+	for _, job := range all {
+		if job.Type == domain.JobTypeBackup && *job.SourceID == sourceID && (job.Status == domain.JobPending || job.Status == domain.JobRunning) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (m *memoryJobRepo) ClaimNext(ctx context.Context, at time.Time) (*domain.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	busy := make(map[int64]bool)
+	for _, job := range m.jobs {
+		if job.Type == domain.JobTypeBackup && job.Status == domain.JobRunning {
+			busy[*job.SourceID] = true
+		}
+	}
+	for _, job := range m.jobs {
+		if job.Status == domain.JobPending && !(job.Type == domain.JobTypeBackup && busy[*job.SourceID]) {
+			job.Status = domain.JobRunning
+			job.StartedAt = at
+			job.HeartbeatAt = &at
+			claimed := *job
+			return &claimed, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (m *memoryJobRepo) Heartbeat(ctx context.Context, id int64, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[id-1].HeartbeatAt = &at
+	return nil
+}
+
+func (m *memoryJobRepo) UpdateProgress(ctx context.Context, id int64, progress *domain.JobProgress) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	saved := *progress
+	m.jobs[id-1].Progress = &saved
+	return nil
+}
+
+func (m *memoryJobRepo) UpdateResult(ctx context.Context, id int64, result json.RawMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[id-1].Result = append(json.RawMessage(nil), result...)
+	return nil
+}
+
+func (m *memoryJobRepo) Append(ctx context.Context, entries []*domain.JobLogEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range entries {
+		entry.ID = int64(len(m.logs) + 1)
+		m.logs = append(m.logs, entry)
+	}
+	return nil
+}
+
+func (m *memoryJobRepo) GetByJobID(ctx context.Context, jobID int64, offset, limit int) ([]*domain.JobLogEntry, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []*domain.JobLogEntry
+	for _, entry := range m.logs {
+		if entry.JobID == jobID {
+			entries = append(entries, entry)
+		}
+	}
+	total := len(entries)
+	entries = entries[min(offset, total):min(offset+limit, total)]
+	return entries, total, nil
+}
+
+func (m *memoryJobRepo) CancelPending(ctx context.Context, id int64, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id < 1 || int(id) > len(m.jobs) || m.jobs[id-1].Status != domain.JobPending {
+		return domain.ErrNotFound
+	}
+	m.jobs[id-1].Status = domain.JobCancelled
+	m.jobs[id-1].EndedAt = &at
+	return nil
+}
+
+func (m *memoryJobRepo) Update(ctx context.Context, job *domain.Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := m.jobs[job.ID-1]
+	stored.Status = job.Status
+	stored.Error = job.Error
+	stored.EndedAt = job.EndedAt
+	return nil
+}
+
+func (m *memoryJobRepo) Delete(ctx context.Context, id int64) error {
+	return nil
+}
+
+// memorySnapshotRepo is an in-memory domain.SnapshotRepository
+type memorySnapshotRepo struct {
+	snapshots []*domain.Snapshot
+}
+
+func (m *memorySnapshotRepo) Create(ctx context.Context, snapshot *domain.Snapshot) error {
+	snapshot.ID = int64(len(m.snapshots) + 1)
+	m.snapshots = append(m.snapshots, snapshot)
+	return nil
+}
+
+func (m *memorySnapshotRepo) GetByID(ctx context.Context, id int64) (*domain.Snapshot, error) {
+	if id < 1 || int(id) > len(m.snapshots) {
+		return nil, domain.ErrNotFound
+	}
+	return m.snapshots[id-1], nil
+}
+
+func (m *memorySnapshotRepo) GetAll(ctx context.Context) ([]*domain.Snapshot, error) {
+	return m.snapshots, nil
+}
+
+func (m *memorySnapshotRepo) GetBySourceID(ctx context.Context, sourceID int64) ([]*domain.Snapshot, error) {
+	return nil, nil
+}
+
+func (m *memorySnapshotRepo) GetByStatus(ctx context.Context, status string) ([]*domain.Snapshot, error) {
+	var snapshots []*domain.Snapshot
+	for _, snapshot := range m.snapshots {
+		if snapshot.Status == status {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, nil
+}
+
+func (m *memorySnapshotRepo) Update(ctx context.Context, snapshot *domain.Snapshot) error {
+	m.snapshots[snapshot.ID-1] = snapshot
+	return nil
+}
+
+func (m *memorySnapshotRepo) Delete(ctx context.Context, id int64) error {
+	return nil
+}
+
+// newTestRunner creates a runner whose jobs are run by execute
+func newTestRunner(repo *memoryJobRepo, snapshotRepo *memorySnapshotRepo, opts Options, execute func(ctx context.Context, job *domain.Job) error) *Runner {
+	return newTestRunnerWithEvents(repo, snapshotRepo, nil, opts, execute)
+}
+
+// newTestRunnerWithEvents is newTestRunner publishing job changes to events
+func newTestRunnerWithEvents(repo *memoryJobRepo, snapshotRepo *memorySnapshotRepo, events domain.EventPublisher, opts Options, execute func(ctx context.Context, job *domain.Job) error) *Runner {
+	logger := zap.NewNop()
+	backupService := backupservice.New(nil, nil, snapshotRepo, repo, nil, events, logger)
+	runner := New(backupService, nil, jobservice.New(repo, repo, events, logger), nil, opts, logger)
+	runner.execute = execute
+	return runner
+}
+
+// startRunner runs the queue in the background, returning a function which
+// stops it and waits for it to return
+func startRunner(runner *Runner) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runner.Run(ctx)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func jobStatus(t *testing.T, repo *memoryJobRepo, id int64) string {
+	job, err := repo.GetByID(context.Background(), id)
+	require.NoError(t, err)
+	return job.Status
+}
+
+func TestRunner_BoundsConcurrentJobs(t *testing.T) {
+	repo := &memoryJobRepo{}
+	var running, maxRunning atomic.Int32
+	runner := newTestRunner(repo, &memorySnapshotRepo{}, Options{Workers: 2}, func(ctx context.Context, job *domain.Job) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			max := maxRunning.Load()
+			if n <= max || maxRunning.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+
+	stop := startRunner(runner)
+	defer stop()
+
+	for i := 0; i < 6; i++ {
+		_, err := runner.EnqueueBackup(context.Background(), int64(i+1))
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		jobs, _ := repo.GetByStatus(context.Background(), domain.JobSuccess)
+		return len(jobs) == 6
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.LessOrEqual(t, maxRunning.Load(), int32(2))
+}
+
+func TestRunner_ShutdownWaitsForRunningJobs(t *testing.T) {
+	repo := &memoryJobRepo{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	runner := newTestRunner(repo, &memorySnapshotRepo{}, Options{Workers: 1, ShutdownTimeout: 5 * time.Second}, func(ctx context.Context, job *domain.Job) error {
+		close(started)
+		<-release
+		return ctx.Err()
+	})
+
+	stop := startRunner(runner)
+	_, err := runner.EnqueueBackup(context.Background(), 1)
+	require.NoError(t, err)
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("runner stopped before its job finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-stopped
+	assert.Equal(t, domain.JobSuccess, jobStatus(t, repo, 1))
+}
+
+func TestRunner_ShutdownInterruptsAndRequeuesJobs(t *testing.T) {
+	repo := &memoryJobRepo{}
+	started := make(chan struct{})
+	runner := newTestRunner(repo, &memorySnapshotRepo{}, Options{
+		Workers:            1,
+		ShutdownTimeout:    10 * time.Millisecond,
+		RequeueInterrupted: true,
+	}, func(ctx context.Context, job *domain.Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	stop := startRunner(runner)
+	_, err := runner.EnqueueBackup(context.Background(), 1)
+	require.NoError(t, err)
+	<-started
+	stop()
+
+	interrupted, err := repo.GetByID(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, domain.JobInterrupted, interrupted.Status)
+	assert.NotNil(t, interrupted.EndedAt)
+	require.NotNil(t, interrupted.Error)
+	assert.Contains(t, *interrupted.Error, "shut down")
+
+	retry, err := repo.GetByID(context.Background(), 2)
+	require.NoError(t, err)
+	assert.Equal(t, domain.JobPending, retry.Status)
+	assert.Equal(t, 2, retry.Attempt)
+	assert.Equal(t, int64(1), *retry.RetryOf)
+	assert.Equal(t, int64(1), *retry.SourceID)
+}
+
+func TestRunner_RecoverMarksOrphansInterrupted(t *testing.T) {
+	sourceID := int64(1)
+	repo := &memoryJobRepo{}
+	for _, job := range []*domain.Job{
+		{Type: domain.JobTypeBackup, SourceID: &sourceID, Status: domain.JobRunning, Attempt: 1},
+		{Type: domain.JobTypeBackup, SourceID: &sourceID, Status: domain.JobRunning, Attempt: 3},
+		{Type: domain.JobTypeBackup, SourceID: &sourceID, Status: domain.JobSuccess, Attempt: 1},
+	} {
+		require.NoError(t, repo.Create(context.Background(), job))
+	}
+	snapshotRepo := &memorySnapshotRepo{}
+	require.NoError(t, snapshotRepo.Create(context.Background(), &domain.Snapshot{SourceID: 1, Status: "running"}))
+	require.NoError(t, snapshotRepo.Create(context.Background(), &domain.Snapshot{SourceID: 1, Status: "success"}))
+
+	runner := newTestRunner(repo, snapshotRepo, Options{RequeueInterrupted: true}, nil)
+	require.NoError(t, runner.Recover(context.Background()))
+
+	assert.Equal(t, domain.JobInterrupted, jobStatus(t, repo, 1))
+	assert.Equal(t, domain.JobInterrupted, jobStatus(t, repo, 2))
+	assert.Equal(t, domain.JobSuccess, jobStatus(t, repo, 3))
+
+	// Only the job with attempts left is queued again
+	pending, err := repo.GetByStatus(context.Background(), domain.JobPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, int64(1), *pending[0].RetryOf)
+
+	assert.Equal(t, "interrupted", snapshotRepo.snapshots[0].Status)
+	assert.NotNil(t, snapshotRepo.snapshots[0].CompletedAt)
+	assert.Equal(t, "success", snapshotRepo.snapshots[1].Status)
+}
+
+func TestRunner_CancelJobs(t *testing.T) {
+	repo := &memoryJobRepo{}
+	started := make(chan int64, 2)
+	runner := newTestRunner(repo, &memorySnapshotRepo{}, Options{Workers: 1}, func(ctx context.Context, job *domain.Job) error {
+		started <- job.ID
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	stop := startRunner(runner)
+	defer stop()
+
+	for i := 0; i < 2; i++ {
+		_, err := runner.EnqueueBackup(context.Background(), int64(i+1))
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(1), <-started)
+
+	// The second job waits for the only worker, so it is cancelled right away
+	require.NoError(t, runner.Cancel(context.Background(), 2))
+	assert.Equal(t, domain.JobCancelled, jobStatus(t, repo, 2))
+
+	require.NoError(t, runner.Cancel(context.Background(), 1))
+	require.Eventually(t, func() bool {
+		return jobStatus(t, repo, 1) == domain.JobCancelled
+	}, 5*time.Second, 10*time.Millisecond)
+
+	job, err := repo.GetByID(context.Background(), 1)
+	require.NoError(t, err)
+	assert.NotNil(t, job.EndedAt)
+	assert.Empty(t, started, "cancelled pending job was run")
+
+	assert.ErrorIs(t, runner.Cancel(context.Background(), 1), domain.ErrJobFinished)
+	assert.ErrorIs(t, runner.Cancel(context.Background(), 42), domain.ErrNotFound)
+}
+
+func TestRunner_RecordsProgress(t *testing.T) {
+	repo := &memoryJobRepo{}
+	release := make(chan struct{})
+	runner := newTestRunner(repo, &memorySnapshotRepo{}, Options{ProgressInterval: 10 * time.Millisecond}, func(ctx context.Context, job *domain.Job) error {
+		<-release
+		return nil
+	})
+
+	stop := startRunner(runner)
+	defer stop()
+
+	_, err := runner.EnqueueBackup(context.Background(), 1)
+	require.NoError(t, err)
+
+	// Progress is recorded while the job runs, and once more when it ends
+	require.Eventually(t, func() bool {
+		job, err := repo.GetByID(context.Background(), 1)
+		return err == nil && job.Progress != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		return jobStatus(t, repo, 1) == domain.JobSuccess
+	}, 5*time.Second, 10*time.Millisecond)
+
+	job, err := repo.GetByID(context.Background(), 1)
+	require.NoError(t, err)
+	require.NotNil(t, job.Progress)
+	assert.False(t, job.Progress.UpdatedAt.After(*job.EndedAt))
+}
+
+func TestRunner_RecordsJobLog(t *testing.T) {
+	repo := &memoryJobRepo{}
+	runner := newTestRunner(repo, &memorySnapshotRepo{}, Options{}, func(ctx context.Context, job *domain.Job) error {
+		return errors.New("backend unreachable")
+	})
+
+	stop := startRunner(runner)
+	defer stop()
+
+	_, err := runner.EnqueueBackup(context.Background(), 1)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return jobStatus(t, repo, 1) == domain.JobFailed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The log is saved before the outcome is visible
+	entries, total, err := runner.jobService.GetLogs(context.Background(), 1, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	assert.Equal(t, "error", entries[0].Level)
+	assert.Equal(t, "job failed: backend unreachable", entries[0].Message)
+}
+
+func TestRunner_PublishesJobEvents(t *testing.T) {
+	repo := &memoryJobRepo{}
+	bus := eventbus.New(zap.NewNop())
+	sourceID := int64(1)
+	sub := bus.Subscribe(eventbus.Filter{SourceID: &sourceID})
+	defer sub.Close()
+
+	runner := newTestRunnerWithEvents(repo, &memorySnapshotRepo{}, bus, Options{}, func(ctx context.Context, job *domain.Job) error {
+		return nil
+	})
+	stop := startRunner(runner)
+	defer stop()
+
+	_, err := runner.EnqueueBackup(context.Background(), 1)
+	require.NoError(t, err)
+
+	// Queued, claimed, final progress, then the outcome
+	var statuses []string
+	for event := range sub.C {
+		require.NotNil(t, event.JobID)
+		assert.Equal(t, int64(1), *event.JobID)
+		switch data := event.Data.(type) {
+		case *domain.Job:
+			assert.Equal(t, domain.EventJobUpdated, event.Type)
+			statuses = append(statuses, data.Status)
+		case *domain.JobProgress:
+			assert.Equal(t, domain.EventJobProgress, event.Type)
+			statuses = append(statuses, "progress")
+		}
+		if len(statuses) == 4 {
+			break
+		}
+	}
+	assert.Equal(t, []string{domain.JobPending, domain.JobRunning, "progress", domain.JobSuccess}, statuses)
+}
+
+func TestRunner_BackupPolicyRefuse(t *testing.T) {
+	repo := &memoryJobRepo{}
+	runner := newTestRunner(repo, &memorySnapshotRepo{}, Options{}, nil)
+
+	first, err := runner.EnqueueBackup(context.Background(), 1)
+	require.NoError(t, err)
+
+	_, err = runner.EnqueueBackup(context.Background(), 1)
+	require.ErrorIs(t, err, domain.ErrJobRunning)
+	var conflict *ConflictError
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, first.ID, conflict.Job.ID)
+
+	// Other sources are not affected
+	_, err = runner.EnqueueBackup(context.Background(), 2)
+	assert.NoError(t, err)
+}
+
+func TestRunner_BackupPolicyCoalesce(t *testing.T) {
+	repo := &memoryJobRepo{}
+	runner := newTestRunner(repo, &memorySnapshotRepo{}, Options{BackupPolicy: domain.BackupPolicyCoalesce}, nil)
+
+	first, err := runner.EnqueueBackup(context.Background(), 1)
+	require.NoError(t, err)
+	second, err := runner.EnqueueBackup(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+
+	// Once the backup runs, the next request waits behind it
+	first.Status = domain.JobRunning
+	require.NoError(t, repo.Update(context.Background(), first))
+	third, err := runner.EnqueueBackup(context.Background(), 1)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, third.ID)
+
+	fourth, err := runner.EnqueueBackup(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, third.ID, fourth.ID)
+}
+
+func TestRunner_BackupPolicyQueue(t *testing.T) {
+	repo := &memoryJobRepo{}
+	release := make(chan struct{})
+	started := make(chan int64, 2)
+	runner := newTestRunner(repo, &memorySnapshotRepo{}, Options{Workers: 2, BackupPolicy: domain.BackupPolicyQueue}, func(ctx context.Context, job *domain.Job) error {
+		started <- job.ID
+		<-release
+		return nil
+	})
+
+	stop := startRunner(runner)
+	defer stop()
+
+	for i := 0; i < 2; i++ {
+		_, err := runner.EnqueueBackup(context.Background(), 1)
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(1), <-started)
+
+	// A worker is free, but the second backup waits for the first one
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, domain.JobPending, jobStatus(t, repo, 2))
+
+	release <- struct{}{}
+	require.Equal(t, int64(2), <-started)
+	release <- struct{}{}
+	require.Eventually(t, func() bool {
+		return jobStatus(t, repo, 2) == domain.JobSuccess
+	}, 5*time.Second, 10*time.Millisecond)
+}
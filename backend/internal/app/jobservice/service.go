@@ -2,6 +2,8 @@ package jobservice
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/axelfrache/savesync/internal/domain"
@@ -10,24 +12,30 @@ import (
 
 // Service handles job tracking operations
 type Service struct {
-	repo   domain.JobRepository
-	logger *zap.Logger
+	repo    domain.JobRepository
+	logRepo domain.JobLogRepository
+	events  domain.EventPublisher
+	logger  *zap.Logger
 }
 
-// New creates a new job service
-func New(repo domain.JobRepository, logger *zap.Logger) *Service {
+// New creates a new job service. Job changes are published to events, which
+// may be nil.
+func New(repo domain.JobRepository, logRepo domain.JobLogRepository, events domain.EventPublisher, logger *zap.Logger) *Service {
 	return &Service{
-		repo:   repo,
-		logger: logger,
+		repo:    repo,
+		logRepo: logRepo,
+		events:  events,
+		logger:  logger,
 	}
 }
 
 // CreateBackupJob creates a new backup job
 func (s *Service) CreateBackupJob(ctx context.Context, sourceID int64) (*domain.Job, error) {
 	job := &domain.Job{
-		Type:      "backup",
+		Type:      domain.JobTypeBackup,
 		SourceID:  &sourceID,
-		Status:    "pending",
+		Status:    domain.JobPending,
+		Attempt:   1,
 		StartedAt: time.Now(),
 	}
 
@@ -37,9 +45,91 @@ func (s *Service) CreateBackupJob(ctx context.Context, sourceID int64) (*domain.
 	}
 
 	s.logger.Info("backup job created", zap.Int64("job_id", job.ID), zap.Int64("source_id", sourceID))
+	s.publishJob(job)
 	return job, nil
 }
 
+// CreateRestoreJob creates a new restore job for a snapshot. The params are
+// the JSON encoded restore options.
+func (s *Service) CreateRestoreJob(ctx context.Context, snapshotID int64, params string) (*domain.Job, error) {
+	job := &domain.Job{
+		Type:       domain.JobTypeRestore,
+		SnapshotID: &snapshotID,
+		Status:     domain.JobPending,
+		Params:     params,
+		Attempt:    1,
+		StartedAt:  time.Now(),
+	}
+
+	if err := s.repo.Create(ctx, job); err != nil {
+		s.logger.Error("failed to create restore job", zap.Error(err), zap.Int64("snapshot_id", snapshotID))
+		return nil, err
+	}
+
+	s.logger.Info("restore job created", zap.Int64("job_id", job.ID), zap.Int64("snapshot_id", snapshotID))
+	s.publishJob(job)
+	return job, nil
+}
+
+// CreatePruneJob creates a new prune job for a target. The params are the
+// JSON encoded prune options.
+func (s *Service) CreatePruneJob(ctx context.Context, targetID int64, params string) (*domain.Job, error) {
+	job := &domain.Job{
+		Type:      domain.JobTypePrune,
+		TargetID:  &targetID,
+		Status:    domain.JobPending,
+		Params:    params,
+		Attempt:   1,
+		StartedAt: time.Now(),
+	}
+
+	if err := s.repo.Create(ctx, job); err != nil {
+		s.logger.Error("failed to create prune job", zap.Error(err), zap.Int64("target_id", targetID))
+		return nil, err
+	}
+
+	s.logger.Info("prune job created", zap.Int64("job_id", job.ID), zap.Int64("target_id", targetID))
+	s.publishJob(job)
+	return job, nil
+}
+
+// CreateCheckJob creates a new check job for a target, or for one snapshot
+// of it. The params are the JSON encoded check options.
+func (s *Service) CreateCheckJob(ctx context.Context, targetID int64, snapshotID *int64, params string) (*domain.Job, error) {
+	job := &domain.Job{
+		Type:       domain.JobTypeCheck,
+		TargetID:   &targetID,
+		SnapshotID: snapshotID,
+		Status:     domain.JobPending,
+		Params:     params,
+		Attempt:    1,
+		StartedAt:  time.Now(),
+	}
+
+	if err := s.repo.Create(ctx, job); err != nil {
+		s.logger.Error("failed to create check job", zap.Error(err), zap.Int64("target_id", targetID))
+		return nil, err
+	}
+
+	s.logger.Info("check job created", zap.Int64("job_id", job.ID), zap.Int64("target_id", targetID))
+	s.publishJob(job)
+	return job, nil
+}
+
+// SetResult records the report of a job, which is encoded as JSON
+func (s *Service) SetResult(ctx context.Context, jobID int64, result any) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to encode job result: %w", err)
+	}
+
+	if err := s.repo.UpdateResult(ctx, jobID, data); err != nil {
+		s.logger.Error("failed to record job result", zap.Error(err), zap.Int64("job_id", jobID))
+		return err
+	}
+	return nil
+}
+
 // UpdateStatus updates a job's status
 func (s *Service) UpdateStatus(ctx context.Context, jobID int64, status string, err error) error {
 	job, getErr := s.repo.GetByID(ctx, jobID)
@@ -53,7 +143,7 @@ func (s *Service) UpdateStatus(ctx context.Context, jobID int64, status string,
 		job.Error = &errMsg
 	}
 
-	if status == "success" || status == "failed" {
+	if status != domain.JobPending && status != domain.JobRunning {
 		now := time.Now()
 		job.EndedAt = &now
 	}
@@ -64,9 +154,133 @@ func (s *Service) UpdateStatus(ctx context.Context, jobID int64, status string,
 	}
 
 	s.logger.Info("job status updated", zap.Int64("job_id", jobID), zap.String("status", status))
+	s.publishJob(job)
+	return nil
+}
+
+// ClaimNext marks the oldest pending job as running and returns it, or
+// returns domain.ErrNotFound when the queue is empty
+func (s *Service) ClaimNext(ctx context.Context) (*domain.Job, error) {
+	job, err := s.repo.ClaimNext(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	s.publishJob(job)
+	return job, nil
+}
+
+// Heartbeat records that a running job is still alive
+func (s *Service) Heartbeat(ctx context.Context, jobID int64) error {
+	return s.repo.Heartbeat(ctx, jobID, time.Now())
+}
+
+// GetActiveBackups retrieves the pending and running backup jobs of a source
+func (s *Service) GetActiveBackups(ctx context.Context, sourceID int64) ([]*domain.Job, error) {
+	return s.repo.GetActiveBackups(ctx, sourceID)
+}
+
+// GetRunning retrieves the jobs currently marked as running
+func (s *Service) GetRunning(ctx context.Context) ([]*domain.Job, error) {
+	return s.repo.GetByStatus(ctx, domain.JobRunning)
+}
+
+// CancelPending cancels a job which has not started yet. It returns
+// domain.ErrJobFinished if the job is no longer pending.
+func (s *Service) CancelPending(ctx context.Context, jobID int64) error {
+	err := s.repo.CancelPending(ctx, jobID, time.Now())
+	if err == domain.ErrNotFound {
+		if _, getErr := s.repo.GetByID(ctx, jobID); getErr != nil {
+			return getErr
+		}
+		return domain.ErrJobFinished
+	}
+	if err != nil {
+		s.logger.Error("failed to cancel job", zap.Error(err), zap.Int64("job_id", jobID))
+		return err
+	}
+
+	s.logger.Info("pending job cancelled", zap.Int64("job_id", jobID))
+	if job, err := s.repo.GetByID(ctx, jobID); err == nil {
+		s.publishJob(job)
+	}
+	return nil
+}
+
+// UpdateProgress records the progress of a running job
+func (s *Service) UpdateProgress(ctx context.Context, job *domain.Job, progress *domain.JobProgress) error {
+	if err := s.repo.UpdateProgress(ctx, job.ID, progress); err != nil {
+		return err
+	}
+
+	if s.events != nil {
+		published := *progress
+		s.events.Publish(domain.Event{
+			Type:       domain.EventJobProgress,
+			JobID:      &job.ID,
+			SourceID:   job.SourceID,
+			SnapshotID: job.SnapshotID,
+			Data:       &published,
+			Time:       time.Now(),
+		})
+	}
 	return nil
 }
 
+// AppendLogs records warnings and errors of a job
+func (s *Service) AppendLogs(ctx context.Context, entries []*domain.JobLogEntry) error {
+	return s.logRepo.Append(ctx, entries)
+}
+
+// GetLogs retrieves a page of the log of a job and its total number of
+// entries. It returns domain.ErrNotFound if the job doesn't exist.
+func (s *Service) GetLogs(ctx context.Context, jobID int64, offset, limit int) ([]*domain.JobLogEntry, int, error) {
+	if _, err := s.repo.GetByID(ctx, jobID); err != nil {
+		return nil, 0, err
+	}
+	return s.logRepo.GetByJobID(ctx, jobID, offset, limit)
+}
+
+// Requeue creates a pending copy of an interrupted job, so that it runs again
+func (s *Service) Requeue(ctx context.Context, job *domain.Job) (*domain.Job, error) {
+	retry := &domain.Job{
+		Type:       job.Type,
+		SourceID:   job.SourceID,
+		SnapshotID: job.SnapshotID,
+		TargetID:   job.TargetID,
+		Status:     domain.JobPending,
+		Params:     job.Params,
+		Attempt:    job.Attempt + 1,
+		RetryOf:    &job.ID,
+		StartedAt:  time.Now(),
+	}
+
+	if err := s.repo.Create(ctx, retry); err != nil {
+		s.logger.Error("failed to requeue job", zap.Error(err), zap.Int64("job_id", job.ID))
+		return nil, err
+	}
+
+	s.logger.Info("job requeued", zap.Int64("job_id", retry.ID), zap.Int64("retry_of", job.ID), zap.Int("attempt", retry.Attempt))
+	s.publishJob(retry)
+	return retry, nil
+}
+
+// publishJob announces a new job or status change, when events are enabled
+func (s *Service) publishJob(job *domain.Job) {
+	if s.events == nil {
+		return
+	}
+	published := *job
+	s.events.Publish(domain.Event{
+		Type:       domain.EventJobUpdated,
+		JobID:      &published.ID,
+		SourceID:   published.SourceID,
+		SnapshotID: published.SnapshotID,
+		Data:       &published,
+		Time:       time.Now(),
+	})
+}
+
 // GetByID retrieves a job by ID
 func (s *Service) GetByID(ctx context.Context, id int64) (*domain.Job, error) {
 	return s.repo.GetByID(ctx, id)
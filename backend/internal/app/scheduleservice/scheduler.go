@@ -0,0 +1,270 @@
+package scheduleservice
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"go.uber.org/zap"
+)
+
+// Enqueuer starts backup and check jobs
+type Enqueuer interface {
+	EnqueueBackup(ctx context.Context, sourceID int64) (*domain.Job, error)
+	EnqueueCheck(ctx context.Context, targetID int64, snapshotID *int64, opts domain.CheckOptions) (*domain.Job, error)
+}
+
+// Scheduler enqueues backups and checks when enabled schedules fire
+type Scheduler struct {
+	repo     domain.ScheduleRepository
+	enqueuer Enqueuer
+	logger   *zap.Logger
+	now      func() time.Time
+	jitter   func(max time.Duration) time.Duration
+	reload   chan struct{}
+}
+
+// entry is a loaded schedule with its next fire time
+type entry struct {
+	schedule *domain.Schedule
+	spec     *Spec
+	next     time.Time // Zero when the schedule never fires, jitter included
+}
+
+// NewScheduler creates a new scheduler
+func NewScheduler(repo domain.ScheduleRepository, enqueuer Enqueuer, logger *zap.Logger) *Scheduler {
+	return &Scheduler{
+		repo:     repo,
+		enqueuer: enqueuer,
+		logger:   logger,
+		now:      time.Now,
+		jitter:   randomJitter,
+		reload:   make(chan struct{}, 1),
+	}
+}
+
+// Reload makes the scheduler read the schedules again, after they changed
+func (s *Scheduler) Reload() {
+	select {
+	case s.reload <- struct{}{}:
+	default: // A reload is already pending
+	}
+}
+
+// Run fires schedules until the context is cancelled. Runs missed while the
+// daemon was down are caught up first, according to each schedule's policy.
+func (s *Scheduler) Run(ctx context.Context) {
+	entries := s.load(ctx, nil)
+	s.catchUp(ctx, entries, s.now())
+
+	s.logger.Info("scheduler started", zap.Int("schedules", len(entries)))
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if next := earliest(entries); !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+
+		select {
+		case <-ctx.Done():
+			s.logger.Info("scheduler stopped")
+			return
+		case <-s.reload:
+			entries = s.load(ctx, entries)
+			s.logger.Debug("schedules reloaded", zap.Int("schedules", len(entries)))
+		case <-timer.C:
+			s.fireDue(ctx, entries, s.now())
+		}
+	}
+}
+
+// load reads the enabled schedules and computes their next fire time.
+// Invalid schedules are logged and ignored. Schedules whose timing is the
+// same as in previous keep their next fire time, so that a run delayed by
+// its jitter still happens when another schedule changes.
+func (s *Scheduler) load(ctx context.Context, previous []*entry) []*entry {
+	schedules, err := s.repo.GetEnabled(ctx)
+	if err != nil {
+		s.logger.Error("failed to load schedules", zap.Error(err))
+		return nil
+	}
+
+	pending := make(map[int64]*entry, len(previous))
+	for _, e := range previous {
+		pending[e.schedule.ID] = e
+	}
+
+	now := s.now()
+	entries := make([]*entry, 0, len(schedules))
+	for _, schedule := range schedules {
+		spec, err := ParseSpec(schedule)
+		if err != nil {
+			s.logger.Warn("ignoring invalid schedule", zap.Int64("schedule_id", schedule.ID), zap.Error(err))
+			continue
+		}
+		e := &entry{schedule: schedule, spec: spec}
+		if prev, ok := pending[schedule.ID]; ok && sameTiming(prev.schedule, schedule) {
+			e.next = prev.next
+		} else {
+			e.next = s.nextRun(e, now)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// catchUp enqueues the job of schedules which should have fired between
+// their last run and now
+func (s *Scheduler) catchUp(ctx context.Context, entries []*entry, now time.Time) {
+	for _, e := range entries {
+		since := e.schedule.CreatedAt
+		if e.schedule.LastRunAt != nil {
+			since = *e.schedule.LastRunAt
+		}
+
+		missed := e.spec.Next(since)
+		if missed.IsZero() || missed.After(now) {
+			continue
+		}
+
+		if e.schedule.CatchUp == domain.CatchUpSkip {
+			s.logger.Info("skipping missed scheduled runs",
+				zap.Int64("schedule_id", e.schedule.ID),
+				zap.Time("missed", missed),
+			)
+			continue
+		}
+
+		s.logger.Info("catching up missed scheduled run",
+			zap.Int64("schedule_id", e.schedule.ID),
+			zap.Time("missed", missed),
+		)
+		s.fire(ctx, e, now)
+	}
+}
+
+// fireDue enqueues the schedules whose fire time has come
+func (s *Scheduler) fireDue(ctx context.Context, entries []*entry, now time.Time) {
+	for _, e := range entries {
+		if e.next.IsZero() || e.next.After(now) {
+			continue
+		}
+		s.fire(ctx, e, now)
+		e.next = s.nextRun(e, now)
+	}
+}
+
+// nextRun returns the next fire time of a schedule after t, delayed by its jitter
+func (s *Scheduler) nextRun(e *entry, t time.Time) time.Time {
+	next := e.spec.Next(t)
+	if next.IsZero() || e.schedule.Jitter <= 0 {
+		return next
+	}
+	return next.Add(s.jitter(time.Duration(e.schedule.Jitter) * time.Second))
+}
+
+// fire enqueues the job of a schedule and records the run
+func (s *Scheduler) fire(ctx context.Context, e *entry, now time.Time) {
+	if e.schedule.Type == domain.ScheduleTypeCheck {
+		s.fireCheck(ctx, e, now)
+		return
+	}
+
+	job, err := s.enqueuer.EnqueueBackup(ctx, e.schedule.SourceID)
+	if errors.Is(err, domain.ErrJobRunning) {
+		// The backup in progress covers this run
+		s.logger.Info("scheduled backup skipped, source already being backed up",
+			zap.Int64("schedule_id", e.schedule.ID),
+			zap.Int64("source_id", e.schedule.SourceID),
+		)
+		s.recordRun(ctx, e, now)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to enqueue scheduled backup",
+			zap.Error(err),
+			zap.Int64("schedule_id", e.schedule.ID),
+			zap.Int64("source_id", e.schedule.SourceID),
+		)
+		return
+	}
+
+	s.logger.Info("scheduled backup enqueued",
+		zap.Int64("schedule_id", e.schedule.ID),
+		zap.Int64("source_id", e.schedule.SourceID),
+		zap.Int64("job_id", job.ID),
+	)
+	s.recordRun(ctx, e, now)
+}
+
+// fireCheck enqueues the check of the target of a schedule and records the run
+func (s *Scheduler) fireCheck(ctx context.Context, e *entry, now time.Time) {
+	if e.schedule.TargetID == nil {
+		s.logger.Error("check schedule has no target", zap.Int64("schedule_id", e.schedule.ID))
+		return
+	}
+
+	var opts domain.CheckOptions
+	if e.schedule.Check != nil {
+		opts = *e.schedule.Check
+	}
+
+	job, err := s.enqueuer.EnqueueCheck(ctx, *e.schedule.TargetID, nil, opts)
+	if err != nil {
+		s.logger.Error("failed to enqueue scheduled check",
+			zap.Error(err),
+			zap.Int64("schedule_id", e.schedule.ID),
+			zap.Int64("target_id", *e.schedule.TargetID),
+		)
+		return
+	}
+
+	s.logger.Info("scheduled check enqueued",
+		zap.Int64("schedule_id", e.schedule.ID),
+		zap.Int64("target_id", *e.schedule.TargetID),
+		zap.Int64("job_id", job.ID),
+	)
+	s.recordRun(ctx, e, now)
+}
+
+// recordRun saves the time a schedule last fired
+func (s *Scheduler) recordRun(ctx context.Context, e *entry, now time.Time) {
+	e.schedule.LastRunAt = &now
+	if err := s.repo.SetLastRun(ctx, e.schedule.ID, now); err != nil {
+		s.logger.Error("failed to record schedule run", zap.Error(err), zap.Int64("schedule_id", e.schedule.ID))
+	}
+}
+
+// sameTiming tells whether two versions of a schedule fire at the same times
+func sameTiming(a, b *domain.Schedule) bool {
+	if (a.CronExpr == nil) != (b.CronExpr == nil) || (a.CronExpr != nil && *a.CronExpr != *b.CronExpr) {
+		return false
+	}
+	return a.Frequency == b.Frequency && a.Timezone == b.Timezone && a.Jitter == b.Jitter
+}
+
+// earliest returns the first upcoming fire time, or the zero time if no
+// schedule fires
+func earliest(entries []*entry) time.Time {
+	var next time.Time
+	for _, e := range entries {
+		if !e.next.IsZero() && (next.IsZero() || e.next.Before(next)) {
+			next = e.next
+		}
+	}
+	return next
+}
+
+// randomJitter returns a random delay in [0, max)
+func randomJitter(max time.Duration) time.Duration {
+	return rand.N(max)
+}
@@ -0,0 +1,295 @@
+package scheduleservice
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// MockScheduleRepository is a mock implementation of domain.ScheduleRepository
+type MockScheduleRepository struct {
+	mock.Mock
+}
+
+func (m *MockScheduleRepository) Create(ctx context.Context, schedule *domain.Schedule) error {
+	args := m.Called(ctx, schedule)
+	return args.Error(0)
+}
+
+func (m *MockScheduleRepository) GetByID(ctx context.Context, id int64) (*domain.Schedule, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Schedule), args.Error(1)
+}
+
+func (m *MockScheduleRepository) GetBySourceID(ctx context.Context, sourceID int64) (*domain.Schedule, error) {
+	args := m.Called(ctx, sourceID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Schedule), args.Error(1)
+}
+
+func (m *MockScheduleRepository) GetAll(ctx context.Context) ([]*domain.Schedule, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]*domain.Schedule), args.Error(1)
+}
+
+func (m *MockScheduleRepository) GetEnabled(ctx context.Context) ([]*domain.Schedule, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]*domain.Schedule), args.Error(1)
+}
+
+func (m *MockScheduleRepository) Update(ctx context.Context, schedule *domain.Schedule) error {
+	args := m.Called(ctx, schedule)
+	return args.Error(0)
+}
+
+func (m *MockScheduleRepository) SetLastRun(ctx context.Context, id int64, at time.Time) error {
+	args := m.Called(ctx, id, at)
+	return args.Error(0)
+}
+
+func (m *MockScheduleRepository) Delete(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// fakeEnqueuer records the sources it was asked to back up and the targets
+// it was asked to check, and refuses the busy sources
+type fakeEnqueuer struct {
+	mu      sync.Mutex
+	sources []int64
+	checks  []domain.CheckOptions
+	targets []int64
+	busy    map[int64]bool
+}
+
+func (f *fakeEnqueuer) EnqueueBackup(ctx context.Context, sourceID int64) (*domain.Job, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.busy[sourceID] {
+		return nil, domain.ErrJobRunning
+	}
+	f.sources = append(f.sources, sourceID)
+	return &domain.Job{ID: int64(len(f.sources)), SourceID: &sourceID, Status: "pending"}, nil
+}
+
+func (f *fakeEnqueuer) EnqueueCheck(ctx context.Context, targetID int64, snapshotID *int64, opts domain.CheckOptions) (*domain.Job, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.targets = append(f.targets, targetID)
+	f.checks = append(f.checks, opts)
+	return &domain.Job{ID: int64(len(f.targets)), Type: domain.JobTypeCheck, TargetID: &targetID, Status: "pending"}, nil
+}
+
+func (f *fakeEnqueuer) enqueued() []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int64(nil), f.sources...)
+}
+
+func stringPtr(s string) *string { return &s }
+
+func TestParseSpec(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+	from := time.Date(2025, 3, 29, 12, 0, 0, 0, time.UTC) // The day before the DST change in Paris
+
+	spec, err := ParseSpec(&domain.Schedule{Frequency: domain.FrequencyDaily, Timezone: "Europe/Paris"})
+	require.NoError(t, err)
+	runs := spec.NextRuns(from, 2)
+	require.Len(t, runs, 2)
+	assert.Equal(t, time.Date(2025, 3, 30, 0, 0, 0, 0, paris), runs[0])
+	assert.Equal(t, time.Date(2025, 3, 31, 0, 0, 0, 0, paris), runs[1])
+	assert.Equal(t, 23*time.Hour, runs[1].Sub(runs[0]))
+
+	spec, err = ParseSpec(&domain.Schedule{Frequency: domain.FrequencyCron, CronExpr: stringPtr("30 2 * * 1-5"), Timezone: "UTC"})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 3, 31, 2, 30, 0, 0, time.UTC), spec.Next(from)) // Monday
+
+	spec, err = ParseSpec(&domain.Schedule{Frequency: domain.FrequencyManual})
+	require.NoError(t, err)
+	assert.True(t, spec.Next(from).IsZero())
+	assert.Empty(t, spec.NextRuns(from, 3))
+
+	for _, invalid := range []*domain.Schedule{
+		{Frequency: "monthly"},
+		{Frequency: domain.FrequencyCron},
+		{Frequency: domain.FrequencyCron, CronExpr: stringPtr("61 * * * *")},
+		{Frequency: domain.FrequencyDaily, Timezone: "Mars/Olympus"},
+		{Frequency: domain.FrequencyDaily, CatchUp: "all"},
+	} {
+		_, err := ParseSpec(invalid)
+		assert.ErrorIs(t, err, domain.ErrInvalidInput)
+	}
+}
+
+func TestScheduler_CatchUp(t *testing.T) {
+	now := time.Date(2025, 1, 21, 10, 30, 0, 0, time.UTC)
+	lastRun := now.Add(-26 * time.Hour)
+	recentRun := now.Add(-10 * time.Minute)
+
+	repo := new(MockScheduleRepository)
+	repo.On("GetEnabled", mock.Anything).Return([]*domain.Schedule{
+		{ID: 1, SourceID: 10, Frequency: domain.FrequencyDaily, Timezone: "UTC", LastRunAt: &lastRun},
+		{ID: 2, SourceID: 20, Frequency: domain.FrequencyDaily, Timezone: "UTC", LastRunAt: &lastRun, CatchUp: domain.CatchUpSkip},
+		{ID: 3, SourceID: 30, Frequency: domain.FrequencyHourly, Timezone: "UTC", LastRunAt: &recentRun},
+		{ID: 4, SourceID: 40, Frequency: domain.FrequencyCron, CronExpr: stringPtr("not a cron")},
+	}, nil)
+	repo.On("SetLastRun", mock.Anything, int64(1), now).Return(nil)
+
+	enqueuer := &fakeEnqueuer{}
+	scheduler := NewScheduler(repo, enqueuer, zap.NewNop())
+	scheduler.now = func() time.Time { return now }
+
+	entries := scheduler.load(context.Background(), nil)
+	require.Len(t, entries, 3)
+	scheduler.catchUp(context.Background(), entries, now)
+
+	assert.Equal(t, []int64{10}, enqueuer.enqueued())
+	repo.AssertExpectations(t)
+	assert.Equal(t, time.Date(2025, 1, 22, 0, 0, 0, 0, time.UTC), entries[0].next)
+	assert.Equal(t, time.Date(2025, 1, 21, 11, 0, 0, 0, time.UTC), entries[2].next)
+}
+
+func TestScheduler_FireDue(t *testing.T) {
+	now := time.Date(2025, 1, 21, 11, 0, 0, 0, time.UTC)
+
+	repo := new(MockScheduleRepository)
+	repo.On("SetLastRun", mock.Anything, int64(1), now).Return(nil)
+
+	enqueuer := &fakeEnqueuer{}
+	scheduler := NewScheduler(repo, enqueuer, zap.NewNop())
+
+	hourly, err := ParseSpec(&domain.Schedule{Frequency: domain.FrequencyHourly, Timezone: "UTC"})
+	require.NoError(t, err)
+	entries := []*entry{
+		{schedule: &domain.Schedule{ID: 1, SourceID: 10}, spec: hourly, next: now},
+		{schedule: &domain.Schedule{ID: 2, SourceID: 20}, spec: hourly, next: now.Add(time.Hour)},
+	}
+
+	scheduler.fireDue(context.Background(), entries, now)
+
+	assert.Equal(t, []int64{10}, enqueuer.enqueued())
+	assert.Equal(t, now.Add(time.Hour), entries[0].next)
+	assert.Equal(t, now, *entries[0].schedule.LastRunAt)
+	assert.Equal(t, now.Add(time.Hour), earliest(entries))
+	repo.AssertExpectations(t)
+}
+
+func TestScheduler_ReloadKeepsJitteredRun(t *testing.T) {
+	now := time.Date(2025, 1, 21, 11, 0, 30, 0, time.UTC)
+	schedules := []*domain.Schedule{
+		{ID: 1, SourceID: 10, Frequency: domain.FrequencyHourly, Timezone: "UTC", Jitter: 300},
+		{ID: 2, SourceID: 20, Frequency: domain.FrequencyDaily, Timezone: "UTC", Jitter: 300},
+	}
+	repo := new(MockScheduleRepository)
+	repo.On("GetEnabled", mock.Anything).Return(schedules, nil).Once()
+
+	scheduler := NewScheduler(repo, &fakeEnqueuer{}, zap.NewNop())
+	scheduler.now = func() time.Time { return now }
+	scheduler.jitter = func(max time.Duration) time.Duration { return 2 * time.Minute }
+
+	// The 11:00 run of schedule 1 is still waiting for its jitter
+	entries := scheduler.load(context.Background(), nil)
+	entries[0].next = time.Date(2025, 1, 21, 11, 2, 0, 0, time.UTC)
+
+	// Another schedule changes, and so does the jitter of schedule 2
+	updated := []*domain.Schedule{
+		{ID: 1, SourceID: 10, Frequency: domain.FrequencyHourly, Timezone: "UTC", Jitter: 300},
+		{ID: 2, SourceID: 20, Frequency: domain.FrequencyDaily, Timezone: "UTC", Jitter: 60},
+		{ID: 3, SourceID: 30, Frequency: domain.FrequencyHourly, Timezone: "UTC"},
+	}
+	repo.On("GetEnabled", mock.Anything).Return(updated, nil).Once()
+	scheduler.jitter = func(max time.Duration) time.Duration { return 0 }
+	entries = scheduler.load(context.Background(), entries)
+
+	require.Len(t, entries, 3)
+	assert.Equal(t, time.Date(2025, 1, 21, 11, 2, 0, 0, time.UTC), entries[0].next)
+	assert.Equal(t, time.Date(2025, 1, 22, 0, 0, 0, 0, time.UTC), entries[1].next)
+	assert.Equal(t, time.Date(2025, 1, 21, 12, 0, 0, 0, time.UTC), entries[2].next)
+}
+
+func TestScheduler_FireCheck(t *testing.T) {
+	now := time.Date(2025, 1, 21, 11, 0, 0, 0, time.UTC)
+
+	repo := new(MockScheduleRepository)
+	repo.On("SetLastRun", mock.Anything, int64(1), now).Return(nil)
+
+	enqueuer := &fakeEnqueuer{}
+	scheduler := NewScheduler(repo, enqueuer, zap.NewNop())
+
+	hourly, err := ParseSpec(&domain.Schedule{Frequency: domain.FrequencyHourly, Timezone: "UTC"})
+	require.NoError(t, err)
+	targetID := int64(4)
+	opts := &domain.CheckOptions{ReadData: true, ReadDataPercent: 10}
+	entries := []*entry{{
+		schedule: &domain.Schedule{ID: 1, Type: domain.ScheduleTypeCheck, TargetID: &targetID, Check: opts},
+		spec:     hourly,
+		next:     now,
+	}}
+
+	scheduler.fireDue(context.Background(), entries, now)
+
+	assert.Empty(t, enqueuer.enqueued())
+	assert.Equal(t, []int64{4}, enqueuer.targets)
+	assert.Equal(t, []domain.CheckOptions{*opts}, enqueuer.checks)
+	assert.Equal(t, now, *entries[0].schedule.LastRunAt)
+	repo.AssertExpectations(t)
+}
+
+func TestScheduler_FireSkipsBusySource(t *testing.T) {
+	now := time.Date(2025, 1, 21, 11, 0, 0, 0, time.UTC)
+
+	repo := new(MockScheduleRepository)
+	repo.On("SetLastRun", mock.Anything, int64(1), now).Return(nil)
+
+	enqueuer := &fakeEnqueuer{busy: map[int64]bool{10: true}}
+	scheduler := NewScheduler(repo, enqueuer, zap.NewNop())
+
+	hourly, err := ParseSpec(&domain.Schedule{Frequency: domain.FrequencyHourly, Timezone: "UTC"})
+	require.NoError(t, err)
+	entries := []*entry{{schedule: &domain.Schedule{ID: 1, SourceID: 10}, spec: hourly, next: now}}
+
+	scheduler.fireDue(context.Background(), entries, now)
+
+	// The skipped run still counts, so it is not caught up later
+	assert.Empty(t, enqueuer.enqueued())
+	assert.Equal(t, now.Add(time.Hour), entries[0].next)
+	assert.Equal(t, now, *entries[0].schedule.LastRunAt)
+	repo.AssertExpectations(t)
+}
+
+func TestScheduler_RunFiresAndStops(t *testing.T) {
+	repo := new(MockScheduleRepository)
+	repo.On("GetEnabled", mock.Anything).Return([]*domain.Schedule{
+		{ID: 1, SourceID: 10, Frequency: domain.FrequencyCron, CronExpr: stringPtr("@every 1s"), CreatedAt: time.Now()},
+	}, nil)
+	repo.On("SetLastRun", mock.Anything, int64(1), mock.Anything).Return(nil)
+
+	enqueuer := &fakeEnqueuer{}
+	scheduler := NewScheduler(repo, enqueuer, zap.NewNop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scheduler.Run(ctx)
+	}()
+
+	assert.Eventually(t, func() bool { return len(enqueuer.enqueued()) > 0 }, 5*time.Second, 50*time.Millisecond)
+	scheduler.Reload()
+	cancel()
+	<-done
+	assert.Equal(t, int64(10), enqueuer.enqueued()[0])
+}
@@ -0,0 +1,246 @@
+package scheduleservice
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"go.uber.org/zap"
+)
+
+// Reloader is notified when schedules change
+type Reloader interface {
+	Reload()
+}
+
+// Service handles schedule management operations
+type Service struct {
+	repo       domain.ScheduleRepository
+	sourceRepo domain.SourceRepository
+	targetRepo domain.TargetRepository
+	reloader   Reloader
+	logger     *zap.Logger
+}
+
+// New creates a new schedule service
+func New(repo domain.ScheduleRepository, sourceRepo domain.SourceRepository, targetRepo domain.TargetRepository, reloader Reloader, logger *zap.Logger) *Service {
+	return &Service{
+		repo:       repo,
+		sourceRepo: sourceRepo,
+		targetRepo: targetRepo,
+		reloader:   reloader,
+		logger:     logger,
+	}
+}
+
+// Validate checks the settings of a schedule without saving it
+func (s *Service) Validate(schedule *domain.Schedule) error {
+	switch schedule.Type {
+	case "", domain.ScheduleTypeBackup, domain.ScheduleTypeCheck:
+	default:
+		return fmt.Errorf("%w: unknown schedule type %q", domain.ErrInvalidInput, schedule.Type)
+	}
+
+	if schedule.Check != nil && (schedule.Check.ReadDataPercent < 0 || schedule.Check.ReadDataPercent > 100) {
+		return fmt.Errorf("%w: read_data_percent must be between 0 and 100", domain.ErrInvalidInput)
+	}
+
+	_, err := ParseSpec(schedule)
+	return err
+}
+
+// NextRuns returns the next n fire times of a schedule, jitter excluded
+func (s *Service) NextRuns(schedule *domain.Schedule, n int) ([]time.Time, error) {
+	spec, err := ParseSpec(schedule)
+	if err != nil {
+		return nil, err
+	}
+	return spec.NextRuns(time.Now(), n), nil
+}
+
+// Create creates the backup schedule of a source, which can only have one,
+// or a check schedule of a target
+func (s *Service) Create(ctx context.Context, schedule *domain.Schedule) error {
+	if err := s.Validate(schedule); err != nil {
+		return err
+	}
+
+	if schedule.Type == domain.ScheduleTypeCheck {
+		return s.createCheck(ctx, schedule)
+	}
+	schedule.Type = domain.ScheduleTypeBackup
+	schedule.TargetID = nil
+	schedule.Check = nil
+
+	source, err := s.sourceRepo.GetByID(ctx, schedule.SourceID)
+	if err != nil {
+		if err == domain.ErrNotFound {
+			return fmt.Errorf("%w: source %d does not exist", domain.ErrInvalidInput, schedule.SourceID)
+		}
+		return err
+	}
+
+	if _, err := s.repo.GetBySourceID(ctx, schedule.SourceID); err == nil {
+		return fmt.Errorf("%w: source %d already has a schedule", domain.ErrInvalidInput, schedule.SourceID)
+	} else if err != domain.ErrNotFound {
+		return err
+	}
+
+	if err := s.repo.Create(ctx, schedule); err != nil {
+		s.logger.Error("failed to create schedule", zap.Error(err), zap.Int64("source_id", schedule.SourceID))
+		return err
+	}
+
+	// Keep the source pointing at its schedule
+	source.ScheduleID = &schedule.ID
+	if err := s.sourceRepo.Update(ctx, source); err != nil {
+		s.logger.Error("failed to attach schedule to source", zap.Error(err), zap.Int64("source_id", source.ID))
+		return err
+	}
+
+	s.reloader.Reload()
+	s.logger.Info("schedule created", zap.Int64("id", schedule.ID), zap.Int64("source_id", schedule.SourceID))
+	return nil
+}
+
+// createCheck creates a schedule checking the repository of a target
+func (s *Service) createCheck(ctx context.Context, schedule *domain.Schedule) error {
+	if schedule.TargetID == nil {
+		return fmt.Errorf("%w: a check schedule requires a target", domain.ErrInvalidInput)
+	}
+	if _, err := s.targetRepo.GetByID(ctx, *schedule.TargetID); err != nil {
+		if err == domain.ErrNotFound {
+			return fmt.Errorf("%w: target %d does not exist", domain.ErrInvalidInput, *schedule.TargetID)
+		}
+		return err
+	}
+
+	schedule.SourceID = 0
+	if schedule.Check == nil {
+		schedule.Check = &domain.CheckOptions{}
+	}
+
+	if err := s.repo.Create(ctx, schedule); err != nil {
+		s.logger.Error("failed to create schedule", zap.Error(err), zap.Int64("target_id", *schedule.TargetID))
+		return err
+	}
+
+	s.reloader.Reload()
+	s.logger.Info("check schedule created", zap.Int64("id", schedule.ID), zap.Int64("target_id", *schedule.TargetID))
+	return nil
+}
+
+// GetByID retrieves a schedule by ID
+func (s *Service) GetByID(ctx context.Context, id int64) (*domain.Schedule, error) {
+	return s.repo.GetByID(ctx, id)
+}
+
+// GetAll retrieves all schedules
+func (s *Service) GetAll(ctx context.Context) ([]*domain.Schedule, error) {
+	return s.repo.GetAll(ctx)
+}
+
+// Update updates the settings of a schedule. The type, source and target of
+// a schedule can't be changed, and its last run is kept.
+func (s *Service) Update(ctx context.Context, schedule *domain.Schedule) error {
+	if err := s.Validate(schedule); err != nil {
+		return err
+	}
+
+	existing, err := s.repo.GetByID(ctx, schedule.ID)
+	if err != nil {
+		return err
+	}
+	schedule.Type = existing.Type
+	schedule.SourceID = existing.SourceID
+	schedule.TargetID = existing.TargetID
+	if existing.Type != domain.ScheduleTypeCheck {
+		schedule.Check = nil
+	} else if schedule.Check == nil {
+		schedule.Check = existing.Check
+	}
+	schedule.LastRunAt = existing.LastRunAt
+	schedule.CreatedAt = existing.CreatedAt
+
+	if err := s.repo.Update(ctx, schedule); err != nil {
+		s.logger.Error("failed to update schedule", zap.Error(err), zap.Int64("id", schedule.ID))
+		return err
+	}
+
+	s.reloader.Reload()
+	s.logger.Info("schedule updated", zap.Int64("id", schedule.ID))
+	return nil
+}
+
+// Delete deletes a schedule and detaches it from its source, if any
+func (s *Service) Delete(ctx context.Context, id int64) error {
+	schedule, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.Delete(ctx, id); err != nil {
+		s.logger.Error("failed to delete schedule", zap.Error(err), zap.Int64("id", id))
+		return err
+	}
+
+	// Check schedules have no source
+	if schedule.Type != domain.ScheduleTypeCheck {
+		source, err := s.sourceRepo.GetByID(ctx, schedule.SourceID)
+		if err == nil && source.ScheduleID != nil && *source.ScheduleID == id {
+			source.ScheduleID = nil
+			if err := s.sourceRepo.Update(ctx, source); err != nil {
+				s.logger.Warn("failed to detach schedule from source", zap.Error(err), zap.Int64("source_id", source.ID))
+			}
+		}
+	}
+
+	s.reloader.Reload()
+	s.logger.Info("schedule deleted", zap.Int64("id", id))
+	return nil
+}
+
+// DeleteForSource deletes the schedule of a source, if it has one
+func (s *Service) DeleteForSource(ctx context.Context, sourceID int64) error {
+	schedule, err := s.repo.GetBySourceID(ctx, sourceID)
+	if err == domain.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.Delete(ctx, schedule.ID); err != nil {
+		return err
+	}
+
+	s.reloader.Reload()
+	s.logger.Info("schedule deleted with its source", zap.Int64("id", schedule.ID), zap.Int64("source_id", sourceID))
+	return nil
+}
+
+// DeleteForTarget deletes the check schedules of a target
+func (s *Service) DeleteForTarget(ctx context.Context, targetID int64) error {
+	schedules, err := s.repo.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	deleted := 0
+	for _, schedule := range schedules {
+		if schedule.Type != domain.ScheduleTypeCheck || schedule.TargetID == nil || *schedule.TargetID != targetID {
+			continue
+		}
+		if err := s.repo.Delete(ctx, schedule.ID); err != nil && err != domain.ErrNotFound {
+			return err
+		}
+		deleted++
+	}
+
+	if deleted > 0 {
+		s.reloader.Reload()
+		s.logger.Info("check schedules deleted with their target", zap.Int("count", deleted), zap.Int64("target_id", targetID))
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package scheduleservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// MockSourceRepository is a mock implementation of domain.SourceRepository
+type MockSourceRepository struct {
+	mock.Mock
+}
+
+func (m *MockSourceRepository) Create(ctx context.Context, source *domain.Source) error {
+	args := m.Called(ctx, source)
+	return args.Error(0)
+}
+
+func (m *MockSourceRepository) GetByID(ctx context.Context, id int64) (*domain.Source, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Source), args.Error(1)
+}
+
+func (m *MockSourceRepository) GetAll(ctx context.Context) ([]*domain.Source, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]*domain.Source), args.Error(1)
+}
+
+func (m *MockSourceRepository) Update(ctx context.Context, source *domain.Source) error {
+	args := m.Called(ctx, source)
+	return args.Error(0)
+}
+
+func (m *MockSourceRepository) Delete(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// MockTargetRepository is a mock implementation of domain.TargetRepository
+type MockTargetRepository struct {
+	mock.Mock
+}
+
+func (m *MockTargetRepository) Create(ctx context.Context, target *domain.Target) error {
+	args := m.Called(ctx, target)
+	return args.Error(0)
+}
+
+func (m *MockTargetRepository) GetByID(ctx context.Context, id int64) (*domain.Target, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Target), args.Error(1)
+}
+
+func (m *MockTargetRepository) GetAll(ctx context.Context) ([]*domain.Target, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]*domain.Target), args.Error(1)
+}
+
+func (m *MockTargetRepository) Update(ctx context.Context, target *domain.Target) error {
+	args := m.Called(ctx, target)
+	return args.Error(0)
+}
+
+func (m *MockTargetRepository) Delete(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// countingReloader counts reload notifications
+type countingReloader struct {
+	reloads int
+}
+
+func (r *countingReloader) Reload() { r.reloads++ }
+
+func TestScheduleService_CreateAttachesSource(t *testing.T) {
+	repo := new(MockScheduleRepository)
+	sourceRepo := new(MockSourceRepository)
+	reloader := &countingReloader{}
+	service := New(repo, sourceRepo, new(MockTargetRepository), reloader, zap.NewNop())
+
+	source := &domain.Source{ID: 1, Name: "documents"}
+	sourceRepo.On("GetByID", mock.Anything, int64(1)).Return(source, nil)
+	sourceRepo.On("Update", mock.Anything, source).Return(nil)
+	repo.On("GetBySourceID", mock.Anything, int64(1)).Return(nil, domain.ErrNotFound)
+	repo.On("Create", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(1).(*domain.Schedule).ID = 7
+	}).Return(nil)
+
+	schedule := &domain.Schedule{SourceID: 1, Frequency: domain.FrequencyCron, CronExpr: stringPtr("0 3 * * *"), Enabled: true}
+	require.NoError(t, service.Create(context.Background(), schedule))
+
+	require.NotNil(t, source.ScheduleID)
+	assert.Equal(t, int64(7), *source.ScheduleID)
+	assert.Equal(t, 1, reloader.reloads)
+}
+
+func TestScheduleService_CreateRejectsInvalidSchedules(t *testing.T) {
+	repo := new(MockScheduleRepository)
+	sourceRepo := new(MockSourceRepository)
+	service := New(repo, sourceRepo, new(MockTargetRepository), &countingReloader{}, zap.NewNop())
+
+	sourceRepo.On("GetByID", mock.Anything, int64(1)).Return(&domain.Source{ID: 1}, nil)
+	sourceRepo.On("GetByID", mock.Anything, int64(2)).Return(nil, domain.ErrNotFound)
+	repo.On("GetBySourceID", mock.Anything, int64(1)).Return(&domain.Schedule{ID: 3, SourceID: 1}, nil)
+
+	for _, schedule := range []*domain.Schedule{
+		{SourceID: 1, Frequency: domain.FrequencyCron, CronExpr: stringPtr("0 3 * *")},
+		{SourceID: 1, Frequency: domain.FrequencyDaily, Jitter: -1},
+		{SourceID: 2, Frequency: domain.FrequencyDaily},
+		{SourceID: 1, Frequency: domain.FrequencyDaily}, // Already scheduled
+	} {
+		err := service.Create(context.Background(), schedule)
+		assert.ErrorIs(t, err, domain.ErrInvalidInput)
+	}
+	repo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestScheduleService_CreateCheck(t *testing.T) {
+	repo := new(MockScheduleRepository)
+	sourceRepo := new(MockSourceRepository)
+	targetRepo := new(MockTargetRepository)
+	reloader := &countingReloader{}
+	service := New(repo, sourceRepo, targetRepo, reloader, zap.NewNop())
+
+	targetRepo.On("GetByID", mock.Anything, int64(2)).Return(&domain.Target{ID: 2}, nil)
+	targetRepo.On("GetByID", mock.Anything, int64(3)).Return(nil, domain.ErrNotFound)
+	repo.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+	targetID := int64(2)
+	schedule := &domain.Schedule{Type: domain.ScheduleTypeCheck, SourceID: 1, TargetID: &targetID, Frequency: domain.FrequencyWeekly}
+	require.NoError(t, service.Create(context.Background(), schedule))
+	assert.Zero(t, schedule.SourceID)
+	assert.Equal(t, &domain.CheckOptions{}, schedule.Check)
+	assert.Equal(t, 1, reloader.reloads)
+
+	missingTarget := int64(3)
+	for _, invalid := range []*domain.Schedule{
+		{Type: domain.ScheduleTypeCheck, Frequency: domain.FrequencyWeekly},
+		{Type: domain.ScheduleTypeCheck, TargetID: &missingTarget, Frequency: domain.FrequencyWeekly},
+		{Type: domain.ScheduleTypeCheck, TargetID: &targetID, Frequency: domain.FrequencyWeekly, Check: &domain.CheckOptions{ReadData: true, ReadDataPercent: 101}},
+		{Type: "verify", TargetID: &targetID, Frequency: domain.FrequencyWeekly},
+	} {
+		assert.ErrorIs(t, service.Create(context.Background(), invalid), domain.ErrInvalidInput)
+	}
+	repo.AssertNumberOfCalls(t, "Create", 1)
+	sourceRepo.AssertNotCalled(t, "GetByID", mock.Anything, mock.Anything)
+}
+
+func TestScheduleService_UpdateKeepsSourceAndLastRun(t *testing.T) {
+	repo := new(MockScheduleRepository)
+	reloader := &countingReloader{}
+	service := New(repo, new(MockSourceRepository), new(MockTargetRepository), reloader, zap.NewNop())
+
+	lastRun := time.Date(2025, 1, 21, 3, 0, 0, 0, time.UTC)
+	repo.On("GetByID", mock.Anything, int64(7)).Return(&domain.Schedule{ID: 7, SourceID: 1, LastRunAt: &lastRun}, nil)
+	repo.On("Update", mock.Anything, mock.Anything).Return(nil)
+
+	schedule := &domain.Schedule{ID: 7, SourceID: 5, Frequency: domain.FrequencyWeekly, Timezone: "Europe/Paris"}
+	require.NoError(t, service.Update(context.Background(), schedule))
+
+	assert.Equal(t, int64(1), schedule.SourceID)
+	assert.Equal(t, &lastRun, schedule.LastRunAt)
+	assert.Equal(t, 1, reloader.reloads)
+}
+
+func TestScheduleService_DeleteDetachesSource(t *testing.T) {
+	repo := new(MockScheduleRepository)
+	sourceRepo := new(MockSourceRepository)
+	service := New(repo, sourceRepo, new(MockTargetRepository), &countingReloader{}, zap.NewNop())
+
+	scheduleID := int64(7)
+	source := &domain.Source{ID: 1, ScheduleID: &scheduleID}
+	repo.On("GetByID", mock.Anything, scheduleID).Return(&domain.Schedule{ID: scheduleID, SourceID: 1}, nil)
+	repo.On("Delete", mock.Anything, scheduleID).Return(nil)
+	sourceRepo.On("GetByID", mock.Anything, int64(1)).Return(source, nil)
+	sourceRepo.On("Update", mock.Anything, source).Return(nil)
+
+	require.NoError(t, service.Delete(context.Background(), scheduleID))
+	assert.Nil(t, source.ScheduleID)
+	sourceRepo.AssertExpectations(t)
+}
+
+func TestScheduleService_DeleteForTarget(t *testing.T) {
+	repo := new(MockScheduleRepository)
+	sourceRepo := new(MockSourceRepository)
+	reloader := &countingReloader{}
+	service := New(repo, sourceRepo, new(MockTargetRepository), reloader, zap.NewNop())
+
+	target, other := int64(2), int64(3)
+	repo.On("GetAll", mock.Anything).Return([]*domain.Schedule{
+		{ID: 1, Type: domain.ScheduleTypeBackup, SourceID: 2},
+		{ID: 2, Type: domain.ScheduleTypeCheck, TargetID: &target},
+		{ID: 3, Type: domain.ScheduleTypeCheck, TargetID: &other},
+	}, nil)
+	repo.On("Delete", mock.Anything, int64(2)).Return(nil)
+
+	require.NoError(t, service.DeleteForTarget(context.Background(), target))
+	repo.AssertNumberOfCalls(t, "Delete", 1)
+	assert.Equal(t, 1, reloader.reloads)
+}
+
+func TestScheduleService_NextRuns(t *testing.T) {
+	service := New(new(MockScheduleRepository), new(MockSourceRepository), new(MockTargetRepository), &countingReloader{}, zap.NewNop())
+
+	runs, err := service.NextRuns(&domain.Schedule{Frequency: domain.FrequencyHourly}, 3)
+	require.NoError(t, err)
+	require.Len(t, runs, 3)
+	assert.Equal(t, time.Hour, runs[1].Sub(runs[0]))
+	assert.Zero(t, runs[0].Minute())
+
+	_, err = service.NextRuns(&domain.Schedule{Frequency: domain.FrequencyCron, CronExpr: stringPtr("every day")}, 3)
+	assert.ErrorIs(t, err, domain.ErrInvalidInput)
+}
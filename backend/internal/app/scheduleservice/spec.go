@@ -0,0 +1,93 @@
+package scheduleservice
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/robfig/cron/v3"
+)
+
+// presets maps the fixed frequencies to cron descriptors
+var presets = map[string]string{
+	domain.FrequencyHourly: "@hourly",
+	domain.FrequencyDaily:  "@daily",
+	domain.FrequencyWeekly: "@weekly",
+}
+
+// MaxJitter is the longest random delay a schedule can add to its runs
+const MaxJitter = 24 * 60 * 60
+
+// Spec tells when a schedule fires
+type Spec struct {
+	schedule cron.Schedule // nil for manual schedules, which never fire
+	location *time.Location
+}
+
+// ParseSpec validates the timing settings of a schedule. Cron expressions
+// have five fields (minute, hour, day of month, month, day of week) or are
+// descriptors such as "@daily" or "@every 6h".
+func ParseSpec(schedule *domain.Schedule) (*Spec, error) {
+	location := time.Local
+	if schedule.Timezone != "" {
+		loc, err := time.LoadLocation(schedule.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("%w: unknown time zone %q", domain.ErrInvalidInput, schedule.Timezone)
+		}
+		location = loc
+	}
+
+	if schedule.Jitter < 0 || schedule.Jitter > MaxJitter {
+		return nil, fmt.Errorf("%w: jitter must be between 0 and %d seconds", domain.ErrInvalidInput, MaxJitter)
+	}
+
+	switch schedule.CatchUp {
+	case "", domain.CatchUpOnce, domain.CatchUpSkip:
+	default:
+		return nil, fmt.Errorf("%w: unknown catch-up policy %q", domain.ErrInvalidInput, schedule.CatchUp)
+	}
+
+	var expr string
+	switch schedule.Frequency {
+	case domain.FrequencyManual:
+		return &Spec{location: location}, nil
+	case domain.FrequencyHourly, domain.FrequencyDaily, domain.FrequencyWeekly:
+		expr = presets[schedule.Frequency]
+	case domain.FrequencyCron:
+		if schedule.CronExpr == nil || *schedule.CronExpr == "" {
+			return nil, fmt.Errorf("%w: cron frequency requires a cron expression", domain.ErrInvalidInput)
+		}
+		expr = *schedule.CronExpr
+	default:
+		return nil, fmt.Errorf("%w: unknown frequency %q", domain.ErrInvalidInput, schedule.Frequency)
+	}
+
+	parsed, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid cron expression %q: %v", domain.ErrInvalidInput, expr, err)
+	}
+
+	return &Spec{schedule: parsed, location: location}, nil
+}
+
+// Next returns the first fire time strictly after t, or the zero time if the
+// schedule never fires
+func (s *Spec) Next(t time.Time) time.Time {
+	if s.schedule == nil {
+		return time.Time{}
+	}
+	return s.schedule.Next(t.In(s.location))
+}
+
+// NextRuns returns up to n fire times after t
+func (s *Spec) NextRuns(t time.Time, n int) []time.Time {
+	runs := make([]time.Time, 0, n)
+	for len(runs) < n {
+		t = s.Next(t)
+		if t.IsZero() {
+			break
+		}
+		runs = append(runs, t)
+	}
+	return runs
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/axelfrache/savesync/internal/app/backupservice"
 	"github.com/axelfrache/savesync/internal/domain"
 	"go.uber.org/zap"
 )
@@ -38,6 +39,10 @@ func (s *Service) Create(ctx context.Context, source *domain.Source) error {
 		return domain.ErrInvalidInput
 	}
 
+	if !validCompression(source) || !validFilters(source.Filters) || !validRetention(source.Retention) {
+		return domain.ErrInvalidInput
+	}
+
 	// Initialize exclusions if nil
 	if source.Exclusions == nil {
 		source.Exclusions = []string{}
@@ -85,6 +90,10 @@ func (s *Service) Update(ctx context.Context, source *domain.Source) error {
 		return domain.ErrInvalidInput
 	}
 
+	if !validCompression(source) || !validFilters(source.Filters) || !validRetention(source.Retention) {
+		return domain.ErrInvalidInput
+	}
+
 	if err := s.repo.Update(ctx, source); err != nil {
 		s.logger.Error("failed to update source", zap.Error(err), zap.Int64("id", source.ID))
 		return err
@@ -104,3 +113,42 @@ func (s *Service) Delete(ctx context.Context, id int64) error {
 	s.logger.Info("source deleted", zap.Int64("id", id))
 	return nil
 }
+
+// validCompression checks the optional compression override of a source
+func validCompression(source *domain.Source) bool {
+	switch source.Compression {
+	case "":
+		return source.CompressionLevel == 0
+	case domain.CompressionNone, domain.CompressionZstd:
+		return source.CompressionLevel >= 0 && source.CompressionLevel <= backupservice.MaxCompressionLevel
+	default:
+		return false
+	}
+}
+
+// validFilters checks the limits and node types of source filters
+func validFilters(filters domain.SourceFilters) bool {
+	if filters.MinSize < 0 || filters.MaxSize < 0 || filters.MinAgeDays < 0 || filters.MaxAgeDays < 0 {
+		return false
+	}
+	if filters.MaxSize > 0 && filters.MinSize > filters.MaxSize {
+		return false
+	}
+	if filters.MaxAgeDays > 0 && filters.MinAgeDays > filters.MaxAgeDays {
+		return false
+	}
+
+	for _, t := range filters.Types {
+		switch t {
+		case domain.NodeFile, domain.NodeSymlink, domain.NodeFIFO, domain.NodeCharDevice, domain.NodeBlockDevice:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validRetention checks the optional retention policy of a source
+func validRetention(policy *domain.RetentionPolicy) bool {
+	return policy == nil || policy.Validate() == nil
+}
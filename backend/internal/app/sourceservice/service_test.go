@@ -113,3 +113,77 @@ func TestSourceService_GetByID_NotFound(t *testing.T) {
 	assert.Nil(t, source)
 	mockRepo.AssertExpectations(t)
 }
+
+func TestSourceService_Create_InvalidCompression(t *testing.T) {
+	// Setup
+	mockRepo := new(MockSourceRepository)
+	logger, _ := zap.NewDevelopment()
+	service := New(mockRepo, logger)
+
+	source := &domain.Source{
+		Name:             "test-source",
+		Path:             t.TempDir(),
+		Compression:      domain.CompressionZstd,
+		CompressionLevel: 30,
+	}
+
+	// Execute
+	err := service.Create(context.Background(), source)
+
+	// Assert
+	assert.Equal(t, domain.ErrInvalidInput, err)
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestSourceService_Create_InvalidFilters(t *testing.T) {
+	// Setup
+	mockRepo := new(MockSourceRepository)
+	logger, _ := zap.NewDevelopment()
+	service := New(mockRepo, logger)
+
+	for _, filters := range []domain.SourceFilters{
+		{MinSize: 2048, MaxSize: 1024},
+		{MinAgeDays: -1},
+		{MinAgeDays: 30, MaxAgeDays: 7},
+		{Types: []string{domain.NodeFile, domain.NodeDir}},
+	} {
+		source := &domain.Source{
+			Name:    "test-source",
+			Path:    t.TempDir(),
+			Filters: filters,
+		}
+
+		// Execute
+		err := service.Create(context.Background(), source)
+
+		// Assert
+		assert.Equal(t, domain.ErrInvalidInput, err)
+	}
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestSourceService_Create_InvalidRetention(t *testing.T) {
+	// Setup
+	mockRepo := new(MockSourceRepository)
+	logger, _ := zap.NewDevelopment()
+	service := New(mockRepo, logger)
+
+	for _, policy := range []domain.RetentionPolicy{
+		{},
+		{KeepLast: -1},
+		{KeepDaily: 7, KeepWithin: "2 weeks"},
+	} {
+		source := &domain.Source{
+			Name:      "test-source",
+			Path:      t.TempDir(),
+			Retention: &policy,
+		}
+
+		// Execute
+		err := service.Create(context.Background(), source)
+
+		// Assert
+		assert.Equal(t, domain.ErrInvalidInput, err)
+	}
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
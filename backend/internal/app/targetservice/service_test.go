@@ -56,7 +56,7 @@ func TestTargetService_Create(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
 	registry := backends.NewRegistry()
 	// Register a dummy backend for testing
-	registry.Register("local", func() domain.Backend {
+	registry.Register("local", func() domain.ByteBackend {
 		return &MockBackend{}
 	})
 
@@ -157,3 +157,13 @@ func (m *MockBackend) LoadManifest(ctx context.Context, snapshotID string) ([]by
 	return nil, nil
 }
 func (m *MockBackend) DeleteManifest(ctx context.Context, snapshotID string) error { return nil }
+func (m *MockBackend) StoreKeyFile(ctx context.Context, data []byte) error         { return nil }
+func (m *MockBackend) LoadKeyFile(ctx context.Context) ([]byte, error) {
+	return nil, domain.ErrNotFound
+}
+func (m *MockBackend) ListChunks(ctx context.Context, fn func(domain.ObjectInfo) error) error {
+	return nil
+}
+func (m *MockBackend) ListManifests(ctx context.Context, fn func(domain.ObjectInfo) error) error {
+	return nil
+}
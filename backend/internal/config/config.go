@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
 )
 
 // Config holds the application configuration
@@ -11,6 +14,7 @@ type Config struct {
 	Server   ServerConfig
 	Database DatabaseConfig
 	Log      LogConfig
+	Jobs     JobsConfig
 }
 
 // ServerConfig holds HTTP server configuration
@@ -29,6 +33,15 @@ type LogConfig struct {
 	Level string
 }
 
+// JobsConfig holds job queue configuration
+type JobsConfig struct {
+	Workers            int
+	ShutdownTimeout    time.Duration
+	RequeueInterrupted bool
+	BackupPolicy       string
+	MaxLogEntries      int
+}
+
 // Load loads configuration from environment variables with defaults
 func Load() (*Config, error) {
 	cfg := &Config{
@@ -42,6 +55,13 @@ func Load() (*Config, error) {
 		Log: LogConfig{
 			Level: getEnv("LOG_LEVEL", "info"),
 		},
+		Jobs: JobsConfig{
+			Workers:            getEnvInt("JOBS_WORKERS", 2),
+			ShutdownTimeout:    getEnvDuration("JOBS_SHUTDOWN_TIMEOUT", 30*time.Second),
+			RequeueInterrupted: getEnvBool("JOBS_REQUEUE_INTERRUPTED", false),
+			BackupPolicy:       getEnv("JOBS_BACKUP_POLICY", domain.BackupPolicyRefuse),
+			MaxLogEntries:      getEnvInt("JOBS_LOG_MAX_ENTRIES", 1000),
+		},
 	}
 
 	return cfg, nil
@@ -65,6 +85,27 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvBool gets a boolean environment variable or returns a default value
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// getEnvDuration gets a duration environment variable, such as "30s", or
+// returns a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+	}
+	return defaultValue
+}
+
 // Validate validates the configuration
 func (c *Config) Validate() error {
 	if c.Server.Port < 1 || c.Server.Port > 65535 {
@@ -73,5 +114,19 @@ func (c *Config) Validate() error {
 	if c.Database.Path == "" {
 		return fmt.Errorf("database path is required")
 	}
+	if c.Jobs.Workers < 1 {
+		return fmt.Errorf("invalid number of job workers: %d", c.Jobs.Workers)
+	}
+	if c.Jobs.ShutdownTimeout < 0 {
+		return fmt.Errorf("invalid job shutdown timeout: %s", c.Jobs.ShutdownTimeout)
+	}
+	if c.Jobs.MaxLogEntries < 1 {
+		return fmt.Errorf("invalid job log size: %d", c.Jobs.MaxLogEntries)
+	}
+	switch c.Jobs.BackupPolicy {
+	case domain.BackupPolicyRefuse, domain.BackupPolicyQueue, domain.BackupPolicyCoalesce:
+	default:
+		return fmt.Errorf("invalid backup policy: %q", c.Jobs.BackupPolicy)
+	}
 	return nil
 }
@@ -1,8 +1,16 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
 
-type Backend interface {
+// ByteBackend is the storage a backend provides through whole byte slices.
+// Backends which only implement it are adapted to Backend by the backend
+// registry.
+type ByteBackend interface {
 	Init(config map[string]string) error
 	StoreChunk(ctx context.Context, hash string, data []byte) error
 	LoadChunk(ctx context.Context, hash string) ([]byte, error)
@@ -11,5 +19,69 @@ type Backend interface {
 	StoreManifest(ctx context.Context, snapshotID string, data []byte) error
 	LoadManifest(ctx context.Context, snapshotID string) ([]byte, error)
 	DeleteManifest(ctx context.Context, snapshotID string) error
+	// StoreKeyFile stores the key file of the repository unless it already
+	// has one, in which case it returns ErrAlreadyExists. The key file is
+	// never overwritten, so that two clients creating it at the same time
+	// end up sharing the key.
+	StoreKeyFile(ctx context.Context, data []byte) error
+	LoadKeyFile(ctx context.Context) ([]byte, error)
+	// ListChunks calls fn for each stored chunk, in no particular order. It
+	// stops at the first error of fn and returns it.
+	ListChunks(ctx context.Context, fn func(ObjectInfo) error) error
+	// ListManifests calls fn for each stored manifest, in no particular
+	// order. It stops at the first error of fn and returns it.
+	ListManifests(ctx context.Context, fn func(ObjectInfo) error) error
 	Close() error
 }
+
+// Backend stores chunks and manifests, either as byte slices or as streams.
+// Streaming methods address objects by key, see ChunkKey and ManifestKey.
+type Backend interface {
+	ByteBackend
+	// PutObject stores the content of r under key. size is the number of
+	// bytes r holds, or -1 when unknown.
+	PutObject(ctx context.Context, key string, r io.Reader, size int64) error
+	// GetObject opens the object stored under key. The caller closes it.
+	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetObjectRange opens length bytes of the object stored under key,
+	// starting at offset. A negative length reads to the end of the object,
+	// and fewer bytes are read when the object ends first.
+	GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// ObjectInfo describes a chunk or manifest listed by a backend
+type ObjectInfo struct {
+	Name string // Hash of a chunk, or snapshot ID of a manifest
+	Size int64  // Bytes stored, or -1 when the backend doesn't report it
+}
+
+// Kinds of objects a key can address
+const (
+	ObjectChunk    = "chunks"
+	ObjectManifest = "manifests"
+)
+
+// ChunkKey returns the key of a chunk for the streaming methods of Backend
+func ChunkKey(hash string) string {
+	return ObjectChunk + "/" + hash
+}
+
+// ManifestKey returns the key of the manifest of a snapshot for the
+// streaming methods of Backend
+func ManifestKey(snapshotID string) string {
+	return ObjectManifest + "/" + snapshotID
+}
+
+// SplitObjectKey returns the kind of object a key addresses, ObjectChunk or
+// ObjectManifest, and its name: the hash of a chunk or the snapshot ID of a
+// manifest
+func SplitObjectKey(key string) (kind, name string, err error) {
+	kind, name, ok := strings.Cut(key, "/")
+	if !ok || name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", "", fmt.Errorf("%w: object key %q", ErrInvalidInput, key)
+	}
+	if kind != ObjectChunk && kind != ObjectManifest {
+		return "", "", fmt.Errorf("%w: object key %q", ErrInvalidInput, key)
+	}
+	return kind, name, nil
+}
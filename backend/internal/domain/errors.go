@@ -9,5 +9,9 @@ var (
 	ErrBackendInit     = errors.New("backend initialization failed")
 	ErrJobRunning      = errors.New("job already running")
 	ErrJobFailed       = errors.New("job failed")
+	ErrJobCancelled    = errors.New("job cancelled")
+	ErrJobFinished     = errors.New("job already finished")
 	ErrSnapshotInvalid = errors.New("invalid snapshot")
+	ErrNotSupported    = errors.New("operation not supported")
+	ErrAlreadyExists   = errors.New("resource already exists")
 )
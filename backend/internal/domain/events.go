@@ -0,0 +1,26 @@
+package domain
+
+import "time"
+
+// Event types
+const (
+	EventJobUpdated        = "job.updated"        // A job was queued or changed status; data is the Job
+	EventJobProgress       = "job.progress"       // A running job recorded its progress; data is the JobProgress
+	EventSnapshotCompleted = "snapshot.completed" // A backup ended, whatever its outcome; data is the Snapshot
+)
+
+// Event is a change published while jobs run
+type Event struct {
+	Type       string    `json:"type"`
+	JobID      *int64    `json:"job_id,omitempty"`
+	SourceID   *int64    `json:"source_id,omitempty"`
+	SnapshotID *int64    `json:"snapshot_id,omitempty"`
+	Data       any       `json:"data"`
+	Time       time.Time `json:"time"`
+}
+
+// EventPublisher delivers events to in-process subscribers. Publishing must
+// not block.
+type EventPublisher interface {
+	Publish(event Event)
+}
@@ -1,6 +1,9 @@
 package domain
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // User represents a user account
 type User struct {
@@ -21,17 +24,49 @@ type Setting struct {
 
 // Source represents a directory to be backed up
 type Source struct {
-	ID         int64     `json:"id"`
-	UserID     int64     `json:"user_id"`
-	Name       string    `json:"name"`
-	Path       string    `json:"path"`
-	Exclusions []string  `json:"exclusions"` // Glob patterns to exclude
-	TargetID   *int64    `json:"target_id"`
-	ScheduleID *int64    `json:"schedule_id"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID               int64            `json:"id"`
+	UserID           int64            `json:"user_id"`
+	Name             string           `json:"name"`
+	Path             string           `json:"path"`
+	Exclusions       []string         `json:"exclusions"` // Glob patterns to exclude
+	Filters          SourceFilters    `json:"filters"`
+	TargetID         *int64           `json:"target_id"`
+	ScheduleID       *int64           `json:"schedule_id"`
+	Compression      string           `json:"compression,omitempty"`       // Overrides the target's compression: none, zstd
+	CompressionLevel int              `json:"compression_level,omitempty"` // zstd level, 0 for the default
+	Retention        *RetentionPolicy `json:"retention,omitempty"`         // Snapshots to keep, nil to keep them all
+	CreatedAt        time.Time        `json:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at"`
 }
 
+// SourceFilters narrows down the files of a source that are backed up, on top
+// of its exclusions. Directories are always walked and zero values disable a
+// filter.
+type SourceFilters struct {
+	Includes   []string `json:"includes,omitempty"`     // Gitignore-style patterns, only matching files are backed up
+	MinSize    int64    `json:"min_size,omitempty"`     // In bytes, regular files only
+	MaxSize    int64    `json:"max_size,omitempty"`     // In bytes, regular files only
+	MinAgeDays int      `json:"min_age_days,omitempty"` // Skip files modified more recently
+	MaxAgeDays int      `json:"max_age_days,omitempty"` // Skip files not modified for longer
+	Types      []string `json:"types,omitempty"`        // Node types to back up: file, symlink, fifo, chardev, blockdev
+}
+
+// Source filter names, as reported in snapshot skip counts
+const (
+	FilterType    = "type"
+	FilterInclude = "include"
+	FilterMinSize = "min_size"
+	FilterMaxSize = "max_size"
+	FilterMinAge  = "min_age"
+	FilterMaxAge  = "max_age"
+)
+
+// Chunk compression algorithms
+const (
+	CompressionNone = "none"
+	CompressionZstd = "zstd"
+)
+
 // TargetType represents the type of backup target
 type TargetType string
 
@@ -74,17 +109,27 @@ type Target struct {
 
 // Snapshot represents a backup snapshot at a point in time
 type Snapshot struct {
-	ID          int64      `json:"id"`
-	UserID      int64      `json:"user_id"`
-	SourceID    int64      `json:"source_id"`
-	TargetID    int64      `json:"target_id"`
-	Status      string     `json:"status"` // pending, running, success, failed
-	FileCount   int        `json:"file_count"`
-	TotalBytes  int64      `json:"total_bytes"`
-	DeltaBytes  int64      `json:"delta_bytes"` // New bytes uploaded
-	Error       *string    `json:"error,omitempty"`
-	CreatedAt   time.Time  `json:"created_at"`
-	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ID          int64          `json:"id"`
+	UserID      int64          `json:"user_id"`
+	SourceID    int64          `json:"source_id"`
+	TargetID    int64          `json:"target_id"`
+	Status      string         `json:"status"` // pending, running, success, partial, failed, interrupted, cancelled
+	FileCount   int            `json:"file_count"`
+	TotalBytes  int64          `json:"total_bytes"`
+	DeltaBytes  int64          `json:"delta_bytes"`            // New bytes uploaded, before compression
+	StoredBytes int64          `json:"stored_bytes"`           // New bytes written to the backend, after compression
+	ParentID    *int64         `json:"parent_id,omitempty"`    // Snapshot whose manifest was used for the incremental scan
+	FilterSkips map[string]int `json:"filter_skips,omitempty"` // Files skipped by each source filter
+	ErrorCount  int            `json:"error_count"`            // Files which could not be read, listed in the manifest
+	Error       *string        `json:"error,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+	CompletedAt *time.Time     `json:"completed_at,omitempty"`
+}
+
+// Completed reports whether a snapshot stored its manifest and can be
+// restored: it succeeded, or it is partial because some files could not be read
+func (s *Snapshot) Completed() bool {
+	return s.Status == "success" || s.Status == "partial"
 }
 
 // SnapshotFile represents a file within a snapshot
@@ -101,48 +146,181 @@ type SnapshotFile struct {
 
 // Job represents a backup job execution
 type Job struct {
-	ID         int64      `json:"id"`
-	UserID     int64      `json:"user_id"`
-	Type       string     `json:"type"` // backup, restore
-	SourceID   *int64     `json:"source_id,omitempty"`
-	SnapshotID *int64     `json:"snapshot_id,omitempty"`
-	Status     string     `json:"status"` // pending, running, success, failed
-	Error      *string    `json:"error,omitempty"`
-	StartedAt  time.Time  `json:"started_at"`
-	EndedAt    *time.Time `json:"ended_at,omitempty"`
+	ID          int64           `json:"id"`
+	UserID      int64           `json:"user_id"`
+	Type        string          `json:"type"` // backup, restore, prune, check
+	SourceID    *int64          `json:"source_id,omitempty"`
+	SnapshotID  *int64          `json:"snapshot_id,omitempty"`
+	TargetID    *int64          `json:"target_id,omitempty"` // Target of a job which works on a whole repository, such as a prune
+	Status      string          `json:"status"`              // pending, running, success, failed, interrupted, cancelled
+	Error       *string         `json:"error,omitempty"`
+	Params      string          `json:"-"`                  // JSON parameters of the job, such as a restore destination
+	Attempt     int             `json:"attempt"`            // 1 for the first run, incremented when an interrupted job is re-queued
+	RetryOf     *int64          `json:"retry_of,omitempty"` // The interrupted job this one re-queues
+	StartedAt   time.Time       `json:"started_at"`         // When the job was queued, then when a worker picked it up
+	HeartbeatAt *time.Time      `json:"heartbeat_at,omitempty"`
+	EndedAt     *time.Time      `json:"ended_at,omitempty"`
+	Progress    *JobProgress    `json:"progress,omitempty"` // Last progress recorded while the job ran
+	Result      json.RawMessage `json:"result,omitempty"`   // JSON report of a finished job, such as the space reclaimed by a prune
 }
 
-// Schedule represents a backup schedule
-type Schedule struct {
+// JobProgress is the progress of a backup or restore. For a restore, the
+// files and bytes are those of the snapshot, and bytes read are the bytes
+// written to the destination.
+type JobProgress struct {
+	FilesScanned       int64     `json:"files_scanned"`   // Files found so far, or in the snapshot for a restore
+	FilesProcessed     int64     `json:"files_processed"` // Files backed up or restored
+	BytesTotal         int64     `json:"bytes_total"`     // Size of the files scanned so far
+	BytesRead          int64     `json:"bytes_read"`
+	BytesUnchanged     int64     `json:"bytes_unchanged"` // Size of the files reused from the parent snapshot without being read
+	BytesUploaded      int64     `json:"bytes_uploaded"`  // Bytes written to the backend, after compression
+	ChunksDeduplicated int64     `json:"chunks_deduplicated"`
+	CurrentPath        string    `json:"current_path,omitempty"`
+	ScanComplete       bool      `json:"scan_complete"`
+	BytesPerSecond     float64   `json:"bytes_per_second"`      // Average read throughput
+	ETASeconds         *int64    `json:"eta_seconds,omitempty"` // Unknown until the scan completes
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// Job types
+const (
+	JobTypeBackup  = "backup"
+	JobTypeRestore = "restore"
+	JobTypePrune   = "prune"
+	JobTypeCheck   = "check"
+)
+
+// Job statuses
+const (
+	JobPending     = "pending"
+	JobRunning     = "running"
+	JobSuccess     = "success"
+	JobFailed      = "failed"
+	JobInterrupted = "interrupted" // The daemon stopped while the job was running
+	JobCancelled   = "cancelled"   // Stopped on request
+)
+
+// JobLogEntry is a warning or error recorded while a job ran
+type JobLogEntry struct {
 	ID        int64     `json:"id"`
-	SourceID  int64     `json:"source_id"`
-	Frequency string    `json:"frequency"` // manual, hourly, daily, weekly, cron
-	CronExpr  *string   `json:"cron_expr,omitempty"`
-	Enabled   bool      `json:"enabled"`
+	JobID     int64     `json:"job_id"`
+	Level     string    `json:"level"`          // warn, error
+	Path      string    `json:"path,omitempty"` // File the entry is about, if any
+	Message   string    `json:"message"`
 	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// Policies for a backup requested while another one of the same source is
+// pending or running
+const (
+	BackupPolicyRefuse   = "refuse"   // Reject the new backup, the default
+	BackupPolicyQueue    = "queue"    // Run the new backup after the current one
+	BackupPolicyCoalesce = "coalesce" // Reuse the pending backup if there is one, else queue
+)
+
+// Schedule represents a backup schedule
+type Schedule struct {
+	ID        int64         `json:"id"`
+	Type      string        `json:"type,omitempty"`      // Job the schedule runs: backup (the default), check
+	SourceID  int64         `json:"source_id"`           // Source backed up by a backup schedule
+	TargetID  *int64        `json:"target_id,omitempty"` // Target checked by a check schedule
+	Check     *CheckOptions `json:"check,omitempty"`     // Options of a check schedule
+	Frequency string        `json:"frequency"`           // manual, hourly, daily, weekly, cron
+	CronExpr  *string       `json:"cron_expr,omitempty"`
+	Timezone  string        `json:"timezone,omitempty"`       // IANA name, the server's time zone when empty
+	CatchUp   string        `json:"catch_up,omitempty"`       // What to do with runs missed while the daemon was down: once, skip
+	Jitter    int           `json:"jitter_seconds,omitempty"` // Random delay of up to this many seconds added to each run
+	Enabled   bool          `json:"enabled"`
+	LastRunAt *time.Time    `json:"last_run_at,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// Schedule types, by the job they run
+const (
+	ScheduleTypeBackup = "backup"
+	ScheduleTypeCheck  = "check"
+)
+
+// CheckOptions selects how deeply a check verifies a repository
+type CheckOptions struct {
+	ReadData        bool `json:"read_data,omitempty"`         // Download the chunks and verify their content, not only that they exist
+	ReadDataPercent int  `json:"read_data_percent,omitempty"` // Share of the chunks downloaded, picked at random, all of them when 0
+}
+
+// Schedule frequencies
+const (
+	FrequencyManual = "manual"
+	FrequencyHourly = "hourly"
+	FrequencyDaily  = "daily"
+	FrequencyWeekly = "weekly"
+	FrequencyCron   = "cron"
+)
+
+// Catch-up policies for runs missed while the daemon was down
+const (
+	CatchUpOnce = "once" // Run a single backup on startup, the default
+	CatchUpSkip = "skip" // Wait for the next scheduled run
+)
+
 // Chunk represents a content-addressable chunk
 type Chunk struct {
 	Hash string `json:"hash"` // SHA256
-	Size int64  `json:"size"`
-	Data []byte `json:"-"` // Not serialized
+	Size int64  `json:"size"` // Bytes stored on the backend, after compression
+	Data []byte `json:"-"`    // Not serialized
+}
+
+// ChunkerParams describes how files were split into chunks
+type ChunkerParams struct {
+	Algorithm string `json:"algorithm"` // fastcdc, fixed
+	MinSize   int    `json:"min_size,omitempty"`
+	AvgSize   int    `json:"avg_size,omitempty"`
+	MaxSize   int    `json:"max_size"`
 }
 
 // Manifest represents a snapshot manifest stored in the backend
 type Manifest struct {
 	SnapshotID int64          `json:"snapshot_id"`
 	SourcePath string         `json:"source_path"`
+	ParentID   *int64         `json:"parent_id,omitempty"`
+	Chunker    *ChunkerParams `json:"chunker,omitempty"` // Absent in manifests written by the legacy fixed-size chunker
 	Files      []ManifestFile `json:"files"`
+	Errors     []FileError    `json:"errors,omitempty"` // Files left out because they could not be read
 	CreatedAt  time.Time      `json:"created_at"`
 }
 
-// ManifestFile represents a file entry in a manifest
+// FileError records why a file of the source was left out of a snapshot
+type FileError struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// ManifestFile represents an entry in a manifest: a file, directory, symlink
+// or special file, with its metadata
 type ManifestFile struct {
-	Path    string    `json:"path"`
-	Size    int64     `json:"size"`
-	Hash    string    `json:"hash"`
-	Chunks  []string  `json:"chunks"`
-	ModTime time.Time `json:"mod_time"`
+	Path       string            `json:"path"`
+	Type       string            `json:"type,omitempty"` // See the Node* constants; empty in older manifests, meaning a regular file
+	Size       int64             `json:"size"`
+	Hash       string            `json:"hash"`
+	Chunks     []string          `json:"chunks"`
+	ModTime    time.Time         `json:"mod_time"`
+	Mode       uint32            `json:"mode,omitempty"` // Unix permission bits, including setuid, setgid and sticky
+	UID        *uint32           `json:"uid,omitempty"`
+	GID        *uint32           `json:"gid,omitempty"`
+	LinkTarget string            `json:"link_target,omitempty"` // Symlink target, stored verbatim
+	HardLink   string            `json:"hard_link,omitempty"`   // Path of an earlier entry this file is a hard link to
+	Device     uint64            `json:"device,omitempty"`      // Device number of character and block devices
+	Xattrs     map[string][]byte `json:"xattrs,omitempty"`
+	Inode      uint64            `json:"inode,omitempty"`       // Where the platform provides it
+	ChangeTime *time.Time        `json:"change_time,omitempty"` // Inode change time (ctime), where the platform provides it
 }
+
+// Manifest entry types
+const (
+	NodeFile        = "file"
+	NodeDir         = "dir"
+	NodeSymlink     = "symlink"
+	NodeCharDevice  = "chardev"
+	NodeBlockDevice = "blockdev"
+	NodeFIFO        = "fifo"
+)
@@ -1,6 +1,10 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
 
 // UserRepository handles user data persistence
 type UserRepository interface {
@@ -35,11 +39,17 @@ type TargetRepository interface {
 	Delete(ctx context.Context, id int64) error
 }
 
+type JobLogRepository interface {
+	Append(ctx context.Context, entries []*JobLogEntry) error
+	GetByJobID(ctx context.Context, jobID int64, offset, limit int) ([]*JobLogEntry, int, error)
+}
+
 type SnapshotRepository interface {
 	Create(ctx context.Context, snapshot *Snapshot) error
 	GetByID(ctx context.Context, id int64) (*Snapshot, error)
 	GetAll(ctx context.Context) ([]*Snapshot, error)
 	GetBySourceID(ctx context.Context, sourceID int64) ([]*Snapshot, error)
+	GetByStatus(ctx context.Context, status string) ([]*Snapshot, error)
 	Update(ctx context.Context, snapshot *Snapshot) error
 	Delete(ctx context.Context, id int64) error
 }
@@ -54,6 +64,13 @@ type JobRepository interface {
 	Create(ctx context.Context, job *Job) error
 	GetByID(ctx context.Context, id int64) (*Job, error)
 	GetAll(ctx context.Context) ([]*Job, error)
+	GetByStatus(ctx context.Context, status string) ([]*Job, error)
+	GetActiveBackups(ctx context.Context, sourceID int64) ([]*Job, error)
+	ClaimNext(ctx context.Context, at time.Time) (*Job, error)
+	Heartbeat(ctx context.Context, id int64, at time.Time) error
+	UpdateProgress(ctx context.Context, id int64, progress *JobProgress) error
+	UpdateResult(ctx context.Context, id int64, result json.RawMessage) error
+	CancelPending(ctx context.Context, id int64, at time.Time) error
 	Update(ctx context.Context, job *Job) error
 	Delete(ctx context.Context, id int64) error
 }
@@ -65,17 +82,18 @@ type ScheduleRepository interface {
 	GetAll(ctx context.Context) ([]*Schedule, error)
 	GetEnabled(ctx context.Context) ([]*Schedule, error)
 	Update(ctx context.Context, schedule *Schedule) error
+	SetLastRun(ctx context.Context, id int64, at time.Time) error
 	Delete(ctx context.Context, id int64) error
 }
 
+// ChunkRepository indexes the chunks written to each target, so that chunks
+// no manifest references anymore can be found and deleted. Chunks are not
+// reference counted: a prune marks the chunks the manifests reference, which
+// stays right when a backup crashes or two backups share a chunk.
 type ChunkRepository interface {
-	Create(ctx context.Context, chunk *Chunk) error
-	GetByHash(ctx context.Context, hash string) (*Chunk, error)
-	Exists(ctx context.Context, hash string) (bool, error)
-	IncrementRefCount(ctx context.Context, hash string) error
-	DecrementRefCount(ctx context.Context, hash string) error
-	GetUnreferenced(ctx context.Context) ([]*Chunk, error)
-	Delete(ctx context.Context, hash string) error
+	Record(ctx context.Context, targetID int64, chunks []*Chunk) error
+	GetByTarget(ctx context.Context, targetID int64) ([]*Chunk, error)
+	Delete(ctx context.Context, targetID int64, hash string) error
 }
 
 type ManifestRepository interface {
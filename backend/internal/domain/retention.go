@@ -0,0 +1,83 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RetentionPolicy decides which completed snapshots of a source are kept, in
+// the grandfather-father-son style. A snapshot is kept as soon as one rule
+// keeps it; zero values disable a rule.
+type RetentionPolicy struct {
+	KeepLast    int    `json:"keep_last,omitempty"`    // Most recent snapshots
+	KeepHourly  int    `json:"keep_hourly,omitempty"`  // Latest snapshot of each of the last N hours with one
+	KeepDaily   int    `json:"keep_daily,omitempty"`   // Latest snapshot of each of the last N days with one
+	KeepWeekly  int    `json:"keep_weekly,omitempty"`  // Latest snapshot of each of the last N ISO weeks with one
+	KeepMonthly int    `json:"keep_monthly,omitempty"` // Latest snapshot of each of the last N months with one
+	KeepYearly  int    `json:"keep_yearly,omitempty"`  // Latest snapshot of each of the last N years with one
+	KeepWithin  string `json:"keep_within,omitempty"`  // Snapshots younger than this period, such as 2w or 1y6m
+}
+
+// Empty reports whether the policy has no rule, which would forget every snapshot
+func (p *RetentionPolicy) Empty() bool {
+	return p.KeepLast == 0 && p.KeepHourly == 0 && p.KeepDaily == 0 && p.KeepWeekly == 0 &&
+		p.KeepMonthly == 0 && p.KeepYearly == 0 && p.KeepWithin == ""
+}
+
+// Validate checks the counts and period of the policy
+func (p *RetentionPolicy) Validate() error {
+	if p.KeepLast < 0 || p.KeepHourly < 0 || p.KeepDaily < 0 || p.KeepWeekly < 0 || p.KeepMonthly < 0 || p.KeepYearly < 0 {
+		return fmt.Errorf("%w: retention counts must not be negative", ErrInvalidInput)
+	}
+	if p.Empty() {
+		return fmt.Errorf("%w: a retention policy needs at least one rule", ErrInvalidInput)
+	}
+	if _, err := p.WithinCutoff(time.Now()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// WithinCutoff returns the creation time after which snapshots are kept by
+// KeepWithin, or the zero time when the rule is disabled. The period is a
+// sequence of counts with a unit: y (years), m (months), w (weeks), d (days)
+// or h (hours), as in "1y6m" or "36h".
+func (p *RetentionPolicy) WithinCutoff(now time.Time) (time.Time, error) {
+	if p.KeepWithin == "" {
+		return time.Time{}, nil
+	}
+
+	var years, months, days, hours int
+	rest := p.KeepWithin
+	for rest != "" {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		if i == 0 || i == len(rest) {
+			return time.Time{}, fmt.Errorf("%w: invalid keep_within %q", ErrInvalidInput, p.KeepWithin)
+		}
+		n, err := strconv.Atoi(rest[:i])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: invalid keep_within %q", ErrInvalidInput, p.KeepWithin)
+		}
+		switch rest[i] {
+		case 'y':
+			years += n
+		case 'm':
+			months += n
+		case 'w':
+			days += 7 * n
+		case 'd':
+			days += n
+		case 'h':
+			hours += n
+		default:
+			return time.Time{}, fmt.Errorf("%w: invalid keep_within unit %q", ErrInvalidInput, rest[i])
+		}
+		rest = rest[i+1:]
+	}
+
+	return now.AddDate(-years, -months, -days).Add(-time.Duration(hours) * time.Hour), nil
+}
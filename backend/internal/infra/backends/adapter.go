@@ -0,0 +1,79 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/axelfrache/savesync/internal/domain"
+)
+
+// Adapt returns a backend providing the streaming methods of domain.Backend.
+// Backends which already implement them are returned as is, others go
+// through their byte-slice methods, holding whole objects in memory.
+func Adapt(backend domain.ByteBackend) domain.Backend {
+	if streaming, ok := backend.(domain.Backend); ok {
+		return streaming
+	}
+	return &byteAdapter{ByteBackend: backend}
+}
+
+// byteAdapter implements the streaming methods of domain.Backend with the
+// byte-slice methods of a backend
+type byteAdapter struct {
+	domain.ByteBackend
+}
+
+// PutObject reads the whole object and stores it
+func (a *byteAdapter) PutObject(ctx context.Context, key string, r io.Reader, size int64) error {
+	kind, name, err := domain.SplitObjectKey(key)
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return fmt.Errorf("short read: %d of %d bytes", len(data), size)
+	}
+
+	if kind == domain.ObjectManifest {
+		return a.StoreManifest(ctx, name, data)
+	}
+	return a.StoreChunk(ctx, name, data)
+}
+
+// GetObject loads the whole object
+func (a *byteAdapter) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	return a.GetObjectRange(ctx, key, 0, -1)
+}
+
+// GetObjectRange loads the whole object and returns the range
+func (a *byteAdapter) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	kind, name, err := domain.SplitObjectKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset", domain.ErrInvalidInput)
+	}
+
+	var data []byte
+	if kind == domain.ObjectManifest {
+		data, err = a.LoadManifest(ctx, name)
+	} else {
+		data, err = a.LoadChunk(ctx, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data = data[min(offset, int64(len(data))):]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
@@ -0,0 +1,51 @@
+package backends
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/axelfrache/savesync/internal/infra/backends/local"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// byteOnlyBackend hides the streaming methods of a backend, like a
+// third-party backend written before they existed
+type byteOnlyBackend struct {
+	domain.ByteBackend
+}
+
+func TestAdapt(t *testing.T) {
+	ctx := context.Background()
+	inner := local.New()
+	require.NoError(t, inner.Init(map[string]string{"path": t.TempDir()}))
+
+	// Streaming backends are used as is
+	assert.Same(t, inner, Adapt(inner))
+
+	backend := Adapt(byteOnlyBackend{inner})
+	require.NoError(t, backend.PutObject(ctx, domain.ChunkKey("abcd0001"), strings.NewReader("0123456789"), -1))
+	require.NoError(t, backend.PutObject(ctx, domain.ManifestKey("2"), strings.NewReader(`{}`), 2))
+
+	data, err := inner.LoadChunk(ctx, "abcd0001")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+	data, err = inner.LoadManifest(ctx, "2")
+	require.NoError(t, err)
+	assert.Equal(t, `{}`, string(data))
+
+	body, err := backend.GetObjectRange(ctx, domain.ChunkKey("abcd0001"), 2, 3)
+	require.NoError(t, err)
+	data, err = io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "234", string(data))
+
+	_, err = backend.GetObject(ctx, domain.ManifestKey("3"))
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+
+	err = backend.PutObject(ctx, domain.ManifestKey("4"), strings.NewReader(`{}`), 10)
+	assert.Error(t, err, "size mismatch")
+}
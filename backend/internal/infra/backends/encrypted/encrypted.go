@@ -0,0 +1,329 @@
+// Package encrypted provides client-side authenticated encryption as a layer
+// around any domain.Backend.
+//
+// Every chunk and manifest is sealed with the repository's data key before it
+// reaches the inner backend. Chunks are stored under an HMAC-SHA256 of their
+// content hash rather than the hash itself, so the storage IDs reveal nothing
+// about the plaintext. The data key and HMAC key are generated once per
+// repository and kept in a key file on the backend, wrapped by a key derived
+// from the passphrase with Argon2id.
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/axelfrache/savesync/internal/domain"
+)
+
+// envelopeVersion is the first byte of every sealed object
+const envelopeVersion = 1
+
+// Options configures the encryption layer
+type Options struct {
+	Cipher     string // Cipher used when the repository key is created
+	Passphrase string
+}
+
+// Backend implements domain.Backend by encrypting data before handing it to
+// an inner backend
+type Backend struct {
+	inner  domain.Backend
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// Wrap unlocks the repository key of inner, creating it on first use, and
+// returns a backend that encrypts everything written to inner. The cipher of
+// an existing repository is kept regardless of opts.Cipher.
+func Wrap(ctx context.Context, inner domain.Backend, opts Options) (*Backend, error) {
+	if opts.Passphrase == "" {
+		return nil, fmt.Errorf("%w: encryption passphrase is required", domain.ErrInvalidInput)
+	}
+
+	var master []byte
+	var cipherName string
+
+	data, err := inner.LoadKeyFile(ctx)
+	if errors.Is(err, domain.ErrNotFound) {
+		master, err = createKeyFile(ctx, inner, opts)
+		switch {
+		case err == nil:
+			cipherName = opts.Cipher
+		case errors.Is(err, domain.ErrAlreadyExists):
+			// Another client created it first: its key is the one to use
+			data, err = inner.LoadKeyFile(ctx)
+		default:
+			return nil, err
+		}
+	}
+
+	if master == nil {
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key file: %w", err)
+		}
+		kf, err := parseKeyFile(data)
+		if err != nil {
+			return nil, err
+		}
+		if master, err = kf.unlock(opts.Passphrase); err != nil {
+			return nil, err
+		}
+		cipherName = kf.Cipher
+	}
+
+	aead, err := newAEAD(cipherName, master[:32])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Backend{
+		inner:  inner,
+		aead:   aead,
+		macKey: master[32:],
+	}, nil
+}
+
+// createKeyFile generates the master key of a new repository and stores it
+// in a key file protected by the passphrase. It returns
+// domain.ErrAlreadyExists if the repository got a key file in the meantime.
+func createKeyFile(ctx context.Context, inner domain.Backend, opts Options) ([]byte, error) {
+	kf, key, err := newKeyFile(opts.Cipher, opts.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key file: %w", err)
+	}
+	if err := inner.StoreKeyFile(ctx, encoded); err != nil {
+		if errors.Is(err, domain.ErrAlreadyExists) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to store key file: %w", err)
+	}
+	return key, nil
+}
+
+// newAEAD creates the AEAD for a cipher name
+func newAEAD(name string, key []byte) (cipher.AEAD, error) {
+	switch name {
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher: %w", err)
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("%w: unknown encryption cipher %q", domain.ErrInvalidInput, name)
+	}
+}
+
+// chunkID returns the storage ID of a chunk from its plaintext hash
+func (b *Backend) chunkID(hash string) string {
+	mac := hmac.New(sha256.New, b.macKey)
+	mac.Write([]byte(hash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// seal encrypts data into a versioned envelope: version, nonce, ciphertext.
+// The additional data ties the envelope to the object it is stored as, so
+// objects cannot be swapped on the backend without detection.
+func (b *Backend) seal(data []byte, ad string) ([]byte, error) {
+	nonceSize := b.aead.NonceSize()
+	out := make([]byte, 1+nonceSize, 1+nonceSize+len(data)+b.aead.Overhead())
+	out[0] = envelopeVersion
+	if _, err := rand.Read(out[1:]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return b.aead.Seal(out, out[1:], data, []byte(ad)), nil
+}
+
+// open decrypts an envelope produced by seal
+func (b *Backend) open(data []byte, ad string) ([]byte, error) {
+	nonceSize := b.aead.NonceSize()
+	if len(data) < 1+nonceSize+b.aead.Overhead() || data[0] != envelopeVersion {
+		return nil, fmt.Errorf("%w: malformed encrypted object", domain.ErrSnapshotInvalid)
+	}
+	plain, err := b.aead.Open(nil, data[1:1+nonceSize], data[1+nonceSize:], []byte(ad))
+	if err != nil {
+		return nil, fmt.Errorf("%w: encrypted object failed authentication", domain.ErrSnapshotInvalid)
+	}
+	return plain, nil
+}
+
+// Init is a no-op: the inner backend is initialized before being wrapped
+func (b *Backend) Init(config map[string]string) error {
+	return nil
+}
+
+// StoreChunk encrypts and stores a chunk
+func (b *Backend) StoreChunk(ctx context.Context, hash string, data []byte) error {
+	id := b.chunkID(hash)
+	sealed, err := b.seal(data, "chunk:"+id)
+	if err != nil {
+		return err
+	}
+	return b.inner.StoreChunk(ctx, id, sealed)
+}
+
+// LoadChunk loads and decrypts a chunk
+func (b *Backend) LoadChunk(ctx context.Context, hash string) ([]byte, error) {
+	id := b.chunkID(hash)
+	data, err := b.inner.LoadChunk(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return b.open(data, "chunk:"+id)
+}
+
+// DeleteChunk deletes a chunk
+func (b *Backend) DeleteChunk(ctx context.Context, hash string) error {
+	return b.inner.DeleteChunk(ctx, b.chunkID(hash))
+}
+
+// ChunkExists checks if a chunk exists
+func (b *Backend) ChunkExists(ctx context.Context, hash string) (bool, error) {
+	return b.inner.ChunkExists(ctx, b.chunkID(hash))
+}
+
+// StoreManifest encrypts and stores a snapshot manifest
+func (b *Backend) StoreManifest(ctx context.Context, snapshotID string, data []byte) error {
+	sealed, err := b.seal(data, "manifest:"+snapshotID)
+	if err != nil {
+		return err
+	}
+	return b.inner.StoreManifest(ctx, snapshotID, sealed)
+}
+
+// LoadManifest loads and decrypts a snapshot manifest
+func (b *Backend) LoadManifest(ctx context.Context, snapshotID string) ([]byte, error) {
+	data, err := b.inner.LoadManifest(ctx, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	return b.open(data, "manifest:"+snapshotID)
+}
+
+// DeleteManifest deletes a snapshot manifest
+func (b *Backend) DeleteManifest(ctx context.Context, snapshotID string) error {
+	return b.inner.DeleteManifest(ctx, snapshotID)
+}
+
+// StoreKeyFile stores the repository key file, which is already protected
+// by the passphrase
+func (b *Backend) StoreKeyFile(ctx context.Context, data []byte) error {
+	return b.inner.StoreKeyFile(ctx, data)
+}
+
+// LoadKeyFile loads the repository key file
+func (b *Backend) LoadKeyFile(ctx context.Context) ([]byte, error) {
+	return b.inner.LoadKeyFile(ctx)
+}
+
+// ListChunks is not supported: chunks are stored under an HMAC of their
+// content hash, which can't be turned back into the hash
+func (b *Backend) ListChunks(ctx context.Context, fn func(domain.ObjectInfo) error) error {
+	return fmt.Errorf("%w: chunks of an encrypted repository can't be listed by hash", domain.ErrNotSupported)
+}
+
+// ListManifests lists the manifests, whose snapshot IDs are stored in clear.
+// Sizes are those of the sealed manifests.
+func (b *Backend) ListManifests(ctx context.Context, fn func(domain.ObjectInfo) error) error {
+	return b.inner.ListManifests(ctx, fn)
+}
+
+// innerKey returns the key an object is stored under on the inner backend,
+// and the additional data its envelope is sealed with
+func (b *Backend) innerKey(key string) (innerKey, ad string, err error) {
+	kind, name, err := domain.SplitObjectKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	if kind == domain.ObjectManifest {
+		return key, "manifest:" + name, nil
+	}
+	id := b.chunkID(name)
+	return domain.ChunkKey(id), "chunk:" + id, nil
+}
+
+// PutObject encrypts and stores an object. The whole object is read first,
+// since an envelope is authenticated as a whole.
+func (b *Backend) PutObject(ctx context.Context, key string, r io.Reader, size int64) error {
+	innerKey, ad, err := b.innerKey(key)
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return fmt.Errorf("short read: %d of %d bytes", len(data), size)
+	}
+
+	sealed, err := b.seal(data, ad)
+	if err != nil {
+		return err
+	}
+	return b.inner.PutObject(ctx, innerKey, bytes.NewReader(sealed), int64(len(sealed)))
+}
+
+// GetObject loads and decrypts an object
+func (b *Backend) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	return b.GetObjectRange(ctx, key, 0, -1)
+}
+
+// GetObjectRange loads and decrypts a whole object, then returns the range:
+// an envelope can't be authenticated in parts
+func (b *Backend) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	innerKey, ad, err := b.innerKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset", domain.ErrInvalidInput)
+	}
+
+	body, err := b.inner.GetObject(ctx, innerKey)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+	plain, err := b.open(data, ad)
+	if err != nil {
+		return nil, err
+	}
+
+	plain = plain[min(offset, int64(len(plain))):]
+	if length >= 0 && length < int64(len(plain)) {
+		plain = plain[:length]
+	}
+	return io.NopCloser(bytes.NewReader(plain)), nil
+}
+
+// Close closes the inner backend
+func (b *Backend) Close() error {
+	return b.inner.Close()
+}
@@ -0,0 +1,222 @@
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/axelfrache/savesync/internal/infra/backends/local"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	// Keep key derivation cheap in tests
+	defaultKDFParams = KDFParams{Algorithm: "argon2id", Time: 1, Memory: 1024, Threads: 1}
+}
+
+func newLocalBackend(t *testing.T) (*local.Backend, string) {
+	t.Helper()
+	dir := t.TempDir()
+	backend := local.New()
+	require.NoError(t, backend.Init(map[string]string{"path": dir}))
+	return backend, dir
+}
+
+func hashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestEncryptedBackend_RoundTrip(t *testing.T) {
+	for _, cipherName := range []string{CipherAES256GCM, CipherXChaCha20Poly1305} {
+		t.Run(cipherName, func(t *testing.T) {
+			ctx := context.Background()
+			inner, dir := newLocalBackend(t)
+			backend, err := Wrap(ctx, inner, Options{Cipher: cipherName, Passphrase: "correct horse"})
+			require.NoError(t, err)
+
+			chunk := []byte("some very secret chunk content")
+			hash := hashOf(chunk)
+			require.NoError(t, backend.StoreChunk(ctx, hash, chunk))
+			require.NoError(t, backend.StoreManifest(ctx, "1", []byte(`{"files":["secret.txt"]}`)))
+
+			exists, err := backend.ChunkExists(ctx, hash)
+			require.NoError(t, err)
+			assert.True(t, exists)
+
+			loaded, err := backend.LoadChunk(ctx, hash)
+			require.NoError(t, err)
+			assert.Equal(t, chunk, loaded)
+
+			manifest, err := backend.LoadManifest(ctx, "1")
+			require.NoError(t, err)
+			assert.Equal(t, `{"files":["secret.txt"]}`, string(manifest))
+
+			// Nothing on disk reveals the plaintext or its hash
+			_, err = inner.LoadChunk(ctx, hash)
+			assert.ErrorIs(t, err, domain.ErrNotFound)
+			filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+				require.NoError(t, err)
+				assert.NotContains(t, path, hash)
+				if !info.IsDir() {
+					data, err := os.ReadFile(path)
+					require.NoError(t, err)
+					assert.False(t, bytes.Contains(data, []byte("secret")), path)
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestEncryptedBackend_ReopenKeepsKey(t *testing.T) {
+	ctx := context.Background()
+	inner, _ := newLocalBackend(t)
+	first, err := Wrap(ctx, inner, Options{Cipher: CipherXChaCha20Poly1305, Passphrase: "passphrase"})
+	require.NoError(t, err)
+	require.NoError(t, first.StoreChunk(ctx, hashOf([]byte("data")), []byte("data")))
+
+	// The cipher recorded in the key file wins over the requested one
+	second, err := Wrap(ctx, inner, Options{Cipher: CipherAES256GCM, Passphrase: "passphrase"})
+	require.NoError(t, err)
+	data, err := second.LoadChunk(ctx, hashOf([]byte("data")))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	_, err = Wrap(ctx, inner, Options{Cipher: CipherAES256GCM, Passphrase: "wrong"})
+	assert.ErrorIs(t, err, ErrWrongPassphrase)
+}
+
+// staleBackend misses the key file on its first lookup, as a client does
+// when another one creates the key file right after it looked
+type staleBackend struct {
+	*local.Backend
+	looked bool
+}
+
+func (b *staleBackend) LoadKeyFile(ctx context.Context) ([]byte, error) {
+	if !b.looked {
+		b.looked = true
+		return nil, domain.ErrNotFound
+	}
+	return b.Backend.LoadKeyFile(ctx)
+}
+
+func TestEncryptedBackend_ConcurrentCreationSharesKey(t *testing.T) {
+	ctx := context.Background()
+	inner, _ := newLocalBackend(t)
+	first, err := Wrap(ctx, inner, Options{Cipher: CipherAES256GCM, Passphrase: "passphrase"})
+	require.NoError(t, err)
+	require.NoError(t, first.StoreChunk(ctx, hashOf([]byte("data")), []byte("data")))
+
+	// The losing client adopts the key file instead of replacing it
+	second, err := Wrap(ctx, &staleBackend{Backend: inner}, Options{Cipher: CipherXChaCha20Poly1305, Passphrase: "passphrase"})
+	require.NoError(t, err)
+	data, err := second.LoadChunk(ctx, hashOf([]byte("data")))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	_, err = Wrap(ctx, &staleBackend{Backend: inner}, Options{Cipher: CipherAES256GCM, Passphrase: "wrong"})
+	assert.ErrorIs(t, err, ErrWrongPassphrase)
+}
+
+func TestEncryptedBackend_DetectsTampering(t *testing.T) {
+	ctx := context.Background()
+	inner, _ := newLocalBackend(t)
+	backend, err := Wrap(ctx, inner, Options{Cipher: CipherAES256GCM, Passphrase: "passphrase"})
+	require.NoError(t, err)
+
+	require.NoError(t, backend.StoreManifest(ctx, "1", []byte("first")))
+	require.NoError(t, backend.StoreManifest(ctx, "2", []byte("second")))
+
+	// A manifest moved to another snapshot ID fails authentication
+	sealed, err := inner.LoadManifest(ctx, "2")
+	require.NoError(t, err)
+	require.NoError(t, inner.StoreManifest(ctx, "1", sealed))
+	_, err = backend.LoadManifest(ctx, "1")
+	assert.ErrorIs(t, err, domain.ErrSnapshotInvalid)
+
+	// So does a flipped bit
+	sealed[len(sealed)-1] ^= 1
+	require.NoError(t, inner.StoreManifest(ctx, "2", sealed))
+	_, err = backend.LoadManifest(ctx, "2")
+	assert.ErrorIs(t, err, domain.ErrSnapshotInvalid)
+}
+
+func TestWrap_InvalidOptions(t *testing.T) {
+	ctx := context.Background()
+	inner, _ := newLocalBackend(t)
+
+	_, err := Wrap(ctx, inner, Options{Cipher: CipherAES256GCM})
+	assert.ErrorIs(t, err, domain.ErrInvalidInput)
+
+	_, err = Wrap(ctx, inner, Options{Cipher: "rot13", Passphrase: "passphrase"})
+	assert.ErrorIs(t, err, domain.ErrInvalidInput)
+
+	// No key file is left behind by a failed creation
+	_, err = inner.LoadKeyFile(ctx)
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+}
+
+func TestEncryptedBackend_List(t *testing.T) {
+	ctx := context.Background()
+	inner, _ := newLocalBackend(t)
+	backend, err := Wrap(ctx, inner, Options{Cipher: CipherAES256GCM, Passphrase: "passphrase"})
+	require.NoError(t, err)
+
+	require.NoError(t, backend.StoreChunk(ctx, hashOf([]byte("chunk")), []byte("chunk")))
+	require.NoError(t, backend.StoreManifest(ctx, "3", []byte(`{}`)))
+
+	// Storage IDs can't be turned back into content hashes
+	err = backend.ListChunks(ctx, func(domain.ObjectInfo) error { return nil })
+	assert.ErrorIs(t, err, domain.ErrNotSupported)
+
+	var manifests []string
+	require.NoError(t, backend.ListManifests(ctx, func(object domain.ObjectInfo) error {
+		manifests = append(manifests, object.Name)
+		assert.Greater(t, object.Size, int64(len(`{}`)), "sizes are those of the sealed manifests")
+		return nil
+	}))
+	assert.Equal(t, []string{"3"}, manifests)
+}
+
+func TestEncryptedBackend_Stream(t *testing.T) {
+	ctx := context.Background()
+	inner, _ := newLocalBackend(t)
+	backend, err := Wrap(ctx, inner, Options{Cipher: CipherXChaCha20Poly1305, Passphrase: "passphrase"})
+	require.NoError(t, err)
+
+	chunk := []byte("streamed secret chunk")
+	hash := hashOf(chunk)
+	require.NoError(t, backend.PutObject(ctx, domain.ChunkKey(hash), bytes.NewReader(chunk), int64(len(chunk))))
+	require.NoError(t, backend.PutObject(ctx, domain.ManifestKey("5"), strings.NewReader(`{"files":[]}`), -1))
+
+	// Streamed objects are sealed like the others
+	loaded, err := backend.LoadChunk(ctx, hash)
+	require.NoError(t, err)
+	assert.Equal(t, chunk, loaded)
+	manifest, err := backend.LoadManifest(ctx, "5")
+	require.NoError(t, err)
+	assert.Equal(t, `{"files":[]}`, string(manifest))
+	_, err = inner.LoadChunk(ctx, hash)
+	assert.ErrorIs(t, err, domain.ErrNotFound, "chunks are stored under their storage ID")
+
+	// Ranges are taken from the plaintext
+	body, err := backend.GetObjectRange(ctx, domain.ChunkKey(hash), 9, 6)
+	require.NoError(t, err)
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	body.Close()
+	assert.Equal(t, "secret", string(data))
+
+	_, err = backend.GetObject(ctx, domain.ChunkKey(hashOf([]byte("missing"))))
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+}
@@ -0,0 +1,131 @@
+package encrypted
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Supported ciphers
+const (
+	CipherAES256GCM         = "aes-256-gcm"
+	CipherXChaCha20Poly1305 = "xchacha20-poly1305"
+)
+
+const (
+	keyFileVersion = 1
+
+	// masterKeySize covers the data encryption key followed by the chunk ID key
+	masterKeySize = 64
+	saltSize      = 16
+
+	// keyWrapAAD binds the wrapped key to its purpose
+	keyWrapAAD = "savesync-repository-key"
+)
+
+// ErrWrongPassphrase is returned when the passphrase does not unlock the repository key
+var ErrWrongPassphrase = errors.New("wrong repository passphrase")
+
+// KDFParams are the Argon2id parameters used to derive the key encryption key
+type KDFParams struct {
+	Algorithm string `json:"algorithm"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory"` // KiB
+	Threads   uint8  `json:"threads"`
+}
+
+// defaultKDFParams follows the second recommended Argon2id profile of RFC 9106
+var defaultKDFParams = KDFParams{
+	Algorithm: "argon2id",
+	Time:      3,
+	Memory:    64 * 1024,
+	Threads:   4,
+}
+
+// keyFile is the repository key file stored on the backend. The master key
+// never leaves the client unwrapped.
+type keyFile struct {
+	Version    int       `json:"version"`
+	Cipher     string    `json:"cipher"`
+	KDF        KDFParams `json:"kdf"`
+	Salt       []byte    `json:"salt"`
+	WrappedKey []byte    `json:"wrapped_key"` // Nonce followed by the sealed master key
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// newKeyFile generates a random master key and wraps it with the passphrase
+func newKeyFile(cipherName, passphrase string) (*keyFile, []byte, error) {
+	master := make([]byte, masterKeySize)
+	if _, err := rand.Read(master); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate master key: %w", err)
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	kf := &keyFile{
+		Version:   keyFileVersion,
+		Cipher:    cipherName,
+		KDF:       defaultKDFParams,
+		Salt:      salt,
+		CreatedAt: time.Now(),
+	}
+
+	aead, err := newAEAD(cipherName, kf.deriveKey(passphrase))
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	kf.WrappedKey = aead.Seal(nonce, nonce, master, []byte(keyWrapAAD))
+
+	return kf, master, nil
+}
+
+// parseKeyFile decodes a key file read from the backend
+func parseKeyFile(data []byte) (*keyFile, error) {
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return nil, fmt.Errorf("failed to parse key file: %w", err)
+	}
+	if kf.Version != keyFileVersion {
+		return nil, fmt.Errorf("unsupported key file version %d", kf.Version)
+	}
+	if kf.KDF.Algorithm != "argon2id" {
+		return nil, fmt.Errorf("unsupported key derivation %q", kf.KDF.Algorithm)
+	}
+	return &kf, nil
+}
+
+// unlock unwraps the master key with the passphrase
+func (kf *keyFile) unlock(passphrase string) ([]byte, error) {
+	aead, err := newAEAD(kf.Cipher, kf.deriveKey(passphrase))
+	if err != nil {
+		return nil, err
+	}
+	if len(kf.WrappedKey) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid key file: wrapped key too short")
+	}
+
+	nonce, sealed := kf.WrappedKey[:aead.NonceSize()], kf.WrappedKey[aead.NonceSize():]
+	master, err := aead.Open(nil, nonce, sealed, []byte(keyWrapAAD))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	if len(master) != masterKeySize {
+		return nil, fmt.Errorf("invalid key file: unexpected master key size")
+	}
+	return master, nil
+}
+
+// deriveKey derives the key encryption key from the passphrase
+func (kf *keyFile) deriveKey(passphrase string) []byte {
+	return argon2.IDKey([]byte(passphrase), kf.Salt, kf.KDF.Time, kf.KDF.Memory, kf.KDF.Threads, 32)
+}
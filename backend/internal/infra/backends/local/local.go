@@ -1,10 +1,14 @@
 package local
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/axelfrache/savesync/internal/domain"
 )
@@ -45,6 +49,9 @@ func (b *Backend) StoreChunk(ctx context.Context, hash string, data []byte) erro
 	if len(hash) < 4 {
 		return fmt.Errorf("invalid hash: too short")
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	// Create nested directory structure: chunks/ab/cd/abcd1234...
 	dir := filepath.Join(b.basePath, "chunks", hash[:2], hash[2:4])
@@ -59,13 +66,41 @@ func (b *Backend) StoreChunk(ctx context.Context, hash string, data []byte) erro
 		return nil // Chunk already exists
 	}
 
-	if err := os.WriteFile(chunkPath, data, 0644); err != nil {
+	if err := writeFileAtomic(chunkPath, bytes.NewReader(data), int64(len(data)), 0644); err != nil {
 		return fmt.Errorf("failed to write chunk: %w", err)
 	}
 
 	return nil
 }
 
+// writeFileAtomic writes the content of r to a file through a temporary file
+// renamed into place, so that an interrupted write never leaves a truncated
+// file behind. size is checked against the bytes written, unless negative.
+func writeFileAtomic(path string, r io.Reader, size int64, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	written, err := io.Copy(tmp, r)
+	if err == nil && size >= 0 && written != size {
+		err = fmt.Errorf("short write: %d of %d bytes", written, size)
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
 // LoadChunk loads a chunk by hash
 func (b *Backend) LoadChunk(ctx context.Context, hash string) ([]byte, error) {
 	if len(hash) < 4 {
@@ -162,6 +197,204 @@ func (b *Backend) DeleteManifest(ctx context.Context, snapshotID string) error {
 	return nil
 }
 
+// StoreKeyFile stores the repository key file unless it already exists. It
+// is written to a temporary file first and then linked into place, which
+// fails if the key file exists, so that it is never seen half written.
+func (b *Backend) StoreKeyFile(ctx context.Context, data []byte) error {
+	keyPath := filepath.Join(b.basePath, "key")
+
+	tmp, err := os.CreateTemp(b.basePath, ".key-*")
+	if err != nil {
+		return fmt.Errorf("failed to create key file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	if err := os.Link(tmpPath, keyPath); err != nil {
+		if os.IsExist(err) {
+			return domain.ErrAlreadyExists
+		}
+		return fmt.Errorf("failed to move key file into place: %w", err)
+	}
+
+	return nil
+}
+
+// LoadKeyFile loads the repository key file
+func (b *Backend) LoadKeyFile(ctx context.Context) ([]byte, error) {
+	keyPath := filepath.Join(b.basePath, "key")
+
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	return data, nil
+}
+
+// ListChunks calls fn for each chunk below the chunks directory
+func (b *Backend) ListChunks(ctx context.Context, fn func(domain.ObjectInfo) error) error {
+	var fnErr error
+	err := filepath.WalkDir(filepath.Join(b.basePath, "chunks"), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Temporary files of interrupted writes are not chunks
+		if entry.IsDir() || strings.Contains(entry.Name(), ".") {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // Deleted since the directory was read
+			}
+			return err
+		}
+
+		if err := fn(domain.ObjectInfo{Name: entry.Name(), Size: info.Size()}); err != nil {
+			fnErr = err
+			return err
+		}
+		return nil
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list chunks: %w", err)
+	}
+
+	return nil
+}
+
+// ListManifests calls fn for each manifest of the manifests directory
+func (b *Backend) ListManifests(ctx context.Context, fn func(domain.ObjectInfo) error) error {
+	entries, err := os.ReadDir(filepath.Join(b.basePath, "manifests"))
+	if err != nil {
+		return fmt.Errorf("failed to list manifests: %w", err)
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		snapshotID, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to list manifests: %w", err)
+		}
+
+		if err := fn(domain.ObjectInfo{Name: snapshotID, Size: info.Size()}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// objectPath returns the path of the file holding the object of a key
+func (b *Backend) objectPath(key string) (string, error) {
+	kind, name, err := domain.SplitObjectKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	if kind == domain.ObjectManifest {
+		return filepath.Join(b.basePath, "manifests", name+".json"), nil
+	}
+	if len(name) < 4 {
+		return "", fmt.Errorf("invalid hash: too short")
+	}
+	return filepath.Join(b.basePath, "chunks", name[:2], name[2:4], name), nil
+}
+
+// PutObject writes an object through a temporary file renamed into place
+func (b *Backend) PutObject(ctx context.Context, key string, r io.Reader, size int64) error {
+	objectPath, err := b.objectPath(key)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+		return fmt.Errorf("failed to create object directory: %w", err)
+	}
+
+	if err := writeFileAtomic(objectPath, r, size, 0644); err != nil {
+		return fmt.Errorf("failed to write object: %w", err)
+	}
+
+	return nil
+}
+
+// GetObject opens the file of an object
+func (b *Backend) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	return b.GetObjectRange(ctx, key, 0, -1)
+}
+
+// GetObjectRange opens the file of an object at offset
+func (b *Backend) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	objectPath, err := b.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset", domain.ErrInvalidInput)
+	}
+
+	file, err := os.Open(objectPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to open object: %w", err)
+	}
+
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to seek object: %w", err)
+		}
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
 // Close closes the backend (no-op for local filesystem)
 func (b *Backend) Close() error {
 	return nil
@@ -0,0 +1,133 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackend_List(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	backend := New()
+	require.NoError(t, backend.Init(map[string]string{"path": dir}))
+
+	require.NoError(t, backend.StoreChunk(ctx, "abcd0001", []byte("first")))
+	require.NoError(t, backend.StoreChunk(ctx, "abef0002", []byte("second")))
+	require.NoError(t, backend.StoreChunk(ctx, "1234abcd", []byte("third!")))
+	require.NoError(t, backend.StoreManifest(ctx, "7", []byte(`{}`)))
+	require.NoError(t, backend.StoreManifest(ctx, "12", []byte(`{"files":[]}`)))
+
+	// Leftovers of interrupted writes are not listed
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "chunks", "ab", "cd", "abcd0003.tmp-123"), []byte("partial"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "manifests", "notes.txt"), []byte("notes"), 0644))
+
+	var chunks, manifests []domain.ObjectInfo
+	require.NoError(t, backend.ListChunks(ctx, func(object domain.ObjectInfo) error {
+		chunks = append(chunks, object)
+		return nil
+	}))
+	require.NoError(t, backend.ListManifests(ctx, func(object domain.ObjectInfo) error {
+		manifests = append(manifests, object)
+		return nil
+	}))
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Name < chunks[j].Name })
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].Name < manifests[j].Name })
+
+	assert.Equal(t, []domain.ObjectInfo{
+		{Name: "1234abcd", Size: 6},
+		{Name: "abcd0001", Size: 5},
+		{Name: "abef0002", Size: 6},
+	}, chunks)
+	assert.Equal(t, []domain.ObjectInfo{
+		{Name: "12", Size: 12},
+		{Name: "7", Size: 2},
+	}, manifests)
+
+	// An error of the callback stops the listing and is returned as is
+	stop := errors.New("stop")
+	calls := 0
+	err := backend.ListChunks(ctx, func(object domain.ObjectInfo) error {
+		calls++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestBackend_StoreKeyFileKeepsExisting(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	backend := New()
+	require.NoError(t, backend.Init(map[string]string{"path": dir}))
+
+	require.NoError(t, backend.StoreKeyFile(ctx, []byte("first")))
+	assert.ErrorIs(t, backend.StoreKeyFile(ctx, []byte("second")), domain.ErrAlreadyExists)
+
+	data, err := backend.LoadKeyFile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), data)
+
+	// No temporary file is left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.False(t, strings.HasPrefix(entry.Name(), ".key-"), entry.Name())
+	}
+}
+
+func TestBackend_Stream(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	backend := New()
+	require.NoError(t, backend.Init(map[string]string{"path": dir}))
+
+	// Streamed objects land where the byte-slice methods read them
+	require.NoError(t, backend.PutObject(ctx, domain.ChunkKey("abcd0001"), strings.NewReader("0123456789"), 10))
+	require.NoError(t, backend.PutObject(ctx, domain.ManifestKey("4"), strings.NewReader(`{"files":[]}`), -1))
+
+	data, err := backend.LoadChunk(ctx, "abcd0001")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+	data, err = backend.LoadManifest(ctx, "4")
+	require.NoError(t, err)
+	assert.Equal(t, `{"files":[]}`, string(data))
+
+	read := func(offset, length int64) string {
+		body, err := backend.GetObjectRange(ctx, domain.ChunkKey("abcd0001"), offset, length)
+		require.NoError(t, err)
+		defer body.Close()
+		data, err := io.ReadAll(body)
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, "0123456789", read(0, -1))
+	assert.Equal(t, "3456", read(3, 4))
+	assert.Equal(t, "789", read(7, -1))
+	assert.Equal(t, "89", read(8, 100))
+	assert.Equal(t, "", read(20, 5))
+
+	// A size which doesn't match leaves nothing behind
+	err = backend.PutObject(ctx, domain.ChunkKey("abcd0002"), strings.NewReader("short"), 10)
+	assert.Error(t, err)
+	_, err = backend.GetObject(ctx, domain.ChunkKey("abcd0002"))
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+	entries, err := os.ReadDir(filepath.Join(dir, "chunks", "ab", "cd"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// Keys can't leave the repository
+	for _, key := range []string{"key", "chunks/", "manifests/../key", "snapshots/1"} {
+		err := backend.PutObject(ctx, key, strings.NewReader("x"), 1)
+		assert.ErrorIs(t, err, domain.ErrInvalidInput, key)
+	}
+}
@@ -1,33 +1,37 @@
 package backends
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/axelfrache/savesync/internal/infra/backends/encrypted"
 	"github.com/axelfrache/savesync/internal/infra/backends/local"
 	"github.com/axelfrache/savesync/internal/infra/backends/s3"
 	"github.com/axelfrache/savesync/internal/infra/backends/sftp"
 )
 
 type Registry struct {
-	backends map[string]func() domain.Backend
+	backends map[string]func() domain.ByteBackend
 }
 
 func NewRegistry() *Registry {
 	r := &Registry{
-		backends: make(map[string]func() domain.Backend),
+		backends: make(map[string]func() domain.ByteBackend),
 	}
 
-	r.Register("local", func() domain.Backend { return &local.Backend{} })
-	r.Register("s3", func() domain.Backend { return &s3.Backend{} })
-	r.Register("s3_generic", func() domain.Backend { return &s3.Backend{} })
-	r.Register("s3_aws", func() domain.Backend { return &s3.Backend{} })
-	r.Register("sftp", func() domain.Backend { return &sftp.Backend{} })
+	r.Register("local", func() domain.ByteBackend { return &local.Backend{} })
+	r.Register("s3", func() domain.ByteBackend { return &s3.Backend{} })
+	r.Register("s3_generic", func() domain.ByteBackend { return &s3.Backend{} })
+	r.Register("s3_aws", func() domain.ByteBackend { return &s3.Backend{} })
+	r.Register("sftp", func() domain.ByteBackend { return &sftp.Backend{} })
 
 	return r
 }
 
-func (r *Registry) Register(backendType string, factory func() domain.Backend) {
+// Register adds a backend type. Backends which only implement the byte-slice
+// methods are given the streaming ones through Adapt.
+func (r *Registry) Register(backendType string, factory func() domain.ByteBackend) {
 	r.backends[backendType] = factory
 }
 
@@ -37,11 +41,24 @@ func (r *Registry) Create(backendType string, config map[string]string) (domain.
 		return nil, fmt.Errorf("unknown backend type: %s", backendType)
 	}
 
-	backend := factory()
+	backend := Adapt(factory())
 	if err := backend.Init(config); err != nil {
 		return nil, fmt.Errorf("failed to initialize backend: %w", err)
 	}
 
+	// Encrypt everything written to the backend when the target asks for it
+	if cipher := config["encryption"]; cipher != "" && cipher != "none" {
+		encryptedBackend, err := encrypted.Wrap(context.Background(), backend, encrypted.Options{
+			Cipher:     cipher,
+			Passphrase: config["encryption_passphrase"],
+		})
+		if err != nil {
+			backend.Close()
+			return nil, fmt.Errorf("failed to unlock repository key: %w", err)
+		}
+		return encryptedBackend, nil
+	}
+
 	return backend, nil
 }
 
@@ -3,16 +3,26 @@ package s3
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/axelfrache/savesync/internal/domain"
 )
 
+// partSize is the size of the parts of multipart uploads. S3 allows up to
+// 10,000 parts, so objects up to 160 GiB can be streamed.
+const partSize = 16 << 20
+
 // Backend implements domain.Backend for S3-compatible storage
 type Backend struct {
 	client *s3.Client
@@ -223,13 +233,274 @@ func (b *Backend) DeleteManifest(ctx context.Context, snapshotID string) error {
 	return nil
 }
 
+// StoreKeyFile stores the repository key file in S3 unless it already
+// exists, through a conditional write
+func (b *Backend) StoreKeyFile(ctx context.Context, data []byte) error {
+	_, err := b.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(b.bucket),
+		Key:         aws.String("key"),
+		Body:        bytes.NewReader(data),
+		IfNoneMatch: aws.String("*"),
+	})
+	if err != nil {
+		if isPreconditionError(err) {
+			return domain.ErrAlreadyExists
+		}
+		return fmt.Errorf("failed to put key file: %w", err)
+	}
+
+	return nil
+}
+
+// LoadKeyFile loads the repository key file from S3
+func (b *Backend) LoadKeyFile(ctx context.Context) ([]byte, error) {
+	result, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String("key"),
+	})
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get key file: %w", err)
+	}
+	defer result.Body.Close()
+
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file body: %w", err)
+	}
+
+	return data, nil
+}
+
+// ListChunks calls fn for each object under the chunks/ prefix, a page of
+// ListObjectsV2 at a time
+func (b *Backend) ListChunks(ctx context.Context, fn func(domain.ObjectInfo) error) error {
+	return b.list(ctx, "chunks/", "", fn)
+}
+
+// ListManifests calls fn for each object under the manifests/ prefix
+func (b *Backend) ListManifests(ctx context.Context, fn func(domain.ObjectInfo) error) error {
+	return b.list(ctx, "manifests/", ".json", fn)
+}
+
+// list calls fn for each object whose key has prefix and suffix, named by
+// the rest of its key
+func (b *Backend) list(ctx context.Context, prefix, suffix string, fn func(domain.ObjectInfo) error) error {
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			name, ok := strings.CutSuffix(strings.TrimPrefix(aws.ToString(object.Key), prefix), suffix)
+			if !ok || name == "" || strings.Contains(name, "/") {
+				continue
+			}
+
+			size := int64(-1)
+			if object.Size != nil {
+				size = *object.Size
+			}
+			if err := fn(domain.ObjectInfo{Name: name, Size: size}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// objectKey returns the S3 key of the object of a key
+func objectKey(key string) (string, error) {
+	kind, name, err := domain.SplitObjectKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	if kind == domain.ObjectManifest {
+		return fmt.Sprintf("manifests/%s.json", name), nil
+	}
+	return fmt.Sprintf("chunks/%s", name), nil
+}
+
+// PutObject uploads an object in a single request when it fits in a part,
+// and as a multipart upload otherwise. Objects of unknown size are read a
+// part at a time, so at most one part is held in memory.
+func (b *Backend) PutObject(ctx context.Context, key string, r io.Reader, size int64) error {
+	s3Key, err := objectKey(key)
+	if err != nil {
+		return err
+	}
+
+	if size >= 0 && size <= partSize {
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("failed to read object: %w", err)
+		}
+		return b.putObject(ctx, s3Key, data)
+	}
+
+	part := make([]byte, partSize)
+	n, err := io.ReadFull(r, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return b.putObject(ctx, s3Key, part[:n])
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+
+	return b.putMultipart(ctx, s3Key, part, r)
+}
+
+// putObject uploads a whole object in a single request
+func (b *Backend) putObject(ctx context.Context, s3Key string, data []byte) error {
+	_, err := b.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(b.bucket),
+		Key:           aws.String(s3Key),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put object: %w", err)
+	}
+
+	return nil
+}
+
+// putMultipart uploads an object as a multipart upload, starting with a full
+// first part already read from r. The upload is aborted if any part fails,
+// so that no orphan parts are billed.
+func (b *Backend) putMultipart(ctx context.Context, s3Key string, part []byte, r io.Reader) error {
+	upload, err := b.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(s3Key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	abort := func(err error) error {
+		_, abortErr := b.client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(b.bucket),
+			Key:      aws.String(s3Key),
+			UploadId: upload.UploadId,
+		})
+		return errors.Join(err, abortErr)
+	}
+
+	var parts []types.CompletedPart
+	for number := int32(1); len(part) > 0; number++ {
+		uploaded, err := b.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(b.bucket),
+			Key:           aws.String(s3Key),
+			UploadId:      upload.UploadId,
+			PartNumber:    aws.Int32(number),
+			Body:          bytes.NewReader(part),
+			ContentLength: aws.Int64(int64(len(part))),
+		})
+		if err != nil {
+			return abort(fmt.Errorf("failed to upload part %d: %w", number, err))
+		}
+		parts = append(parts, types.CompletedPart{ETag: uploaded.ETag, PartNumber: aws.Int32(number)})
+
+		n, err := io.ReadFull(r, part[:cap(part)])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return abort(fmt.Errorf("failed to read object: %w", err))
+		}
+		part = part[:n]
+	}
+
+	_, err = b.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(b.bucket),
+		Key:             aws.String(s3Key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return abort(fmt.Errorf("failed to complete multipart upload: %w", err))
+	}
+
+	return nil
+}
+
+// GetObject opens the body of an object
+func (b *Backend) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	return b.GetObjectRange(ctx, key, 0, -1)
+}
+
+// GetObjectRange opens part of the body of an object with a Range header
+func (b *Backend) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	s3Key, err := objectKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset", domain.ErrInvalidInput)
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(s3Key),
+	}
+	switch {
+	case length == 0:
+		// No Range header can ask for nothing
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset))
+	case length > 0:
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	result, err := b.client.GetObject(ctx, input)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil, domain.ErrNotFound
+		}
+		// A range starting past the end of the object reads nothing
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+			return io.NopCloser(bytes.NewReader(nil)), nil
+		}
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+
+	if length == 0 {
+		result.Body.Close()
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	return result.Body, nil
+}
+
 // Close closes the backend (no-op for S3)
 func (b *Backend) Close() error {
 	return nil
 }
 
+// isPreconditionError reports whether a conditional write failed because
+// the object exists, or because another conditional write of it is running
+func isPreconditionError(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict"
+	}
+	return false
+}
+
 // isNotFoundError checks if an error is a "not found" error
 func isNotFoundError(err error) bool {
 	// AWS SDK v2 error handling
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey"
+	}
 	return err != nil && (err.Error() == "NotFound" || err.Error() == "NoSuchKey")
 }
@@ -1,11 +1,15 @@
 package sftp
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
@@ -115,6 +119,10 @@ func (b *Backend) StoreChunk(ctx context.Context, hash string, data []byte) erro
 		return fmt.Errorf("invalid hash: too short")
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// Create nested directory structure
 	dir := filepath.Join(b.basePath, "chunks", hash[:2], hash[2:4])
 	if err := b.sftpClient.MkdirAll(dir); err != nil {
@@ -128,19 +136,68 @@ func (b *Backend) StoreChunk(ctx context.Context, hash string, data []byte) erro
 		return nil // Chunk already exists
 	}
 
-	file, err := b.sftpClient.Create(chunkPath)
-	if err != nil {
-		return fmt.Errorf("failed to create chunk file: %w", err)
+	// Chunks are named by their content: one stored meanwhile by another
+	// backup is the same chunk
+	if err := b.writeFileAtomic(chunkPath, bytes.NewReader(data), int64(len(data))); err != nil && !errors.Is(err, domain.ErrAlreadyExists) {
+		return fmt.Errorf("failed to write chunk: %w", err)
 	}
-	defer file.Close()
 
-	if _, err := file.Write(data); err != nil {
-		return fmt.Errorf("failed to write chunk: %w", err)
+	return nil
+}
+
+// writeFileAtomic writes the content of r to a remote file through a
+// uniquely named temporary file moved into place, so that an interrupted
+// write never leaves a truncated file behind and concurrent writes of the
+// same file don't truncate each other. size is checked against the bytes
+// written, unless negative. Without the posix-rename@openssh.com extension
+// an existing file can't be replaced, and domain.ErrAlreadyExists is returned.
+func (b *Backend) writeFileAtomic(path string, r io.Reader, size int64) error {
+	tmpPath, err := b.writeTemp(path, r, size)
+	if err != nil {
+		return err
 	}
 
+	if _, ok := b.sftpClient.HasExtension("posix-rename@openssh.com"); ok {
+		err = b.sftpClient.PosixRename(tmpPath, path)
+	} else if err = b.sftpClient.Rename(tmpPath, path); err != nil {
+		// Plain SFTP renames refuse to overwrite their target
+		if _, statErr := b.sftpClient.Stat(path); statErr == nil {
+			err = fmt.Errorf("%w: %s, and the server can't replace it without the posix-rename@openssh.com extension", domain.ErrAlreadyExists, path)
+		}
+	}
+	if err != nil {
+		b.sftpClient.Remove(tmpPath)
+		return err
+	}
 	return nil
 }
 
+// writeTemp writes the content of r to a new temporary file next to path,
+// with a random name, and returns the name of the temporary file
+func (b *Backend) writeTemp(path string, r io.Reader, size int64) (string, error) {
+	tmpPath := path + ".tmp-" + rand.Text()
+	file, err := b.sftpClient.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return "", err
+	}
+
+	// The sftp file reads r with concurrent writes
+	written, err := io.Copy(file, r)
+	if err == nil && size >= 0 && written != size {
+		err = fmt.Errorf("short write: %d of %d bytes", written, size)
+	}
+	if err != nil {
+		file.Close()
+		b.sftpClient.Remove(tmpPath)
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		b.sftpClient.Remove(tmpPath)
+		return "", err
+	}
+	return tmpPath, nil
+}
+
 // LoadChunk loads a chunk via SFTP
 func (b *Backend) LoadChunk(ctx context.Context, hash string) ([]byte, error) {
 	if len(hash) < 4 {
@@ -255,6 +312,205 @@ func (b *Backend) DeleteManifest(ctx context.Context, snapshotID string) error {
 	return nil
 }
 
+// StoreKeyFile stores the repository key file via SFTP unless it already
+// exists. It is written to a temporary file first and then linked into
+// place, which fails if the key file exists, so that it is never seen half
+// written.
+func (b *Backend) StoreKeyFile(ctx context.Context, data []byte) error {
+	keyPath := filepath.Join(b.basePath, "key")
+
+	tmpPath, err := b.writeTemp(keyPath, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	defer b.sftpClient.Remove(tmpPath)
+
+	// Plain SFTP renames refuse to overwrite their target too
+	if _, ok := b.sftpClient.HasExtension("hardlink@openssh.com"); ok {
+		err = b.sftpClient.Link(tmpPath, keyPath)
+	} else {
+		err = b.sftpClient.Rename(tmpPath, keyPath)
+	}
+	if err != nil {
+		// SFTP v3 servers report an existing file as a generic failure
+		if _, statErr := b.sftpClient.Stat(keyPath); statErr == nil {
+			return domain.ErrAlreadyExists
+		}
+		return fmt.Errorf("failed to move key file into place: %w", err)
+	}
+
+	return nil
+}
+
+// LoadKeyFile loads the repository key file via SFTP
+func (b *Backend) LoadKeyFile(ctx context.Context) ([]byte, error) {
+	keyPath := filepath.Join(b.basePath, "key")
+
+	file, err := b.sftpClient.Open(keyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to open key file: %w", err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	return data, nil
+}
+
+// ListChunks calls fn for each chunk, reading the two levels of directories
+// chunks are spread over
+func (b *Backend) ListChunks(ctx context.Context, fn func(domain.ObjectInfo) error) error {
+	root := filepath.Join(b.basePath, "chunks")
+	level1, err := b.sftpClient.ReadDir(root)
+	if err != nil {
+		return fmt.Errorf("failed to list chunks: %w", err)
+	}
+
+	for _, dir1 := range level1 {
+		if !dir1.IsDir() {
+			continue
+		}
+		level2, err := b.sftpClient.ReadDir(filepath.Join(root, dir1.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to list chunks: %w", err)
+		}
+
+		for _, dir2 := range level2 {
+			if !dir2.IsDir() {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			files, err := b.sftpClient.ReadDir(filepath.Join(root, dir1.Name(), dir2.Name()))
+			if err != nil {
+				return fmt.Errorf("failed to list chunks: %w", err)
+			}
+
+			for _, file := range files {
+				// Temporary files of interrupted uploads are not chunks
+				if file.IsDir() || strings.Contains(file.Name(), ".") {
+					continue
+				}
+				if err := fn(domain.ObjectInfo{Name: file.Name(), Size: file.Size()}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// ListManifests calls fn for each manifest of the manifests directory
+func (b *Backend) ListManifests(ctx context.Context, fn func(domain.ObjectInfo) error) error {
+	files, err := b.sftpClient.ReadDir(filepath.Join(b.basePath, "manifests"))
+	if err != nil {
+		return fmt.Errorf("failed to list manifests: %w", err)
+	}
+
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		snapshotID, ok := strings.CutSuffix(file.Name(), ".json")
+		if file.IsDir() || !ok {
+			continue
+		}
+		if err := fn(domain.ObjectInfo{Name: snapshotID, Size: file.Size()}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// objectPath returns the remote path of the file holding the object of a key
+func (b *Backend) objectPath(key string) (string, error) {
+	kind, name, err := domain.SplitObjectKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	if kind == domain.ObjectManifest {
+		return filepath.Join(b.basePath, "manifests", name+".json"), nil
+	}
+	if len(name) < 4 {
+		return "", fmt.Errorf("invalid hash: too short")
+	}
+	return filepath.Join(b.basePath, "chunks", name[:2], name[2:4], name), nil
+}
+
+// PutObject uploads an object through a temporary file moved into place
+func (b *Backend) PutObject(ctx context.Context, key string, r io.Reader, size int64) error {
+	objectPath, err := b.objectPath(key)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := b.sftpClient.MkdirAll(filepath.Dir(objectPath)); err != nil {
+		return fmt.Errorf("failed to create object directory: %w", err)
+	}
+
+	err = b.writeFileAtomic(objectPath, r, size)
+	if errors.Is(err, domain.ErrAlreadyExists) && strings.HasPrefix(key, domain.ObjectChunk+"/") {
+		return nil // Chunks are named by their content
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write object: %w", err)
+	}
+
+	return nil
+}
+
+// GetObject opens the remote file of an object
+func (b *Backend) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	return b.GetObjectRange(ctx, key, 0, -1)
+}
+
+// GetObjectRange opens the remote file of an object at offset
+func (b *Backend) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	objectPath, err := b.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset", domain.ErrInvalidInput)
+	}
+
+	file, err := b.sftpClient.Open(objectPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to open object: %w", err)
+	}
+
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to seek object: %w", err)
+		}
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
 // Close closes the SFTP and SSH connections
 func (b *Backend) Close() error {
 	if b.sftpClient != nil {
@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 )
 
@@ -60,7 +61,7 @@ func (db *DB) migrate(ctx context.Context) error {
 			user_id INTEGER,
 			source_id INTEGER NOT NULL,
 			target_id INTEGER NOT NULL,
-			status TEXT NOT NULL DEFAULT 'pending', -- pending, running, success, failed
+			status TEXT NOT NULL DEFAULT 'pending', -- pending, running, success, partial, failed, interrupted, cancelled
 			file_count INTEGER DEFAULT 0,
 			total_bytes INTEGER DEFAULT 0,
 			delta_bytes INTEGER DEFAULT 0,
@@ -92,7 +93,7 @@ func (db *DB) migrate(ctx context.Context) error {
 			type TEXT NOT NULL, -- backup, restore
 			source_id INTEGER,
 			snapshot_id INTEGER,
-			status TEXT NOT NULL DEFAULT 'pending', -- pending, running, success, failed
+			status TEXT NOT NULL DEFAULT 'pending', -- pending, running, success, failed, interrupted, cancelled
 			error TEXT,
 			started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			ended_at TIMESTAMP,
@@ -113,6 +114,27 @@ func (db *DB) migrate(ctx context.Context) error {
 			FOREIGN KEY (source_id) REFERENCES sources(id) ON DELETE CASCADE
 		)`,
 
+		// Warnings and errors of each job
+		`CREATE TABLE IF NOT EXISTS job_logs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			job_id INTEGER NOT NULL,
+			level TEXT NOT NULL, -- warn, error
+			path TEXT NOT NULL DEFAULT '',
+			message TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (job_id) REFERENCES jobs(id) ON DELETE CASCADE
+		)`,
+
+		// Chunks written to each target, by the bytes they take there
+		`CREATE TABLE IF NOT EXISTS chunks (
+			target_id INTEGER NOT NULL,
+			hash TEXT NOT NULL,
+			size INTEGER NOT NULL, -- bytes stored, after compression
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (target_id, hash),
+			FOREIGN KEY (target_id) REFERENCES targets(id) ON DELETE CASCADE
+		)`,
+
 		// Indexes for performance
 		`CREATE INDEX IF NOT EXISTS idx_sources_user_id ON sources(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_targets_user_id ON targets(user_id)`,
@@ -126,6 +148,7 @@ func (db *DB) migrate(ctx context.Context) error {
 		`CREATE INDEX IF NOT EXISTS idx_jobs_source_id ON jobs(source_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_schedules_source_id ON schedules(source_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_schedules_enabled ON schedules(enabled)`,
+		`CREATE INDEX IF NOT EXISTS idx_job_logs_job_id ON job_logs(job_id)`,
 	}
 
 	for i, migration := range migrations {
@@ -134,5 +157,74 @@ func (db *DB) migrate(ctx context.Context) error {
 		}
 	}
 
+	// Columns added after the initial schema. SQLite has no
+	// "ADD COLUMN IF NOT EXISTS", so each one is checked first.
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"snapshots", "parent_id", "INTEGER REFERENCES snapshots(id) ON DELETE SET NULL"},
+		{"snapshots", "stored_bytes", "INTEGER DEFAULT 0"},
+		{"sources", "compression", "TEXT NOT NULL DEFAULT ''"},
+		{"sources", "compression_level", "INTEGER NOT NULL DEFAULT 0"},
+		{"sources", "filters", "TEXT NOT NULL DEFAULT '{}'"},
+		{"snapshots", "filter_skips", "TEXT"},
+		{"snapshots", "error_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"sources", "retention", "TEXT"},
+		{"schedules", "timezone", "TEXT NOT NULL DEFAULT ''"},
+		{"schedules", "catch_up", "TEXT NOT NULL DEFAULT ''"},
+		{"schedules", "last_run_at", "TIMESTAMP"},
+		{"schedules", "jitter_seconds", "INTEGER NOT NULL DEFAULT 0"},
+		{"schedules", "type", "TEXT NOT NULL DEFAULT ''"},
+		{"schedules", "target_id", "INTEGER REFERENCES targets(id) ON DELETE CASCADE"},
+		{"schedules", "check_options", "TEXT"},
+		{"jobs", "params", "TEXT NOT NULL DEFAULT ''"},
+		{"jobs", "attempt", "INTEGER NOT NULL DEFAULT 1"},
+		{"jobs", "retry_of", "INTEGER REFERENCES jobs(id) ON DELETE SET NULL"},
+		{"jobs", "heartbeat_at", "TIMESTAMP"},
+		{"jobs", "progress", "TEXT"},
+		{"jobs", "target_id", "INTEGER REFERENCES targets(id) ON DELETE SET NULL"},
+		{"jobs", "result", "TEXT"},
+	}
+
+	for _, c := range columns {
+		if err := db.addColumnIfMissing(ctx, c.table, c.column, c.definition); err != nil {
+			return fmt.Errorf("migration of %s.%s failed: %w", c.table, c.column, err)
+		}
+	}
+
 	return nil
 }
+
+// addColumnIfMissing adds a column to an existing table unless it is already there
+func (db *DB) addColumnIfMissing(ctx context.Context, table, column, definition string) error {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
@@ -0,0 +1,87 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/axelfrache/savesync/internal/domain"
+)
+
+// ChunkRepo implements domain.ChunkRepository
+type ChunkRepo struct {
+	db *sql.DB
+}
+
+// NewChunkRepo creates a new chunk repository
+func NewChunkRepo(db *sql.DB) *ChunkRepo {
+	return &ChunkRepo{db: db}
+}
+
+// Record indexes chunks written to a target in a single transaction. A chunk
+// written again replaces its entry.
+func (r *ChunkRepo) Record(ctx context.Context, targetID int64, chunks []*domain.Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT OR REPLACE INTO chunks (target_id, hash, size) VALUES (?, ?, ?)`
+	for _, chunk := range chunks {
+		if _, err := tx.ExecContext(ctx, query, targetID, chunk.Hash, chunk.Size); err != nil {
+			return fmt.Errorf("failed to record chunk: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit chunks: %w", err)
+	}
+	return nil
+}
+
+// GetByTarget retrieves the chunks indexed for a target
+func (r *ChunkRepo) GetByTarget(ctx context.Context, targetID int64) ([]*domain.Chunk, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT hash, size FROM chunks WHERE target_id = ? ORDER BY hash`, targetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chunks: %w", err)
+	}
+	defer rows.Close()
+
+	var chunks []*domain.Chunk
+	for rows.Next() {
+		var chunk domain.Chunk
+		if err := rows.Scan(&chunk.Hash, &chunk.Size); err != nil {
+			return nil, fmt.Errorf("failed to scan chunk: %w", err)
+		}
+		chunks = append(chunks, &chunk)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return chunks, nil
+}
+
+// Delete removes a chunk from the index of a target
+func (r *ChunkRepo) Delete(ctx context.Context, targetID int64, hash string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM chunks WHERE target_id = ? AND hash = ?`, targetID, hash)
+	if err != nil {
+		return fmt.Errorf("failed to delete chunk: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return domain.ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/axelfrache/savesync/internal/domain"
+)
+
+// JobLogRepo implements domain.JobLogRepository
+type JobLogRepo struct {
+	db *sql.DB
+}
+
+// NewJobLogRepo creates a new job log repository
+func NewJobLogRepo(db *sql.DB) *JobLogRepo {
+	return &JobLogRepo{db: db}
+}
+
+// Append stores log entries in a single transaction
+func (r *JobLogRepo) Append(ctx context.Context, entries []*domain.JobLogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO job_logs (job_id, level, path, message, created_at) VALUES (?, ?, ?, ?, ?)`
+	for _, entry := range entries {
+		result, err := tx.ExecContext(ctx, query, entry.JobID, entry.Level, entry.Path, entry.Message, entry.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to append job log: %w", err)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to get last insert id: %w", err)
+		}
+		entry.ID = id
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit job logs: %w", err)
+	}
+	return nil
+}
+
+// GetByJobID retrieves a page of the log of a job in the order it was
+// written, along with the total number of entries
+func (r *JobLogRepo) GetByJobID(ctx context.Context, jobID int64, offset, limit int) ([]*domain.JobLogEntry, int, error) {
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM job_logs WHERE job_id = ?`, jobID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count job logs: %w", err)
+	}
+
+	query := `
+		SELECT id, job_id, level, path, message, created_at
+		FROM job_logs
+		WHERE job_id = ?
+		ORDER BY id
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, jobID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query job logs: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*domain.JobLogEntry
+	for rows.Next() {
+		var entry domain.JobLogEntry
+		if err := rows.Scan(&entry.ID, &entry.JobID, &entry.Level, &entry.Path, &entry.Message, &entry.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan job log: %w", err)
+		}
+		entries = append(entries, &entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("rows error: %w", err)
+	}
+
+	return entries, total, nil
+}
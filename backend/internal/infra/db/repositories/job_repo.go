@@ -3,7 +3,9 @@ package repositories
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/axelfrache/savesync/internal/domain"
 )
@@ -18,19 +20,25 @@ func NewJobRepo(db *sql.DB) *JobRepo {
 	return &JobRepo{db: db}
 }
 
+const jobColumns = `id, type, source_id, snapshot_id, target_id, status, error, params, attempt, retry_of, started_at, heartbeat_at, ended_at, progress, result`
+
 // Create creates a new job
 func (r *JobRepo) Create(ctx context.Context, job *domain.Job) error {
 	query := `
-		INSERT INTO jobs (type, source_id, snapshot_id, status, error, started_at, ended_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO jobs (type, source_id, snapshot_id, target_id, status, error, params, attempt, retry_of, started_at, ended_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	result, err := r.db.ExecContext(ctx, query,
 		job.Type,
 		job.SourceID,
 		job.SnapshotID,
+		job.TargetID,
 		job.Status,
 		job.Error,
+		job.Params,
+		job.Attempt,
+		job.RetryOf,
 		job.StartedAt,
 		job.EndedAt,
 	)
@@ -49,23 +57,9 @@ func (r *JobRepo) Create(ctx context.Context, job *domain.Job) error {
 
 // GetByID retrieves a job by ID
 func (r *JobRepo) GetByID(ctx context.Context, id int64) (*domain.Job, error) {
-	query := `
-		SELECT id, type, source_id, snapshot_id, status, error, started_at, ended_at
-		FROM jobs
-		WHERE id = ?
-	`
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = ?`
 
-	var job domain.Job
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&job.ID,
-		&job.Type,
-		&job.SourceID,
-		&job.SnapshotID,
-		&job.Status,
-		&job.Error,
-		&job.StartedAt,
-		&job.EndedAt,
-	)
+	job, err := scanJob(r.db.QueryRowContext(ctx, query, id))
 	if err == sql.ErrNoRows {
 		return nil, domain.ErrNotFound
 	}
@@ -73,17 +67,12 @@ func (r *JobRepo) GetByID(ctx context.Context, id int64) (*domain.Job, error) {
 		return nil, fmt.Errorf("failed to get job: %w", err)
 	}
 
-	return &job, nil
+	return job, nil
 }
 
 // GetAll retrieves all jobs
 func (r *JobRepo) GetAll(ctx context.Context) ([]*domain.Job, error) {
-	query := `
-		SELECT id, type, source_id, snapshot_id, status, error, started_at, ended_at
-		FROM jobs
-		ORDER BY started_at DESC
-		LIMIT 100
-	`
+	query := `SELECT ` + jobColumns + ` FROM jobs ORDER BY started_at DESC LIMIT 100`
 
 	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
@@ -91,30 +80,149 @@ func (r *JobRepo) GetAll(ctx context.Context) ([]*domain.Job, error) {
 	}
 	defer rows.Close()
 
-	var jobs []*domain.Job
-	for rows.Next() {
-		var job domain.Job
-		err := rows.Scan(
-			&job.ID,
-			&job.Type,
-			&job.SourceID,
-			&job.SnapshotID,
-			&job.Status,
-			&job.Error,
-			&job.StartedAt,
-			&job.EndedAt,
+	return scanJobs(rows)
+}
+
+// GetByStatus retrieves all jobs with a status, oldest first
+func (r *JobRepo) GetByStatus(ctx context.Context, status string) ([]*domain.Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE status = ? ORDER BY id`
+
+	rows, err := r.db.QueryContext(ctx, query, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query jobs: %w", err)
+	}
+	defer rows.Close()
+
+	return scanJobs(rows)
+}
+
+// GetActiveBackups retrieves the pending and running backup jobs of a
+// source, oldest first
+func (r *JobRepo) GetActiveBackups(ctx context.Context, sourceID int64) ([]*domain.Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE type = ? AND source_id = ? AND status IN (?, ?) ORDER BY id`
+
+	rows, err := r.db.QueryContext(ctx, query, domain.JobTypeBackup, sourceID, domain.JobPending, domain.JobRunning)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query jobs: %w", err)
+	}
+	defer rows.Close()
+
+	return scanJobs(rows)
+}
+
+// ClaimNext marks the oldest pending job as running and returns it. Backups
+// of a source which is already being backed up are left in the queue. The
+// selection and the update are a single statement, so a job is never claimed
+// twice.
+func (r *JobRepo) ClaimNext(ctx context.Context, at time.Time) (*domain.Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = ?, started_at = ?, heartbeat_at = ?
+		WHERE id = (
+			SELECT id FROM jobs AS pending
+			WHERE status = ?
+			AND NOT (type = ? AND EXISTS (
+				SELECT 1 FROM jobs AS running
+				WHERE running.status = ? AND running.type = ? AND running.source_id = pending.source_id
+			))
+			ORDER BY id LIMIT 1
 		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan job: %w", err)
-		}
-		jobs = append(jobs, &job)
+		RETURNING ` + jobColumns
+
+	job, err := scanJob(r.db.QueryRowContext(ctx, query,
+		domain.JobRunning, at, at,
+		domain.JobPending,
+		domain.JobTypeBackup,
+		domain.JobRunning, domain.JobTypeBackup,
+	))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows error: %w", err)
+	return job, nil
+}
+
+// Heartbeat records that a running job is still alive
+func (r *JobRepo) Heartbeat(ctx context.Context, id int64, at time.Time) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE jobs SET heartbeat_at = ? WHERE id = ?`, at, id)
+	if err != nil {
+		return fmt.Errorf("failed to record job heartbeat: %w", err)
 	}
 
-	return jobs, nil
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return domain.ErrNotFound
+	}
+
+	return nil
+}
+
+// UpdateProgress records the progress of a running job
+func (r *JobRepo) UpdateProgress(ctx context.Context, id int64, progress *domain.JobProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job progress: %w", err)
+	}
+
+	result, err := r.db.ExecContext(ctx, `UPDATE jobs SET progress = ? WHERE id = ?`, string(data), id)
+	if err != nil {
+		return fmt.Errorf("failed to update job progress: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return domain.ErrNotFound
+	}
+
+	return nil
+}
+
+// UpdateResult records the report of a finished job
+func (r *JobRepo) UpdateResult(ctx context.Context, id int64, result json.RawMessage) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE jobs SET result = ? WHERE id = ?`, string(result), id)
+	if err != nil {
+		return fmt.Errorf("failed to update job result: %w", err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return domain.ErrNotFound
+	}
+
+	return nil
+}
+
+// CancelPending cancels a job unless a worker already claimed it. It returns
+// domain.ErrNotFound when no pending job has this ID.
+func (r *JobRepo) CancelPending(ctx context.Context, id int64, at time.Time) error {
+	query := `UPDATE jobs SET status = ?, ended_at = ? WHERE id = ? AND status = ?`
+
+	result, err := r.db.ExecContext(ctx, query, domain.JobCancelled, at, id, domain.JobPending)
+	if err != nil {
+		return fmt.Errorf("failed to cancel job: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return domain.ErrNotFound
+	}
+
+	return nil
 }
 
 // Update updates a job
@@ -147,6 +255,11 @@ func (r *JobRepo) Update(ctx context.Context, job *domain.Job) error {
 }
 
 func (r *JobRepo) Delete(ctx context.Context, id int64) error {
+	// Foreign keys are not enforced, so the log is removed explicitly
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM job_logs WHERE job_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete job logs: %w", err)
+	}
+
 	query := `DELETE FROM jobs WHERE id = ?`
 
 	result, err := r.db.ExecContext(ctx, query, id)
@@ -164,3 +277,56 @@ func (r *JobRepo) Delete(ctx context.Context, id int64) error {
 
 	return nil
 }
+
+// scanJobs reads all the jobs selected with jobColumns
+func scanJobs(rows *sql.Rows) ([]*domain.Job, error) {
+	var jobs []*domain.Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// scanJob reads a job selected with jobColumns
+func scanJob(row interface{ Scan(dest ...any) error }) (*domain.Job, error) {
+	var job domain.Job
+	var progress, result sql.NullString
+	err := row.Scan(
+		&job.ID,
+		&job.Type,
+		&job.SourceID,
+		&job.SnapshotID,
+		&job.TargetID,
+		&job.Status,
+		&job.Error,
+		&job.Params,
+		&job.Attempt,
+		&job.RetryOf,
+		&job.StartedAt,
+		&job.HeartbeatAt,
+		&job.EndedAt,
+		&progress,
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if progress.Valid && progress.String != "" {
+		if err := json.Unmarshal([]byte(progress.String), &job.Progress); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal job progress: %w", err)
+		}
+	}
+	if result.Valid && result.String != "" {
+		job.Result = json.RawMessage(result.String)
+	}
+	return &job, nil
+}
@@ -0,0 +1,263 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/domain"
+)
+
+// ScheduleRepo implements domain.ScheduleRepository
+type ScheduleRepo struct {
+	db *sql.DB
+}
+
+// NewScheduleRepo creates a new schedule repository
+func NewScheduleRepo(db *sql.DB) *ScheduleRepo {
+	return &ScheduleRepo{db: db}
+}
+
+const scheduleColumns = `id, type, source_id, target_id, check_options, frequency, cron_expr, timezone, catch_up, jitter_seconds, enabled, last_run_at, created_at, updated_at`
+
+// Create creates a new schedule
+func (r *ScheduleRepo) Create(ctx context.Context, schedule *domain.Schedule) error {
+	checkOptions, err := marshalCheckOptions(schedule.Check)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO schedules (type, source_id, target_id, check_options, frequency, cron_expr, timezone, catch_up, jitter_seconds, enabled, last_run_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	now := time.Now()
+	result, err := r.db.ExecContext(ctx, query,
+		schedule.Type,
+		schedule.SourceID,
+		schedule.TargetID,
+		checkOptions,
+		schedule.Frequency,
+		schedule.CronExpr,
+		schedule.Timezone,
+		schedule.CatchUp,
+		schedule.Jitter,
+		schedule.Enabled,
+		schedule.LastRunAt,
+		now,
+		now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create schedule: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	schedule.ID = id
+	schedule.CreatedAt = now
+	schedule.UpdatedAt = now
+
+	return nil
+}
+
+// GetByID retrieves a schedule by ID
+func (r *ScheduleRepo) GetByID(ctx context.Context, id int64) (*domain.Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM schedules WHERE id = ?`
+
+	schedule, err := scanSchedule(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schedule: %w", err)
+	}
+
+	return schedule, nil
+}
+
+// GetBySourceID retrieves the schedule of a source
+func (r *ScheduleRepo) GetBySourceID(ctx context.Context, sourceID int64) (*domain.Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM schedules WHERE source_id = ? AND type != 'check' ORDER BY id LIMIT 1`
+
+	schedule, err := scanSchedule(r.db.QueryRowContext(ctx, query, sourceID))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schedule: %w", err)
+	}
+
+	return schedule, nil
+}
+
+// GetAll retrieves all schedules
+func (r *ScheduleRepo) GetAll(ctx context.Context) ([]*domain.Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM schedules ORDER BY id`
+	return r.query(ctx, query)
+}
+
+// GetEnabled retrieves the schedules that should fire
+func (r *ScheduleRepo) GetEnabled(ctx context.Context) ([]*domain.Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM schedules WHERE enabled = 1 ORDER BY id`
+	return r.query(ctx, query)
+}
+
+// Update updates a schedule
+func (r *ScheduleRepo) Update(ctx context.Context, schedule *domain.Schedule) error {
+	checkOptions, err := marshalCheckOptions(schedule.Check)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE schedules
+		SET type = ?, source_id = ?, target_id = ?, check_options = ?, frequency = ?, cron_expr = ?, timezone = ?, catch_up = ?, jitter_seconds = ?, enabled = ?, last_run_at = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	now := time.Now()
+	result, err := r.db.ExecContext(ctx, query,
+		schedule.Type,
+		schedule.SourceID,
+		schedule.TargetID,
+		checkOptions,
+		schedule.Frequency,
+		schedule.CronExpr,
+		schedule.Timezone,
+		schedule.CatchUp,
+		schedule.Jitter,
+		schedule.Enabled,
+		schedule.LastRunAt,
+		now,
+		schedule.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update schedule: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return domain.ErrNotFound
+	}
+
+	schedule.UpdatedAt = now
+	return nil
+}
+
+// SetLastRun records when a schedule last fired, leaving its settings alone
+func (r *ScheduleRepo) SetLastRun(ctx context.Context, id int64, at time.Time) error {
+	query := `UPDATE schedules SET last_run_at = ? WHERE id = ?`
+
+	result, err := r.db.ExecContext(ctx, query, at, id)
+	if err != nil {
+		return fmt.Errorf("failed to update schedule: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return domain.ErrNotFound
+	}
+
+	return nil
+}
+
+// Delete deletes a schedule
+func (r *ScheduleRepo) Delete(ctx context.Context, id int64) error {
+	query := `DELETE FROM schedules WHERE id = ?`
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete schedule: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return domain.ErrNotFound
+	}
+
+	return nil
+}
+
+// query runs a query returning schedules
+func (r *ScheduleRepo) query(ctx context.Context, query string, args ...any) ([]*domain.Schedule, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schedules: %w", err)
+	}
+	defer rows.Close()
+
+	var schedules []*domain.Schedule
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan schedule: %w", err)
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return schedules, nil
+}
+
+// scanSchedule reads a schedule selected with scheduleColumns
+func scanSchedule(row interface{ Scan(dest ...any) error }) (*domain.Schedule, error) {
+	var schedule domain.Schedule
+	var checkOptions sql.NullString
+	err := row.Scan(
+		&schedule.ID,
+		&schedule.Type,
+		&schedule.SourceID,
+		&schedule.TargetID,
+		&checkOptions,
+		&schedule.Frequency,
+		&schedule.CronExpr,
+		&schedule.Timezone,
+		&schedule.CatchUp,
+		&schedule.Jitter,
+		&schedule.Enabled,
+		&schedule.LastRunAt,
+		&schedule.CreatedAt,
+		&schedule.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if checkOptions.Valid && checkOptions.String != "" {
+		if err := json.Unmarshal([]byte(checkOptions.String), &schedule.Check); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal check options: %w", err)
+		}
+	}
+	return &schedule, nil
+}
+
+// marshalCheckOptions encodes the options of a check schedule, NULL for
+// other schedules
+func marshalCheckOptions(opts *domain.CheckOptions) (*string, error) {
+	if opts == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal check options: %w", err)
+	}
+	value := string(data)
+	return &value, nil
+}
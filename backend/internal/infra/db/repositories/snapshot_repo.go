@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 
 	"github.com/axelfrache/savesync/internal/domain"
@@ -21,10 +22,15 @@ func NewSnapshotRepo(db *sql.DB) *SnapshotRepo {
 // Create creates a new snapshot
 func (r *SnapshotRepo) Create(ctx context.Context, snapshot *domain.Snapshot) error {
 	query := `
-		INSERT INTO snapshots (source_id, target_id, status, file_count, total_bytes, delta_bytes, error, created_at, completed_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO snapshots (source_id, target_id, status, file_count, total_bytes, delta_bytes, stored_bytes, parent_id, filter_skips, error_count, error, created_at, completed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
+	filterSkips, err := marshalFilterSkips(snapshot.FilterSkips)
+	if err != nil {
+		return err
+	}
+
 	result, err := r.db.ExecContext(ctx, query,
 		snapshot.SourceID,
 		snapshot.TargetID,
@@ -32,6 +38,10 @@ func (r *SnapshotRepo) Create(ctx context.Context, snapshot *domain.Snapshot) er
 		snapshot.FileCount,
 		snapshot.TotalBytes,
 		snapshot.DeltaBytes,
+		snapshot.StoredBytes,
+		snapshot.ParentID,
+		filterSkips,
+		snapshot.ErrorCount,
 		snapshot.Error,
 		snapshot.CreatedAt,
 		snapshot.CompletedAt,
@@ -52,12 +62,13 @@ func (r *SnapshotRepo) Create(ctx context.Context, snapshot *domain.Snapshot) er
 // GetByID retrieves a snapshot by ID
 func (r *SnapshotRepo) GetByID(ctx context.Context, id int64) (*domain.Snapshot, error) {
 	query := `
-		SELECT id, source_id, target_id, status, file_count, total_bytes, delta_bytes, error, created_at, completed_at
+		SELECT id, source_id, target_id, status, file_count, total_bytes, delta_bytes, stored_bytes, parent_id, filter_skips, error_count, error, created_at, completed_at
 		FROM snapshots
 		WHERE id = ?
 	`
 
 	var snapshot domain.Snapshot
+	var filterSkips sql.NullString
 	err := r.db.QueryRowContext(ctx, query, id).Scan(
 		&snapshot.ID,
 		&snapshot.SourceID,
@@ -66,6 +77,10 @@ func (r *SnapshotRepo) GetByID(ctx context.Context, id int64) (*domain.Snapshot,
 		&snapshot.FileCount,
 		&snapshot.TotalBytes,
 		&snapshot.DeltaBytes,
+		&snapshot.StoredBytes,
+		&snapshot.ParentID,
+		&filterSkips,
+		&snapshot.ErrorCount,
 		&snapshot.Error,
 		&snapshot.CreatedAt,
 		&snapshot.CompletedAt,
@@ -77,13 +92,17 @@ func (r *SnapshotRepo) GetByID(ctx context.Context, id int64) (*domain.Snapshot,
 		return nil, fmt.Errorf("failed to get snapshot: %w", err)
 	}
 
+	if err := unmarshalFilterSkips(filterSkips, &snapshot); err != nil {
+		return nil, err
+	}
+
 	return &snapshot, nil
 }
 
 // GetBySourceID retrieves all snapshots for a source
 func (r *SnapshotRepo) GetBySourceID(ctx context.Context, sourceID int64) ([]*domain.Snapshot, error) {
 	query := `
-		SELECT id, source_id, target_id, status, file_count, total_bytes, delta_bytes, error, created_at, completed_at
+		SELECT id, source_id, target_id, status, file_count, total_bytes, delta_bytes, stored_bytes, parent_id, filter_skips, error_count, error, created_at, completed_at
 		FROM snapshots
 		WHERE source_id = ?
 		ORDER BY created_at DESC
@@ -98,10 +117,28 @@ func (r *SnapshotRepo) GetBySourceID(ctx context.Context, sourceID int64) ([]*do
 	return r.scanSnapshots(rows)
 }
 
+// GetByStatus retrieves all snapshots with a status, oldest first
+func (r *SnapshotRepo) GetByStatus(ctx context.Context, status string) ([]*domain.Snapshot, error) {
+	query := `
+		SELECT id, source_id, target_id, status, file_count, total_bytes, delta_bytes, stored_bytes, parent_id, filter_skips, error_count, error, created_at, completed_at
+		FROM snapshots
+		WHERE status = ?
+		ORDER BY id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	return r.scanSnapshots(rows)
+}
+
 // GetAll retrieves all snapshots
 func (r *SnapshotRepo) GetAll(ctx context.Context) ([]*domain.Snapshot, error) {
 	query := `
-		SELECT id, source_id, target_id, status, file_count, total_bytes, delta_bytes, error, created_at, completed_at
+		SELECT id, source_id, target_id, status, file_count, total_bytes, delta_bytes, stored_bytes, parent_id, filter_skips, error_count, error, created_at, completed_at
 		FROM snapshots
 		ORDER BY created_at DESC
 	`
@@ -119,15 +156,23 @@ func (r *SnapshotRepo) GetAll(ctx context.Context) ([]*domain.Snapshot, error) {
 func (r *SnapshotRepo) Update(ctx context.Context, snapshot *domain.Snapshot) error {
 	query := `
 		UPDATE snapshots
-		SET status = ?, file_count = ?, total_bytes = ?, delta_bytes = ?, error = ?, completed_at = ?
+		SET status = ?, file_count = ?, total_bytes = ?, delta_bytes = ?, stored_bytes = ?, filter_skips = ?, error_count = ?, error = ?, completed_at = ?
 		WHERE id = ?
 	`
 
+	filterSkips, err := marshalFilterSkips(snapshot.FilterSkips)
+	if err != nil {
+		return err
+	}
+
 	result, err := r.db.ExecContext(ctx, query,
 		snapshot.Status,
 		snapshot.FileCount,
 		snapshot.TotalBytes,
 		snapshot.DeltaBytes,
+		snapshot.StoredBytes,
+		filterSkips,
+		snapshot.ErrorCount,
 		snapshot.Error,
 		snapshot.CompletedAt,
 		snapshot.ID,
@@ -172,6 +217,7 @@ func (r *SnapshotRepo) scanSnapshots(rows *sql.Rows) ([]*domain.Snapshot, error)
 	var snapshots []*domain.Snapshot
 	for rows.Next() {
 		var snapshot domain.Snapshot
+		var filterSkips sql.NullString
 		err := rows.Scan(
 			&snapshot.ID,
 			&snapshot.SourceID,
@@ -180,6 +226,10 @@ func (r *SnapshotRepo) scanSnapshots(rows *sql.Rows) ([]*domain.Snapshot, error)
 			&snapshot.FileCount,
 			&snapshot.TotalBytes,
 			&snapshot.DeltaBytes,
+			&snapshot.StoredBytes,
+			&snapshot.ParentID,
+			&filterSkips,
+			&snapshot.ErrorCount,
 			&snapshot.Error,
 			&snapshot.CreatedAt,
 			&snapshot.CompletedAt,
@@ -187,6 +237,9 @@ func (r *SnapshotRepo) scanSnapshots(rows *sql.Rows) ([]*domain.Snapshot, error)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan snapshot: %w", err)
 		}
+		if err := unmarshalFilterSkips(filterSkips, &snapshot); err != nil {
+			return nil, err
+		}
 		snapshots = append(snapshots, &snapshot)
 	}
 
@@ -196,3 +249,27 @@ func (r *SnapshotRepo) scanSnapshots(rows *sql.Rows) ([]*domain.Snapshot, error)
 
 	return snapshots, nil
 }
+
+// marshalFilterSkips encodes the filter skip counts of a snapshot, NULL when there are none
+func marshalFilterSkips(skips map[string]int) (*string, error) {
+	if len(skips) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(skips)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal filter skips: %w", err)
+	}
+	value := string(data)
+	return &value, nil
+}
+
+// unmarshalFilterSkips decodes the filter skip counts of a snapshot
+func unmarshalFilterSkips(value sql.NullString, snapshot *domain.Snapshot) error {
+	if !value.Valid || value.String == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(value.String), &snapshot.FilterSkips); err != nil {
+		return fmt.Errorf("failed to unmarshal filter skips: %w", err)
+	}
+	return nil
+}
@@ -27,9 +27,19 @@ func (r *SourceRepo) Create(ctx context.Context, source *domain.Source) error {
 		return fmt.Errorf("failed to marshal exclusions: %w", err)
 	}
 
+	filtersJSON, err := json.Marshal(source.Filters)
+	if err != nil {
+		return fmt.Errorf("failed to marshal filters: %w", err)
+	}
+
+	retention, err := marshalRetention(source.Retention)
+	if err != nil {
+		return err
+	}
+
 	query := `
-		INSERT INTO sources (name, path, exclusions, target_id, schedule_id, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO sources (name, path, exclusions, filters, target_id, schedule_id, compression, compression_level, retention, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	now := time.Now()
@@ -37,8 +47,12 @@ func (r *SourceRepo) Create(ctx context.Context, source *domain.Source) error {
 		source.Name,
 		source.Path,
 		string(exclusionsJSON),
+		string(filtersJSON),
 		source.TargetID,
 		source.ScheduleID,
+		source.Compression,
+		source.CompressionLevel,
+		retention,
 		now,
 		now,
 	)
@@ -61,21 +75,26 @@ func (r *SourceRepo) Create(ctx context.Context, source *domain.Source) error {
 // GetByID retrieves a source by ID
 func (r *SourceRepo) GetByID(ctx context.Context, id int64) (*domain.Source, error) {
 	query := `
-		SELECT id, name, path, exclusions, target_id, schedule_id, created_at, updated_at
+		SELECT id, name, path, exclusions, filters, target_id, schedule_id, compression, compression_level, retention, created_at, updated_at
 		FROM sources
 		WHERE id = ?
 	`
 
 	var source domain.Source
-	var exclusionsJSON string
+	var exclusionsJSON, filtersJSON string
+	var retention sql.NullString
 
 	err := r.db.QueryRowContext(ctx, query, id).Scan(
 		&source.ID,
 		&source.Name,
 		&source.Path,
 		&exclusionsJSON,
+		&filtersJSON,
 		&source.TargetID,
 		&source.ScheduleID,
+		&source.Compression,
+		&source.CompressionLevel,
+		&retention,
 		&source.CreatedAt,
 		&source.UpdatedAt,
 	)
@@ -90,13 +109,21 @@ func (r *SourceRepo) GetByID(ctx context.Context, id int64) (*domain.Source, err
 		return nil, fmt.Errorf("failed to unmarshal exclusions: %w", err)
 	}
 
+	if err := json.Unmarshal([]byte(filtersJSON), &source.Filters); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal filters: %w", err)
+	}
+
+	if err := unmarshalRetention(retention, &source); err != nil {
+		return nil, err
+	}
+
 	return &source, nil
 }
 
 // GetAll retrieves all sources
 func (r *SourceRepo) GetAll(ctx context.Context) ([]*domain.Source, error) {
 	query := `
-		SELECT id, name, path, exclusions, target_id, schedule_id, created_at, updated_at
+		SELECT id, name, path, exclusions, filters, target_id, schedule_id, compression, compression_level, retention, created_at, updated_at
 		FROM sources
 		ORDER BY created_at DESC
 	`
@@ -110,15 +137,20 @@ func (r *SourceRepo) GetAll(ctx context.Context) ([]*domain.Source, error) {
 	var sources []*domain.Source
 	for rows.Next() {
 		var source domain.Source
-		var exclusionsJSON string
+		var exclusionsJSON, filtersJSON string
+		var retention sql.NullString
 
 		err := rows.Scan(
 			&source.ID,
 			&source.Name,
 			&source.Path,
 			&exclusionsJSON,
+			&filtersJSON,
 			&source.TargetID,
 			&source.ScheduleID,
+			&source.Compression,
+			&source.CompressionLevel,
+			&retention,
 			&source.CreatedAt,
 			&source.UpdatedAt,
 		)
@@ -130,6 +162,14 @@ func (r *SourceRepo) GetAll(ctx context.Context) ([]*domain.Source, error) {
 			return nil, fmt.Errorf("failed to unmarshal exclusions: %w", err)
 		}
 
+		if err := json.Unmarshal([]byte(filtersJSON), &source.Filters); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal filters: %w", err)
+		}
+
+		if err := unmarshalRetention(retention, &source); err != nil {
+			return nil, err
+		}
+
 		sources = append(sources, &source)
 	}
 
@@ -147,9 +187,19 @@ func (r *SourceRepo) Update(ctx context.Context, source *domain.Source) error {
 		return fmt.Errorf("failed to marshal exclusions: %w", err)
 	}
 
+	filtersJSON, err := json.Marshal(source.Filters)
+	if err != nil {
+		return fmt.Errorf("failed to marshal filters: %w", err)
+	}
+
+	retention, err := marshalRetention(source.Retention)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE sources
-		SET name = ?, path = ?, exclusions = ?, target_id = ?, schedule_id = ?, updated_at = ?
+		SET name = ?, path = ?, exclusions = ?, filters = ?, target_id = ?, schedule_id = ?, compression = ?, compression_level = ?, retention = ?, updated_at = ?
 		WHERE id = ?
 	`
 
@@ -158,8 +208,12 @@ func (r *SourceRepo) Update(ctx context.Context, source *domain.Source) error {
 		source.Name,
 		source.Path,
 		string(exclusionsJSON),
+		string(filtersJSON),
 		source.TargetID,
 		source.ScheduleID,
+		source.Compression,
+		source.CompressionLevel,
+		retention,
 		now,
 		source.ID,
 	)
@@ -198,3 +252,27 @@ func (r *SourceRepo) Delete(ctx context.Context, id int64) error {
 
 	return nil
 }
+
+// marshalRetention encodes the retention policy of a source, NULL when it has none
+func marshalRetention(policy *domain.RetentionPolicy) (*string, error) {
+	if policy == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal retention policy: %w", err)
+	}
+	value := string(data)
+	return &value, nil
+}
+
+// unmarshalRetention decodes the retention policy of a source
+func unmarshalRetention(value sql.NullString, source *domain.Source) error {
+	if !value.Valid || value.String == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(value.String), &source.Retention); err != nil {
+		return fmt.Errorf("failed to unmarshal retention policy: %w", err)
+	}
+	return nil
+}
@@ -160,6 +160,11 @@ func (r *TargetRepo) Update(ctx context.Context, target *domain.Target) error {
 
 // Delete deletes a target
 func (r *TargetRepo) Delete(ctx context.Context, id int64) error {
+	// Foreign keys are not enforced, so the chunk index is removed explicitly
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM chunks WHERE target_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete target chunks: %w", err)
+	}
+
 	query := `DELETE FROM targets WHERE id = ?`
 
 	result, err := r.db.ExecContext(ctx, query, id)
@@ -1,15 +1,16 @@
 package handlers
 
 import (
-	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 
 	"github.com/axelfrache/savesync/internal/app/backupservice"
-	"github.com/axelfrache/savesync/internal/app/jobservice"
-	"github.com/axelfrache/savesync/internal/app/sourceservice"
-	"github.com/axelfrache/savesync/internal/app/targetservice"
+	"github.com/axelfrache/savesync/internal/app/jobrunner"
+	"github.com/axelfrache/savesync/internal/domain"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
@@ -17,25 +18,19 @@ import (
 // BackupHandler handles backup-related requests
 type BackupHandler struct {
 	backupService *backupservice.Service
-	targetService *targetservice.Service
-	jobService    *jobservice.Service
-	sourceService *sourceservice.Service
+	runner        *jobrunner.Runner
 	logger        *zap.Logger
 }
 
 // NewBackupHandler creates a new backup handler
 func NewBackupHandler(
 	backupService *backupservice.Service,
-	targetService *targetservice.Service,
-	jobService *jobservice.Service,
-	sourceService *sourceservice.Service,
+	runner *jobrunner.Runner,
 	logger *zap.Logger,
 ) *BackupHandler {
 	return &BackupHandler{
 		backupService: backupService,
-		targetService: targetService,
-		jobService:    jobService,
-		sourceService: sourceService,
+		runner:        runner,
 		logger:        logger,
 	}
 }
@@ -49,61 +44,226 @@ func (h *BackupHandler) Run(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create job
-	job, err := h.jobService.CreateBackupJob(r.Context(), sourceID)
+	// Create the job, which runs asynchronously
+	job, err := h.runner.EnqueueBackup(r.Context(), sourceID)
 	if err != nil {
+		var conflict *jobrunner.ConflictError
+		if errors.As(err, &conflict) {
+			writeJobConflict(w, conflict)
+			return
+		}
 		h.logger.Error("failed to create backup job", zap.Error(err), zap.Int64("source_id", sourceID))
 		WriteError(w, http.StatusInternalServerError, "Failed to create backup job")
 		return
 	}
 
-	// Run backup asynchronously
-	go func() {
-		// Create a background context since the request context will be cancelled
-		ctx := context.Background()
+	WriteJSON(w, http.StatusAccepted, map[string]interface{}{
+		"job_id": job.ID,
+		"status": job.Status,
+	})
+}
+
+// TestExclusion godoc
+// @Summary Tester les exclusions d'une source
+// @Description Indique si un chemin relatif à la source serait exclu des sauvegardes, et par quelle règle (exclusions de la source ou fichier .savesyncignore)
+// @Tags sources
+// @Accept json
+// @Produce json
+// @Param id path int true "Source ID"
+// @Param request body handlers.ExclusionTestRequest true "Chemin à tester"
+// @Success 200 {object} handlers.ExclusionTestResponse
+// @Failure 400 {object} handlers.ErrorInfo
+// @Failure 404 {object} handlers.ErrorInfo
+// @Failure 500 {object} handlers.ErrorInfo
+// @Router /sources/{id}/exclusions/test [post]
+func (h *BackupHandler) TestExclusion(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	sourceID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid source ID")
+		return
+	}
 
-		// Update job status to running
-		h.jobService.UpdateStatus(ctx, job.ID, "running", nil)
+	var req ExclusionTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
 
-		// Get source to find target
-		source, err := h.sourceService.GetByID(ctx, sourceID)
-		if err != nil {
-			h.logger.Error("failed to get source for backup", zap.Error(err), zap.Int64("source_id", sourceID))
-			h.jobService.UpdateStatus(ctx, job.ID, "failed", fmt.Errorf("failed to get source: %w", err))
+	match, err := h.backupService.TestExclusion(r.Context(), sourceID, req.Path, req.IsDir)
+	if err != nil {
+		if err == domain.ErrNotFound {
+			WriteError(w, http.StatusNotFound, "Source not found")
 			return
 		}
+		if err == domain.ErrInvalidInput {
+			WriteError(w, http.StatusBadRequest, "Path must be relative to the source")
+			return
+		}
+		h.logger.Error("failed to test exclusion", zap.Error(err), zap.Int64("source_id", sourceID))
+		WriteError(w, http.StatusInternalServerError, "Failed to test exclusion")
+		return
+	}
 
-		if source.TargetID == nil {
-			h.logger.Error("source has no target", zap.Int64("source_id", sourceID))
-			h.jobService.UpdateStatus(ctx, job.ID, "failed", fmt.Errorf("source has no target configured"))
+	resp := ExclusionTestResponse{Path: match.Path, Excluded: match.Excluded}
+	if match.Pattern != "" {
+		resp.Rule = &ExclusionRuleResponse{Pattern: match.Pattern, Origin: match.Origin, Line: match.Line}
+	}
+	WriteJSON(w, http.StatusOK, resp)
+}
+
+// Prune godoc
+// @Summary Nettoyer un dépôt
+// @Description Lance une tâche qui supprime les chunks d'une cible qu'aucun snapshot ne référence plus. Le résultat de la tâche indique l'espace récupéré.
+// @Tags targets
+// @Accept json
+// @Produce json
+// @Param id path int true "Target ID"
+// @Param request body handlers.PruneRequest false "Options du nettoyage"
+// @Success 202 {object} handlers.PruneResponse
+// @Failure 400 {object} handlers.ErrorInfo
+// @Failure 404 {object} handlers.ErrorInfo
+// @Failure 500 {object} handlers.ErrorInfo
+// @Router /targets/{id}/prune [post]
+func (h *BackupHandler) Prune(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	targetID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid target ID")
+		return
+	}
+
+	// The body is optional: without it the prune deletes chunks
+	var req PruneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	job, err := h.runner.EnqueuePrune(r.Context(), targetID, req.DryRun)
+	if err != nil {
+		if err == domain.ErrNotFound {
+			WriteError(w, http.StatusNotFound, "Target not found")
 			return
 		}
+		h.logger.Error("failed to create prune job", zap.Error(err), zap.Int64("target_id", targetID))
+		WriteError(w, http.StatusInternalServerError, "Failed to create prune job")
+		return
+	}
 
-		// Initialize backend
-		backend, err := h.targetService.GetBackend(ctx, *source.TargetID)
-		if err != nil {
-			h.logger.Error("failed to initialize backend", zap.Error(err), zap.Int64("target_id", *source.TargetID))
-			h.jobService.UpdateStatus(ctx, job.ID, "failed", fmt.Errorf("failed to initialize backend: %w", err))
+	WriteJSON(w, http.StatusAccepted, PruneResponse{
+		JobID:  job.ID,
+		Status: job.Status,
+	})
+}
+
+// Check godoc
+// @Summary Vérifier un dépôt
+// @Description Lance une tâche qui vérifie que chaque chunk référencé par les snapshots d'une cible existe. Avec read_data, les chunks (ou read_data_percent % d'entre eux, tirés au hasard) sont téléchargés et leur empreinte SHA-256 recalculée. Le résultat de la tâche liste les chunks manquants ou corrompus et les snapshots et fichiers touchés.
+// @Tags targets
+// @Accept json
+// @Produce json
+// @Param id path int true "Target ID"
+// @Param request body handlers.CheckRequest false "Options de la vérification"
+// @Success 202 {object} handlers.CheckResponse
+// @Failure 400 {object} handlers.ErrorInfo
+// @Failure 404 {object} handlers.ErrorInfo
+// @Failure 500 {object} handlers.ErrorInfo
+// @Router /targets/{id}/check [post]
+func (h *BackupHandler) Check(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	targetID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid target ID")
+		return
+	}
+
+	h.enqueueCheck(w, r, targetID, nil)
+}
+
+// CheckSnapshot godoc
+// @Summary Vérifier un snapshot
+// @Description Lance une tâche qui vérifie que chaque chunk référencé par un snapshot existe, et avec read_data que son contenu est intact
+// @Tags snapshots
+// @Accept json
+// @Produce json
+// @Param id path int true "Snapshot ID"
+// @Param request body handlers.CheckRequest false "Options de la vérification"
+// @Success 202 {object} handlers.CheckResponse
+// @Failure 400 {object} handlers.ErrorInfo
+// @Failure 404 {object} handlers.ErrorInfo
+// @Failure 500 {object} handlers.ErrorInfo
+// @Router /snapshots/{id}/check [post]
+func (h *BackupHandler) CheckSnapshot(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid snapshot ID")
+		return
+	}
+
+	snapshot, err := h.backupService.GetSnapshot(r.Context(), id)
+	if err != nil {
+		if err == domain.ErrNotFound {
+			WriteError(w, http.StatusNotFound, "Snapshot not found")
 			return
 		}
-		defer backend.Close()
+		h.logger.Error("failed to get snapshot", zap.Error(err), zap.Int64("id", id))
+		WriteError(w, http.StatusInternalServerError, "Failed to get snapshot")
+		return
+	}
 
-		h.logger.Info("backup job started", zap.Int64("job_id", job.ID), zap.Int64("source_id", sourceID))
+	if !snapshot.Completed() {
+		WriteError(w, http.StatusBadRequest, "Only successful or partial snapshots can be checked")
+		return
+	}
 
-		// Run backup
-		if err := h.backupService.RunBackup(ctx, sourceID, backend); err != nil {
-			h.logger.Error("backup failed", zap.Error(err), zap.Int64("job_id", job.ID))
-			h.jobService.UpdateStatus(ctx, job.ID, "failed", fmt.Errorf("backup failed: %w", err))
+	h.enqueueCheck(w, r, snapshot.TargetID, &snapshot.ID)
+}
+
+// enqueueCheck creates a check job for a target, or for one snapshot of it,
+// with the options of the optional request body
+func (h *BackupHandler) enqueueCheck(w http.ResponseWriter, r *http.Request, targetID int64, snapshotID *int64) {
+	// The body is optional: without it only the presence of the chunks is checked
+	var req CheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	opts := domain.CheckOptions{ReadData: req.ReadData, ReadDataPercent: req.ReadDataPercent}
+	job, err := h.runner.EnqueueCheck(r.Context(), targetID, snapshotID, opts)
+	if err != nil {
+		if err == domain.ErrNotFound {
+			WriteError(w, http.StatusNotFound, "Target not found")
 			return
 		}
+		if errors.Is(err, domain.ErrInvalidInput) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Error("failed to create check job", zap.Error(err), zap.Int64("target_id", targetID))
+		WriteError(w, http.StatusInternalServerError, "Failed to create check job")
+		return
+	}
 
-		// Update job as success
-		h.jobService.UpdateStatus(ctx, job.ID, "success", nil)
-		h.logger.Info("backup job completed successfully", zap.Int64("job_id", job.ID))
-	}()
+	WriteJSON(w, http.StatusAccepted, CheckResponse{
+		JobID:  job.ID,
+		Status: job.Status,
+	})
+}
 
-	WriteJSON(w, http.StatusAccepted, map[string]interface{}{
-		"job_id": job.ID,
-		"status": job.Status,
+// writeJobConflict answers a backup refused because of an existing job. The
+// response links to that job and carries it as data.
+func writeJobConflict(w http.ResponseWriter, conflict *jobrunner.ConflictError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/api/jobs/%d", conflict.Job.ID))
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(Response{
+		Data: conflict.Job,
+		Error: &ErrorInfo{
+			Message: fmt.Sprintf("A backup of this source is already %s (job %d)", conflict.Job.Status, conflict.Job.ID),
+			Code:    "backup_in_progress",
+		},
 	})
 }
@@ -9,30 +9,64 @@ import (
 )
 
 type CreateSourceRequest struct {
-	Name       string   `json:"name" example:"mes-documents"`
-	Path       string   `json:"path" example:"/home/user/documents"`
-	Exclusions []string `json:"exclusions" example:"*.tmp,*.log"`
-	TargetID   *int64   `json:"target_id" example:"1"`
-	ScheduleID *int64   `json:"schedule_id,omitempty"`
+	Name             string           `json:"name" example:"mes-documents"`
+	Path             string           `json:"path" example:"/home/user/documents"`
+	Exclusions       []string         `json:"exclusions" example:"*.tmp,*.log"`
+	Filters          SourceFilters    `json:"filters"`
+	TargetID         *int64           `json:"target_id" example:"1"`
+	ScheduleID       *int64           `json:"schedule_id,omitempty"`
+	Schedule         *ScheduleRequest `json:"schedule,omitempty"` // Creates the schedule of the source along with it
+	Compression      string           `json:"compression,omitempty" example:"zstd"`
+	CompressionLevel int              `json:"compression_level,omitempty" example:"3"`
+	Retention        *RetentionPolicy `json:"retention,omitempty"`
+}
+
+// SourceFilters mirrors domain.SourceFilters
+type SourceFilters struct {
+	Includes   []string `json:"includes,omitempty" example:"*.pdf,*.docx"`
+	MinSize    int64    `json:"min_size,omitempty" example:"0"`
+	MaxSize    int64    `json:"max_size,omitempty" example:"2147483648"`
+	MinAgeDays int      `json:"min_age_days,omitempty" example:"0"`
+	MaxAgeDays int      `json:"max_age_days,omitempty" example:"90"`
+	Types      []string `json:"types,omitempty" example:"file,symlink"`
+}
+
+// RetentionPolicy mirrors domain.RetentionPolicy
+type RetentionPolicy struct {
+	KeepLast    int    `json:"keep_last,omitempty" example:"3"`
+	KeepHourly  int    `json:"keep_hourly,omitempty" example:"0"`
+	KeepDaily   int    `json:"keep_daily,omitempty" example:"7"`
+	KeepWeekly  int    `json:"keep_weekly,omitempty" example:"4"`
+	KeepMonthly int    `json:"keep_monthly,omitempty" example:"12"`
+	KeepYearly  int    `json:"keep_yearly,omitempty" example:"2"`
+	KeepWithin  string `json:"keep_within,omitempty" example:"2w"`
 }
 
 type UpdateSourceRequest struct {
-	Name       string   `json:"name" example:"mes-documents"`
-	Path       string   `json:"path" example:"/home/user/documents"`
-	Exclusions []string `json:"exclusions" example:"*.tmp,*.log"`
-	TargetID   *int64   `json:"target_id" example:"1"`
-	ScheduleID *int64   `json:"schedule_id,omitempty"`
+	Name             string           `json:"name" example:"mes-documents"`
+	Path             string           `json:"path" example:"/home/user/documents"`
+	Exclusions       []string         `json:"exclusions" example:"*.tmp,*.log"`
+	Filters          SourceFilters    `json:"filters"`
+	TargetID         *int64           `json:"target_id" example:"1"`
+	ScheduleID       *int64           `json:"schedule_id,omitempty"`
+	Compression      string           `json:"compression,omitempty" example:"zstd"`
+	CompressionLevel int              `json:"compression_level,omitempty" example:"3"`
+	Retention        *RetentionPolicy `json:"retention,omitempty"`
 }
 
 type SourceResponse struct {
-	ID         int64     `json:"id" example:"1"`
-	Name       string    `json:"name" example:"mes-documents"`
-	Path       string    `json:"path" example:"/home/user/documents"`
-	Exclusions []string  `json:"exclusions" example:"*.tmp,*.log"`
-	TargetID   *int64    `json:"target_id" example:"1"`
-	ScheduleID *int64    `json:"schedule_id,omitempty"`
-	CreatedAt  time.Time `json:"created_at" example:"2025-01-21T10:00:00Z"`
-	UpdatedAt  time.Time `json:"updated_at" example:"2025-01-21T10:00:00Z"`
+	ID               int64            `json:"id" example:"1"`
+	Name             string           `json:"name" example:"mes-documents"`
+	Path             string           `json:"path" example:"/home/user/documents"`
+	Exclusions       []string         `json:"exclusions" example:"*.tmp,*.log"`
+	Filters          SourceFilters    `json:"filters"`
+	TargetID         *int64           `json:"target_id" example:"1"`
+	ScheduleID       *int64           `json:"schedule_id,omitempty"`
+	Compression      string           `json:"compression,omitempty" example:"zstd"`
+	CompressionLevel int              `json:"compression_level,omitempty" example:"3"`
+	Retention        *RetentionPolicy `json:"retention,omitempty"`
+	CreatedAt        time.Time        `json:"created_at" example:"2025-01-21T10:00:00Z"`
+	UpdatedAt        time.Time        `json:"updated_at" example:"2025-01-21T10:00:00Z"`
 }
 
 type CreateTargetRequest struct {
@@ -100,7 +134,153 @@ func ToTargetResponse(target *domain.Target) (*TargetResponse, error) {
 	}, nil
 }
 
+type ScheduleRequest struct {
+	Type      string               `json:"type,omitempty" example:"backup"` // backup (the default) or check
+	SourceID  int64                `json:"source_id,omitempty" example:"1"` // Ignored when the schedule is created with its source
+	TargetID  *int64               `json:"target_id,omitempty" example:"1"` // Target of a check schedule
+	Check     *domain.CheckOptions `json:"check,omitempty"`                 // Options of a check schedule
+	Frequency string               `json:"frequency" example:"cron"`
+	CronExpr  *string              `json:"cron_expr,omitempty" example:"30 2 * * 1-5"`
+	Timezone  string               `json:"timezone,omitempty" example:"Europe/Paris"`
+	CatchUp   string               `json:"catch_up,omitempty" example:"once"`
+	Enabled   *bool                `json:"enabled,omitempty" example:"true"` // Defaults to true
+	Jitter    int                  `json:"jitter_seconds,omitempty" example:"300"`
+}
+
+// ToScheduleDomain converts the request to a schedule
+func (r *ScheduleRequest) ToScheduleDomain() *domain.Schedule {
+	enabled := true
+	if r.Enabled != nil {
+		enabled = *r.Enabled
+	}
+	return &domain.Schedule{
+		Type:      r.Type,
+		SourceID:  r.SourceID,
+		TargetID:  r.TargetID,
+		Check:     r.Check,
+		Frequency: r.Frequency,
+		CronExpr:  r.CronExpr,
+		Timezone:  r.Timezone,
+		CatchUp:   r.CatchUp,
+		Enabled:   enabled,
+		Jitter:    r.Jitter,
+	}
+}
+
+type ScheduleResponse struct {
+	ID        int64                `json:"id" example:"1"`
+	Type      string               `json:"type" example:"backup"`
+	SourceID  int64                `json:"source_id,omitempty" example:"1"`
+	TargetID  *int64               `json:"target_id,omitempty" example:"1"`
+	Check     *domain.CheckOptions `json:"check,omitempty"`
+	Frequency string               `json:"frequency" example:"cron"`
+	CronExpr  *string              `json:"cron_expr,omitempty" example:"30 2 * * 1-5"`
+	Timezone  string               `json:"timezone,omitempty" example:"Europe/Paris"`
+	CatchUp   string               `json:"catch_up,omitempty" example:"once"`
+	Enabled   bool                 `json:"enabled" example:"true"`
+	Jitter    int                  `json:"jitter_seconds,omitempty" example:"300"`
+	LastRunAt *time.Time           `json:"last_run_at,omitempty" example:"2025-01-21T02:30:00Z"`
+	NextRuns  []time.Time          `json:"next_runs"` // Upcoming fire times, jitter excluded
+	CreatedAt time.Time            `json:"created_at" example:"2025-01-21T10:00:00Z"`
+	UpdatedAt time.Time            `json:"updated_at" example:"2025-01-21T10:00:00Z"`
+}
+
+// ToScheduleResponse converts a schedule and its upcoming runs to a response
+func ToScheduleResponse(schedule *domain.Schedule, nextRuns []time.Time) *ScheduleResponse {
+	if !schedule.Enabled || nextRuns == nil {
+		nextRuns = []time.Time{}
+	}
+	scheduleType := schedule.Type
+	if scheduleType == "" {
+		scheduleType = domain.ScheduleTypeBackup
+	}
+	return &ScheduleResponse{
+		ID:        schedule.ID,
+		Type:      scheduleType,
+		SourceID:  schedule.SourceID,
+		TargetID:  schedule.TargetID,
+		Check:     schedule.Check,
+		Frequency: schedule.Frequency,
+		CronExpr:  schedule.CronExpr,
+		Timezone:  schedule.Timezone,
+		CatchUp:   schedule.CatchUp,
+		Enabled:   schedule.Enabled,
+		Jitter:    schedule.Jitter,
+		LastRunAt: schedule.LastRunAt,
+		NextRuns:  nextRuns,
+		CreatedAt: schedule.CreatedAt,
+		UpdatedAt: schedule.UpdatedAt,
+	}
+}
+
+type SchedulePreviewResponse struct {
+	NextRuns []time.Time `json:"next_runs"`
+}
+
+type JobLogsResponse struct {
+	Entries []*domain.JobLogEntry `json:"entries"`
+	Total   int                   `json:"total" example:"42"`
+	Offset  int                   `json:"offset" example:"0"`
+	Limit   int                   `json:"limit" example:"100"`
+}
+
 type BackupResponse struct {
 	JobID  int64  `json:"job_id" example:"1"`
 	Status string `json:"status" example:"pending"`
 }
+
+type ExclusionTestRequest struct {
+	Path  string `json:"path" example:"build/output/app.o"`
+	IsDir bool   `json:"is_dir" example:"false"`
+}
+
+type ExclusionRuleResponse struct {
+	Pattern string `json:"pattern" example:"build/**"`
+	Origin  string `json:"origin" example:"source"`
+	Line    int    `json:"line" example:"1"`
+}
+
+type ExclusionTestResponse struct {
+	Path     string                 `json:"path" example:"build/output/app.o"`
+	Excluded bool                   `json:"excluded" example:"true"`
+	Rule     *ExclusionRuleResponse `json:"rule,omitempty"`
+}
+
+type RestoreRequest struct {
+	Destination string `json:"destination,omitempty" example:"/tmp/restore"`
+}
+
+// ForgetRequest previews or applies a retention policy. Policy defaults to
+// the retention policy of the source.
+type ForgetRequest struct {
+	Policy *RetentionPolicy `json:"policy,omitempty"`
+	DryRun bool             `json:"dry_run" example:"true"`
+}
+
+// PruneRequest asks for a prune of a target. A dry run only reports what
+// would be deleted.
+type PruneRequest struct {
+	DryRun bool `json:"dry_run" example:"false"`
+}
+
+type PruneResponse struct {
+	JobID  int64  `json:"job_id" example:"1"`
+	Status string `json:"status" example:"pending"`
+}
+
+// CheckRequest asks for a check of a repository. With read data, the chunks
+// are also downloaded, or a share of them, and hashed again.
+type CheckRequest struct {
+	ReadData        bool `json:"read_data" example:"true"`
+	ReadDataPercent int  `json:"read_data_percent,omitempty" example:"10"`
+}
+
+type CheckResponse struct {
+	JobID  int64  `json:"job_id" example:"1"`
+	Status string `json:"status" example:"pending"`
+}
+
+type RestoreResponse struct {
+	JobID  int64  `json:"job_id" example:"1"`
+	Status string `json:"status" example:"pending"`
+}
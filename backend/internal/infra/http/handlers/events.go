@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/axelfrache/savesync/internal/app/eventbus"
+	"go.uber.org/zap"
+)
+
+// eventKeepAlive is how often an idle stream sends a comment, so that
+// proxies don't close it
+const eventKeepAlive = 15 * time.Second
+
+// EventHandler streams job and snapshot events
+type EventHandler struct {
+	bus    *eventbus.Bus
+	logger *zap.Logger
+}
+
+// NewEventHandler creates a new event handler
+func NewEventHandler(bus *eventbus.Bus, logger *zap.Logger) *EventHandler {
+	return &EventHandler{
+		bus:    bus,
+		logger: logger,
+	}
+}
+
+// Stream godoc
+// @Summary Suivre les jobs en direct
+// @Description Flux Server-Sent Events des changements de statut des jobs (job.updated), de leur progression (job.progress) et des snapshots terminés (snapshot.completed). Le flux se ferme si le client prend trop de retard : il doit alors recharger l'état via l'API.
+// @Tags jobs
+// @Produce text/event-stream
+// @Param source_id query int false "Uniquement les événements de cette source"
+// @Param job_id query int false "Uniquement les événements de ce job"
+// @Success 200 {object} domain.Event
+// @Failure 400 {object} handlers.ErrorInfo
+// @Router /events [get]
+func (h *EventHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	sourceID, err := optionalID(r, "source_id")
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid source ID")
+		return
+	}
+	jobID, err := optionalID(r, "job_id")
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid job ID")
+		return
+	}
+	filter := eventbus.Filter{SourceID: sourceID, JobID: jobID}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		h.logger.Warn("failed to clear write deadline", zap.Error(err))
+	}
+
+	sub := h.bus.Subscribe(filter)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		h.logger.Error("event stream not supported", zap.Error(err))
+		return
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				return // Too slow, or the server is shutting down
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				h.logger.Error("failed to marshal event", zap.Error(err), zap.String("event", event.Type))
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// optionalID parses an optional ID query parameter
+func optionalID(r *http.Request, name string) (*int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
@@ -4,22 +4,31 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/axelfrache/savesync/internal/app/jobrunner"
 	"github.com/axelfrache/savesync/internal/app/jobservice"
 	"github.com/axelfrache/savesync/internal/domain"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
 
+// Paging of job logs
+const (
+	defaultJobLogLimit = 100
+	maxJobLogLimit     = 1000
+)
+
 // JobHandler handles job-related requests
 type JobHandler struct {
 	service *jobservice.Service
+	runner  *jobrunner.Runner
 	logger  *zap.Logger
 }
 
 // NewJobHandler creates a new job handler
-func NewJobHandler(service *jobservice.Service, logger *zap.Logger) *JobHandler {
+func NewJobHandler(service *jobservice.Service, runner *jobrunner.Runner, logger *zap.Logger) *JobHandler {
 	return &JobHandler{
 		service: service,
+		runner:  runner,
 		logger:  logger,
 	}
 }
@@ -58,3 +67,79 @@ func (h *JobHandler) Get(w http.ResponseWriter, r *http.Request) {
 
 	WriteJSON(w, http.StatusOK, job)
 }
+
+// Cancel handles POST /api/jobs/:id/cancel. A running job keeps its status
+// until it reaches its next cancellation check.
+func (h *JobHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid job ID")
+		return
+	}
+
+	if err := h.runner.Cancel(r.Context(), id); err != nil {
+		switch err {
+		case domain.ErrNotFound:
+			WriteError(w, http.StatusNotFound, "Job not found")
+		case domain.ErrJobFinished:
+			WriteError(w, http.StatusConflict, "Job already finished")
+		default:
+			h.logger.Error("failed to cancel job", zap.Error(err), zap.Int64("id", id))
+			WriteError(w, http.StatusInternalServerError, "Failed to cancel job")
+		}
+		return
+	}
+
+	job, err := h.service.GetByID(r.Context(), id)
+	if err != nil {
+		h.logger.Error("failed to get job", zap.Error(err), zap.Int64("id", id))
+		WriteError(w, http.StatusInternalServerError, "Failed to get job")
+		return
+	}
+
+	WriteJSON(w, http.StatusAccepted, job)
+}
+
+// Logs handles GET /api/jobs/:id/logs?offset=&limit=, returning the
+// warnings and errors of a job in the order they were logged
+func (h *JobHandler) Logs(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid job ID")
+		return
+	}
+
+	offset, limit := 0, defaultJobLogLimit
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			WriteError(w, http.StatusBadRequest, "Invalid offset parameter")
+			return
+		}
+	}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxJobLogLimit {
+			WriteError(w, http.StatusBadRequest, "Invalid limit parameter")
+			return
+		}
+	}
+
+	entries, total, err := h.service.GetLogs(r.Context(), id, offset, limit)
+	if err != nil {
+		if err == domain.ErrNotFound {
+			WriteError(w, http.StatusNotFound, "Job not found")
+			return
+		}
+		h.logger.Error("failed to get job logs", zap.Error(err), zap.Int64("id", id))
+		WriteError(w, http.StatusInternalServerError, "Failed to get job logs")
+		return
+	}
+
+	if entries == nil {
+		entries = []*domain.JobLogEntry{}
+	}
+	WriteJSON(w, http.StatusOK, JobLogsResponse{Entries: entries, Total: total, Offset: offset, Limit: limit})
+}
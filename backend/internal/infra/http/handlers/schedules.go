@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/axelfrache/savesync/internal/app/scheduleservice"
+	"github.com/axelfrache/savesync/internal/domain"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPreviewRuns = 5
+	maxPreviewRuns     = 100
+)
+
+// ScheduleHandler handles schedule-related requests
+type ScheduleHandler struct {
+	service *scheduleservice.Service
+	logger  *zap.Logger
+}
+
+// NewScheduleHandler creates a new schedule handler
+func NewScheduleHandler(service *scheduleservice.Service, logger *zap.Logger) *ScheduleHandler {
+	return &ScheduleHandler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+// List godoc
+// @Summary Lister les planifications
+// @Description Récupère toutes les planifications avec leurs prochaines exécutions
+// @Tags schedules
+// @Produce json
+// @Param runs query int false "Nombre de prochaines exécutions à calculer (5 par défaut, 100 au maximum)"
+// @Success 200 {array} handlers.ScheduleResponse
+// @Failure 400 {object} handlers.ErrorInfo
+// @Failure 500 {object} handlers.ErrorInfo
+// @Router /schedules [get]
+func (h *ScheduleHandler) List(w http.ResponseWriter, r *http.Request) {
+	runs, ok := previewRuns(w, r)
+	if !ok {
+		return
+	}
+
+	schedules, err := h.service.GetAll(r.Context())
+	if err != nil {
+		h.logger.Error("failed to list schedules", zap.Error(err))
+		WriteError(w, http.StatusInternalServerError, "Failed to list schedules")
+		return
+	}
+
+	resp := make([]*ScheduleResponse, 0, len(schedules))
+	for _, schedule := range schedules {
+		resp = append(resp, h.toResponse(schedule, runs))
+	}
+	WriteJSON(w, http.StatusOK, resp)
+}
+
+// Get godoc
+// @Summary Récupérer une planification
+// @Description Récupère une planification et ses prochaines exécutions
+// @Tags schedules
+// @Produce json
+// @Param id path int true "Schedule ID"
+// @Param runs query int false "Nombre de prochaines exécutions à calculer (5 par défaut, 100 au maximum)"
+// @Success 200 {object} handlers.ScheduleResponse
+// @Failure 400 {object} handlers.ErrorInfo
+// @Failure 404 {object} handlers.ErrorInfo
+// @Failure 500 {object} handlers.ErrorInfo
+// @Router /schedules/{id} [get]
+func (h *ScheduleHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid schedule ID")
+		return
+	}
+
+	runs, ok := previewRuns(w, r)
+	if !ok {
+		return
+	}
+
+	schedule, err := h.service.GetByID(r.Context(), id)
+	if err != nil {
+		if err == domain.ErrNotFound {
+			WriteError(w, http.StatusNotFound, "Schedule not found")
+			return
+		}
+		h.logger.Error("failed to get schedule", zap.Error(err), zap.Int64("id", id))
+		WriteError(w, http.StatusInternalServerError, "Failed to get schedule")
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, h.toResponse(schedule, runs))
+}
+
+// Create godoc
+// @Summary Créer une planification
+// @Description Planifie les sauvegardes d'une source, qui ne peut avoir qu'une planification
+// @Tags schedules
+// @Accept json
+// @Produce json
+// @Param schedule body handlers.ScheduleRequest true "Planification à créer"
+// @Success 201 {object} handlers.ScheduleResponse
+// @Failure 400 {object} handlers.ErrorInfo
+// @Failure 500 {object} handlers.ErrorInfo
+// @Router /schedules [post]
+func (h *ScheduleHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req ScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	schedule := req.ToScheduleDomain()
+	if err := h.service.Create(r.Context(), schedule); err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Error("failed to create schedule", zap.Error(err))
+		WriteError(w, http.StatusInternalServerError, "Failed to create schedule")
+		return
+	}
+
+	WriteJSON(w, http.StatusCreated, h.toResponse(schedule, defaultPreviewRuns))
+}
+
+// Update godoc
+// @Summary Mettre à jour une planification
+// @Description Met à jour une planification existante, sans changer sa source
+// @Tags schedules
+// @Accept json
+// @Produce json
+// @Param id path int true "Schedule ID"
+// @Param schedule body handlers.ScheduleRequest true "Planification mise à jour"
+// @Success 200 {object} handlers.ScheduleResponse
+// @Failure 400 {object} handlers.ErrorInfo
+// @Failure 404 {object} handlers.ErrorInfo
+// @Failure 500 {object} handlers.ErrorInfo
+// @Router /schedules/{id} [put]
+func (h *ScheduleHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid schedule ID")
+		return
+	}
+
+	var req ScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	schedule := req.ToScheduleDomain()
+	schedule.ID = id
+	if err := h.service.Update(r.Context(), schedule); err != nil {
+		if err == domain.ErrNotFound {
+			WriteError(w, http.StatusNotFound, "Schedule not found")
+			return
+		}
+		if errors.Is(err, domain.ErrInvalidInput) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Error("failed to update schedule", zap.Error(err), zap.Int64("id", id))
+		WriteError(w, http.StatusInternalServerError, "Failed to update schedule")
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, h.toResponse(schedule, defaultPreviewRuns))
+}
+
+// Delete godoc
+// @Summary Supprimer une planification
+// @Description Supprime une planification et la détache de sa source
+// @Tags schedules
+// @Param id path int true "Schedule ID"
+// @Success 204
+// @Failure 400 {object} handlers.ErrorInfo
+// @Failure 404 {object} handlers.ErrorInfo
+// @Failure 500 {object} handlers.ErrorInfo
+// @Router /schedules/{id} [delete]
+func (h *ScheduleHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid schedule ID")
+		return
+	}
+
+	if err := h.service.Delete(r.Context(), id); err != nil {
+		if err == domain.ErrNotFound {
+			WriteError(w, http.StatusNotFound, "Schedule not found")
+			return
+		}
+		h.logger.Error("failed to delete schedule", zap.Error(err), zap.Int64("id", id))
+		WriteError(w, http.StatusInternalServerError, "Failed to delete schedule")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Preview godoc
+// @Summary Prévisualiser une planification
+// @Description Valide une planification sans l'enregistrer et renvoie ses prochaines exécutions
+// @Tags schedules
+// @Accept json
+// @Produce json
+// @Param runs query int false "Nombre de prochaines exécutions à calculer (5 par défaut, 100 au maximum)"
+// @Param schedule body handlers.ScheduleRequest true "Planification à tester"
+// @Success 200 {object} handlers.SchedulePreviewResponse
+// @Failure 400 {object} handlers.ErrorInfo
+// @Router /schedules/preview [post]
+func (h *ScheduleHandler) Preview(w http.ResponseWriter, r *http.Request) {
+	runs, ok := previewRuns(w, r)
+	if !ok {
+		return
+	}
+
+	var req ScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	nextRuns, err := h.service.NextRuns(req.ToScheduleDomain(), runs)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, SchedulePreviewResponse{NextRuns: nextRuns})
+}
+
+// toResponse adds the upcoming runs to a schedule. Stored schedules which no
+// longer parse, such as ones with a time zone unknown to this host, have none.
+func (h *ScheduleHandler) toResponse(schedule *domain.Schedule, runs int) *ScheduleResponse {
+	nextRuns, err := h.service.NextRuns(schedule, runs)
+	if err != nil {
+		h.logger.Warn("invalid stored schedule", zap.Error(err), zap.Int64("id", schedule.ID))
+	}
+	return ToScheduleResponse(schedule, nextRuns)
+}
+
+// previewRuns reads the number of upcoming runs requested, writing an error
+// response when it is invalid
+func previewRuns(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("runs")
+	if value == "" {
+		return defaultPreviewRuns, true
+	}
+
+	runs, err := strconv.Atoi(value)
+	if err != nil || runs < 0 || runs > maxPreviewRuns {
+		WriteError(w, http.StatusBadRequest, "Invalid runs parameter")
+		return 0, false
+	}
+	return runs, true
+}
@@ -1,13 +1,19 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"path/filepath"
 	"strconv"
 
 	"github.com/axelfrache/savesync/internal/app/backupservice"
+	"github.com/axelfrache/savesync/internal/app/jobrunner"
 	"github.com/axelfrache/savesync/internal/app/sourceservice"
 	"github.com/axelfrache/savesync/internal/app/targetservice"
+	"github.com/axelfrache/savesync/internal/domain"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
@@ -16,6 +22,7 @@ type SnapshotHandler struct {
 	service       *backupservice.Service
 	sourceService *sourceservice.Service
 	targetService *targetservice.Service
+	runner        *jobrunner.Runner
 	logger        *zap.Logger
 }
 
@@ -23,12 +30,14 @@ func NewSnapshotHandler(
 	service *backupservice.Service,
 	sourceService *sourceservice.Service,
 	targetService *targetservice.Service,
+	runner *jobrunner.Runner,
 	logger *zap.Logger,
 ) *SnapshotHandler {
 	return &SnapshotHandler{
 		service:       service,
 		sourceService: sourceService,
 		targetService: targetService,
+		runner:        runner,
 		logger:        logger,
 	}
 }
@@ -83,11 +92,15 @@ func (h *SnapshotHandler) Get(w http.ResponseWriter, r *http.Request) {
 
 // Restore godoc
 // @Summary Restaurer un snapshot
-// @Description Restaure un snapshot spécifique
+// @Description Lance la restauration d'un snapshot vers son chemin d'origine ou un répertoire de destination
 // @Tags snapshots
+// @Accept json
+// @Produce json
 // @Param id path int true "Snapshot ID"
-// @Success 202
+// @Param restore body handlers.RestoreRequest false "Options de restauration"
+// @Success 202 {object} handlers.RestoreResponse
 // @Failure 400 {object} handlers.ErrorInfo
+// @Failure 404 {object} handlers.ErrorInfo
 // @Failure 500 {object} handlers.ErrorInfo
 // @Router /snapshots/{id}/restore [post]
 func (h *SnapshotHandler) Restore(w http.ResponseWriter, r *http.Request) {
@@ -98,13 +111,46 @@ func (h *SnapshotHandler) Restore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.RestoreSnapshot(r.Context(), id); err != nil {
-		h.logger.Error("failed to restore snapshot", zap.Error(err), zap.Int64("id", id))
-		WriteError(w, http.StatusInternalServerError, "Failed to restore snapshot")
+	// The body is optional: without it the snapshot is restored in place
+	var req RestoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Destination != "" && !filepath.IsAbs(req.Destination) {
+		WriteError(w, http.StatusBadRequest, "Destination must be an absolute path")
 		return
 	}
 
-	w.WriteHeader(http.StatusAccepted)
+	snapshot, err := h.service.GetSnapshot(r.Context(), id)
+	if err != nil {
+		if err == domain.ErrNotFound {
+			WriteError(w, http.StatusNotFound, "Snapshot not found")
+			return
+		}
+		h.logger.Error("failed to get snapshot", zap.Error(err), zap.Int64("id", id))
+		WriteError(w, http.StatusInternalServerError, "Failed to get snapshot")
+		return
+	}
+
+	if !snapshot.Completed() {
+		WriteError(w, http.StatusBadRequest, "Only successful or partial snapshots can be restored")
+		return
+	}
+
+	opts := backupservice.RestoreOptions{Destination: req.Destination}
+	job, err := h.runner.EnqueueRestore(r.Context(), id, opts)
+	if err != nil {
+		h.logger.Error("failed to create restore job", zap.Error(err), zap.Int64("snapshot_id", id))
+		WriteError(w, http.StatusInternalServerError, "Failed to create restore job")
+		return
+	}
+
+	WriteJSON(w, http.StatusAccepted, RestoreResponse{
+		JobID:  job.ID,
+		Status: job.Status,
+	})
 }
 
 // GetManifest godoc
@@ -158,17 +204,18 @@ func (h *SnapshotHandler) GetManifest(w http.ResponseWriter, r *http.Request) {
 	defer backend.Close()
 
 	// 4. Get Manifest
-	manifestJSON, err := h.service.GetManifestWithBackend(ctx, id, backend)
+	manifest, err := h.service.OpenManifest(ctx, id, backend)
 	if err != nil {
 		h.logger.Error("failed to get manifest", zap.Error(err))
 		WriteError(w, http.StatusInternalServerError, "Failed to retrieve manifest")
 		return
 	}
+	defer manifest.Close()
 
 	// 5. Serve File
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"manifest-%d.json\"", id))
-	w.Write(manifestJSON)
+	io.Copy(w, manifest)
 }
 
 // GetFiles godoc
@@ -231,3 +278,144 @@ func (h *SnapshotHandler) GetFiles(w http.ResponseWriter, r *http.Request) {
 
 	WriteJSON(w, http.StatusOK, tree)
 }
+
+// Diff godoc
+// @Summary Comparer deux snapshots
+// @Description Compare les manifests de deux snapshots d'une même source et liste les fichiers ajoutés, supprimés, modifiés et ceux dont seules les métadonnées ont changé, avec l'écart de taille de chacun et des totaux
+// @Tags snapshots
+// @Produce json
+// @Param id path int true "Snapshot de départ"
+// @Param other path int true "Snapshot d'arrivée"
+// @Param path query string false "Préfixe de chemin limitant la comparaison"
+// @Success 200 {object} backupservice.SnapshotDiff
+// @Failure 400 {object} handlers.ErrorInfo
+// @Failure 404 {object} handlers.ErrorInfo
+// @Failure 500 {object} handlers.ErrorInfo
+// @Router /snapshots/{id}/diff/{other} [get]
+func (h *SnapshotHandler) Diff(w http.ResponseWriter, r *http.Request) {
+	fromID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid snapshot ID")
+		return
+	}
+	toID, err := strconv.ParseInt(chi.URLParam(r, "other"), 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid snapshot ID")
+		return
+	}
+
+	ctx := r.Context()
+
+	// Each manifest is read from the target its snapshot was written to
+	backends := make(map[int64]domain.Backend)
+	defer func() {
+		for _, backend := range backends {
+			backend.Close()
+		}
+	}()
+	var targets []int64
+	for _, id := range []int64{fromID, toID} {
+		snapshot, err := h.service.GetSnapshot(ctx, id)
+		if err != nil {
+			if err == domain.ErrNotFound {
+				WriteError(w, http.StatusNotFound, "Snapshot not found")
+				return
+			}
+			h.logger.Error("failed to get snapshot", zap.Error(err), zap.Int64("id", id))
+			WriteError(w, http.StatusInternalServerError, "Failed to get snapshot")
+			return
+		}
+		targets = append(targets, snapshot.TargetID)
+		if _, ok := backends[snapshot.TargetID]; ok {
+			continue
+		}
+
+		backend, err := h.targetService.GetBackend(ctx, snapshot.TargetID)
+		if err != nil {
+			h.logger.Error("failed to get backend", zap.Error(err), zap.Int64("target_id", snapshot.TargetID))
+			WriteError(w, http.StatusInternalServerError, "Failed to initialize backend")
+			return
+		}
+		backends[snapshot.TargetID] = backend
+	}
+
+	diff, err := h.service.DiffSnapshots(ctx, fromID, toID, backends[targets[0]], backends[targets[1]], r.URL.Query().Get("path"))
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Error("failed to diff snapshots", zap.Error(err), zap.Int64("from", fromID), zap.Int64("to", toID))
+		WriteError(w, http.StatusInternalServerError, "Failed to compare snapshots")
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, diff)
+}
+
+// Forget godoc
+// @Summary Appliquer la politique de rétention
+// @Description Supprime les snapshots d'une source qu'aucune règle de rétention ne conserve. Sans politique dans la requête, celle de la source est utilisée ; avec dry_run, rien n'est supprimé.
+// @Tags sources
+// @Accept json
+// @Produce json
+// @Param id path int true "Source ID"
+// @Param request body handlers.ForgetRequest false "Options de rétention"
+// @Success 200 {object} backupservice.ForgetResult
+// @Failure 400 {object} handlers.ErrorInfo
+// @Failure 404 {object} handlers.ErrorInfo
+// @Failure 500 {object} handlers.ErrorInfo
+// @Router /sources/{id}/forget [post]
+func (h *SnapshotHandler) Forget(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	sourceID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid source ID")
+		return
+	}
+
+	// The body is optional: without it the policy of the source is applied
+	var req ForgetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	ctx := r.Context()
+	source, err := h.sourceService.GetByID(ctx, sourceID)
+	if err != nil {
+		if err == domain.ErrNotFound {
+			WriteError(w, http.StatusNotFound, "Source not found")
+			return
+		}
+		h.logger.Error("failed to get source", zap.Error(err), zap.Int64("source_id", sourceID))
+		WriteError(w, http.StatusInternalServerError, "Failed to get source")
+		return
+	}
+
+	if source.TargetID == nil {
+		WriteError(w, http.StatusBadRequest, "Source has no target")
+		return
+	}
+
+	backend, err := h.targetService.GetBackend(ctx, *source.TargetID)
+	if err != nil {
+		h.logger.Error("failed to get backend", zap.Error(err))
+		WriteError(w, http.StatusInternalServerError, "Failed to initialize backend")
+		return
+	}
+	defer backend.Close()
+
+	result, err := h.service.ForgetSnapshots(ctx, sourceID, (*domain.RetentionPolicy)(req.Policy), backend, req.DryRun)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Error("failed to forget snapshots", zap.Error(err), zap.Int64("source_id", sourceID))
+		WriteError(w, http.StatusInternalServerError, "Failed to apply retention policy")
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, result)
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/axelfrache/savesync/internal/app/scheduleservice"
 	"github.com/axelfrache/savesync/internal/app/sourceservice"
 	"github.com/axelfrache/savesync/internal/domain"
 	"github.com/go-chi/chi/v5"
@@ -14,15 +15,17 @@ import (
 
 // SourceHandler handles source-related requests
 type SourceHandler struct {
-	service *sourceservice.Service
-	logger  *zap.Logger
+	service   *sourceservice.Service
+	schedules *scheduleservice.Service
+	logger    *zap.Logger
 }
 
 // NewSourceHandler creates a new source handler
-func NewSourceHandler(service *sourceservice.Service, logger *zap.Logger) *SourceHandler {
+func NewSourceHandler(service *sourceservice.Service, schedules *scheduleservice.Service, logger *zap.Logger) *SourceHandler {
 	return &SourceHandler{
-		service: service,
-		logger:  logger,
+		service:   service,
+		schedules: schedules,
+		logger:    logger,
 	}
 }
 
@@ -80,7 +83,7 @@ func (h *SourceHandler) Get(w http.ResponseWriter, r *http.Request) {
 
 // Create godoc
 // @Summary Créer une source
-// @Description Crée une nouvelle source de backup
+// @Description Crée une nouvelle source de backup, avec sa planification le cas échéant
 // @Tags sources
 // @Accept json
 // @Produce json
@@ -97,11 +100,25 @@ func (h *SourceHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	source := &domain.Source{
-		Name:       req.Name,
-		Path:       req.Path,
-		Exclusions: req.Exclusions,
-		TargetID:   req.TargetID,
-		ScheduleID: req.ScheduleID,
+		Name:             req.Name,
+		Path:             req.Path,
+		Exclusions:       req.Exclusions,
+		Filters:          domain.SourceFilters(req.Filters),
+		TargetID:         req.TargetID,
+		ScheduleID:       req.ScheduleID,
+		Compression:      req.Compression,
+		CompressionLevel: req.CompressionLevel,
+		Retention:        (*domain.RetentionPolicy)(req.Retention),
+	}
+
+	// Validate the schedule first so an invalid one doesn't leave a source behind
+	var schedule *domain.Schedule
+	if req.Schedule != nil {
+		schedule = req.Schedule.ToScheduleDomain()
+		if err := h.schedules.Validate(schedule); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 	}
 
 	if err := h.service.Create(r.Context(), source); err != nil {
@@ -120,6 +137,20 @@ func (h *SourceHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if schedule != nil {
+		schedule.Type = domain.ScheduleTypeBackup
+		schedule.SourceID = source.ID
+		if err := h.schedules.Create(r.Context(), schedule); err != nil {
+			h.logger.Error("failed to create source schedule", zap.Error(err), zap.Int64("source_id", source.ID))
+			if err := h.service.Delete(r.Context(), source.ID); err != nil {
+				h.logger.Error("failed to remove source without its schedule", zap.Error(err), zap.Int64("source_id", source.ID))
+			}
+			WriteError(w, http.StatusInternalServerError, "Failed to create source schedule")
+			return
+		}
+		source.ScheduleID = &schedule.ID
+	}
+
 	WriteJSON(w, http.StatusCreated, source)
 }
 
@@ -152,12 +183,16 @@ func (h *SourceHandler) Update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	source := &domain.Source{
-		ID:         id,
-		Name:       req.Name,
-		Path:       req.Path,
-		Exclusions: req.Exclusions,
-		TargetID:   req.TargetID,
-		ScheduleID: req.ScheduleID,
+		ID:               id,
+		Name:             req.Name,
+		Path:             req.Path,
+		Exclusions:       req.Exclusions,
+		Filters:          domain.SourceFilters(req.Filters),
+		TargetID:         req.TargetID,
+		ScheduleID:       req.ScheduleID,
+		Compression:      req.Compression,
+		CompressionLevel: req.CompressionLevel,
+		Retention:        (*domain.RetentionPolicy)(req.Retention),
 	}
 
 	if err := h.service.Update(r.Context(), source); err != nil {
@@ -169,6 +204,10 @@ func (h *SourceHandler) Update(w http.ResponseWriter, r *http.Request) {
 			WriteError(w, http.StatusBadRequest, "Invalid path: directory does not exist")
 			return
 		}
+		if err == domain.ErrInvalidInput {
+			WriteError(w, http.StatusBadRequest, "Invalid input")
+			return
+		}
 		h.logger.Error("failed to update source", zap.Error(err), zap.Int64("id", id))
 		WriteError(w, http.StatusInternalServerError, "Failed to update source")
 		return
@@ -205,5 +244,10 @@ func (h *SourceHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Schedules aren't removed by the database when their source goes away
+	if err := h.schedules.DeleteForSource(r.Context(), id); err != nil {
+		h.logger.Error("failed to delete source schedule", zap.Error(err), zap.Int64("source_id", id))
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/axelfrache/savesync/internal/app/scheduleservice"
 	"github.com/axelfrache/savesync/internal/app/targetservice"
 	"github.com/axelfrache/savesync/internal/domain"
 	"github.com/go-chi/chi/v5"
@@ -13,15 +14,17 @@ import (
 
 // TargetHandler handles target-related requests
 type TargetHandler struct {
-	service *targetservice.Service
-	logger  *zap.Logger
+	service   *targetservice.Service
+	schedules *scheduleservice.Service
+	logger    *zap.Logger
 }
 
 // NewTargetHandler creates a new target handler
-func NewTargetHandler(service *targetservice.Service, logger *zap.Logger) *TargetHandler {
+func NewTargetHandler(service *targetservice.Service, schedules *scheduleservice.Service, logger *zap.Logger) *TargetHandler {
 	return &TargetHandler{
-		service: service,
-		logger:  logger,
+		service:   service,
+		schedules: schedules,
+		logger:    logger,
 	}
 }
 
@@ -185,5 +188,10 @@ func (h *TargetHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check schedules aren't removed by the database when their target goes away
+	if err := h.schedules.DeleteForTarget(r.Context(), id); err != nil {
+		h.logger.Error("failed to delete target check schedules", zap.Error(err), zap.Int64("target_id", id))
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
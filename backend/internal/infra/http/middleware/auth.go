@@ -31,6 +31,15 @@ func AuthMiddleware(authService *authservice.Service, logger *zap.Logger) func(h
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Extract token from Authorization header
 			authHeader := r.Header.Get("Authorization")
+
+			// Browsers can't set headers on an EventSource, so event
+			// streams may pass the token in the query string instead
+			if authHeader == "" && r.Header.Get("Accept") == "text/event-stream" {
+				if token := r.URL.Query().Get("access_token"); token != "" {
+					authHeader = "Bearer " + token
+				}
+			}
+
 			if authHeader == "" {
 				logger.Warn("missing authorization header", zap.String("path", r.URL.Path))
 				writeError(w, http.StatusUnauthorized, "Missing authorization token")
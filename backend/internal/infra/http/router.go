@@ -5,7 +5,10 @@ import (
 
 	"github.com/axelfrache/savesync/internal/app/authservice"
 	"github.com/axelfrache/savesync/internal/app/backupservice"
+	"github.com/axelfrache/savesync/internal/app/eventbus"
+	"github.com/axelfrache/savesync/internal/app/jobrunner"
 	"github.com/axelfrache/savesync/internal/app/jobservice"
+	"github.com/axelfrache/savesync/internal/app/scheduleservice"
 	"github.com/axelfrache/savesync/internal/app/settingsservice"
 	"github.com/axelfrache/savesync/internal/app/sourceservice"
 	"github.com/axelfrache/savesync/internal/app/targetservice"
@@ -31,6 +34,9 @@ func NewRouter(
 	targetService *targetservice.Service,
 	backupService *backupservice.Service,
 	jobService *jobservice.Service,
+	jobRunner *jobrunner.Runner,
+	scheduleService *scheduleservice.Service,
+	eventBus *eventbus.Bus,
 	logger *zap.Logger,
 ) http.Handler {
 	r := chi.NewRouter()
@@ -78,7 +84,7 @@ func NewRouter(
 		// Apply auth middleware to all /api routes
 		r.Use(authMiddleware)
 		// Sources
-		sourceHandler := handlers.NewSourceHandler(sourceService, logger)
+		sourceHandler := handlers.NewSourceHandler(sourceService, scheduleService, logger)
 		r.Route("/sources", func(r chi.Router) {
 			r.Get("/", sourceHandler.List)
 			r.Post("/", sourceHandler.Create)
@@ -88,7 +94,7 @@ func NewRouter(
 		})
 
 		// Targets
-		targetHandler := handlers.NewTargetHandler(targetService, logger)
+		targetHandler := handlers.NewTargetHandler(targetService, scheduleService, logger)
 		r.Route("/targets", func(r chi.Router) {
 			r.Get("/", targetHandler.List)
 			r.Post("/", targetHandler.Create)
@@ -97,26 +103,49 @@ func NewRouter(
 			r.Delete("/{id}", targetHandler.Delete)
 		})
 
+		// Schedules
+		scheduleHandler := handlers.NewScheduleHandler(scheduleService, logger)
+		r.Route("/schedules", func(r chi.Router) {
+			r.Get("/", scheduleHandler.List)
+			r.Post("/", scheduleHandler.Create)
+			r.Post("/preview", scheduleHandler.Preview)
+			r.Get("/{id}", scheduleHandler.Get)
+			r.Put("/{id}", scheduleHandler.Update)
+			r.Delete("/{id}", scheduleHandler.Delete)
+		})
+
 		// Jobs
-		jobHandler := handlers.NewJobHandler(jobService, logger)
+		jobHandler := handlers.NewJobHandler(jobService, jobRunner, logger)
 		r.Route("/jobs", func(r chi.Router) {
 			r.Get("/", jobHandler.List)
 			r.Get("/{id}", jobHandler.Get)
+			r.Get("/{id}/logs", jobHandler.Logs)
+			r.Post("/{id}/cancel", jobHandler.Cancel)
 		})
 
+		// Live job and snapshot events
+		eventHandler := handlers.NewEventHandler(eventBus, logger)
+		r.Get("/events", eventHandler.Stream)
+
 		// Backup trigger
-		backupHandler := handlers.NewBackupHandler(backupService, targetService, jobService, sourceService, logger)
+		backupHandler := handlers.NewBackupHandler(backupService, jobRunner, logger)
 		r.Post("/sources/{id}/run", backupHandler.Run)
+		r.Post("/sources/{id}/exclusions/test", backupHandler.TestExclusion)
+		r.Post("/targets/{id}/prune", backupHandler.Prune)
+		r.Post("/targets/{id}/check", backupHandler.Check)
+		r.Post("/snapshots/{id}/check", backupHandler.CheckSnapshot)
 
 		// Snapshots
-		snapshotHandler := handlers.NewSnapshotHandler(backupService, sourceService, targetService, logger)
+		snapshotHandler := handlers.NewSnapshotHandler(backupService, sourceService, targetService, jobRunner, logger)
 		r.Route("/snapshots", func(r chi.Router) {
 			r.Get("/", snapshotHandler.List)
 			r.Get("/{id}", snapshotHandler.Get)
 			r.Get("/{id}/manifest", snapshotHandler.GetManifest)
 			r.Get("/{id}/files", snapshotHandler.GetFiles)
+			r.Get("/{id}/diff/{other}", snapshotHandler.Diff)
 			r.Post("/{id}/restore", snapshotHandler.Restore)
 		})
+		r.Post("/sources/{id}/forget", snapshotHandler.Forget)
 
 		// System (File Explorer)
 		systemHandler := handlers.NewSystemHandler(logger)